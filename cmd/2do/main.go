@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/anas-salha/2do/internal/buildinfo"
 	"github.com/anas-salha/2do/internal/config"
@@ -28,16 +30,79 @@ func main() {
 		log.Fatal(err)
 	}
 
-	err = database.RunMigrations(db)
+	err = database.RunMigrations(cfg)
 	if err != nil {
 		log.Fatalf("Running migrations failed: %v", err)
 	}
 
+	events := todo.NewEventBus(int(cfg.EventBufferSize))
+
+	shareRepo := todo.NewShareRepo(db)
+
 	todoRepo := todo.NewRepo(db)
-	todoService := todo.NewService(todoRepo)
-	todoHandler := todo.NewHandler(todoService)
+	todoService := todo.NewService(
+		todoRepo,
+		todo.WithCursorSecret([]byte(cfg.CursorSecret)),
+		todo.WithDefaultList(cfg.DefaultListID),
+		todo.WithMaxDepth(int(cfg.MaxTodoDepth)),
+		todo.WithTextRules(todo.TextRules{MinLength: int(cfg.TodoTextMinLength), MaxLength: int(cfg.TodoTextMaxLength)}),
+		todo.WithSearcher(todo.NewSearcher(db)),
+		todo.WithEventBus(events),
+		todo.WithOutbox(),
+		todo.WithSharing(shareRepo),
+	)
+	todoHandler := todo.NewHandler(
+		todoService,
+		todo.WithIdempotency(todo.NewIdempotencyStore(db), cfg.IdempotencyKeyTTL),
+		todo.WithEventStream(events, cfg.EventHeartbeat),
+		todo.WithAllowedOrigins(cfg.AllowedOrigins),
+	)
+	go todo.NewPurger(todoRepo, cfg.TrashRetention).Run(context.Background(), time.Hour)
 
-	r := http.NewRouter(todoHandler, cfg.AllowedOrigins)
+	tagRepo := todo.NewTagRepo(db)
+	tagService := todo.NewTagService(tagRepo)
+	tagHandler := todo.NewTagHandler(tagService)
+
+	listRepo := todo.NewListRepo(db)
+	listService := todo.NewListService(listRepo, todoService, cfg.DefaultListID)
+	listHandler := todo.NewListHandler(listService, todoService)
+
+	webhookRepo := todo.NewWebhookRepo(db)
+	webhookService := todo.NewWebhookService(webhookRepo)
+	webhookHandler := todo.NewWebhookHandler(webhookService)
+	dispatcher := todo.NewDispatcher(
+		webhookRepo,
+		todo.WithMaxAttempts(int(cfg.WebhookMaxAttempts)),
+		todo.WithFailureLimit(int(cfg.WebhookFailureLimit)),
+	)
+	go dispatcher.Run(context.Background(), 5*time.Second)
+
+	userRepo := todo.NewUserRepo(db)
+	userService := todo.NewUserService(userRepo, cfg.SessionTTL)
+
+	shareService := todo.NewShareService(shareRepo, userRepo)
+	shareHandler := todo.NewShareHandler(shareService)
+
+	tokenRepo := todo.NewTokenRepo(db)
+	tokenService := todo.NewTokenService(tokenRepo)
+	tokenHandler := todo.NewTokenHandler(tokenService)
+
+	var (
+		auth     http.Authenticator = todo.NewBearerAuth(userService, tokenService)
+		userOpts []todo.UserHandlerOption
+	)
+	if cfg.OIDCJWKS != "" {
+		keys, err := http.LoadJWKS(context.Background(), cfg.OIDCJWKS)
+		if err != nil {
+			log.Fatal(err)
+		}
+		jwtAuth := http.NewJWTAuth(keys, userService, cfg.OIDCIssuer, cfg.OIDCAudience, http.WithClockSkew(cfg.OIDCClockSkew))
+		auth = http.Chain(jwtAuth, auth)
+		userOpts = append(userOpts, todo.WithIdentities(jwtAuth))
+	}
+	userHandler := todo.NewUserHandler(userService, userOpts...)
+	tenancy := todo.NewTenancy(todo.NewWorkspaceRepo(db), cfg.DefaultWorkspace, cfg.WorkspaceDomain)
+	r := http.NewRouter(cfg.AllowedOrigins, auth, tenancy, userHandler, tokenHandler, todoHandler, tagHandler, listHandler, shareHandler, webhookHandler)
 
 	r.Run("0.0.0.0:" + cfg.Port)
 }
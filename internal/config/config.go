@@ -3,28 +3,66 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
-	DBName         string
-	DBUser         string
-	DBPassword     string
-	DBHost         string
-	DBPort         string
-	Port           string
-	AllowedOrigins []string
+	DBName              string
+	DBUser              string
+	DBPassword          string
+	DBHost              string
+	DBPort              string
+	Port                string
+	AllowedOrigins      []string
+	CursorSecret        string
+	DefaultListID       uint32
+	MaxTodoDepth        uint32
+	TrashRetention      time.Duration
+	IdempotencyKeyTTL   time.Duration
+	TodoTextMinLength   uint32
+	TodoTextMaxLength   uint32
+	EventBufferSize     uint32
+	EventHeartbeat      time.Duration
+	WebhookMaxAttempts  uint32
+	WebhookFailureLimit uint32
+	SessionTTL          time.Duration
+	OIDCIssuer          string
+	OIDCAudience        string
+	OIDCJWKS            string
+	OIDCClockSkew       time.Duration
+	DefaultWorkspace    string
+	WorkspaceDomain     string
 }
 
 func Load() Config {
 	return Config{
-		DBName:         getEnv("DB_NAME"),
-		DBUser:         getEnv("DB_USER"),
-		DBPassword:     getEnv("DB_PASS"),
-		DBHost:         getEnv("DB_HOST"),
-		DBPort:         getEnv("DB_PORT"),
-		Port:           getEnvDefault("PORT", "8080"),
-		AllowedOrigins: splitAndTrim(getEnvDefault("ALLOWED_ORIGINS", "*")),
+		DBName:              getEnv("DB_NAME"),
+		DBUser:              getEnv("DB_USER"),
+		DBPassword:          getEnv("DB_PASS"),
+		DBHost:              getEnv("DB_HOST"),
+		DBPort:              getEnv("DB_PORT"),
+		Port:                getEnvDefault("PORT", "8080"),
+		AllowedOrigins:      splitAndTrim(getEnvDefault("ALLOWED_ORIGINS", "*")),
+		CursorSecret:        os.Getenv("CURSOR_SECRET"),
+		DefaultListID:       getEnvUint32("DEFAULT_LIST_ID", 1),
+		MaxTodoDepth:        getEnvUint32("MAX_TODO_DEPTH", 5),
+		TrashRetention:      time.Duration(getEnvUint32("TRASH_RETENTION_DAYS", 30)) * 24 * time.Hour,
+		IdempotencyKeyTTL:   time.Duration(getEnvUint32("IDEMPOTENCY_KEY_TTL_HOURS", 24)) * time.Hour,
+		TodoTextMinLength:   getEnvUint32("TODO_TEXT_MIN_LENGTH", 1),
+		TodoTextMaxLength:   getEnvUint32("TODO_TEXT_MAX_LENGTH", 1000),
+		EventBufferSize:     getEnvUint32("EVENT_BUFFER_SIZE", 1000),
+		EventHeartbeat:      time.Duration(getEnvUint32("EVENT_HEARTBEAT_SECONDS", 15)) * time.Second,
+		WebhookMaxAttempts:  getEnvUint32("WEBHOOK_MAX_ATTEMPTS", 10),
+		WebhookFailureLimit: getEnvUint32("WEBHOOK_FAILURE_LIMIT", 50),
+		SessionTTL:          time.Duration(getEnvUint32("SESSION_TTL_HOURS", 720)) * time.Hour,
+		OIDCIssuer:          os.Getenv("OIDC_ISSUER"),
+		OIDCAudience:        os.Getenv("OIDC_AUDIENCE"),
+		OIDCJWKS:            os.Getenv("OIDC_JWKS"),
+		OIDCClockSkew:       time.Duration(getEnvUint32("OIDC_CLOCK_SKEW_SECONDS", 60)) * time.Second,
+		DefaultWorkspace:    getEnvDefault("DEFAULT_WORKSPACE", "default"),
+		WorkspaceDomain:     os.Getenv("WORKSPACE_DOMAIN"),
 	}
 }
 
@@ -46,6 +84,20 @@ func getEnvDefault(k string, d string) string {
 	return v
 }
 
+func getEnvUint32(k string, d uint32) uint32 {
+	v := os.Getenv(k)
+	if v == "" {
+		return d
+	}
+
+	n, err := strconv.ParseUint(v, 10, 32)
+	if err != nil || n == 0 {
+		log.Fatalf("Environment variable %s must be a positive integer.", k)
+	}
+
+	return uint32(n)
+}
+
 func splitAndTrim(s string) []string {
 	if s == "" {
 		return []string{"*"}
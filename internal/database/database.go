@@ -14,11 +14,8 @@ import (
 )
 
 func Open(cfg config.Config) (*sql.DB, error) {
-	// Construct DSN
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true", cfg.DBUser, cfg.DBPassword, cfg.DBHost, cfg.DBPort, cfg.DBName)
-
 	// Connect to the database
-	db, err := sql.Open("mysql", dsn)
+	db, err := sql.Open("mysql", dsn(cfg, ""))
 	if err != nil {
 		return nil, err
 	}
@@ -33,7 +30,21 @@ func Open(cfg config.Config) (*sql.DB, error) {
 	return db, nil
 }
 
-func RunMigrations(db *sql.DB) error {
+// dsn constructs the DSN of the database, followed by the given parameters.
+func dsn(cfg config.Config, params string) string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true%s", cfg.DBUser, cfg.DBPassword, cfg.DBHost, cfg.DBPort, cfg.DBName, params)
+}
+
+// RunMigrations applies the pending migrations over a connection of its own.
+// Migrations may hold several statements, which the migrate driver runs in a
+// single call, so only that connection allows them.
+func RunMigrations(cfg config.Config) error {
+	db, err := sql.Open("mysql", dsn(cfg, "&multiStatements=true"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
 	driver, err := mysql.WithInstance(db, &mysql.Config{})
 	if err != nil {
 		return err
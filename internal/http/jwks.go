@@ -0,0 +1,262 @@
+package http
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksRefreshInterval bounds how often a JWKS served from a URL is fetched
+// again to find a key it didn't hold, so that tokens with made up key IDs
+// can't hammer the identity provider.
+const jwksRefreshInterval = 5 * time.Minute
+
+// minRSAKeyBits is the smallest RSA key signatures are accepted from.
+const minRSAKeyBits = 2048
+
+// JWKS is a JSON Web Key Set holding the public keys JWTs are signed with,
+// loaded from a URL or a local file. A set loaded from a URL is fetched again
+// when a token is signed with a key it doesn't hold, as it is when the
+// identity provider rotates its keys.
+type JWKS struct {
+	source string
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+	// refreshing is closed once the fetch in flight, if any, is over.
+	refreshing chan struct{}
+}
+
+// LoadJWKS loads the JWKS at source, an http or https URL or the path of a
+// local file.
+func LoadJWKS(ctx context.Context, source string) (*JWKS, error) {
+	k := &JWKS{source: source, client: &http.Client{Timeout: 10 * time.Second}}
+	keys, err := k.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	k.keys, k.fetched = keys, time.Now()
+	return k, nil
+}
+
+// Key returns the key with ID kid, or the only key of the set when kid is
+// empty.
+func (k *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if key, ok := k.lookup(kid); ok {
+		return key, nil
+	}
+	if k.remote() {
+		if err := k.refresh(ctx); err != nil {
+			return nil, err
+		}
+		if key, ok := k.lookup(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+func (k *JWKS) lookup(kid string) (crypto.PublicKey, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if kid == "" && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true
+		}
+	}
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+func (k *JWKS) remote() bool {
+	return strings.HasPrefix(k.source, "http://") || strings.HasPrefix(k.source, "https://")
+}
+
+// refresh fetches the set again, replacing the keys held, unless it was
+// fetched less than jwksRefreshInterval ago. The fetch runs without holding
+// the lock, and only one runs at a time: callers arriving while it is in
+// flight wait for it instead.
+func (k *JWKS) refresh(ctx context.Context) error {
+	k.mu.Lock()
+	if done := k.refreshing; done != nil {
+		k.mu.Unlock()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if time.Since(k.fetched) < jwksRefreshInterval {
+		k.mu.Unlock()
+		return nil
+	}
+	done := make(chan struct{})
+	k.refreshing, k.fetched = done, time.Now()
+	k.mu.Unlock()
+
+	keys, err := k.read(ctx)
+
+	k.mu.Lock()
+	if err == nil {
+		k.keys = keys
+	}
+	k.refreshing = nil
+	k.mu.Unlock()
+	close(done)
+	return err
+}
+
+// read reads the set from its source.
+func (k *JWKS) read(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var (
+		b   []byte
+		err error
+	)
+	if k.remote() {
+		b, err = k.fetch(ctx)
+	} else {
+		b, err = os.ReadFile(k.source)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading JWKS: %w", err)
+	}
+
+	keys, err := parseJWKS(b)
+	if err != nil {
+		return nil, fmt.Errorf("loading JWKS: %w", err)
+	}
+	return keys, nil
+}
+
+func (k *JWKS) fetch(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA keys
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC keys
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS returns the signing keys of the set in b by their ID. Keys of
+// other types or uses are skipped, as are those no signature is accepted from,
+// such as keys on other curves than P-256 or RSA keys that are too short, so
+// that one of them doesn't cost the whole set.
+func parseJWKS(b []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, j := range set.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		var (
+			key crypto.PublicKey
+			err error
+		)
+		switch j.Kty {
+		case "RSA":
+			key, err = rsaKey(j)
+		case "EC":
+			key, err = ecKey(j)
+		default:
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		keys[j.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	return keys, nil
+}
+
+func rsaKey(j jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(j.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(j.E)
+	if err != nil {
+		return nil, err
+	}
+	exp := new(big.Int).SetBytes(e)
+	if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid exponent")
+	}
+
+	key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}
+	if key.N.BitLen() < minRSAKeyBits {
+		return nil, fmt.Errorf("RSA keys must have at least %d bits", minRSAKeyBits)
+	}
+	return key, nil
+}
+
+// ecKey returns the P-256 key of j, the only curve ES256 signs with.
+func ecKey(j jwk) (*ecdsa.PublicKey, error) {
+	if j.Crv != "P-256" {
+		return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(j.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(j.Y)
+	if err != nil {
+		return nil, err
+	}
+	if len(x) != 32 || len(y) != 32 {
+		return nil, errors.New("invalid point")
+	}
+
+	// Parsing the uncompressed point checks that it is on the curve.
+	if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+		return nil, err
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+}
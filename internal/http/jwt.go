@@ -0,0 +1,247 @@
+package http
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/anas-salha/2do/internal/todo"
+)
+
+// DefaultClockSkew is how far the clocks of the identity provider and the
+// server may drift apart before tokens are taken as expired or not yet valid.
+const DefaultClockSkew = time.Minute
+
+// Provisioner returns the ID of the user of an identity, registering one on
+// first sight.
+type Provisioner interface {
+	Provision(ctx context.Context, id todo.Identity) (uint32, error)
+}
+
+// JWTAuth authenticates JWTs issued by an OpenID Connect provider and signed
+// with RS256 or ES256 by a key of its JWKS.
+type JWTAuth struct {
+	keys     *JWKS
+	users    Provisioner
+	issuer   string
+	audience string
+	skew     time.Duration
+	now      func() time.Time
+}
+
+type JWTOption func(*JWTAuth)
+
+// WithClockSkew sets how far the clocks of the identity provider and the
+// server may drift apart. It defaults to DefaultClockSkew.
+func WithClockSkew(d time.Duration) JWTOption {
+	return func(a *JWTAuth) {
+		a.skew = d
+	}
+}
+
+// NewJWTAuth returns a JWTAuth accepting the tokens issued by issuer to
+// audience, whose users are provisioned by users.
+func NewJWTAuth(keys *JWKS, users Provisioner, issuer, audience string, opts ...JWTOption) *JWTAuth {
+	a := &JWTAuth{keys: keys, users: users, issuer: issuer, audience: audience, skew: DefaultClockSkew, now: time.Now}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// audience is the aud claim, which is either a string or an array of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+type claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	ExpiresAt     *float64 `json:"exp"`
+	NotBefore     *float64 `json:"nbf"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Scope         *string  `json:"scope"`
+	Workspace     string   `json:"workspace"`
+}
+
+// Authenticate returns the principal of token, a JWT whose user is
+// provisioned on first sight. The principal is granted the scopes of the
+// scope claim, or every scope without one, and is bound to the workspace of
+// the workspace claim, if any. Tokens which aren't JWTs, or are
+// invalid, fail with todo.ErrUnauthenticated.
+func (a *JWTAuth) Authenticate(ctx context.Context, token string) (*todo.Principal, error) {
+	c, err := a.verify(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", todo.ErrUnauthenticated, err)
+	}
+
+	id, err := a.users.Provision(ctx, todo.Identity{
+		Issuer:        c.Issuer,
+		Subject:       c.Subject,
+		Email:         c.Email,
+		EmailVerified: c.EmailVerified,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p := &todo.Principal{UserID: id, Workspace: c.Workspace}
+	if c.Scope != nil {
+		p.Scopes = []string{}
+		for _, scope := range strings.Fields(*c.Scope) {
+			if scope == todo.ScopeTodosRead || scope == todo.ScopeTodosWrite {
+				p.Scopes = append(p.Scopes, scope)
+			}
+		}
+	}
+	return p, nil
+}
+
+// Identify returns the identity token was issued to, without provisioning
+// its user, for a user linking it to their account. Tokens which aren't JWTs,
+// or are invalid, fail with todo.ErrIdentityInvalid.
+func (a *JWTAuth) Identify(ctx context.Context, token string) (*todo.Identity, error) {
+	c, err := a.verify(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", todo.ErrIdentityInvalid, err)
+	}
+
+	return &todo.Identity{
+		Issuer:        c.Issuer,
+		Subject:       c.Subject,
+		Email:         c.Email,
+		EmailVerified: c.EmailVerified,
+	}, nil
+}
+
+// verify checks the signature of token and its claims, and returns them.
+func (a *JWTAuth) verify(ctx context.Context, token string) (*claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("not a JWT")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("signature: %w", err)
+	}
+	key, err := a.keys.Key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, fmt.Errorf("claims: %w", err)
+	}
+
+	now := a.now()
+	switch {
+	case c.Issuer != a.issuer:
+		return nil, fmt.Errorf("unexpected issuer %q", c.Issuer)
+	case !slices.Contains(c.Audience, a.audience):
+		return nil, errors.New("not issued to this audience")
+	case c.ExpiresAt == nil || !now.Before(numericDate(*c.ExpiresAt).Add(a.skew)):
+		return nil, errors.New("expired")
+	case c.NotBefore != nil && now.Add(a.skew).Before(numericDate(*c.NotBefore)):
+		return nil, errors.New("not valid yet")
+	case c.Subject == "":
+		return nil, errors.New("no subject")
+	}
+	return &c, nil
+}
+
+// verifySignature checks sig, the signature of the signing input of a JWT
+// with algorithm alg, against key. Only RS256 and ES256 are accepted, each
+// with keys of its own type.
+func verifySignature(alg string, key crypto.PublicKey, input string, sig []byte) error {
+	digest := sha256.Sum256([]byte(input))
+
+	switch alg {
+	case "RS256":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("RS256 needs an RSA key")
+		}
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig)
+	case "ES256":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("ES256 needs an EC key")
+		}
+		// The signature is r and s, 32 bytes each, rather than ASN.1.
+		if len(sig) != 64 {
+			return errors.New("invalid signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %q", alg)
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// numericDate returns the time of a NumericDate, in seconds since the epoch.
+func numericDate(secs float64) time.Time {
+	return time.Unix(int64(secs), 0)
+}
+
+// Chain returns an Authenticator trying each of auths in turn, until one
+// authenticates the token. Errors other than todo.ErrUnauthenticated stop the
+// chain.
+func Chain(auths ...Authenticator) Authenticator {
+	return chain(auths)
+}
+
+type chain []Authenticator
+
+func (c chain) Authenticate(ctx context.Context, token string) (*todo.Principal, error) {
+	err := todo.ErrUnauthenticated
+	for _, a := range c {
+		var p *todo.Principal
+		p, err = a.Authenticate(ctx, token)
+		if !errors.Is(err, todo.ErrUnauthenticated) {
+			return p, err
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,294 @@
+package http_test
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/anas-salha/2do/internal/http"
+	"github.com/anas-salha/2do/internal/todo"
+)
+
+const (
+	testIssuer   = "https://id.example.com"
+	testAudience = "2do"
+)
+
+// fakeProvisioner gives each identity it sees a user ID of its own.
+type fakeProvisioner map[todo.Identity]uint32
+
+func (f fakeProvisioner) Provision(ctx context.Context, id todo.Identity) (uint32, error) {
+	if _, ok := f[id]; !ok {
+		f[id] = uint32(len(f) + 1)
+	}
+	return f[id], nil
+}
+
+// signingKey is a key of the stand-in identity provider.
+type signingKey struct {
+	kid string
+	alg string
+	key crypto.Signer
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// jwk returns the public JWK of k.
+func (k signingKey) jwk() map[string]string {
+	switch pub := k.key.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": k.kid, "use": "sig", "n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": k.kid, "crv": "P-256", "x": b64(pub.X.FillBytes(make([]byte, 32))), "y": b64(pub.Y.FillBytes(make([]byte, 32)))}
+	}
+	panic("unexpected key type")
+}
+
+// sign returns a JWT of claims signed by k.
+func (k signingKey) sign(claims map[string]any) string {
+	header, err := json.Marshal(map[string]string{"alg": k.alg, "kid": k.kid, "typ": "JWT"})
+	Expect(err).NotTo(HaveOccurred())
+	payload, err := json.Marshal(claims)
+	Expect(err).NotTo(HaveOccurred())
+	input := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch key := k.key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		Expect(err).NotTo(HaveOccurred())
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		Expect(err).NotTo(HaveOccurred())
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return input + "." + b64(sig)
+}
+
+func jwksOf(keys ...signingKey) []byte {
+	set := map[string][]map[string]string{"keys": {}}
+	for _, k := range keys {
+		set["keys"] = append(set["keys"], k.jwk())
+	}
+	b, err := json.Marshal(set)
+	Expect(err).NotTo(HaveOccurred())
+	return b
+}
+
+var _ = Describe("jwt auth", Label("jwt"), func() {
+	var (
+		ctx      context.Context
+		rsaKey   signingKey
+		ecKey    signingKey
+		users    fakeProvisioner
+		auth     *JWTAuth
+		jwksPath string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		rk, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		rsaKey = signingKey{kid: "rsa-1", alg: "RS256", key: rk}
+		ek, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		ecKey = signingKey{kid: "ec-1", alg: "ES256", key: ek}
+
+		jwksPath = filepath.Join(GinkgoT().TempDir(), "jwks.json")
+		Expect(os.WriteFile(jwksPath, jwksOf(rsaKey, ecKey), 0o600)).To(Succeed())
+		keys, err := LoadJWKS(ctx, jwksPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		users = fakeProvisioner{}
+		auth = NewJWTAuth(keys, users, testIssuer, testAudience, WithClockSkew(30*time.Second))
+	})
+
+	validClaims := func() map[string]any {
+		now := time.Now()
+		return map[string]any{
+			"iss":   testIssuer,
+			"sub":   "abc",
+			"aud":   testAudience,
+			"exp":   now.Add(time.Hour).Unix(),
+			"iat":   now.Unix(),
+			"email": "ada@example.com",
+		}
+	}
+
+	It("authenticates RS256 and ES256 tokens as the provisioned user", func() {
+		for _, k := range []signingKey{rsaKey, ecKey} {
+			p, err := auth.Authenticate(ctx, k.sign(validClaims()))
+			Expect(err).NotTo(HaveOccurred(), k.alg)
+			Expect(p.UserID).To(BeEquivalentTo(1))
+			Expect(p.Scopes).To(BeNil())
+		}
+		Expect(users).To(HaveKeyWithValue(todo.Identity{Issuer: testIssuer, Subject: "abc", Email: "ada@example.com"}, BeEquivalentTo(1)))
+	})
+
+	It("identifies tokens without provisioning their user", func() {
+		claims := validClaims()
+		claims["email_verified"] = true
+
+		id, err := auth.Identify(ctx, rsaKey.sign(claims))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*id).To(Equal(todo.Identity{Issuer: testIssuer, Subject: "abc", Email: "ada@example.com", EmailVerified: true}))
+		Expect(users).To(BeEmpty())
+
+		claims["aud"] = "other"
+		_, err = auth.Identify(ctx, rsaKey.sign(claims))
+		Expect(err).To(MatchError(todo.ErrIdentityInvalid))
+	})
+
+	It("accepts audiences given as an array", func() {
+		claims := validClaims()
+		claims["aud"] = []string{"other", testAudience}
+
+		_, err := auth.Authenticate(ctx, rsaKey.sign(claims))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("grants the known scopes of the scope claim", func() {
+		claims := validClaims()
+		claims["scope"] = "openid todos:read"
+
+		p, err := auth.Authenticate(ctx, rsaKey.sign(claims))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p.Scopes).To(Equal([]string{todo.ScopeTodosRead}))
+	})
+
+	It("tolerates clock skew", func() {
+		claims := validClaims()
+		claims["exp"] = time.Now().Add(-10 * time.Second).Unix()
+		claims["nbf"] = time.Now().Add(10 * time.Second).Unix()
+
+		_, err := auth.Authenticate(ctx, ecKey.sign(claims))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	DescribeTable("rejects invalid tokens",
+		func(change func(map[string]any)) {
+			claims := validClaims()
+			change(claims)
+
+			_, err := auth.Authenticate(ctx, rsaKey.sign(claims))
+			Expect(err).To(MatchError(todo.ErrUnauthenticated))
+			Expect(users).To(BeEmpty())
+		},
+		Entry("of another issuer", func(c map[string]any) { c["iss"] = "https://evil.example.com" }),
+		Entry("for another audience", func(c map[string]any) { c["aud"] = "other" }),
+		Entry("expired", func(c map[string]any) { c["exp"] = time.Now().Add(-time.Minute).Unix() }),
+		Entry("without an expiry", func(c map[string]any) { delete(c, "exp") }),
+		Entry("not valid yet", func(c map[string]any) { c["nbf"] = time.Now().Add(time.Minute).Unix() }),
+		Entry("without a subject", func(c map[string]any) { delete(c, "sub") }),
+	)
+
+	It("rejects tampered tokens", func() {
+		token := rsaKey.sign(validClaims())
+		parts := strings.Split(token, ".")
+		claims := validClaims()
+		claims["sub"] = "someone else"
+		payload, err := json.Marshal(claims)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = auth.Authenticate(ctx, parts[0]+"."+b64(payload)+"."+parts[2])
+		Expect(err).To(MatchError(todo.ErrUnauthenticated))
+	})
+
+	It("rejects tokens signed with other algorithms or unknown keys", func() {
+		none := b64([]byte(`{"alg":"none","kid":"rsa-1"}`)) + "." + b64([]byte(`{"sub":"abc"}`)) + "."
+		_, err := auth.Authenticate(ctx, none)
+		Expect(err).To(MatchError(todo.ErrUnauthenticated))
+
+		mixed := signingKey{kid: "rsa-1", alg: "ES256", key: ecKey.key}
+		_, err = auth.Authenticate(ctx, mixed.sign(validClaims()))
+		Expect(err).To(MatchError(todo.ErrUnauthenticated))
+
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		unknown := signingKey{kid: "ec-2", alg: "ES256", key: k}
+		_, err = auth.Authenticate(ctx, unknown.sign(validClaims()))
+		Expect(err).To(MatchError(todo.ErrUnauthenticated))
+	})
+
+	It("rejects session and API tokens", func() {
+		_, err := auth.Authenticate(ctx, todo.APITokenPrefix+"abc")
+		Expect(err).To(MatchError(todo.ErrUnauthenticated))
+	})
+
+	It("loads the JWKS from a URL", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(jwksOf(ecKey))
+		}))
+		DeferCleanup(server.Close)
+
+		keys, err := LoadJWKS(ctx, server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		auth = NewJWTAuth(keys, users, testIssuer, testAudience)
+
+		_, err = auth.Authenticate(ctx, ecKey.sign(validClaims()))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = auth.Authenticate(ctx, rsaKey.sign(validClaims()))
+		Expect(err).To(MatchError(todo.ErrUnauthenticated))
+	})
+
+	It("refuses JWKS without signing keys", func() {
+		Expect(os.WriteFile(jwksPath, []byte(`{"keys": [{"kty": "oct", "k": "c2VjcmV0"}]}`), 0o600)).To(Succeed())
+		_, err := LoadJWKS(ctx, jwksPath)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("skips the keys of a JWKS it can't verify signatures with", func() {
+		set := map[string][]map[string]string{"keys": {
+			{"kty": "EC", "kid": "p384", "use": "sig", "crv": "P-384", "x": b64(make([]byte, 48)), "y": b64(make([]byte, 48))},
+			{"kty": "RSA", "kid": "short", "use": "sig", "n": b64(bytes.Repeat([]byte{0xff}, 128)), "e": "AQAB"},
+			rsaKey.jwk(),
+		}}
+		b, err := json.Marshal(set)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(jwksPath, b, 0o600)).To(Succeed())
+
+		keys, err := LoadJWKS(ctx, jwksPath)
+		Expect(err).NotTo(HaveOccurred())
+		auth = NewJWTAuth(keys, users, testIssuer, testAudience)
+
+		_, err = auth.Authenticate(ctx, rsaKey.sign(validClaims()))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Describe("chain", func() {
+		It("falls back to the next authenticator for tokens which aren't JWTs", func() {
+			chained := Chain(auth, fakeAuth{"session": {UserID: 9}})
+
+			p, err := chained.Authenticate(ctx, "session")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p.UserID).To(BeEquivalentTo(9))
+
+			p, err = chained.Authenticate(ctx, rsaKey.sign(validClaims()))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p.UserID).To(BeEquivalentTo(1))
+
+			_, err = chained.Authenticate(ctx, "nope")
+			Expect(err).To(MatchError(todo.ErrUnauthenticated))
+		})
+	})
+})
@@ -2,15 +2,25 @@ package http
 
 import (
 	"context"
-	"net/http"
+	"slices"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+
+	"github.com/anas-salha/2do/internal/todo"
 )
 
-func contextTimeout(d time.Duration) gin.HandlerFunc {
+// contextTimeout cancels the context of requests running longer than d,
+// except for those to the streaming routes, which stay open for as long as
+// the client does.
+func contextTimeout(d time.Duration, streams ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if slices.Contains(streams, c.FullPath()) {
+			c.Next()
+			return
+		}
+
 		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
 		defer cancel()
 		c.Request = c.Request.WithContext(ctx)
@@ -18,12 +28,66 @@ func contextTimeout(d time.Duration) gin.HandlerFunc {
 	}
 }
 
+// authenticate lets the requests to every route but the public ones through
+// on behalf of the principal of their bearer token, given as the method
+// followed by the full path. API tokens only reach the routes declaring a
+// scope they were granted, while routes declaring none are only reached by
+// principals granted every scope.
+func authenticate(auth Authenticator, scopes map[string]string, public ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.Request.Method + " " + c.FullPath()
+		if slices.Contains(public, route) {
+			c.Next()
+			return
+		}
+
+		p, err := auth.Authenticate(c.Request.Context(), todo.BearerToken(c))
+		if err == nil {
+			if scope, ok := scopes[route]; !ok && p.Scopes != nil {
+				err = &todo.ScopeError{}
+			} else if ok && !p.Allows(scope) {
+				err = &todo.ScopeError{Scope: scope}
+			}
+		}
+		if err != nil {
+			todo.WriteAuthError(c, err)
+			c.Abort()
+			return
+		}
+
+		c.Request = c.Request.WithContext(todo.WithPrincipal(c.Request.Context(), *p))
+		c.Next()
+	}
+}
+
+// resolveWorkspace lets the authenticated requests through within their
+// workspace, except for those to the public routes, given as the method
+// followed by the full path.
+func resolveWorkspace(tenants TenantResolver, public ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if slices.Contains(public, c.Request.Method+" "+c.FullPath()) {
+			c.Next()
+			return
+		}
+
+		id, err := tenants.Resolve(c)
+		if err != nil {
+			todo.WriteWorkspaceError(c, err)
+			c.Abort()
+			return
+		}
+
+		c.Request = c.Request.WithContext(todo.WithWorkspace(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
 func setCors(allowedOrigins []string) gin.HandlerFunc {
 	return cors.New(cors.Config{
 		AllowOrigins:     allowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length", "Location"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "If-Match", "If-None-Match", "Idempotency-Key", "X-Error-Format", "Last-Event-ID", todo.WorkspaceHeader},
+		ExposeHeaders:    []string{"Content-Length", "Location", "X-Total-Count", "X-Next-Cursor", "X-Prev-Cursor", "ETag", "Idempotent-Replayed"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	})
@@ -33,5 +97,6 @@ func methodNotAllowed(c *gin.Context) {
 	if allow := c.Writer.Header().Get("Allow"); allow != "" {
 		c.Header("Allow", allow)
 	}
-	c.AbortWithStatusJSON(http.StatusMethodNotAllowed, gin.H{"error": "method not allowed"})
+	todo.WriteMethodNotAllowed(c)
+	c.Abort()
 }
@@ -1,25 +1,97 @@
 package http
 
 import (
+	"context"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/anas-salha/2do/internal/todo"
 )
 
+const basePath = "/api/v0"
+
 type registrable interface {
 	Register(gin.IRoutes)
 }
 
-func NewRouter(todoHandler registrable, allowedOrigins []string) *gin.Engine {
+// streamer is implemented by handlers serving long-lived responses on some of
+// their routes.
+type streamer interface {
+	Streams() []string
+}
+
+// Authenticator returns the principal a bearer token was issued to, or fails
+// with todo.ErrUnauthenticated if it can't be authenticated.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (*todo.Principal, error)
+}
+
+// TenantResolver returns the ID of the workspace a request acts within, on
+// behalf of the principal of its context, or fails with
+// todo.ErrWorkspaceNotFound if the principal can't reach it.
+type TenantResolver interface {
+	Resolve(c *gin.Context) (uint32, error)
+}
+
+// scoper is implemented by handlers whose routes API tokens may reach, with
+// the scope each route needs, keyed by the method followed by the path.
+type scoper interface {
+	Scopes() map[string]string
+}
+
+// opener is implemented by handlers serving some of their routes without
+// authentication, given as the method followed by the path.
+type opener interface {
+	Public() []string
+}
+
+// NewRouter serves the routes of handlers. Unless auth is nil, every route
+// but the public ones requires authentication, and unless tenants is nil
+// too, acts within the workspace of the request.
+func NewRouter(allowedOrigins []string, auth Authenticator, tenants TenantResolver, handlers ...registrable) *gin.Engine {
+	var (
+		streams []string
+		public  []string
+		scopes  = map[string]string{}
+	)
+	for _, h := range handlers {
+		if s, ok := h.(streamer); ok {
+			for _, p := range s.Streams() {
+				streams = append(streams, basePath+p)
+			}
+		}
+		if o, ok := h.(opener); ok {
+			for _, route := range o.Public() {
+				method, path, _ := strings.Cut(route, " ")
+				public = append(public, method+" "+basePath+path)
+			}
+		}
+		if s, ok := h.(scoper); ok {
+			for route, scope := range s.Scopes() {
+				method, path, _ := strings.Cut(route, " ")
+				scopes[method+" "+basePath+path] = scope
+			}
+		}
+	}
+
 	r := gin.Default()
 	r.HandleMethodNotAllowed = true
 	r.NoMethod(methodNotAllowed)
-	r.Use(contextTimeout(2 * time.Second))
+	r.Use(contextTimeout(2*time.Second, streams...))
 	r.Use(setCors(allowedOrigins))
 
-	api := r.Group("/api")
-	v := api.Group("/v0")
-	todoHandler.Register(v)
+	v := r.Group(basePath)
+	if auth != nil {
+		v.Use(authenticate(auth, scopes, public...))
+		if tenants != nil {
+			v.Use(resolveWorkspace(tenants, public...))
+		}
+	}
+	for _, h := range handlers {
+		h.Register(v)
+	}
 
 	r.GET("/healthz", func(c *gin.Context) { c.Status(204) })
 	return r
@@ -0,0 +1,161 @@
+package http_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/anas-salha/2do/internal/http"
+	"github.com/anas-salha/2do/internal/todo"
+)
+
+// fakeAuth authenticates the tokens it was given the principals of.
+type fakeAuth map[string]todo.Principal
+
+func (a fakeAuth) Authenticate(ctx context.Context, token string) (*todo.Principal, error) {
+	p, ok := a[token]
+	if !ok {
+		return nil, todo.ErrUnauthenticated
+	}
+	return &p, nil
+}
+
+// fakeTenants resolves the workspaces named by the header of requests.
+type fakeTenants map[string]uint32
+
+func (t fakeTenants) Resolve(c *gin.Context) (uint32, error) {
+	id, ok := t[c.GetHeader(todo.WorkspaceHeader)]
+	if !ok {
+		return 0, todo.ErrWorkspaceNotFound
+	}
+	return id, nil
+}
+
+// fakeHandler serves the user it acts on behalf of on a route of each scope,
+// a public route, and one reached by sessions only. It serves the workspace it
+// acts within on another route.
+type fakeHandler struct{}
+
+func (fakeHandler) Register(r gin.IRoutes) {
+	me := func(ctx *gin.Context) {
+		id, _ := todo.UserID(ctx.Request.Context())
+		ctx.JSON(http.StatusOK, id)
+	}
+	r.GET("/things", me)
+	r.POST("/things", me)
+	r.GET("/public", me)
+	r.GET("/settings", me)
+	r.GET("/workspace", func(ctx *gin.Context) {
+		id, _ := todo.WorkspaceID(ctx.Request.Context())
+		ctx.JSON(http.StatusOK, id)
+	})
+}
+
+func (fakeHandler) Public() []string {
+	return []string{"GET /public"}
+}
+
+func (fakeHandler) Scopes() map[string]string {
+	return map[string]string{"GET /things": todo.ScopeTodosRead, "POST /things": todo.ScopeTodosWrite, "GET /workspace": todo.ScopeTodosRead}
+}
+
+var _ = Describe("router", Label("router"), func() {
+	var router *gin.Engine
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		auth := fakeAuth{
+			"session": {UserID: 1},
+			"reader":  {UserID: 2, Scopes: []string{todo.ScopeTodosRead}},
+		}
+		router = NewRouter([]string{"http://localhost:3000"}, auth, nil, fakeHandler{})
+	})
+
+	request := func(method, path, token string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/api/v0"+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	It("acts on behalf of the principal of the token", func() {
+		rr := request(http.MethodGet, "/things", "reader")
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Body.String()).To(Equal("2"))
+	})
+
+	It("challenges requests without a valid token", func() {
+		for _, token := range []string{"", "nope"} {
+			rr := request(http.MethodGet, "/things", token)
+			Expect(rr.Code).To(Equal(http.StatusUnauthorized), token)
+			Expect(rr.Header().Get("WWW-Authenticate")).To(Equal(todo.TokenTypeBearer))
+		}
+	})
+
+	It("serves public routes without a token", func() {
+		Expect(request(http.MethodGet, "/public", "").Code).To(Equal(http.StatusOK))
+	})
+
+	It("forbids routes needing scopes the token wasn't granted", func() {
+		rr := request(http.MethodPost, "/things", "reader")
+		Expect(rr.Code).To(Equal(http.StatusForbidden))
+		Expect(rr.Header().Get("WWW-Authenticate")).To(ContainSubstring(`scope="todos:write"`))
+		Expect(rr.Body.String()).To(ContainSubstring(todo.ErrInsufficientScope.Error()))
+	})
+
+	It("keeps API tokens off routes declaring no scope", func() {
+		Expect(request(http.MethodGet, "/settings", "reader").Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("grants sessions every route", func() {
+		Expect(request(http.MethodPost, "/things", "session").Code).To(Equal(http.StatusOK))
+		Expect(request(http.MethodGet, "/settings", "session").Code).To(Equal(http.StatusOK))
+	})
+
+	It("reports methods a route doesn't take as problem details", func() {
+		rr := request(http.MethodDelete, "/things", "session")
+		Expect(rr.Code).To(Equal(http.StatusMethodNotAllowed))
+		Expect(rr.Header().Get("Content-Type")).To(HavePrefix("application/problem+json"))
+		Expect(rr.Body.String()).To(ContainSubstring(todo.ErrMethodNotAllowed.Error()))
+	})
+
+	Describe("workspaces", func() {
+		BeforeEach(func() {
+			router = NewRouter([]string{"http://localhost:3000"}, fakeAuth{"session": {UserID: 1}}, fakeTenants{"acme": 2}, fakeHandler{})
+		})
+
+		request := func(path, workspace string) *httptest.ResponseRecorder {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/api/v0"+path, nil)
+			req.Header.Set("Authorization", "Bearer session")
+			req.Header.Set(todo.WorkspaceHeader, workspace)
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		It("acts within the workspace of the request", func() {
+			rr := request("/workspace", "acme")
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Body.String()).To(Equal("2"))
+		})
+
+		It("rejects requests whose workspace can't be resolved", func() {
+			rr := request("/workspace", "globex")
+			Expect(rr.Code).To(Equal(http.StatusNotFound))
+			Expect(rr.Body.String()).To(ContainSubstring(todo.ErrWorkspaceNotFound.Error()))
+		})
+
+		It("serves public routes without a workspace", func() {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v0/public", nil))
+			Expect(rr.Code).To(Equal(http.StatusOK))
+		})
+	})
+})
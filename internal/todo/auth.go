@@ -0,0 +1,133 @@
+package todo
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Scopes granted to API tokens. Lists and tags are part of the todos as far
+// as scopes go.
+const (
+	ScopeTodosRead  = "todos:read"
+	ScopeTodosWrite = "todos:write"
+)
+
+// scopes are all the scopes API tokens can be granted.
+var scopes = []string{ScopeTodosRead, ScopeTodosWrite}
+
+// Principal is who a request is made by: a user, limited to some scopes when
+// authenticated with an API token.
+type Principal struct {
+	UserID uint32
+	// Scopes are the scopes granted to the principal, or nil if it is
+	// granted every scope, as the users of sessions are.
+	Scopes []string
+	// Workspace is the slug of the workspace the token of the principal is
+	// bound to, if any.
+	Workspace string
+}
+
+// Allows reports whether p was granted scope.
+func (p Principal) Allows(scope string) bool {
+	return p.Scopes == nil || slices.Contains(p.Scopes, scope)
+}
+
+// scopesByMethod maps routes, given as the method followed by the path, to
+// the scope they need: reading the todos for GET requests, and writing them
+// for the rest. The WebSocket channel is opened with a GET request, so its
+// mutations check for the write scope themselves.
+func scopesByMethod(routes ...string) map[string]string {
+	m := make(map[string]string, len(routes))
+	for _, route := range routes {
+		method, _, _ := strings.Cut(route, " ")
+		if method == http.MethodGet {
+			m[route] = ScopeTodosRead
+		} else {
+			m[route] = ScopeTodosWrite
+		}
+	}
+	return m
+}
+
+type contextKey int
+
+const (
+	principalKey contextKey = iota
+	allUsersKey
+	workspaceKey
+)
+
+// WithPrincipal returns a copy of ctx acting on behalf of p. Repositories only
+// reach the todos, webhooks and idempotency keys of its user.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// PrincipalOf returns the principal ctx acts on behalf of, if any.
+func PrincipalOf(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey).(Principal)
+	return p, ok && p.UserID != 0
+}
+
+// WithUser returns a copy of ctx acting on behalf of user id, with every
+// scope.
+func WithUser(ctx context.Context, id uint32) context.Context {
+	return WithPrincipal(ctx, Principal{UserID: id})
+}
+
+// UserID returns the ID of the user ctx acts on behalf of, if any.
+func UserID(ctx context.Context) (uint32, bool) {
+	p, ok := PrincipalOf(ctx)
+	return p.UserID, ok
+}
+
+// allUsers returns a copy of ctx reaching the todos of every user in every
+// workspace, for the housekeeping done in the background rather than on behalf
+// of anyone.
+func allUsers(ctx context.Context) context.Context {
+	return context.WithValue(ctx, allUsersKey, true)
+}
+
+// ownerOf returns the ID of the user whose rows ctx reaches. It fails with
+// ErrUnauthenticated rather than reaching the rows of every user when ctx
+// carries none.
+func ownerOf(ctx context.Context) (uint32, error) {
+	id, ok := UserID(ctx)
+	if !ok {
+		return 0, ErrUnauthenticated
+	}
+	return id, nil
+}
+
+// everyUser reports whether ctx reaches the rows of every user.
+func everyUser(ctx context.Context) bool {
+	all, _ := ctx.Value(allUsersKey).(bool)
+	return all
+}
+
+// BearerAuth authenticates the bearer tokens of requests, which are either
+// session tokens or API tokens, told apart by APITokenPrefix.
+type BearerAuth struct {
+	users  UserService
+	tokens TokenService
+}
+
+func NewBearerAuth(users UserService, tokens TokenService) *BearerAuth {
+	return &BearerAuth{users: users, tokens: tokens}
+}
+
+// Authenticate returns the principal token was issued to, or fails with
+// ErrUnauthenticated if it is unknown, expired or revoked.
+func (a *BearerAuth) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	if isAPIToken(token) {
+		return a.tokens.Authenticate(ctx, token)
+	}
+
+	id, err := a.users.Authenticate(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{UserID: id}, nil
+}
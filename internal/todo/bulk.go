@@ -0,0 +1,160 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Bulk runs ops in order within a single transaction. If atomic is set, the
+// first failed operation rolls back all of them and is reported as a
+// *BulkError; otherwise every operation stands on its own and its outcome is
+// reported in the matching result.
+func (s *service) Bulk(ctx context.Context, ops []BulkOp, atomic bool) ([]BulkResult, error) {
+	results := make([]BulkResult, len(ops))
+	err := s.transact(ctx, func(tx *service) error {
+		for i, op := range ops {
+			t, err := tx.apply(ctx, op)
+			if err != nil && atomic {
+				return &BulkError{Index: i, Err: err}
+			}
+			results[i] = BulkResult{Todo: t, Err: err}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (s *service) apply(ctx context.Context, op BulkOp) (*Todo, error) {
+	switch op.Op {
+	case BulkCreate:
+		return s.Create(ctx, op.Todo)
+	case BulkUpdate:
+		in := op.Todo
+		in.Version = op.Version
+		return s.Update(ctx, op.ID, in)
+	case BulkDelete:
+		return nil, s.Delete(ctx, op.ID, op.Version)
+	}
+	return nil, fmt.Errorf("%w: unknown operation %q", ErrInputInvalid, op.Op)
+}
+
+// CompleteAll completes every uncompleted todo matching the filters of p, as
+// if each was updated on its own, and returns how many were completed. Paging
+// and sorting parameters are ignored.
+func (s *service) CompleteAll(ctx context.Context, p ListParams) (int, error) {
+	completed := false
+	p.Completed = &completed
+	ctx, err := s.forAll(ctx, p)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	err = s.transact(ctx, func(tx *service) error {
+		todos, err := tx.matching(ctx, p)
+		if err != nil {
+			return err
+		}
+
+		done := true
+		for _, t := range todos {
+			if _, err := tx.Update(ctx, t.ID, TodoInput{Completed: &done}); err != nil {
+				return err
+			}
+		}
+		n = len(todos)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// DeleteAll moves every todo matching the filters of p to the trash, along
+// with their subtrees, and returns how many matching todos were trashed.
+// Paging and sorting parameters are ignored.
+func (s *service) DeleteAll(ctx context.Context, p ListParams) (int, error) {
+	ctx, err := s.forAll(ctx, p)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	err = s.transact(ctx, func(tx *service) error {
+		todos, err := tx.matching(ctx, p)
+		if err != nil {
+			return err
+		}
+
+		for _, t := range todos {
+			err := tx.Delete(ctx, t.ID, nil)
+			if errors.Is(err, ErrTodoNotFound) {
+				// Already trashed along with an ancestor.
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// matching returns all the todos matching the filters of p.
+func (s *service) matching(ctx context.Context, p ListParams) ([]Todo, error) {
+	s.resolveDue(&p)
+	p.Limit, p.Offset, p.Cursor = 0, 0, ""
+	p.Sort = Sort{Field: SortByID}
+	return s.repo.List(ctx, p)
+}
+
+// forAll returns a copy of ctx acting on behalf of the owner of the todos of
+// the list p is filtered by, if any, for writing all the matching ones.
+func (s *service) forAll(ctx context.Context, p ListParams) (context.Context, error) {
+	if p.ListID == nil {
+		return ctx, nil
+	}
+	return s.forList(ctx, *p.ListID, RoleEditor)
+}
+
+// transact runs fn with a copy of the service whose repository operations
+// all take part in a single transaction. The events of the transaction are
+// published once it commits, and dropped if it rolls back. Within a
+// transaction, fn joins it.
+func (s *service) transact(ctx context.Context, fn func(tx *service) error) error {
+	if s.pending != nil {
+		return fn(s)
+	}
+
+	var pending []Event
+	err := s.repo.Transact(ctx, func(r Repository) error {
+		pending = nil
+		tx := *s
+		tx.repo = r
+		tx.pending = &pending
+		return fn(&tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	if s.events != nil {
+		for _, e := range pending {
+			s.events.Publish(e)
+		}
+	}
+	return nil
+}
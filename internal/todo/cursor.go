@@ -0,0 +1,101 @@
+package todo
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Cursor marks the boundary row of a keyset-paginated listing. A page fetched
+// with a cursor holds the rows strictly after (or, if Backward is set, strictly
+// before) the boundary row in the order given by Sort.
+type Cursor struct {
+	Sort     Sort      `json:"s"`
+	Value    time.Time `json:"v"` // sort column value of the boundary row; unused when sorting by id
+	ID       uint32    `json:"i"`
+	Backward bool      `json:"b,omitempty"`
+}
+
+// cursorCodec turns cursors into opaque tokens and back. Tokens are signed with
+// HMAC-SHA256 so clients can't forge or alter them.
+type cursorCodec struct {
+	secret []byte
+}
+
+// newCursorCodec returns a codec signing with secret, or with a random key if
+// secret is empty. It panics if no random key can be read, as the service
+// can't hand out cursors without one.
+func newCursorCodec(secret []byte) *cursorCodec {
+	if len(secret) == 0 {
+		// Tokens won't survive restarts or be shared across replicas, but
+		// that only forces clients back to the first page.
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(fmt.Sprintf("todo: generating cursor secret: %v", err))
+		}
+	}
+	return &cursorCodec{secret: secret}
+}
+
+func (c *cursorCodec) encode(cur Cursor) string {
+	payload, _ := json.Marshal(cur)
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(c.sign(payload))
+}
+
+func (c *cursorCodec) decode(token string) (*Cursor, error) {
+	enc := base64.RawURLEncoding
+
+	p, s, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrBadCursor
+	}
+	payload, err := enc.DecodeString(p)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	sig, err := enc.DecodeString(s)
+	if err != nil || !hmac.Equal(sig, c.sign(payload)) {
+		return nil, ErrBadCursor
+	}
+
+	var cur Cursor
+	if err := json.Unmarshal(payload, &cur); err != nil {
+		return nil, ErrBadCursor
+	}
+	return &cur, nil
+}
+
+func (c *cursorCodec) sign(payload []byte) []byte {
+	m := hmac.New(sha256.New, c.secret)
+	m.Write(payload)
+	return m.Sum(nil)
+}
+
+// keysetSortable reports whether listings sorted by field can be paged with
+// cursors. Priority ordering spans several nullable columns, so it only
+// supports offsets.
+func keysetSortable(field string) bool {
+	switch field {
+	case SortByID, SortByCreatedAt, SortByUpdatedAt:
+		return true
+	}
+	return false
+}
+
+// cursorAt returns a cursor with t as its boundary row.
+func cursorAt(t Todo, s Sort, backward bool) Cursor {
+	cur := Cursor{Sort: s, ID: t.ID, Backward: backward}
+	switch s.Field {
+	case SortByCreatedAt:
+		cur.Value = t.CreatedAt
+	case SortByUpdatedAt:
+		cur.Value = t.UpdatedAt
+	}
+	return cur
+}
@@ -0,0 +1,328 @@
+package todo
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Defaults of the dispatcher of webhook deliveries, unless configured
+// otherwise. A delivery is given up after DefaultWebhookMaxAttempts, and a
+// webhook disabled after DefaultWebhookFailureLimit failed attempts in a row.
+const (
+	DefaultWebhookMaxAttempts  = 10
+	DefaultWebhookFailureLimit = 50
+)
+
+const (
+	// Retries back off exponentially from webhookMinBackoff, doubling after
+	// every attempt up to webhookMaxBackoff.
+	webhookMinBackoff = 30 * time.Second
+	webhookMaxBackoff = 6 * time.Hour
+
+	webhookTimeout   = 10 * time.Second
+	webhookBatchSize = 20
+	// webhookLease holds a claimed delivery back from other dispatchers
+	// for longer than an attempt may take.
+	webhookLease = time.Minute
+)
+
+// Headers of the requests delivering webhooks.
+const (
+	HeaderWebhookEvent     = "X-2do-Event"
+	HeaderWebhookDelivery  = "X-2do-Delivery"
+	HeaderWebhookTimestamp = "X-2do-Timestamp"
+	HeaderWebhookSignature = "X-2do-Signature"
+)
+
+// SignWebhook returns the signature of a webhook delivered at timestamp, a
+// Unix time in seconds, with the given body: the hex-encoded HMAC-SHA256 of
+// the timestamp, a dot and the body, keyed with the secret of the webhook. It
+// is sent as "sha256=<signature>".
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook reports whether signature, the value of the signature header
+// of a delivery, matches its timestamp and body. Receivers should also reject
+// timestamps too far in the past, so that deliveries cannot be replayed.
+func VerifyWebhook(secret, timestamp, signature string, body []byte) bool {
+	sig, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(SignWebhook(secret, timestamp, body)))
+}
+
+// Dispatcher delivers the webhooks queued in the outbox, retrying failed
+// deliveries with exponential backoff.
+type Dispatcher struct {
+	repo         WebhookRepository
+	client       *http.Client
+	maxAttempts  int
+	failureLimit int
+	now          func() time.Time
+}
+
+// DispatcherOption configures optional behaviour of the dispatcher.
+type DispatcherOption func(*Dispatcher)
+
+// WithHTTPClient sets the client sending deliveries. It defaults to a client
+// giving up on a delivery after 10 seconds, which only connects to public
+// addresses and doesn't follow redirects.
+func WithHTTPClient(c *http.Client) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.client = c
+	}
+}
+
+// WithMaxAttempts sets the number of attempts after which a delivery is given
+// up. It defaults to DefaultWebhookMaxAttempts.
+func WithMaxAttempts(n int) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.maxAttempts = n
+	}
+}
+
+// WithFailureLimit sets the number of failed attempts in a row after which a
+// webhook is disabled. It defaults to DefaultWebhookFailureLimit.
+func WithFailureLimit(n int) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.failureLimit = n
+	}
+}
+
+// WithDispatcherClock replaces the source of the current time, which is used
+// to timestamp deliveries and schedule retries.
+func WithDispatcherClock(now func() time.Time) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.now = now
+	}
+}
+
+func NewDispatcher(r WebhookRepository, opts ...DispatcherOption) *Dispatcher {
+	d := &Dispatcher{
+		repo:         r,
+		client:       webhookClient(),
+		maxAttempts:  DefaultWebhookMaxAttempts,
+		failureLimit: DefaultWebhookFailureLimit,
+		now:          time.Now,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// errAddressNotPublic fails connections to addresses outside of the public
+// internet, which webhooks must not reach.
+var errAddressNotPublic = errors.New("address is not public")
+
+// webhookClient returns the default client delivering webhooks. It dials
+// every address itself, without a proxy, so that the addresses DNS resolves
+// to are checked at connection time, and it stops at redirects, which could
+// lead anywhere.
+func webhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout, Control: dialPublic}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		Timeout:   webhookTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// dialPublic is a dialer Control refusing connections to addresses which
+// aren't public.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !isPublic(ip) {
+		return fmt.Errorf("%w: %s", errAddressNotPublic, ip)
+	}
+	return nil
+}
+
+// nonPublic holds the special-purpose ranges of IANA, whose addresses aren't
+// reachable on the public internet or lead back into private networks.
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("192.88.99.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("::/96"),
+	netip.MustParsePrefix("::ffff:0:0/96"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001::/23"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("ff00::/8"),
+}
+
+// sixToFour is the range of 6to4 addresses, which embed an IPv4 address
+// after their first two bytes.
+var sixToFour = netip.MustParsePrefix("2002::/16")
+
+// isPublic reports whether ip is an address of the public internet, rather
+// than one of a special-purpose range. IPv4 addresses mapped to IPv6, and the
+// IPv4 addresses embedded in 6to4 addresses, are checked as IPv4 addresses.
+func isPublic(ip netip.Addr) bool {
+	ip = ip.Unmap().WithZone("")
+	if sixToFour.Contains(ip) {
+		b := ip.As16()
+		return isPublic(netip.AddrFrom4([4]byte{b[2], b[3], b[4], b[5]}))
+	}
+	for _, p := range nonPublic {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return ip.IsValid()
+}
+
+// Dispatch attempts a batch of due deliveries at once and returns how many
+// were attempted.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	pending, err := d.repo.Claim(ctx, webhookBatchSize, webhookLease)
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	for _, p := range pending {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a := d.attempt(ctx, p)
+			if err := d.repo.RecordAttempt(ctx, p, a, d.failureLimit); err != nil {
+				log.Printf("recording delivery %d failed: %v", p.ID, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return len(pending), nil
+}
+
+// Run dispatches the due deliveries right away and then every interval, until
+// ctx is done. Full batches are followed by the next one without waiting.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := d.Dispatch(ctx)
+		if err != nil {
+			log.Printf("dispatching webhooks failed: %v", err)
+		}
+		if err == nil && n == webhookBatchSize && ctx.Err() == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// attempt posts the payload of p to its webhook. Any 2xx response counts as
+// a success.
+func (d *Dispatcher) attempt(ctx context.Context, p PendingDelivery) DeliveryAttempt {
+	var a DeliveryAttempt
+	status, err := d.post(ctx, p)
+	a.Status = status
+	switch {
+	case err != nil:
+		a.Error = err.Error()
+	case status < 200 || status > 299:
+		a.Error = fmt.Sprintf("unexpected response status %d", status)
+	default:
+		a.Succeeded = true
+		return a
+	}
+
+	if attempts := p.Attempts + 1; attempts < d.maxAttempts {
+		retry := d.now().Add(backoff(attempts))
+		a.RetryAt = &retry
+	}
+	return a
+}
+
+func (d *Dispatcher) post(ctx context.Context, p PendingDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(p.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(d.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "2do-webhooks")
+	req.Header.Set(HeaderWebhookEvent, p.Event)
+	req.Header.Set(HeaderWebhookDelivery, strconv.FormatUint(p.ID, 10))
+	req.Header.Set(HeaderWebhookTimestamp, timestamp)
+	req.Header.Set(HeaderWebhookSignature, "sha256="+SignWebhook(p.Secret, timestamp, p.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain some of the body so that the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay before retrying a delivery after its n-th failed
+// attempt.
+func backoff(n int) time.Duration {
+	delay := webhookMinBackoff
+	for i := 1; i < n && delay < webhookMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookMaxBackoff)
+}
@@ -2,27 +2,175 @@ package todo
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 )
 
 var (
-	ErrTodoNotFound = errors.New("todo_not_found")
-	ErrInputInvalid = errors.New("input_invalid") //Placeholder - replace with more meaningful errors. e.g. todo_too_long
-	ErrUnexpected   = errors.New("unexpected")
+	ErrTodoNotFound      = errors.New("todo_not_found")
+	ErrTagNotFound       = errors.New("tag_not_found")
+	ErrTagExists         = errors.New("tag_exists")
+	ErrListNotFound      = errors.New("list_not_found")
+	ErrListDefault       = errors.New("list_is_default")
+	ErrWebhookNotFound   = errors.New("webhook_not_found")
+	ErrUserNotFound      = errors.New("user_not_found")
+	ErrUserExists        = errors.New("user_exists")
+	ErrIdentityExists    = errors.New("identity_exists")
+	ErrIdentityInvalid   = errors.New("identity_invalid")
+	ErrTokenNotFound     = errors.New("token_not_found")
+	ErrMemberNotFound    = errors.New("member_not_found")
+	ErrMemberExists      = errors.New("member_exists")
+	ErrInviteNotFound    = errors.New("invitation_not_found")
+	ErrForbidden         = errors.New("forbidden")
+	ErrWorkspaceNotFound = errors.New("workspace_not_found")
+	ErrQuotaExceeded     = errors.New("quota_exceeded")
+	ErrParentTrashed     = errors.New("parent_trashed")
+	ErrVersionMismatch   = errors.New("version_mismatch")
+	ErrInputInvalid      = errors.New("input_invalid")
+	ErrUnexpected        = errors.New("unexpected")
+	ErrBadCursor         = errors.New("bad_cursor")
+	ErrSyncConflict      = errors.New("sync_conflict")
+
+	ErrTextEmpty         = errors.New("text_empty")
+	ErrTodoTooShort      = errors.New("todo_too_short")
+	ErrTodoTooLong       = errors.New("todo_too_long")
+	ErrTextControlChars  = errors.New("text_control_characters")
+	ErrStartAfterDue     = errors.New("start_after_due")
+	ErrPriorityInvalid   = errors.New("priority_invalid")
+	ErrTagNameInvalid    = errors.New("tag_name_invalid")
+	ErrTagColorInvalid   = errors.New("tag_color_invalid")
+	ErrListNameInvalid   = errors.New("list_name_invalid")
+	ErrListColorInvalid  = errors.New("list_color_invalid")
+	ErrParentNotFound    = errors.New("parent_not_found")
+	ErrParentCycle       = errors.New("parent_cycle")
+	ErrDepthExceeded     = errors.New("depth_exceeded")
+	ErrRecurrenceInvalid = errors.New("recurrence_invalid")
+
+	ErrWebhookURLInvalid    = errors.New("webhook_url_invalid")
+	ErrWebhookEventsInvalid = errors.New("webhook_events_invalid")
+	ErrWebhookSecretInvalid = errors.New("webhook_secret_invalid")
+
+	ErrEmailInvalid    = errors.New("email_invalid")
+	ErrPasswordInvalid = errors.New("password_invalid")
+
+	ErrTokenNameInvalid   = errors.New("token_name_invalid")
+	ErrTokenScopesInvalid = errors.New("token_scopes_invalid")
+	ErrTokenExpiryInvalid = errors.New("token_expiry_invalid")
+
+	ErrRoleInvalid     = errors.New("role_invalid")
+	ErrInviteeNotFound = errors.New("invitee_not_found")
 )
 
 var (
 	ErrBadJson              = errors.New("bad_json")
 	ErrBadId                = errors.New("bad_id")
+	ErrBadQuery             = errors.New("bad_query")
 	ErrUnsupportedMediaType = errors.New("unsupported_media_type")
+	ErrMethodNotAllowed     = errors.New("method_not_allowed")
+	ErrBadIdempotencyKey    = errors.New("bad_idempotency_key")
+	ErrBadPatch             = errors.New("bad_patch")
+	ErrBadEventID           = errors.New("bad_event_id")
+	ErrBadSyncToken         = errors.New("bad_sync_token")
+
+	ErrPatchTestFailed = errors.New("patch_test_failed")
+	ErrReadOnlyField   = errors.New("read_only_field")
+
+	ErrIdempotencyKeyReused  = errors.New("idempotency_key_reused")
+	ErrIdempotencyInProgress = errors.New("idempotency_key_in_progress")
+
+	ErrUnauthenticated   = errors.New("unauthenticated")
+	ErrBadCredentials    = errors.New("bad_credentials")
+	ErrInsufficientScope = errors.New("insufficient_scope")
+	ErrWorkspaceRequired = errors.New("workspace_required")
 )
 
+// BulkError reports the operation which failed an all-or-nothing bulk
+// request, by its index in the request.
+type BulkError struct {
+	Index int
+	Err   error
+}
+
+func (e *BulkError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.Index, e.Err)
+}
+
+func (e *BulkError) Unwrap() error {
+	return e.Err
+}
+
+// ConflictError reports a change made by an offline client which was not
+// applied, because the todo changed on the server after it. Todo is the todo
+// as it is on the server, or nil if it was deleted.
+type ConflictError struct {
+	Todo *Todo
+}
+
+func (e *ConflictError) Error() string {
+	return ErrSyncConflict.Error()
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrSyncConflict
+}
+
+// ScopeError reports a request needing a scope its principal was not granted.
+type ScopeError struct {
+	Scope string
+}
+
+func (e *ScopeError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrInsufficientScope, e.Scope)
+}
+
+func (e *ScopeError) Unwrap() error {
+	return ErrInsufficientScope
+}
+
+// FieldError is the violation of a validation rule by a field of the input.
+// Rule is the error code of the violation.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+
+	err error
+}
+
+// ValidationError lists every violation of the validation rules by an input.
+// It matches ErrInputInvalid as well as the error of each violated rule.
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Unwrap() []error {
+	errs := []error{ErrInputInvalid}
+	for _, fe := range e.Errors {
+		errs = append(errs, fe.err)
+	}
+	return errs
+}
+
+// ErrorResponse is the legacy shape of error responses, which are now sent as
+// problem details (see Problem) unless the client asks for it.
 type ErrorResponse struct {
 	Error struct {
 		Code      string `json:"code"`
 		Message   string `json:"message,omitempty"`
 		Timestamp string `json:"timestamp"` //RFC3339
 	} `json:"error"`
+
+	// fields lists the invalid fields of the input, if any.
+	fields []FieldError
 }
 
 func NewErrorResponse(code, msg string) *ErrorResponse {
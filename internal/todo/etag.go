@@ -0,0 +1,57 @@
+package todo
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// etag returns the strong entity tag of a todo, derived from its version so
+// that it changes with every write.
+func etag(t *Todo) string {
+	return fmt.Sprintf(`"%d"`, t.Version)
+}
+
+// parseIfMatch reads the If-Match header of a conditional write and returns
+// the version the todo must be at, or nil if the header is absent or "*". Only
+// a single strong entity tag can be matched; anything else is an error since
+// it can never match the todo.
+func parseIfMatch(ctx *gin.Context) (*uint32, error) {
+	v := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	if v == "" || v == "*" {
+		return nil, nil
+	}
+
+	tag, err := strconv.Unquote(v)
+	if err != nil || !strings.HasPrefix(v, `"`) {
+		return nil, errors.New("If-Match must be \"*\" or a single strong ETag")
+	}
+
+	n, err := strconv.ParseUint(tag, 10, 32)
+	if err != nil {
+		return nil, errors.New("If-Match does not match any version of the todo")
+	}
+
+	version := uint32(n)
+	return &version, nil
+}
+
+// ifNoneMatch reports whether the If-None-Match header lists tag, or is "*".
+// Tags are compared weakly, as RFC 9110 requires for If-None-Match.
+func ifNoneMatch(ctx *gin.Context, tag string) bool {
+	v := strings.TrimSpace(ctx.GetHeader("If-None-Match"))
+	if v == "*" {
+		return true
+	}
+
+	for _, t := range strings.Split(v, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t != "" && t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package todo
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultEventBufferSize is the number of past events kept for clients
+// resuming a stream, unless configured otherwise.
+const DefaultEventBufferSize = 1000
+
+// Types of the events published when todos change.
+const (
+	EventTodoCreated = "todo.created"
+	EventTodoUpdated = "todo.updated"
+	EventTodoDeleted = "todo.deleted"
+)
+
+// Event describes a change to a todo. Created and updated events carry the
+// todo as it is after the change, and deleted events only its ID and the list
+// it was in. Events only reach the streams of the owner of the todo and of the
+// members of its list within its workspace.
+type Event struct {
+	ID          uint64    `json:"-"`
+	OwnerID     uint32    `json:"-"`
+	WorkspaceID uint32    `json:"-"`
+	MemberIDs   []uint32  `json:"-"`
+	Type        string    `json:"type"`
+	TodoID      uint32    `json:"id"`
+	ListID      uint32    `json:"list_id,omitempty"`
+	Todo        *Todo     `json:"todo,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// visibleTo reports whether user sees e from within workspace, either as the
+// owner of the todo or as a member of its list when the change was made.
+func (e Event) visibleTo(user, workspace uint32) bool {
+	if e.WorkspaceID != workspace {
+		return false
+	}
+	return e.OwnerID == user || slices.Contains(e.MemberIDs, user)
+}
+
+// EventBus fans out the events published by the service to the subscribed
+// streams, keeping the latest of them in a bounded buffer so that a client
+// can catch up on what it missed while reconnecting.
+//
+// Event IDs increase monotonically. They start at the time the bus is
+// created, in microseconds, so that IDs handed out by an earlier process are
+// below the IDs of a restarted one rather than mistaken for them.
+type EventBus struct {
+	mu     sync.Mutex
+	next   uint64
+	buffer []Event
+	start  int
+	subs   map[chan Event]struct{}
+	now    func() time.Time
+}
+
+// NewEventBus returns a bus remembering the last size events. A non-positive
+// size means DefaultEventBufferSize.
+func NewEventBus(size int) *EventBus {
+	if size <= 0 {
+		size = DefaultEventBufferSize
+	}
+	return &EventBus{
+		next:   uint64(time.Now().UnixMicro()),
+		buffer: make([]Event, 0, size),
+		subs:   make(map[chan Event]struct{}),
+		now:    time.Now,
+	}
+}
+
+// Publish assigns e the next ID and time and delivers it to every
+// subscriber. A subscriber too slow to keep up is dropped, closing its
+// channel, rather than holding up the others.
+func (b *EventBus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.next++
+	e.ID = b.next
+	e.Time = b.now().UTC()
+
+	if len(b.buffer) < cap(b.buffer) {
+		b.buffer = append(b.buffer, e)
+	} else {
+		b.buffer[b.start] = e
+		b.start = (b.start + 1) % len(b.buffer)
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscription is a stream of events from an EventBus.
+type Subscription struct {
+	// Replay holds the buffered events published after the event the
+	// client resumes from.
+	Replay []Event
+	// Missed is set if some events after the one the client resumes from
+	// are no longer buffered, in which case it has to start over from a
+	// fresh listing.
+	Missed bool
+	// LastID is the ID of the latest event published before the
+	// subscription started.
+	LastID uint64
+	// Events delivers the events published from then on. It is closed when
+	// the subscription is cancelled or falls too far behind.
+	Events <-chan Event
+
+	cancel func()
+}
+
+// Cancel stops the delivery of events.
+func (s *Subscription) Cancel() {
+	s.cancel()
+}
+
+// Subscribe starts delivering events until the subscription is cancelled.
+// With the ID of the last event a client has seen, the events it missed are
+// replayed first.
+func (b *EventBus) Subscribe(lastID *uint64) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &Subscription{LastID: b.next}
+	if lastID != nil {
+		var ok bool
+		sub.Replay, ok = b.since(*lastID)
+		sub.Missed = !ok
+	}
+
+	ch := make(chan Event, cap(b.buffer))
+	b.subs[ch] = struct{}{}
+	sub.Events = ch
+	sub.cancel = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+	return sub
+}
+
+// since returns the buffered events published after the event id, and
+// whether none were missed.
+func (b *EventBus) since(id uint64) ([]Event, bool) {
+	if id >= b.next {
+		// Nothing was published since, unless the ID is not one of ours.
+		return nil, id == b.next
+	}
+
+	var out []Event
+	for i := range b.buffer {
+		e := b.buffer[(b.start+i)%len(b.buffer)]
+		if e.ID > id {
+			out = append(out, e)
+		}
+	}
+	return out, len(out) > 0 && out[0].ID == id+1
+}
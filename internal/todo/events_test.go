@@ -0,0 +1,363 @@
+package todo_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+// readEvent reads the next event or comment block of a Server-Sent Events
+// stream, as its lines.
+func readEvent(r *bufio.Reader) []string {
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		Expect(err).NotTo(HaveOccurred())
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+// asUser is a middleware which serves every request on behalf of user id,
+// in place of authentication.
+func asUser(id uint32) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Request = ctx.Request.WithContext(WithUser(ctx.Request.Context(), id))
+		ctx.Next()
+	}
+}
+
+var _ = Describe("events", Label("events"), func() {
+	Describe("bus", func() {
+		var bus *EventBus
+
+		BeforeEach(func() {
+			bus = NewEventBus(3)
+		})
+
+		It("delivers published events to subscribers with increasing IDs", func() {
+			sub := bus.Subscribe(nil)
+			defer sub.Cancel()
+
+			bus.Publish(Event{Type: EventTodoCreated, TodoID: 1})
+			bus.Publish(Event{Type: EventTodoDeleted, TodoID: 1})
+
+			first := <-sub.Events
+			second := <-sub.Events
+			Expect(first).To(MatchFields(IgnoreExtras, Fields{"Type": Equal(EventTodoCreated), "TodoID": BeEquivalentTo(1)}))
+			Expect(first.ID).To(BeNumerically(">", sub.LastID))
+			Expect(second.ID).To(Equal(first.ID + 1))
+			Expect(second.Time).NotTo(BeZero())
+		})
+
+		It("replays the events published after the last one seen", func() {
+			sub := bus.Subscribe(nil)
+			bus.Publish(Event{Type: EventTodoCreated, TodoID: 1})
+			bus.Publish(Event{Type: EventTodoCreated, TodoID: 2})
+			bus.Publish(Event{Type: EventTodoCreated, TodoID: 3})
+			seen := (<-sub.Events).ID
+			sub.Cancel()
+
+			resumed := bus.Subscribe(&seen)
+			defer resumed.Cancel()
+			Expect(resumed.Missed).To(BeFalse())
+			Expect(resumed.Replay).To(HaveLen(2))
+			Expect(resumed.Replay[0].TodoID).To(BeEquivalentTo(2))
+			Expect(resumed.Replay[1].TodoID).To(BeEquivalentTo(3))
+		})
+
+		It("replays nothing to a client that is up to date", func() {
+			bus.Publish(Event{Type: EventTodoCreated, TodoID: 1})
+			last := bus.Subscribe(nil).LastID
+
+			sub := bus.Subscribe(&last)
+			Expect(sub.Missed).To(BeFalse())
+			Expect(sub.Replay).To(BeEmpty())
+		})
+
+		It("reports events that fell out of the buffer as missed", func() {
+			sub := bus.Subscribe(nil)
+			for id := uint32(1); id <= 5; id++ {
+				bus.Publish(Event{Type: EventTodoCreated, TodoID: id})
+			}
+			seen := (<-sub.Events).ID
+
+			Expect(bus.Subscribe(&seen).Missed).To(BeTrue())
+		})
+
+		It("reports IDs it never handed out as missed", func() {
+			stale := uint64(42)
+			Expect(bus.Subscribe(&stale).Missed).To(BeTrue())
+		})
+
+		It("drops subscribers that fall behind", func() {
+			sub := bus.Subscribe(nil)
+			for id := uint32(1); id <= 4; id++ {
+				bus.Publish(Event{Type: EventTodoCreated, TodoID: id})
+			}
+
+			Expect(sub.Events).To(HaveLen(3))
+			for range 3 {
+				<-sub.Events
+			}
+			Expect(sub.Events).To(BeClosed())
+			sub.Cancel()
+		})
+	})
+
+	Describe("service", func() {
+		var (
+			ctx  context.Context
+			repo *mockRepo
+			bus  *EventBus
+			sub  *Subscription
+			svc  Service
+		)
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			repo = &mockRepo{
+				createFn: func(ctx context.Context, in TodoInput) (*Todo, error) {
+					return &Todo{ID: 7, Text: *in.Text}, nil
+				},
+				updateFn: func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+					return &Todo{ID: id, Text: "updated", ListID: 2}, nil
+				},
+				getFn: func(ctx context.Context, id uint32) (*Todo, error) {
+					return &Todo{ID: id, ListID: 2}, nil
+				},
+			}
+			bus = NewEventBus(10)
+			sub = bus.Subscribe(nil)
+			DeferCleanup(sub.Cancel)
+			svc = NewService(repo, WithEventBus(bus))
+		})
+
+		It("publishes created, updated and deleted todos", func() {
+			text := "buy milk"
+			_, err := svc.Create(ctx, TodoInput{Text: &text})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = svc.Update(ctx, 7, TodoInput{Text: &text})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(svc.Delete(ctx, 7, nil)).To(Succeed())
+
+			Expect(<-sub.Events).To(MatchFields(IgnoreExtras, Fields{
+				"Type":   Equal(EventTodoCreated),
+				"TodoID": BeEquivalentTo(7),
+				"Todo":   PointTo(MatchFields(IgnoreExtras, Fields{"Text": Equal("buy milk")})),
+			}))
+			Expect(<-sub.Events).To(MatchFields(IgnoreExtras, Fields{"Type": Equal(EventTodoUpdated), "TodoID": BeEquivalentTo(7)}))
+			Expect(<-sub.Events).To(MatchFields(IgnoreExtras, Fields{"Type": Equal(EventTodoDeleted), "TodoID": BeEquivalentTo(7), "ListID": BeEquivalentTo(2), "Todo": BeNil()}))
+		})
+
+		It("publishes the todos a write affected besides its own", func() {
+			repo.affected = &Affected{Created: []Todo{{ID: 8, ListID: 2}}, Updated: []Todo{{ID: 9, ListID: 2}}}
+			done := true
+			_, err := svc.Update(ctx, 7, TodoInput{Completed: &done})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(<-sub.Events).To(MatchFields(IgnoreExtras, Fields{"Type": Equal(EventTodoUpdated), "TodoID": BeEquivalentTo(7)}))
+			Expect(<-sub.Events).To(MatchFields(IgnoreExtras, Fields{"Type": Equal(EventTodoCreated), "TodoID": BeEquivalentTo(8), "Todo": Not(BeNil())}))
+			Expect(<-sub.Events).To(MatchFields(IgnoreExtras, Fields{"Type": Equal(EventTodoUpdated), "TodoID": BeEquivalentTo(9), "Todo": Not(BeNil())}))
+
+			repo.affected = &Affected{Deleted: []Todo{{ID: 10, ListID: 3}}}
+			Expect(svc.Delete(ctx, 7, nil)).To(Succeed())
+
+			Expect(<-sub.Events).To(MatchFields(IgnoreExtras, Fields{"Type": Equal(EventTodoDeleted), "TodoID": BeEquivalentTo(7)}))
+			Expect(<-sub.Events).To(MatchFields(IgnoreExtras, Fields{"Type": Equal(EventTodoDeleted), "TodoID": BeEquivalentTo(10), "ListID": BeEquivalentTo(3), "Todo": BeNil()}))
+		})
+
+		It("publishes a deletion for every todo trashed with its list", func() {
+			repo.delListFn = func(ctx context.Context, id, into uint32) ([]Todo, error) {
+				Expect(into).To(BeEquivalentTo(DefaultListID))
+				return []Todo{{ID: 5, ListID: id}, {ID: 6, ListID: id}}, nil
+			}
+
+			Expect(svc.DeleteList(ctx, 4)).To(Succeed())
+
+			Expect(<-sub.Events).To(MatchFields(IgnoreExtras, Fields{"Type": Equal(EventTodoDeleted), "TodoID": BeEquivalentTo(5), "ListID": BeEquivalentTo(4)}))
+			Expect(<-sub.Events).To(MatchFields(IgnoreExtras, Fields{"Type": Equal(EventTodoDeleted), "TodoID": BeEquivalentTo(6), "ListID": BeEquivalentTo(4)}))
+		})
+
+		It("names the members of the list of a shared todo", func() {
+			shares := newMockShareRepo()
+			shares.owners[2] = testOwner
+			shares.members[2] = map[uint32]Role{8: RoleEditor}
+			shares.todos[7] = Todo{ID: 7, ListID: 2, OwnerID: testOwner}
+			repo.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+				return &Todo{ID: id, ListID: 2, OwnerID: testOwner}, nil
+			}
+			svc = NewService(repo, WithEventBus(bus), WithSharing(shares))
+
+			text := "buy milk"
+			_, err := svc.Update(WithUser(ctx, 8), 7, TodoInput{Text: &text})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(<-sub.Events).To(MatchFields(IgnoreExtras, Fields{
+				"Type":      Equal(EventTodoUpdated),
+				"OwnerID":   BeEquivalentTo(testOwner),
+				"MemberIDs": ConsistOf(BeEquivalentTo(8)),
+			}))
+		})
+
+		It("publishes nothing for failed operations", func() {
+			repo.deleteFn = func(ctx context.Context, id uint32, version *uint32) error {
+				return ErrTodoNotFound
+			}
+
+			Expect(svc.Delete(ctx, 7, nil)).To(MatchError(ErrTodoNotFound))
+			Expect(sub.Events).To(BeEmpty())
+		})
+
+		It("publishes the events of a bulk request once it commits", func() {
+			text := "buy milk"
+			_, err := svc.Bulk(ctx, []BulkOp{
+				{Op: BulkCreate, Todo: TodoInput{Text: &text}},
+				{Op: BulkDelete, ID: 3},
+			}, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(sub.Events).To(HaveLen(2))
+			Expect((<-sub.Events).Type).To(Equal(EventTodoCreated))
+			Expect((<-sub.Events).Type).To(Equal(EventTodoDeleted))
+		})
+
+		It("publishes nothing when a bulk request rolls back", func() {
+			text := "buy milk"
+			repo.deleteFn = func(ctx context.Context, id uint32, version *uint32) error {
+				return ErrTodoNotFound
+			}
+
+			_, err := svc.Bulk(ctx, []BulkOp{
+				{Op: BulkCreate, Todo: TodoInput{Text: &text}},
+				{Op: BulkDelete, ID: 3},
+			}, true)
+			var be *BulkError
+			Expect(errors.As(err, &be)).To(BeTrue())
+			Expect(sub.Events).To(BeEmpty())
+		})
+	})
+
+	Describe("stream", func() {
+		var (
+			bus    *EventBus
+			server *httptest.Server
+		)
+
+		connect := func(lastID string) (*http.Response, *bufio.Reader) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			DeferCleanup(cancel)
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/todos/events", nil)
+			Expect(err).NotTo(HaveOccurred())
+			if lastID != "" {
+				req.Header.Set("Last-Event-ID", lastID)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(resp.Body.Close)
+			return resp, bufio.NewReader(resp.Body)
+		}
+
+		BeforeEach(func() {
+			gin.SetMode(gin.TestMode)
+			bus = NewEventBus(10)
+			router := gin.New()
+			router.Use(asUser(testOwner))
+			h := NewHandler(&mockService{}, WithEventStream(bus, 50*time.Millisecond))
+			h.Register(router)
+			Expect(h.Streams()).To(ConsistOf("/todos/events", "/todos/ws"))
+			server = httptest.NewServer(router)
+			DeferCleanup(server.Close)
+		})
+
+		It("streams published events", func() {
+			resp, r := connect("")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+
+			bus.Publish(Event{Type: EventTodoUpdated, TodoID: 4, OwnerID: testOwner, Todo: &Todo{ID: 4, Text: "walk the dog"}})
+
+			lines := readEvent(r)
+			Expect(lines).To(HaveLen(3))
+			Expect(lines[0]).To(HavePrefix("id: "))
+			Expect(lines[1]).To(Equal("event: todo.updated"))
+			Expect(lines[2]).To(And(HavePrefix("data: "), ContainSubstring(`"type":"todo.updated"`), ContainSubstring(`"text":"walk the dog"`)))
+		})
+
+		It("leaves out the events of other users", func() {
+			_, r := connect("")
+
+			bus.Publish(Event{Type: EventTodoUpdated, TodoID: 3, OwnerID: testOwner + 1})
+			bus.Publish(Event{Type: EventTodoUpdated, TodoID: 4, OwnerID: testOwner})
+
+			lines := readEvent(r)
+			Expect(lines).To(HaveLen(3))
+			Expect(lines[2]).To(ContainSubstring(`"id":4`))
+		})
+
+		It("streams the events of the lists shared with the user", func() {
+			_, r := connect("")
+
+			bus.Publish(Event{Type: EventTodoUpdated, TodoID: 3, OwnerID: testOwner + 1, MemberIDs: []uint32{testOwner + 2}})
+			bus.Publish(Event{Type: EventTodoUpdated, TodoID: 4, OwnerID: testOwner + 1, MemberIDs: []uint32{testOwner}})
+
+			lines := readEvent(r)
+			Expect(lines).To(HaveLen(3))
+			Expect(lines[2]).To(ContainSubstring(`"id":4`))
+		})
+
+		It("leaves out the events of the user in other workspaces", func() {
+			_, r := connect("")
+
+			bus.Publish(Event{Type: EventTodoUpdated, TodoID: 3, OwnerID: testOwner, WorkspaceID: testWorkspace})
+			bus.Publish(Event{Type: EventTodoUpdated, TodoID: 4, OwnerID: testOwner})
+
+			lines := readEvent(r)
+			Expect(lines).To(HaveLen(3))
+			Expect(lines[2]).To(ContainSubstring(`"id":4`))
+		})
+
+		It("resumes after the last event ID", func() {
+			sub := bus.Subscribe(nil)
+			bus.Publish(Event{Type: EventTodoCreated, TodoID: 1, OwnerID: testOwner})
+			bus.Publish(Event{Type: EventTodoCreated, TodoID: 2, OwnerID: testOwner})
+			seen := (<-sub.Events).ID
+			sub.Cancel()
+
+			_, r := connect(strconv.FormatUint(seen, 10))
+			lines := readEvent(r)
+			Expect(lines[0]).To(Equal("id: " + strconv.FormatUint(seen+1, 10)))
+			Expect(lines[2]).To(ContainSubstring(`"id":2`))
+		})
+
+		It("tells clients to reload when events were missed", func() {
+			_, r := connect("42")
+			Expect(readEvent(r)).To(ConsistOf(HavePrefix("id: "), "event: reset", "data: {}"))
+		})
+
+		It("sends heartbeats while idle", func() {
+			_, r := connect("")
+			Expect(readEvent(r)).To(Equal([]string{": heartbeat"}))
+		})
+
+		It("rejects a malformed Last-Event-ID", func() {
+			resp, _ := connect("abc")
+			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
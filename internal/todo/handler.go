@@ -5,38 +5,138 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 )
 
-type Handler struct{ svc Service }
+type Handler struct {
+	svc            Service
+	idempotency    IdempotencyStore
+	idempotencyTTL time.Duration
+	events         *EventBus
+	heartbeat      time.Duration
+	upgrader       websocket.Upgrader
+}
 
-func NewHandler(s Service) *Handler {
-	return &Handler{svc: s}
+func NewHandler(s Service, opts ...HandlerOption) *Handler {
+	h := &Handler{svc: s}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
 func (h *Handler) Register(r gin.IRoutes) {
 	r.GET("/todos", h.getAll)
+	r.GET("/todos/search", h.search)
+	if h.events != nil {
+		r.GET("/todos/events", h.stream)
+		r.GET("/todos/ws", h.socket)
+	}
 	r.GET("/todos/:id", h.getById)
-	r.POST("/todos", h.post)
+	r.GET("/todos/:id/children", h.getChildren)
+	r.POST("/todos", h.idempotent, h.post)
+	r.POST("/todos/bulk", h.bulk)
+	r.POST("/todos/complete-all", h.completeAll)
+	r.DELETE("/todos", h.deleteAll)
 	r.PUT("/todos/:id", h.put)
 	r.PATCH("/todos/:id", h.patch)
 	r.DELETE("/todos/:id", h.delete)
+	r.POST("/todos/:id/restore", h.restore)
+	r.GET("/trash", h.getTrash)
+	r.DELETE("/trash", h.emptyTrash)
+	r.GET("/sync", h.pull)
+	r.POST("/sync", h.push)
+}
+
+// Scopes returns the scope API tokens need for each route, keyed by the
+// method followed by the path.
+func (h *Handler) Scopes() map[string]string {
+	return scopesByMethod(
+		"GET /todos", "GET /todos/search", "GET /todos/events", "GET /todos/ws",
+		"GET /todos/:id", "GET /todos/:id/children", "POST /todos", "POST /todos/bulk",
+		"POST /todos/complete-all", "DELETE /todos", "PUT /todos/:id", "PATCH /todos/:id",
+		"DELETE /todos/:id", "POST /todos/:id/restore", "GET /trash", "DELETE /trash",
+		"GET /sync", "POST /sync",
+	)
 }
 
 func (h *Handler) getAll(ctx *gin.Context) {
+	p, err := parseListParams(ctx)
+	if err != nil {
+		r := NewErrorResponse(ErrBadQuery.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	listTodos(ctx, h.svc, p)
+}
+
+// listTodos writes the page of todos matching p along with the neighbouring
+// cursors. The total count and the cursors are exposed in headers as well.
+func listTodos(ctx *gin.Context, svc Service, p ListParams) {
 	c := ctx.Request.Context()
+	page, err := svc.GetAll(c, p)
+	if err != nil {
+		if errors.Is(err, ErrBadCursor) {
+			msg := "cursor is malformed or does not match the requested sort"
+			r := NewErrorResponse(ErrBadCursor.Error(), msg)
+			writeError(ctx, http.StatusBadRequest, r)
+			return
+		}
+		// The list or parent filtered by isn't visible to the user.
+		if errors.Is(err, ErrListNotFound) {
+			msg := fmt.Sprintf("No list found with ID = %d", *p.ListID)
+			r := NewErrorResponse(ErrListNotFound.Error(), msg)
+			writeError(ctx, http.StatusNotFound, r)
+			return
+		}
+		if errors.Is(err, ErrTodoNotFound) {
+			msg := fmt.Sprintf("No resource found with ID = %d", *p.ParentID)
+			r := NewErrorResponse(ErrTodoNotFound.Error(), msg)
+			writeError(ctx, http.StatusNotFound, r)
+			return
+		}
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.Header("X-Total-Count", strconv.Itoa(page.Total))
+	if page.NextCursor != "" {
+		ctx.Header("X-Next-Cursor", page.NextCursor)
+	}
+	if page.PrevCursor != "" {
+		ctx.Header("X-Prev-Cursor", page.PrevCursor)
+	}
+	if page.Todos == nil {
+		page.Todos = []Todo{}
+	}
+	ctx.JSON(http.StatusOK, page)
+}
 
-	todos, err := h.svc.GetAll(c)
+func (h *Handler) search(ctx *gin.Context) {
+	p, err := parseSearchParams(ctx)
+	if err != nil {
+		r := NewErrorResponse(ErrBadQuery.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	results, err := h.svc.Search(c, p)
 	if err != nil {
 		r := NewErrorResponse(ErrUnexpected.Error(), "")
-		ctx.JSON(http.StatusInternalServerError, r)
+		writeError(ctx, http.StatusInternalServerError, r)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, todos)
+	ctx.JSON(http.StatusOK, results)
 }
 
 func (h *Handler) getById(ctx *gin.Context) {
@@ -44,7 +144,14 @@ func (h *Handler) getById(ctx *gin.Context) {
 	id, err := strconv.ParseUint(i, 10, 32)
 	if err != nil {
 		r := NewErrorResponse(ErrBadId.Error(), "ID must be an integer")
-		ctx.JSON(http.StatusBadRequest, r)
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	expand, err := parseExpand(ctx)
+	if err != nil {
+		r := NewErrorResponse(ErrBadQuery.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
 		return
 	}
 
@@ -54,21 +161,83 @@ func (h *Handler) getById(ctx *gin.Context) {
 		if errors.Is(err, ErrTodoNotFound) {
 			msg := fmt.Sprintf("No resource found with ID = %d", id)
 			r := NewErrorResponse(ErrTodoNotFound.Error(), msg)
-			ctx.JSON(http.StatusNotFound, r)
+			writeError(ctx, http.StatusNotFound, r)
 			return
 		}
 		r := NewErrorResponse(ErrUnexpected.Error(), "")
-		ctx.JSON(http.StatusInternalServerError, r)
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	if expand {
+		todos := []Todo{*t}
+		if err := h.svc.ExpandChildren(c, todos); err != nil {
+			r := NewErrorResponse(ErrUnexpected.Error(), "")
+			writeError(ctx, http.StatusInternalServerError, r)
+			return
+		}
+		t = &todos[0]
+		ctx.JSON(http.StatusOK, t)
+		return
+	}
+
+	// Only the todo itself carries an entity tag: its children change
+	// without bumping its version.
+	tag := etag(t)
+	ctx.Header("ETag", tag)
+	if ifNoneMatch(ctx, tag) {
+		ctx.Status(http.StatusNotModified)
 		return
 	}
 
 	ctx.JSON(http.StatusOK, t)
 }
 
+func (h *Handler) getChildren(ctx *gin.Context) {
+	i := ctx.Param("id")
+	id, err := strconv.ParseUint(i, 10, 32)
+	if err != nil {
+		r := NewErrorResponse(ErrBadId.Error(), "ID must be an integer")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	p, err := parseListParams(ctx)
+	if err != nil {
+		r := NewErrorResponse(ErrBadQuery.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	_, err = h.svc.GetById(c, uint32(id))
+	if err != nil {
+		if errors.Is(err, ErrTodoNotFound) {
+			msg := fmt.Sprintf("No resource found with ID = %d", id)
+			r := NewErrorResponse(ErrTodoNotFound.Error(), msg)
+			writeError(ctx, http.StatusNotFound, r)
+			return
+		}
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	parent := uint32(id)
+	p.ParentID = &parent
+	listTodos(ctx, h.svc, p)
+}
+
 func (h *Handler) post(ctx *gin.Context) {
+	createTodo(ctx, h.svc, nil)
+}
+
+// createTodo creates a todo from the request body. If listID is set, the todo
+// is created in that list regardless of the body's list_id.
+func createTodo(ctx *gin.Context, svc Service, listID *uint32) {
 	if ctx.ContentType() != "application/json" {
 		r := NewErrorResponse(ErrUnsupportedMediaType.Error(), "Content-Type must be application/json")
-		ctx.JSON(http.StatusUnsupportedMediaType, r)
+		writeError(ctx, http.StatusUnsupportedMediaType, r)
 		return
 	}
 
@@ -76,13 +245,13 @@ func (h *Handler) post(ctx *gin.Context) {
 	err := decodeIntoInput(ctx, &newTodo)
 	if err != nil {
 		r := NewErrorResponse(ErrBadJson.Error(), err.Error())
-		ctx.JSON(http.StatusBadRequest, r)
+		writeError(ctx, http.StatusBadRequest, r)
 		return
 	}
 
 	if newTodo.Text == nil {
 		r := NewErrorResponse(ErrBadJson.Error(), "missing required `text` field")
-		ctx.JSON(http.StatusBadRequest, r)
+		writeError(ctx, http.StatusBadRequest, r)
 		return
 	}
 
@@ -91,27 +260,53 @@ func (h *Handler) post(ctx *gin.Context) {
 		newTodo.Completed = &val
 	}
 
+	if newTodo.Priority == nil {
+		val := PriorityNone
+		newTodo.Priority = &val
+	}
+
+	if listID != nil {
+		newTodo.ListID = listID
+	}
+
 	c := ctx.Request.Context()
-	t, err := h.svc.Create(c, newTodo)
+	t, err := svc.Create(c, newTodo)
 	if err != nil {
-		if errors.Is(err, ErrInputInvalid) {
-			r := NewErrorResponse(ErrInputInvalid.Error(), err.Error())
-			ctx.JSON(http.StatusUnprocessableEntity, r)
+		if e := inputError(err); e != nil {
+			r := newInputErrorResponse(e, err)
+			writeError(ctx, http.StatusUnprocessableEntity, r)
+			return
+		}
+		if errors.Is(err, ErrListNotFound) && newTodo.ListID != nil {
+			msg := fmt.Sprintf("No list found with ID = %d", *newTodo.ListID)
+			r := NewErrorResponse(ErrListNotFound.Error(), msg)
+			writeError(ctx, http.StatusUnprocessableEntity, r)
+			return
+		}
+		if errors.Is(err, ErrForbidden) {
+			r := NewErrorResponse(ErrForbidden.Error(), forbiddenMsg)
+			writeError(ctx, http.StatusForbidden, r)
+			return
+		}
+		if errors.Is(err, ErrQuotaExceeded) {
+			r := NewErrorResponse(ErrQuotaExceeded.Error(), quotaExceededMsg)
+			writeError(ctx, http.StatusForbidden, r)
 			return
 		}
 		r := NewErrorResponse(ErrUnexpected.Error(), "")
-		ctx.JSON(http.StatusInternalServerError, r)
+		writeError(ctx, http.StatusInternalServerError, r)
 		return
 	}
 
 	ctx.Header("Location", fmt.Sprintf("/todos/%d", t.ID))
+	ctx.Header("ETag", etag(t))
 	ctx.JSON(http.StatusCreated, t)
 }
 
 func (h *Handler) put(ctx *gin.Context) {
 	if ctx.ContentType() != "application/json" {
 		r := NewErrorResponse(ErrUnsupportedMediaType.Error(), "Content-Type must be application/json")
-		ctx.JSON(http.StatusUnsupportedMediaType, r)
+		writeError(ctx, http.StatusUnsupportedMediaType, r)
 		return
 	}
 
@@ -119,7 +314,28 @@ func (h *Handler) put(ctx *gin.Context) {
 	id, err := strconv.ParseUint(i, 10, 32)
 	if err != nil {
 		r := NewErrorResponse(ErrBadId.Error(), "ID must be an integer")
-		ctx.JSON(http.StatusBadRequest, r)
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	cascade, err := parseCascade(ctx)
+	if err != nil {
+		r := NewErrorResponse(ErrBadQuery.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	series, err := parseScope(ctx)
+	if err != nil {
+		r := NewErrorResponse(ErrBadQuery.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	version, err := parseIfMatch(ctx)
+	if err != nil {
+		r := NewErrorResponse(ErrVersionMismatch.Error(), err.Error())
+		writeError(ctx, http.StatusPreconditionFailed, r)
 		return
 	}
 
@@ -127,42 +343,75 @@ func (h *Handler) put(ctx *gin.Context) {
 	err = decodeIntoInput(ctx, &updatedTodo)
 	if err != nil {
 		r := NewErrorResponse(ErrBadJson.Error(), err.Error())
-		ctx.JSON(http.StatusBadRequest, r)
+		writeError(ctx, http.StatusBadRequest, r)
 		return
 	}
 
 	if updatedTodo.Text == nil || updatedTodo.Completed == nil {
 		r := NewErrorResponse(ErrBadJson.Error(), "missing required `text` and `completed` fields")
-		ctx.JSON(http.StatusBadRequest, r)
+		writeError(ctx, http.StatusBadRequest, r)
 		return
 	}
+	updatedTodo.Cascade = cascade
+	updatedTodo.Series = series
+	updatedTodo.Version = version
 
 	c := ctx.Request.Context()
 	t, err := h.svc.Update(c, uint32(id), updatedTodo)
 	if err != nil {
-		if errors.Is(err, ErrInputInvalid) {
-			r := NewErrorResponse(ErrInputInvalid.Error(), err.Error())
-			ctx.JSON(http.StatusUnprocessableEntity, r)
+		if e := inputError(err); e != nil {
+			r := newInputErrorResponse(e, err)
+			writeError(ctx, http.StatusUnprocessableEntity, r)
+			return
+		}
+		if errors.Is(err, ErrListNotFound) && updatedTodo.ListID != nil {
+			msg := fmt.Sprintf("No list found with ID = %d", *updatedTodo.ListID)
+			r := NewErrorResponse(ErrListNotFound.Error(), msg)
+			writeError(ctx, http.StatusUnprocessableEntity, r)
 			return
 		}
 		if errors.Is(err, ErrTodoNotFound) {
 			msg := fmt.Sprintf("No resource found with ID = %d", id)
 			r := NewErrorResponse(ErrTodoNotFound.Error(), msg)
-			ctx.JSON(http.StatusNotFound, r)
+			writeError(ctx, http.StatusNotFound, r)
+			return
+		}
+		if errors.Is(err, ErrVersionMismatch) {
+			r := NewErrorResponse(ErrVersionMismatch.Error(), versionMismatchMsg)
+			writeError(ctx, http.StatusPreconditionFailed, r)
+			return
+		}
+		if errors.Is(err, ErrForbidden) {
+			r := NewErrorResponse(ErrForbidden.Error(), forbiddenMsg)
+			writeError(ctx, http.StatusForbidden, r)
+			return
+		}
+		if errors.Is(err, ErrQuotaExceeded) {
+			r := NewErrorResponse(ErrQuotaExceeded.Error(), quotaExceededMsg)
+			writeError(ctx, http.StatusForbidden, r)
 			return
 		}
 		r := NewErrorResponse(ErrUnexpected.Error(), "")
-		ctx.JSON(http.StatusInternalServerError, r)
+		writeError(ctx, http.StatusInternalServerError, r)
 		return
 	}
 
+	ctx.Header("ETag", etag(t))
 	ctx.JSON(http.StatusOK, t)
 }
 
+// patch updates a todo from a document of the fields to change, given as
+// plain JSON, as a JSON Merge Patch (RFC 7396) or as a JSON Patch (RFC 6902).
+// Patch documents apply to the todo as returned by GET, so they can clear
+// nullable fields, and the update fails if the todo changes meanwhile.
 func (h *Handler) patch(ctx *gin.Context) {
-	if ctx.ContentType() != "application/json" {
-		r := NewErrorResponse(ErrUnsupportedMediaType.Error(), "Content-Type must be application/json")
-		ctx.JSON(http.StatusUnsupportedMediaType, r)
+	mediaType := ctx.ContentType()
+	switch mediaType {
+	case mediaTypeJSON, mediaTypeMergePatch, mediaTypeJSONPatch:
+	default:
+		msg := "Content-Type must be application/json, application/merge-patch+json or application/json-patch+json"
+		r := NewErrorResponse(ErrUnsupportedMediaType.Error(), msg)
+		writeError(ctx, http.StatusUnsupportedMediaType, r)
 		return
 	}
 
@@ -170,43 +419,151 @@ func (h *Handler) patch(ctx *gin.Context) {
 	id, err := strconv.ParseUint(i, 10, 32)
 	if err != nil {
 		r := NewErrorResponse(ErrBadId.Error(), "ID must be an integer")
-		ctx.JSON(http.StatusBadRequest, r)
+		writeError(ctx, http.StatusBadRequest, r)
 		return
 	}
 
-	var updatedTodo TodoInput
-	err = decodeIntoInput(ctx, &updatedTodo)
+	cascade, err := parseCascade(ctx)
 	if err != nil {
-		r := NewErrorResponse(ErrBadJson.Error(), err.Error())
-		ctx.JSON(http.StatusBadRequest, r)
+		r := NewErrorResponse(ErrBadQuery.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
 		return
 	}
 
-	if updatedTodo.Text == nil && updatedTodo.Completed == nil {
-		msg := "missing required `text` or `completed` field"
-		r := NewErrorResponse(ErrBadJson.Error(), msg)
-		ctx.JSON(http.StatusBadRequest, r)
+	series, err := parseScope(ctx)
+	if err != nil {
+		r := NewErrorResponse(ErrBadQuery.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
 		return
 	}
+
+	version, err := parseIfMatch(ctx)
+	if err != nil {
+		r := NewErrorResponse(ErrVersionMismatch.Error(), err.Error())
+		writeError(ctx, http.StatusPreconditionFailed, r)
+		return
+	}
+
 	c := ctx.Request.Context()
+	mismatchMsg := versionMismatchMsg
+	var updatedTodo TodoInput
+	if mediaType == mediaTypeJSON {
+		err = decodeIntoInput(ctx, &updatedTodo)
+		if err != nil {
+			r := NewErrorResponse(ErrBadJson.Error(), err.Error())
+			writeError(ctx, http.StatusBadRequest, r)
+			return
+		}
+
+		if updatedTodo == (TodoInput{}) {
+			msg := "missing at least one field to update"
+			r := NewErrorResponse(ErrBadJson.Error(), msg)
+			writeError(ctx, http.StatusBadRequest, r)
+			return
+		}
+	} else {
+		body, err := io.ReadAll(ctx.Request.Body)
+		if err != nil {
+			r := NewErrorResponse(ErrBadJson.Error(), "invalid json input")
+			writeError(ctx, http.StatusBadRequest, r)
+			return
+		}
+
+		cur, err := h.svc.GetById(c, uint32(id))
+		if err != nil {
+			if errors.Is(err, ErrTodoNotFound) {
+				msg := fmt.Sprintf("No resource found with ID = %d", id)
+				r := NewErrorResponse(ErrTodoNotFound.Error(), msg)
+				writeError(ctx, http.StatusNotFound, r)
+				return
+			}
+			r := NewErrorResponse(ErrUnexpected.Error(), "")
+			writeError(ctx, http.StatusInternalServerError, r)
+			return
+		}
+		if version != nil && *version != cur.Version {
+			r := NewErrorResponse(ErrVersionMismatch.Error(), versionMismatchMsg)
+			writeError(ctx, http.StatusPreconditionFailed, r)
+			return
+		}
+
+		updatedTodo, err = patchTodo(cur, mediaType, body)
+		if err != nil {
+			var pe *patchError
+			if !errors.As(err, &pe) {
+				r := NewErrorResponse(ErrUnexpected.Error(), "")
+				writeError(ctx, http.StatusInternalServerError, r)
+				return
+			}
+			status := http.StatusUnprocessableEntity
+			switch pe.Err {
+			case ErrBadJson, ErrBadPatch:
+				status = http.StatusBadRequest
+			case ErrPatchTestFailed:
+				status = http.StatusConflict
+			}
+			r := NewErrorResponse(pe.Err.Error(), pe.Msg)
+			writeError(ctx, status, r)
+			return
+		}
+
+		// A patch leaving the todo as it is changes nothing.
+		if updatedTodo == (TodoInput{}) {
+			ctx.Header("ETag", etag(cur))
+			ctx.JSON(http.StatusOK, cur)
+			return
+		}
+
+		// The patch applies to the todo as it is now.
+		if version == nil {
+			version = &cur.Version
+			mismatchMsg = "The todo has been modified while the patch was applied"
+		}
+	}
+	updatedTodo.Cascade = cascade
+	updatedTodo.Series = series
+	updatedTodo.Version = version
+
 	t, err := h.svc.Update(c, uint32(id), updatedTodo)
 	if err != nil {
-		if errors.Is(err, ErrInputInvalid) {
-			r := NewErrorResponse(ErrInputInvalid.Error(), err.Error())
-			ctx.JSON(http.StatusUnprocessableEntity, r)
+		if e := inputError(err); e != nil {
+			r := newInputErrorResponse(e, err)
+			writeError(ctx, http.StatusUnprocessableEntity, r)
+			return
+		}
+		if errors.Is(err, ErrListNotFound) && updatedTodo.ListID != nil {
+			msg := fmt.Sprintf("No list found with ID = %d", *updatedTodo.ListID)
+			r := NewErrorResponse(ErrListNotFound.Error(), msg)
+			writeError(ctx, http.StatusUnprocessableEntity, r)
 			return
 		}
 		if errors.Is(err, ErrTodoNotFound) {
 			msg := fmt.Sprintf("No resource found with ID = %d", id)
 			r := NewErrorResponse(ErrTodoNotFound.Error(), msg)
-			ctx.JSON(http.StatusNotFound, r)
+			writeError(ctx, http.StatusNotFound, r)
+			return
+		}
+		if errors.Is(err, ErrVersionMismatch) {
+			r := NewErrorResponse(ErrVersionMismatch.Error(), mismatchMsg)
+			writeError(ctx, http.StatusPreconditionFailed, r)
+			return
+		}
+		if errors.Is(err, ErrForbidden) {
+			r := NewErrorResponse(ErrForbidden.Error(), forbiddenMsg)
+			writeError(ctx, http.StatusForbidden, r)
+			return
+		}
+		if errors.Is(err, ErrQuotaExceeded) {
+			r := NewErrorResponse(ErrQuotaExceeded.Error(), quotaExceededMsg)
+			writeError(ctx, http.StatusForbidden, r)
 			return
 		}
 		r := NewErrorResponse(ErrUnexpected.Error(), "")
-		ctx.JSON(http.StatusInternalServerError, r)
+		writeError(ctx, http.StatusInternalServerError, r)
 		return
 	}
 
+	ctx.Header("ETag", etag(t))
 	ctx.JSON(http.StatusOK, t)
 }
 
@@ -215,33 +572,379 @@ func (h *Handler) delete(ctx *gin.Context) {
 	id, err := strconv.ParseUint(i, 10, 32)
 	if err != nil {
 		r := NewErrorResponse(ErrBadId.Error(), "ID must be an integer")
-		ctx.JSON(http.StatusBadRequest, r)
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	version, err := parseIfMatch(ctx)
+	if err != nil {
+		r := NewErrorResponse(ErrVersionMismatch.Error(), err.Error())
+		writeError(ctx, http.StatusPreconditionFailed, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	err = h.svc.Delete(c, uint32(id), version)
+	if err != nil {
+		if errors.Is(err, ErrTodoNotFound) {
+			msg := fmt.Sprintf("No resource found with ID = %d", id)
+			r := NewErrorResponse(ErrTodoNotFound.Error(), msg)
+			writeError(ctx, http.StatusNotFound, r)
+			return
+		}
+		if errors.Is(err, ErrVersionMismatch) {
+			r := NewErrorResponse(ErrVersionMismatch.Error(), versionMismatchMsg)
+			writeError(ctx, http.StatusPreconditionFailed, r)
+			return
+		}
+		if errors.Is(err, ErrForbidden) {
+			r := NewErrorResponse(ErrForbidden.Error(), forbiddenMsg)
+			writeError(ctx, http.StatusForbidden, r)
+			return
+		}
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.JSON(http.StatusNoContent, nil)
+}
+
+// bulkRequest is the body of POST /todos/bulk. Requests are all-or-nothing
+// unless atomic is set to false.
+type bulkRequest struct {
+	Atomic     *bool    `json:"atomic"`
+	Operations []BulkOp `json:"operations"`
+}
+
+// bulkItem reports the outcome of one operation of a bulk request, with the
+// status code it would have got as a request of its own and the problem
+// details of its error, if any.
+type bulkItem struct {
+	Status int      `json:"status"`
+	Todo   *Todo    `json:"todo,omitempty"`
+	Error  *Problem `json:"error,omitempty"`
+}
+
+func (h *Handler) bulk(ctx *gin.Context) {
+	if ctx.ContentType() != "application/json" {
+		r := NewErrorResponse(ErrUnsupportedMediaType.Error(), "Content-Type must be application/json")
+		writeError(ctx, http.StatusUnsupportedMediaType, r)
+		return
+	}
+
+	var req bulkRequest
+	decoder := json.NewDecoder(ctx.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil || decoder.More() {
+		r := NewErrorResponse(ErrBadJson.Error(), "invalid json input")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	if len(req.Operations) == 0 || len(req.Operations) > MaxBulkOps {
+		msg := fmt.Sprintf("`operations` must hold between 1 and %d operations", MaxBulkOps)
+		r := NewErrorResponse(ErrBadJson.Error(), msg)
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	for i := range req.Operations {
+		if err := checkBulkOp(&req.Operations[i]); err != nil {
+			msg := fmt.Sprintf("operations[%d]: %v", i, err)
+			r := NewErrorResponse(ErrBadJson.Error(), msg)
+			writeError(ctx, http.StatusBadRequest, r)
+			return
+		}
+	}
+
+	atomic := req.Atomic == nil || *req.Atomic
+
+	c := ctx.Request.Context()
+	results, err := h.svc.Bulk(c, req.Operations, atomic)
+	if err != nil {
+		var be *BulkError
+		if errors.As(err, &be) {
+			op := req.Operations[be.Index]
+			status, r := todoError(be.Err, op.ID, op.Todo)
+			r.Error.Message = fmt.Sprintf("operations[%d]: %s", be.Index, r.Error.Message)
+			writeError(ctx, status, r)
+			return
+		}
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	items := make([]bulkItem, len(results))
+	for i, res := range results {
+		op := req.Operations[i]
+		if res.Err != nil {
+			status, r := todoError(res.Err, op.ID, op.Todo)
+			items[i] = bulkItem{Status: status, Error: newProblem(status, r, ctx.Request.URL.Path)}
+			continue
+		}
+		switch op.Op {
+		case BulkCreate:
+			items[i] = bulkItem{Status: http.StatusCreated, Todo: res.Todo}
+		case BulkUpdate:
+			items[i] = bulkItem{Status: http.StatusOK, Todo: res.Todo}
+		case BulkDelete:
+			items[i] = bulkItem{Status: http.StatusNoContent}
+		}
+	}
+
+	ctx.JSON(http.StatusOK, items)
+}
+
+// checkBulkOp verifies that op is complete, filling in the defaults of the
+// todo to create like POST /todos does.
+func checkBulkOp(op *BulkOp) error {
+	switch op.Op {
+	case BulkCreate:
+		if op.Todo.Text == nil {
+			return errors.New("missing required `text` field")
+		}
+		if op.Todo.Completed == nil {
+			val := false
+			op.Todo.Completed = &val
+		}
+		if op.Todo.Priority == nil {
+			val := PriorityNone
+			op.Todo.Priority = &val
+		}
+	case BulkUpdate:
+		if op.ID == 0 {
+			return errors.New("missing required `id` field")
+		}
+		if op.Todo == (TodoInput{}) {
+			return errors.New("missing at least one field to update")
+		}
+	case BulkDelete:
+		if op.ID == 0 {
+			return errors.New("missing required `id` field")
+		}
+	default:
+		return fmt.Errorf("`op` must be one of %s, %s, %s", BulkCreate, BulkUpdate, BulkDelete)
+	}
+	return nil
+}
+
+// todoError returns the status code and response reporting err, the error of
+// a write to todo id with the given input.
+func todoError(err error, id uint32, in TodoInput) (int, *ErrorResponse) {
+	if e := inputError(err); e != nil {
+		return http.StatusUnprocessableEntity, newInputErrorResponse(e, err)
+	}
+	if errors.Is(err, ErrListNotFound) && in.ListID != nil {
+		msg := fmt.Sprintf("No list found with ID = %d", *in.ListID)
+		return http.StatusUnprocessableEntity, NewErrorResponse(ErrListNotFound.Error(), msg)
+	}
+	if errors.Is(err, ErrTodoNotFound) {
+		msg := fmt.Sprintf("No resource found with ID = %d", id)
+		return http.StatusNotFound, NewErrorResponse(ErrTodoNotFound.Error(), msg)
+	}
+	if errors.Is(err, ErrVersionMismatch) {
+		return http.StatusPreconditionFailed, NewErrorResponse(ErrVersionMismatch.Error(), versionMismatchMsg)
+	}
+	if errors.Is(err, ErrForbidden) {
+		return http.StatusForbidden, NewErrorResponse(ErrForbidden.Error(), forbiddenMsg)
+	}
+	if errors.Is(err, ErrQuotaExceeded) {
+		return http.StatusForbidden, NewErrorResponse(ErrQuotaExceeded.Error(), quotaExceededMsg)
+	}
+	return http.StatusInternalServerError, NewErrorResponse(ErrUnexpected.Error(), "")
+}
+
+func (h *Handler) completeAll(ctx *gin.Context) {
+	p, err := parseListParams(ctx)
+	if err != nil {
+		r := NewErrorResponse(ErrBadQuery.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	n, err := h.svc.CompleteAll(c, p)
+	if err != nil {
+		if errors.Is(err, ErrListNotFound) {
+			msg := fmt.Sprintf("No list found with ID = %d", *p.ListID)
+			r := NewErrorResponse(ErrListNotFound.Error(), msg)
+			writeError(ctx, http.StatusNotFound, r)
+			return
+		}
+		if errors.Is(err, ErrForbidden) {
+			r := NewErrorResponse(ErrForbidden.Error(), forbiddenMsg)
+			writeError(ctx, http.StatusForbidden, r)
+			return
+		}
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"completed": n})
+}
+
+func (h *Handler) deleteAll(ctx *gin.Context) {
+	p, err := parseListParams(ctx)
+	if err != nil {
+		r := NewErrorResponse(ErrBadQuery.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	// Guards against trashing every todo by mistake.
+	if p.Completed == nil || !*p.Completed {
+		r := NewErrorResponse(ErrBadQuery.Error(), "`completed=true` is required")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	n, err := h.svc.DeleteAll(c, p)
+	if err != nil {
+		if errors.Is(err, ErrListNotFound) {
+			msg := fmt.Sprintf("No list found with ID = %d", *p.ListID)
+			r := NewErrorResponse(ErrListNotFound.Error(), msg)
+			writeError(ctx, http.StatusNotFound, r)
+			return
+		}
+		if errors.Is(err, ErrForbidden) {
+			r := NewErrorResponse(ErrForbidden.Error(), forbiddenMsg)
+			writeError(ctx, http.StatusForbidden, r)
+			return
+		}
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"deleted": n})
+}
+
+func (h *Handler) restore(ctx *gin.Context) {
+	i := ctx.Param("id")
+	id, err := strconv.ParseUint(i, 10, 32)
+	if err != nil {
+		r := NewErrorResponse(ErrBadId.Error(), "ID must be an integer")
+		writeError(ctx, http.StatusBadRequest, r)
 		return
 	}
 
 	c := ctx.Request.Context()
-	err = h.svc.Delete(c, uint32(id))
+	t, err := h.svc.Restore(c, uint32(id))
 	if err != nil {
 		if errors.Is(err, ErrTodoNotFound) {
 			msg := fmt.Sprintf("No resource found with ID = %d", id)
 			r := NewErrorResponse(ErrTodoNotFound.Error(), msg)
-			ctx.JSON(http.StatusNotFound, r)
+			writeError(ctx, http.StatusNotFound, r)
+			return
+		}
+		if errors.Is(err, ErrParentTrashed) {
+			r := NewErrorResponse(ErrParentTrashed.Error(), "The parent of the todo is in the trash and must be restored first")
+			writeError(ctx, http.StatusConflict, r)
+			return
+		}
+		if errors.Is(err, ErrForbidden) {
+			r := NewErrorResponse(ErrForbidden.Error(), forbiddenMsg)
+			writeError(ctx, http.StatusForbidden, r)
 			return
 		}
 		r := NewErrorResponse(ErrUnexpected.Error(), "")
-		ctx.JSON(http.StatusInternalServerError, r)
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.Header("ETag", etag(t))
+	ctx.JSON(http.StatusOK, t)
+}
+
+func (h *Handler) getTrash(ctx *gin.Context) {
+	p, err := parseListParams(ctx)
+	if err != nil {
+		r := NewErrorResponse(ErrBadQuery.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	p.Trashed = true
+	listTodos(ctx, h.svc, p)
+}
+
+func (h *Handler) emptyTrash(ctx *gin.Context) {
+	c := ctx.Request.Context()
+	err := h.svc.EmptyTrash(c)
+	if err != nil {
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
 		return
 	}
 
 	ctx.JSON(http.StatusNoContent, nil)
 }
 
+// versionMismatchMsg is reported when the If-Match header of a write names a
+// version other than the todo's current one.
+const versionMismatchMsg = "The todo has been modified since the ETag in If-Match was issued"
+
+// forbiddenMsg is reported when the role of the user on a shared list doesn't
+// allow a write to it.
+const forbiddenMsg = "The role of the user on the list doesn't allow this"
+
+// quotaExceededMsg is reported when a write would create a todo in a
+// workspace already holding as many as it may.
+const quotaExceededMsg = "The workspace holds as many todos as its quota allows"
+
+// inputErrors are the service errors caused by well-formed but unacceptable
+// input. They are reported as 422 Unprocessable Entity, with the code of the
+// first one matching. ErrInputInvalid comes last since a ValidationError
+// matches it along with the errors of the rules it lists.
+var inputErrors = []error{
+	ErrTextEmpty,
+	ErrTodoTooShort,
+	ErrTodoTooLong,
+	ErrTextControlChars,
+	ErrStartAfterDue,
+	ErrPriorityInvalid,
+	ErrTagNameInvalid,
+	ErrTagColorInvalid,
+	ErrListNameInvalid,
+	ErrListColorInvalid,
+	ErrParentNotFound,
+	ErrParentCycle,
+	ErrDepthExceeded,
+	ErrRecurrenceInvalid,
+	ErrWebhookURLInvalid,
+	ErrWebhookEventsInvalid,
+	ErrWebhookSecretInvalid,
+	ErrEmailInvalid,
+	ErrPasswordInvalid,
+	ErrTokenNameInvalid,
+	ErrTokenScopesInvalid,
+	ErrTokenExpiryInvalid,
+	ErrRoleInvalid,
+	ErrInviteeNotFound,
+	ErrInputInvalid,
+}
+
+// inputError returns the entry of inputErrors matching err, or nil if err
+// isn't an input error.
+func inputError(err error) error {
+	for _, e := range inputErrors {
+		if errors.Is(err, e) {
+			return e
+		}
+	}
+	return nil
+}
+
 // decodeIntoInput decodes the JSON request body from the provided gin.Context
-// into the given TodoInput struct. It returns an error if the input is not valid
-// JSON, contains unknown fields, contains fields explicitly set to null, or if
-// there is extra data after the first JSON object. The function has the side
-// effect of populating the provided TodoInput argument with the decoded data.
-func decodeIntoInput(ctx *gin.Context, t *TodoInput) error {
+// into the given input struct (e.g. TodoInput). It returns an error if the input
+// is not valid JSON, contains unknown fields, contains fields explicitly set to
+// null, or if there is extra data after the first JSON object. The function has
+// the side effect of populating the provided argument with the decoded data.
+func decodeIntoInput(ctx *gin.Context, t any) error {
 	// Decode into a map to check for explicit nulls
 	var raw map[string]*json.RawMessage
 	if err := ctx.ShouldBindJSON(&raw); err != nil {
@@ -8,29 +8,41 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
 
 	. "github.com/anas-salha/2do/internal/todo"
 )
 
 type mockService struct {
-	getAllFn  func(context.Context) ([]Todo, error)
+	getAllFn  func(context.Context, ListParams) (*Page, error)
 	getByIDFn func(context.Context, uint32) (*Todo, error)
 	createFn  func(context.Context, TodoInput) (*Todo, error)
 	updateFn  func(context.Context, uint32, TodoInput) (*Todo, error)
-	deleteFn  func(context.Context, uint32) error
+	deleteFn  func(context.Context, uint32, *uint32) error
+	restoreFn func(context.Context, uint32) (*Todo, error)
+	emptyFn   func(context.Context) error
+	expandFn  func(context.Context, []Todo) error
+	searchFn  func(context.Context, SearchParams) ([]SearchResult, error)
+	bulkFn    func(context.Context, []BulkOp, bool) ([]BulkResult, error)
+	complFn   func(context.Context, ListParams) (int, error)
+	delAllFn  func(context.Context, ListParams) (int, error)
+	delListFn func(context.Context, uint32) error
+	pullFn    func(context.Context, string, int) (*ChangeSet, error)
+	pushFn    func(context.Context, []SyncChange) ([]BulkResult, error)
 }
 
 var _ Service = (*mockService)(nil)
 
-func (m *mockService) GetAll(ctx context.Context) ([]Todo, error) {
+func (m *mockService) GetAll(ctx context.Context, p ListParams) (*Page, error) {
 	if m.getAllFn != nil {
-		return m.getAllFn(ctx)
+		return m.getAllFn(ctx, p)
 	}
-	return nil, nil
+	return &Page{}, nil
 }
 
 func (m *mockService) GetById(ctx context.Context, id uint32) (*Todo, error) {
@@ -54,13 +66,83 @@ func (m *mockService) Update(ctx context.Context, id uint32, in TodoInput) (*Tod
 	return nil, nil
 }
 
-func (m *mockService) Delete(ctx context.Context, id uint32) error {
+func (m *mockService) Delete(ctx context.Context, id uint32, version *uint32) error {
 	if m.deleteFn != nil {
-		return m.deleteFn(ctx, id)
+		return m.deleteFn(ctx, id, version)
+	}
+	return nil
+}
+
+func (m *mockService) Restore(ctx context.Context, id uint32) (*Todo, error) {
+	if m.restoreFn != nil {
+		return m.restoreFn(ctx, id)
+	}
+	return &Todo{ID: id}, nil
+}
+
+func (m *mockService) EmptyTrash(ctx context.Context) error {
+	if m.emptyFn != nil {
+		return m.emptyFn(ctx)
 	}
 	return nil
 }
 
+func (m *mockService) ExpandChildren(ctx context.Context, todos []Todo) error {
+	if m.expandFn != nil {
+		return m.expandFn(ctx, todos)
+	}
+	return nil
+}
+
+func (m *mockService) Search(ctx context.Context, p SearchParams) ([]SearchResult, error) {
+	if m.searchFn != nil {
+		return m.searchFn(ctx, p)
+	}
+	return []SearchResult{}, nil
+}
+
+func (m *mockService) Bulk(ctx context.Context, ops []BulkOp, atomic bool) ([]BulkResult, error) {
+	if m.bulkFn != nil {
+		return m.bulkFn(ctx, ops, atomic)
+	}
+	return make([]BulkResult, len(ops)), nil
+}
+
+func (m *mockService) CompleteAll(ctx context.Context, p ListParams) (int, error) {
+	if m.complFn != nil {
+		return m.complFn(ctx, p)
+	}
+	return 0, nil
+}
+
+func (m *mockService) DeleteAll(ctx context.Context, p ListParams) (int, error) {
+	if m.delAllFn != nil {
+		return m.delAllFn(ctx, p)
+	}
+	return 0, nil
+}
+
+func (m *mockService) DeleteList(ctx context.Context, id uint32) error {
+	if m.delListFn != nil {
+		return m.delListFn(ctx, id)
+	}
+	return nil
+}
+
+func (m *mockService) Pull(ctx context.Context, token string, limit int) (*ChangeSet, error) {
+	if m.pullFn != nil {
+		return m.pullFn(ctx, token, limit)
+	}
+	return &ChangeSet{}, nil
+}
+
+func (m *mockService) Push(ctx context.Context, changes []SyncChange) ([]BulkResult, error) {
+	if m.pushFn != nil {
+		return m.pushFn(ctx, changes)
+	}
+	return make([]BulkResult, len(changes)), nil
+}
+
 var _ = Describe("handler", Label("handler"), func() {
 	var (
 		svc    *mockService
@@ -79,30 +161,199 @@ var _ = Describe("handler", Label("handler"), func() {
 	Describe("GET /todos", Label("get-all"), func() {
 		It("Verifies happy path", func() {
 			expected := []Todo{{ID: 1, Text: "walk the dog"}, {ID: 2, Text: "feed the cat"}}
-			svc.getAllFn = func(ctx context.Context) ([]Todo, error) { return expected, nil }
+			svc.getAllFn = func(ctx context.Context, p ListParams) (*Page, error) {
+				Expect(p).To(Equal(ListParams{Sort: Sort{Field: SortByID}}))
+				return &Page{Todos: expected, Total: 12}, nil
+			}
 
 			req := httptest.NewRequest(http.MethodGet, "/todos", nil)
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Header().Get("X-Total-Count")).To(Equal("12"))
 
-			var out []Todo
+			var out Page
 			Expect(json.Unmarshal(rr.Body.Bytes(), &out)).To(Succeed())
-			Expect(out).To(Equal(expected))
+			Expect(out.Todos).To(Equal(expected))
+		})
+
+		It("Passes filters, sorting and paging to the service", func() {
+			svc.getAllFn = func(ctx context.Context, p ListParams) (*Page, error) {
+				Expect(p.Completed).To(PointTo(BeTrue()))
+				Expect(p.CreatedAfter).To(PointTo(BeTemporally("==", time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC))))
+				Expect(p.CreatedBefore).To(BeNil())
+				Expect(p.UpdatedBefore).To(PointTo(BeTemporally("==", time.Date(2025, 9, 20, 15, 0, 0, 0, time.UTC))))
+				Expect(p.Sort).To(Equal(Sort{Field: SortByUpdatedAt, Desc: true}))
+				Expect(p.Limit).To(Equal(10))
+				Expect(p.Offset).To(Equal(20))
+				return &Page{}, nil
+			}
+
+			url := "/todos?completed=true&created_after=2025-09-01T00:00:00Z&updated_before=2025-09-20T15:00:00Z&sort=-updated_at&limit=10&offset=20"
+			req := httptest.NewRequest(http.MethodGet, url, nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Header().Get("X-Total-Count")).To(Equal("0"))
+		})
+
+		DescribeTable("Reports bad request for malformed query parameters",
+			func(query string) {
+				req := httptest.NewRequest(http.MethodGet, "/todos?"+query, nil)
+				router.ServeHTTP(rr, req)
+
+				Expect(rr.Code).To(Equal(http.StatusBadRequest))
+				var resp Problem
+				Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+				Expect(resp.Code).To(Equal(ErrBadQuery.Error()))
+			},
+			Entry("completed", "completed=maybe"),
+			Entry("created_after", "created_after=yesterday"),
+			Entry("updated_before", "updated_before=2025-09-20"),
+			Entry("sort field", "sort=text"),
+			Entry("zero limit", "limit=0"),
+			Entry("limit above max", fmt.Sprintf("limit=%d", MaxLimit+1)),
+			Entry("negative offset", "offset=-1"),
+			Entry("cursor with offset", "cursor=abc&offset=5"),
+			Entry("due window", "due=tomorrow"),
+			Entry("overdue and completed", "due=overdue&completed=true"),
+			Entry("time zone", "due=today&tz=Mars/Olympus_Mons"),
+			Entry("empty tag", "tag="),
+			Entry("tag mode", "tag=home&tag_mode=some"),
+			Entry("list", "list_id=inbox"),
+			Entry("expand", "expand=parent"),
+			Entry("series", "series_id=weekly"),
+		)
+
+		It("Passes the list filter to the service", func() {
+			svc.getAllFn = func(ctx context.Context, p ListParams) (*Page, error) {
+				Expect(p.ListID).To(PointTo(BeEquivalentTo(3)))
+				return &Page{}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/todos?list_id=3", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+		})
+
+		It("Passes the tag filter to the service", func() {
+			svc.getAllFn = func(ctx context.Context, p ListParams) (*Page, error) {
+				Expect(p.Tags).To(Equal([]string{"home", "work"}))
+				Expect(p.TagMode).To(Equal(TagModeAll))
+				return &Page{}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/todos?tag=home&tag=%20work&tag=Home&tag_mode=all", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+		})
+
+		It("Passes the due date window and time zone to the service", func() {
+			svc.getAllFn = func(ctx context.Context, p ListParams) (*Page, error) {
+				Expect(p.Due).To(Equal(DueThisWeek))
+				Expect(p.Location.String()).To(Equal("America/Toronto"))
+				return &Page{}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/todos?due=this_week&tz=America/Toronto", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+		})
+
+		It("Passes the cursor through and exposes the neighbouring cursors", func() {
+			svc.getAllFn = func(ctx context.Context, p ListParams) (*Page, error) {
+				Expect(p.Cursor).To(Equal("abc.def"))
+				return &Page{Total: 3, NextCursor: "next.sig", PrevCursor: "prev.sig"}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/todos?cursor=abc.def", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Header().Get("X-Next-Cursor")).To(Equal("next.sig"))
+			Expect(rr.Header().Get("X-Prev-Cursor")).To(Equal("prev.sig"))
+			Expect(rr.Body.String()).To(Equal(`{"todos":[],"next_cursor":"next.sig","prev_cursor":"prev.sig"}`))
+		})
+
+		It("Omits cursor headers when there are no neighbouring pages", func() {
+			req := httptest.NewRequest(http.MethodGet, "/todos", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Header()).NotTo(HaveKey("X-Next-Cursor"))
+			Expect(rr.Header()).NotTo(HaveKey("X-Prev-Cursor"))
+			Expect(rr.Body.String()).To(Equal(`{"todos":[]}`))
+		})
+
+		It("Reports bad request for an invalid cursor", func() {
+			svc.getAllFn = func(ctx context.Context, p ListParams) (*Page, error) { return nil, ErrBadCursor }
+
+			req := httptest.NewRequest(http.MethodGet, "/todos?cursor=forged", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Code).To(Equal(ErrBadCursor.Error()))
 		})
 
 		It("Reports internal server error", func() {
 			err := errors.New("database is down")
-			svc.getAllFn = func(ctx context.Context) ([]Todo, error) { return nil, err }
+			svc.getAllFn = func(ctx context.Context, p ListParams) (*Page, error) { return nil, err }
 
 			req := httptest.NewRequest(http.MethodGet, "/todos", nil)
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusInternalServerError))
 
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrUnexpected.Error()))
+			Expect(resp.Code).To(Equal(ErrUnexpected.Error()))
+		})
+	})
+
+	Describe("GET /todos/search", Label("search"), func() {
+		It("Searches with the given query and filters", func() {
+			svc.searchFn = func(ctx context.Context, p SearchParams) ([]SearchResult, error) {
+				Expect(p.Query).To(Equal(SearchQuery{{Words: []string{"buy", "milk"}}, {Words: []string{"gro"}, Prefix: true}}))
+				Expect(p.Completed).To(PointTo(BeFalse()))
+				Expect(p.Limit).To(Equal(5))
+				return []SearchResult{{Todo: Todo{ID: 2, Text: "buy milk at the grocer"}, Score: 1.5, Snippet: "<mark>buy milk</mark> at the <mark>grocer</mark>"}}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodGet, `/todos/search?q=%22buy+milk%22+gro*&completed=false&limit=5`, nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			var out []SearchResult
+			Expect(json.Unmarshal(rr.Body.Bytes(), &out)).To(Succeed())
+			Expect(out).To(HaveLen(1))
+			Expect(out[0].Todo.ID).To(BeEquivalentTo(2))
+			Expect(out[0].Snippet).To(Equal("<mark>buy milk</mark> at the <mark>grocer</mark>"))
+		})
+
+		It("Reports bad request without a query", func() {
+			req := httptest.NewRequest(http.MethodGet, "/todos/search?q=+*+", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Code).To(Equal(ErrBadQuery.Error()))
+		})
+
+		It("Reports internal server error", func() {
+			svc.searchFn = func(ctx context.Context, p SearchParams) ([]SearchResult, error) {
+				return nil, errors.New("database is down")
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/todos/search?q=milk", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusInternalServerError))
 		})
 	})
 
@@ -123,15 +374,32 @@ var _ = Describe("handler", Label("handler"), func() {
 			Expect(out.ID).To(Equal(uint32(3)))
 		})
 
+		It("Expands the subtree on request", func() {
+			svc.getByIDFn = func(ctx context.Context, id uint32) (*Todo, error) { return &Todo{ID: id}, nil }
+			svc.expandFn = func(ctx context.Context, todos []Todo) error {
+				Expect(todos).To(HaveLen(1))
+				todos[0].Children = []Todo{{ID: 4}}
+				return nil
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/todos/3?expand=children", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			var out Todo
+			Expect(json.Unmarshal(rr.Body.Bytes(), &out)).To(Succeed())
+			Expect(out.Children).To(HaveLen(1))
+		})
+
 		It("Reports bad request for invalid ID type", func() {
 			req := httptest.NewRequest(http.MethodGet, "/todos/x", nil)
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusBadRequest))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrBadId.Error()))
-			Expect(resp.Error.Message).To(Equal("ID must be an integer"))
+			Expect(resp.Code).To(Equal(ErrBadId.Error()))
+			Expect(resp.Detail).To(Equal("ID must be an integer"))
 
 		})
 
@@ -142,10 +410,10 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusNotFound))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrTodoNotFound.Error()))
-			Expect(resp.Error.Message).To(Equal(fmt.Sprintf("No resource found with ID = %d", 3)))
+			Expect(resp.Code).To(Equal(ErrTodoNotFound.Error()))
+			Expect(resp.Detail).To(Equal(fmt.Sprintf("No resource found with ID = %d", 3)))
 		})
 
 		It("Reports internal server error", func() {
@@ -156,9 +424,41 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusInternalServerError))
-			var resp ErrorResponse
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Code).To(Equal(ErrUnexpected.Error()))
+		})
+	})
+
+	Describe("GET /todos/:id/children", Label("children"), func() {
+		It("Lists the children of the todo", func() {
+			svc.getAllFn = func(ctx context.Context, p ListParams) (*Page, error) {
+				Expect(p.ParentID).To(PointTo(BeEquivalentTo(3)))
+				Expect(p.Expand).To(BeTrue())
+				return &Page{Todos: []Todo{{ID: 4}}, Total: 1}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/todos/3/children?expand=children", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Header().Get("X-Total-Count")).To(Equal("1"))
+		})
+
+		It("Propagates error not found when the parent doesn't exist", func() {
+			svc.getByIDFn = func(ctx context.Context, id uint32) (*Todo, error) { return nil, ErrTodoNotFound }
+			svc.getAllFn = func(ctx context.Context, p ListParams) (*Page, error) {
+				Fail("todos should not be listed")
+				return nil, nil
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/todos/3/children", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusNotFound))
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrUnexpected.Error()))
+			Expect(resp.Code).To(Equal(ErrTodoNotFound.Error()))
 		})
 	})
 
@@ -169,6 +469,7 @@ var _ = Describe("handler", Label("handler"), func() {
 				Expect(*in.Text).To(Equal("stretch"))
 				Expect(in.Completed).NotTo(BeNil())
 				Expect(*in.Completed).To(BeFalse())
+				Expect(in.Priority).To(PointTo(Equal(PriorityNone)))
 				return &Todo{ID: 7, Text: "stretch", Completed: false}, nil
 			}
 
@@ -201,6 +502,64 @@ var _ = Describe("handler", Label("handler"), func() {
 			Expect(rr.Body.String()).To(ContainSubstring(`"id":7,"text":"stretch","completed":true`))
 		})
 
+		It("Verifies happy path - schedule as timestamp and date", func() {
+			svc.createFn = func(ctx context.Context, in TodoInput) (*Todo, error) {
+				Expect(in.StartAt).To(PointTo(Equal(DateTime{Time: time.Date(2025, 9, 20, 13, 0, 0, 0, time.UTC)})))
+				Expect(in.DueAt).To(PointTo(Equal(DateTime{Time: time.Date(2025, 9, 21, 0, 0, 0, 0, time.UTC), DateOnly: true})))
+				return &Todo{ID: 7, Text: "stretch"}, nil
+			}
+
+			payload := "{\"text\":\"stretch\",\"start_at\":\"2025-09-20T15:00:00+02:00\",\"due_at\":\"2025-09-21\"}"
+			req := httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusCreated))
+		})
+
+		It("Propagates error priority invalid", func() {
+			svc.createFn = func(ctx context.Context, in TodoInput) (*Todo, error) {
+				Expect(in.Priority).To(PointTo(BeEquivalentTo("critical")))
+				return nil, ErrPriorityInvalid
+			}
+
+			payload := "{\"text\":\"stretch\",\"priority\":\"critical\"}"
+			req := httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Code).To(Equal(ErrPriorityInvalid.Error()))
+		})
+
+		It("Reports bad request for malformed dates", func() {
+			payload := "{\"text\":\"stretch\",\"due_at\":\"tomorrow\"}"
+			req := httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Code).To(Equal(ErrBadJson.Error()))
+		})
+
+		It("Propagates error start after due", func() {
+			svc.createFn = func(ctx context.Context, in TodoInput) (*Todo, error) { return nil, ErrStartAfterDue }
+
+			payload := "{\"text\":\"stretch\",\"start_at\":\"2025-09-22\",\"due_at\":\"2025-09-21\"}"
+			req := httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Code).To(Equal(ErrStartAfterDue.Error()))
+		})
+
 		It("Reports bad request for field explicitly set to null", func() {
 			payload := "{\"text\":null}"
 			req := httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(payload))
@@ -208,10 +567,10 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusBadRequest))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrBadJson.Error()))
-			Expect(resp.Error.Message).To(Equal("field \"text\" cannot be null"))
+			Expect(resp.Code).To(Equal(ErrBadJson.Error()))
+			Expect(resp.Detail).To(Equal("field \"text\" cannot be null"))
 		})
 
 		It("Reports bad request for invalid JSON", func() {
@@ -221,10 +580,10 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusBadRequest))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrBadJson.Error()))
-			Expect(resp.Error.Message).To(Equal("invalid json input"))
+			Expect(resp.Code).To(Equal(ErrBadJson.Error()))
+			Expect(resp.Detail).To(Equal("invalid json input"))
 		})
 
 		It("Reports bad request for JSON w/ unsupported fields", func() {
@@ -234,10 +593,10 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusBadRequest))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrBadJson.Error()))
-			Expect(resp.Error.Message).To(Equal("invalid json input"))
+			Expect(resp.Code).To(Equal(ErrBadJson.Error()))
+			Expect(resp.Detail).To(Equal("invalid json input"))
 		})
 
 		It("Reports bad request for missing text field", func() {
@@ -247,10 +606,10 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusBadRequest))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrBadJson.Error()))
-			Expect(resp.Error.Message).To(Equal("missing required `text` field"))
+			Expect(resp.Code).To(Equal(ErrBadJson.Error()))
+			Expect(resp.Detail).To(Equal("missing required `text` field"))
 		})
 
 		It("Reports error unsupported media type", func() {
@@ -260,10 +619,10 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusUnsupportedMediaType))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrUnsupportedMediaType.Error()))
-			Expect(resp.Error.Message).To(Equal("Content-Type must be application/json"))
+			Expect(resp.Code).To(Equal(ErrUnsupportedMediaType.Error()))
+			Expect(resp.Detail).To(Equal("Content-Type must be application/json"))
 		})
 
 		It("Propagates error input invalid", func() {
@@ -275,9 +634,23 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrInputInvalid.Error()))
+			Expect(resp.Code).To(Equal(ErrInputInvalid.Error()))
+		})
+
+		It("Reports forbidden when the workspace is full", func() {
+			svc.createFn = func(ctx context.Context, in TodoInput) (*Todo, error) { return nil, ErrQuotaExceeded }
+
+			payload := "{\"text\":\"stretch\"}"
+			req := httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusForbidden))
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Code).To(Equal(ErrQuotaExceeded.Error()))
 		})
 
 		It("Reports internal server error", func() {
@@ -290,9 +663,9 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusInternalServerError))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrUnexpected.Error()))
+			Expect(resp.Code).To(Equal(ErrUnexpected.Error()))
 		})
 	})
 
@@ -322,10 +695,10 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusBadRequest))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrBadId.Error()))
-			Expect(resp.Error.Message).To(Equal("ID must be an integer"))
+			Expect(resp.Code).To(Equal(ErrBadId.Error()))
+			Expect(resp.Detail).To(Equal("ID must be an integer"))
 
 		})
 
@@ -336,10 +709,10 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusBadRequest))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrBadJson.Error()))
-			Expect(resp.Error.Message).To(Equal("invalid json input"))
+			Expect(resp.Code).To(Equal(ErrBadJson.Error()))
+			Expect(resp.Detail).To(Equal("invalid json input"))
 
 		})
 
@@ -350,10 +723,10 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusBadRequest))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrBadJson.Error()))
-			Expect(resp.Error.Message).To(Equal("invalid json input"))
+			Expect(resp.Code).To(Equal(ErrBadJson.Error()))
+			Expect(resp.Detail).To(Equal("invalid json input"))
 		})
 
 		It("Reports bad request for missing field", func() {
@@ -363,10 +736,10 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusBadRequest))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrBadJson.Error()))
-			Expect(resp.Error.Message).To(Equal("missing required `text` and `completed` fields"))
+			Expect(resp.Code).To(Equal(ErrBadJson.Error()))
+			Expect(resp.Detail).To(Equal("missing required `text` and `completed` fields"))
 
 		})
 
@@ -377,10 +750,10 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusUnsupportedMediaType))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrUnsupportedMediaType.Error()))
-			Expect(resp.Error.Message).To(Equal("Content-Type must be application/json"))
+			Expect(resp.Code).To(Equal(ErrUnsupportedMediaType.Error()))
+			Expect(resp.Detail).To(Equal("Content-Type must be application/json"))
 
 		})
 
@@ -392,10 +765,10 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusNotFound))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrTodoNotFound.Error()))
-			Expect(resp.Error.Message).To(Equal(fmt.Sprintf("No resource found with ID = %d", 3)))
+			Expect(resp.Code).To(Equal(ErrTodoNotFound.Error()))
+			Expect(resp.Detail).To(Equal(fmt.Sprintf("No resource found with ID = %d", 3)))
 		})
 
 		It("Propagates error input invalid", func() {
@@ -406,9 +779,9 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrInputInvalid.Error()))
+			Expect(resp.Code).To(Equal(ErrInputInvalid.Error()))
 		})
 
 		It("Reports internal server error", func() {
@@ -421,9 +794,9 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusInternalServerError))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrUnexpected.Error()))
+			Expect(resp.Code).To(Equal(ErrUnexpected.Error()))
 		})
 	})
 
@@ -447,16 +820,165 @@ var _ = Describe("handler", Label("handler"), func() {
 			Expect(rr.Code).To(Equal(http.StatusOK))
 		})
 
+		It("Verifies happy path - priority only", func() {
+			svc.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+				Expect(in.Text).To(BeNil())
+				Expect(in.Completed).To(BeNil())
+				Expect(in.Priority).To(PointTo(Equal(PriorityHigh)))
+				return &Todo{ID: id, Priority: PriorityHigh}, nil
+			}
+
+			payload := "{\"priority\":\"high\"}"
+			req := httptest.NewRequest(http.MethodPatch, "/todos/12", strings.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Body.String()).To(ContainSubstring(`"priority":"high"`))
+		})
+
+		It("Moves a todo to another list", func() {
+			svc.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+				Expect(in.ListID).To(PointTo(BeEquivalentTo(4)))
+				return &Todo{ID: id, ListID: *in.ListID}, nil
+			}
+
+			payload := "{\"list_id\":4}"
+			req := httptest.NewRequest(http.MethodPatch, "/todos/12", strings.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Body.String()).To(ContainSubstring(`"list_id":4`))
+		})
+
+		It("Cascades completion on request", func() {
+			svc.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+				Expect(in.Cascade).To(BeTrue())
+				return &Todo{ID: id, Completed: true}, nil
+			}
+
+			payload := "{\"completed\":true}"
+			req := httptest.NewRequest(http.MethodPatch, "/todos/12?cascade=true", strings.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+		})
+
+		It("Reports bad request for a malformed cascade flag", func() {
+			payload := "{\"completed\":true}"
+			req := httptest.NewRequest(http.MethodPatch, "/todos/12?cascade=maybe", strings.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Code).To(Equal(ErrBadQuery.Error()))
+		})
+
+		It("Applies the update to the whole series on request", func() {
+			svc.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+				Expect(in.Series).To(BeTrue())
+				return &Todo{ID: id, Text: *in.Text}, nil
+			}
+
+			payload := "{\"text\":\"water all plants\"}"
+			req := httptest.NewRequest(http.MethodPatch, "/todos/12?scope=series", strings.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+		})
+
+		It("Reports bad request for an unknown scope", func() {
+			payload := "{\"text\":\"water all plants\"}"
+			req := httptest.NewRequest(http.MethodPatch, "/todos/12?scope=future", strings.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Code).To(Equal(ErrBadQuery.Error()))
+		})
+
+		It("Reports unprocessable entity for an invalid recurrence rule", func() {
+			svc.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+				return nil, fmt.Errorf("%w: unsupported FREQ HOURLY", ErrRecurrenceInvalid)
+			}
+
+			payload := "{\"recurrence\":\"FREQ=HOURLY\"}"
+			req := httptest.NewRequest(http.MethodPatch, "/todos/12", strings.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Code).To(Equal(ErrRecurrenceInvalid.Error()))
+			Expect(resp.Detail).To(ContainSubstring("HOURLY"))
+		})
+
+		It("Reports unprocessable entity for a parent cycle", func() {
+			svc.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) { return nil, ErrParentCycle }
+
+			payload := "{\"parent_id\":12}"
+			req := httptest.NewRequest(http.MethodPatch, "/todos/12", strings.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Code).To(Equal(ErrParentCycle.Error()))
+		})
+
+		It("Reports unprocessable entity when moving to a missing list", func() {
+			svc.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) { return nil, ErrListNotFound }
+
+			payload := "{\"list_id\":99}"
+			req := httptest.NewRequest(http.MethodPatch, "/todos/12", strings.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Code).To(Equal(ErrListNotFound.Error()))
+			Expect(resp.Detail).To(Equal("No list found with ID = 99"))
+		})
+
+		It("Doesn't name a list it wasn't given when one is missing", func() {
+			svc.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) { return nil, ErrListNotFound }
+
+			req := httptest.NewRequest(http.MethodPatch, "/todos/12", strings.NewReader(`{"completed":true}`))
+			req.Header.Set("Content-Type", "application/json")
+
+			Expect(func() { router.ServeHTTP(rr, req) }).NotTo(Panic())
+			Expect(rr.Code).To(Equal(http.StatusInternalServerError))
+		})
+
 		It("Reports bad request for invalid ID type", func() {
 			req := httptest.NewRequest(http.MethodPatch, "/todos/x", nil)
 			req.Header.Set("Content-Type", "application/json")
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusBadRequest))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrBadId.Error()))
-			Expect(resp.Error.Message).To(Equal("ID must be an integer"))
+			Expect(resp.Code).To(Equal(ErrBadId.Error()))
+			Expect(resp.Detail).To(Equal("ID must be an integer"))
 
 		})
 
@@ -467,10 +989,10 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusBadRequest))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrBadJson.Error()))
-			Expect(resp.Error.Message).To(Equal("invalid json input"))
+			Expect(resp.Code).To(Equal(ErrBadJson.Error()))
+			Expect(resp.Detail).To(Equal("invalid json input"))
 		})
 
 		It("Reports bad request for JSON w/ unsupported fields", func() {
@@ -480,10 +1002,10 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusBadRequest))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrBadJson.Error()))
-			Expect(resp.Error.Message).To(Equal("invalid json input"))
+			Expect(resp.Code).To(Equal(ErrBadJson.Error()))
+			Expect(resp.Detail).To(Equal("invalid json input"))
 		})
 
 		It("Reports bad request for missing fields", func() {
@@ -493,10 +1015,10 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusBadRequest))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrBadJson.Error()))
-			Expect(resp.Error.Message).To(Equal("missing required `text` or `completed` field"))
+			Expect(resp.Code).To(Equal(ErrBadJson.Error()))
+			Expect(resp.Detail).To(Equal("missing at least one field to update"))
 		})
 
 		It("Reports error unsupported media type", func() {
@@ -506,10 +1028,10 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusUnsupportedMediaType))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrUnsupportedMediaType.Error()))
-			Expect(resp.Error.Message).To(Equal("Content-Type must be application/json"))
+			Expect(resp.Code).To(Equal(ErrUnsupportedMediaType.Error()))
+			Expect(resp.Detail).To(Equal("Content-Type must be application/json, application/merge-patch+json or application/json-patch+json"))
 		})
 
 		It("Propagates error not found when ID doesn't exist", func() {
@@ -520,10 +1042,10 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusNotFound))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrTodoNotFound.Error()))
-			Expect(resp.Error.Message).To(Equal(fmt.Sprintf("No resource found with ID = %d", 3)))
+			Expect(resp.Code).To(Equal(ErrTodoNotFound.Error()))
+			Expect(resp.Detail).To(Equal(fmt.Sprintf("No resource found with ID = %d", 3)))
 		})
 
 		It("Propagates error input invalid", func() {
@@ -534,9 +1056,9 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrInputInvalid.Error()))
+			Expect(resp.Code).To(Equal(ErrInputInvalid.Error()))
 		})
 
 		It("Reports internal server error", func() {
@@ -549,15 +1071,15 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusInternalServerError))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrUnexpected.Error()))
+			Expect(resp.Code).To(Equal(ErrUnexpected.Error()))
 		})
 	})
 
 	Describe("DELETE /todos/:id", Label("delete"), func() {
 		It("Verifies happy path", func() {
-			svc.deleteFn = func(ctx context.Context, id uint32) error {
+			svc.deleteFn = func(ctx context.Context, id uint32, version *uint32) error {
 				Expect(id).To(Equal(uint32(5)))
 				return nil
 			}
@@ -573,34 +1095,329 @@ var _ = Describe("handler", Label("handler"), func() {
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusBadRequest))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrBadId.Error()))
-			Expect(resp.Error.Message).To(Equal("ID must be an integer"))
+			Expect(resp.Code).To(Equal(ErrBadId.Error()))
+			Expect(resp.Detail).To(Equal("ID must be an integer"))
 		})
 
 		It("Propagates error not found when ID doesn't exist", func() {
-			svc.deleteFn = func(ctx context.Context, id uint32) error { return ErrTodoNotFound }
+			svc.deleteFn = func(ctx context.Context, id uint32, version *uint32) error { return ErrTodoNotFound }
 			req := httptest.NewRequest(http.MethodDelete, "/todos/3", nil)
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusNotFound))
-			var resp ErrorResponse
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrTodoNotFound.Error()))
-			Expect(resp.Error.Message).To(Equal(fmt.Sprintf("No resource found with ID = %d", 3)))
+			Expect(resp.Code).To(Equal(ErrTodoNotFound.Error()))
+			Expect(resp.Detail).To(Equal(fmt.Sprintf("No resource found with ID = %d", 3)))
 		})
 
 		It("Reports internal server error", func() {
 			err := errors.New("database is down")
-			svc.deleteFn = func(ctx context.Context, id uint32) error { return err }
+			svc.deleteFn = func(ctx context.Context, id uint32, version *uint32) error { return err }
 			req := httptest.NewRequest(http.MethodDelete, "/todos/3", nil)
 			router.ServeHTTP(rr, req)
 
 			Expect(rr.Code).To(Equal(http.StatusInternalServerError))
-			var resp ErrorResponse
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Code).To(Equal(ErrUnexpected.Error()))
+		})
+	})
+
+	Describe("conditional requests", Label("etag"), func() {
+		It("Tags a todo with its version", func() {
+			svc.getByIDFn = func(ctx context.Context, id uint32) (*Todo, error) { return &Todo{ID: id, Version: 4}, nil }
+
+			req := httptest.NewRequest(http.MethodGet, "/todos/3", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Header().Get("ETag")).To(Equal(`"4"`))
+		})
+
+		It("Reports not modified when the todo still matches If-None-Match", func() {
+			svc.getByIDFn = func(ctx context.Context, id uint32) (*Todo, error) { return &Todo{ID: id, Version: 4}, nil }
+
+			req := httptest.NewRequest(http.MethodGet, "/todos/3", nil)
+			req.Header.Set("If-None-Match", `"3", W/"4"`)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusNotModified))
+			Expect(rr.Header().Get("ETag")).To(Equal(`"4"`))
+			Expect(rr.Body.Len()).To(BeZero())
+		})
+
+		It("Returns the todo once it no longer matches If-None-Match", func() {
+			svc.getByIDFn = func(ctx context.Context, id uint32) (*Todo, error) { return &Todo{ID: id, Version: 5}, nil }
+
+			req := httptest.NewRequest(http.MethodGet, "/todos/3", nil)
+			req.Header.Set("If-None-Match", `"4"`)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+		})
+
+		It("Passes the version in If-Match to the update", func() {
+			svc.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+				Expect(in.Version).To(PointTo(BeEquivalentTo(4)))
+				return &Todo{ID: id, Version: 5}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodPatch, "/todos/3", strings.NewReader(`{"completed":true}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("If-Match", `"4"`)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Header().Get("ETag")).To(Equal(`"5"`))
+		})
+
+		It("Updates unconditionally with If-Match: *", func() {
+			svc.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+				Expect(in.Version).To(BeNil())
+				return &Todo{ID: id}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodPut, "/todos/3", strings.NewReader(`{"text":"walk the dog","completed":true}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("If-Match", "*")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+		})
+
+		It("Reports precondition failed on a version mismatch", func() {
+			svc.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) { return nil, ErrVersionMismatch }
+
+			req := httptest.NewRequest(http.MethodPatch, "/todos/3", strings.NewReader(`{"completed":true}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("If-Match", `"4"`)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusPreconditionFailed))
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Code).To(Equal(ErrVersionMismatch.Error()))
+		})
+
+		It("Reports precondition failed for a weak If-Match", func() {
+			svc.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+				Fail("todo should not be updated")
+				return nil, nil
+			}
+
+			req := httptest.NewRequest(http.MethodPatch, "/todos/3", strings.NewReader(`{"completed":true}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("If-Match", `W/"4"`)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusPreconditionFailed))
+		})
+
+		It("Deletes conditionally", func() {
+			svc.deleteFn = func(ctx context.Context, id uint32, version *uint32) error {
+				Expect(version).To(PointTo(BeEquivalentTo(2)))
+				return ErrVersionMismatch
+			}
+
+			req := httptest.NewRequest(http.MethodDelete, "/todos/3", nil)
+			req.Header.Set("If-Match", `"2"`)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusPreconditionFailed))
+		})
+	})
+
+	Describe("bulk", Label("bulk"), func() {
+		post := func(body string) {
+			req := httptest.NewRequest(http.MethodPost, "/todos/bulk", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+		}
+
+		It("Reports the outcome of every operation", func() {
+			svc.bulkFn = func(ctx context.Context, ops []BulkOp, atomic bool) ([]BulkResult, error) {
+				Expect(atomic).To(BeFalse())
+				Expect(ops).To(HaveLen(3))
+				Expect(ops[0].Todo.Completed).To(PointTo(BeFalse()))
+				Expect(ops[0].Todo.Priority).To(PointTo(Equal(PriorityNone)))
+				Expect(ops[1].Version).To(PointTo(BeEquivalentTo(2)))
+				return []BulkResult{
+					{Todo: &Todo{ID: 9, Text: "buy milk"}},
+					{Err: ErrVersionMismatch},
+					{},
+				}, nil
+			}
+
+			post(`{"atomic":false,"operations":[{"op":"create","todo":{"text":"buy milk"}},{"op":"update","id":4,"version":2,"todo":{"completed":true}},{"op":"delete","id":5}]}`)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			var out []struct {
+				Status int      `json:"status"`
+				Todo   *Todo    `json:"todo"`
+				Error  *Problem `json:"error"`
+			}
+			Expect(json.Unmarshal(rr.Body.Bytes(), &out)).To(Succeed())
+			Expect(out).To(HaveLen(3))
+			Expect(out[0].Status).To(Equal(http.StatusCreated))
+			Expect(out[0].Todo.ID).To(BeEquivalentTo(9))
+			Expect(out[1].Status).To(Equal(http.StatusPreconditionFailed))
+			Expect(out[1].Error.Code).To(Equal(ErrVersionMismatch.Error()))
+			Expect(out[1].Error.Status).To(Equal(http.StatusPreconditionFailed))
+			Expect(out[1].Error.Instance).To(Equal("/todos/bulk"))
+			Expect(out[2].Status).To(Equal(http.StatusNoContent))
+			Expect(out[2].Error).To(BeNil())
+		})
+
+		It("Reports the failed operation of an atomic request", func() {
+			svc.bulkFn = func(ctx context.Context, ops []BulkOp, atomic bool) ([]BulkResult, error) {
+				Expect(atomic).To(BeTrue())
+				return nil, &BulkError{Index: 1, Err: ErrTodoNotFound}
+			}
+
+			post(`{"operations":[{"op":"delete","id":4},{"op":"delete","id":5}]}`)
+
+			Expect(rr.Code).To(Equal(http.StatusNotFound))
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Code).To(Equal(ErrTodoNotFound.Error()))
+			Expect(resp.Detail).To(Equal("operations[1]: No resource found with ID = 5"))
+		})
+
+		DescribeTable("Reports bad request for malformed operations",
+			func(body, msg string) {
+				svc.bulkFn = func(ctx context.Context, ops []BulkOp, atomic bool) ([]BulkResult, error) {
+					Fail("operations should not run")
+					return nil, nil
+				}
+
+				post(body)
+
+				Expect(rr.Code).To(Equal(http.StatusBadRequest))
+				var resp Problem
+				Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+				Expect(resp.Code).To(Equal(ErrBadJson.Error()))
+				Expect(resp.Detail).To(Equal(msg))
+			},
+			Entry("no operations", `{"operations":[]}`, "`operations` must hold between 1 and 100 operations"),
+			Entry("unknown field", `{"operations":[{"op":"delete","id":1,"force":true}]}`, "invalid json input"),
+			Entry("unknown op", `{"operations":[{"op":"archive","id":1}]}`, "operations[0]: `op` must be one of create, update, delete"),
+			Entry("create without text", `{"operations":[{"op":"create","todo":{}}]}`, "operations[0]: missing required `text` field"),
+			Entry("update without id", `{"operations":[{"op":"update","todo":{"completed":true}}]}`, "operations[0]: missing required `id` field"),
+			Entry("empty update", `{"operations":[{"op":"delete","id":1},{"op":"update","id":2}]}`, "operations[1]: missing at least one field to update"),
+		)
+
+		It("Completes all matching todos", func() {
+			svc.complFn = func(ctx context.Context, p ListParams) (int, error) {
+				Expect(p.ListID).To(PointTo(BeEquivalentTo(2)))
+				return 4, nil
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/todos/complete-all?list_id=2", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Body.String()).To(MatchJSON(`{"completed":4}`))
+		})
+
+		It("Trashes all completed todos", func() {
+			svc.delAllFn = func(ctx context.Context, p ListParams) (int, error) {
+				Expect(p.Completed).To(PointTo(BeTrue()))
+				return 3, nil
+			}
+
+			req := httptest.NewRequest(http.MethodDelete, "/todos?completed=true", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Body.String()).To(MatchJSON(`{"deleted":3}`))
+		})
+
+		It("Requires completed=true to trash todos in bulk", func() {
+			svc.delAllFn = func(ctx context.Context, p ListParams) (int, error) {
+				Fail("todos should not be trashed")
+				return 0, nil
+			}
+
+			req := httptest.NewRequest(http.MethodDelete, "/todos?list_id=2", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Describe("trash", Label("trash"), func() {
+		It("Lists trashed todos", func() {
+			svc.getAllFn = func(ctx context.Context, p ListParams) (*Page, error) {
+				Expect(p.Trashed).To(BeTrue())
+				Expect(p.Limit).To(Equal(5))
+				return &Page{Todos: []Todo{{ID: 3}}, Total: 1}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/trash?limit=5", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Header().Get("X-Total-Count")).To(Equal("1"))
+		})
+
+		It("Restores a todo", func() {
+			svc.restoreFn = func(ctx context.Context, id uint32) (*Todo, error) {
+				Expect(id).To(BeEquivalentTo(3))
+				return &Todo{ID: id, Text: "move house"}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/todos/3/restore", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Body.String()).To(ContainSubstring(`"deleted_at":null`))
+		})
+
+		It("Reports not found when restoring a missing todo", func() {
+			svc.restoreFn = func(ctx context.Context, id uint32) (*Todo, error) { return nil, ErrTodoNotFound }
+
+			req := httptest.NewRequest(http.MethodPost, "/todos/3/restore", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("Reports conflict when the parent is still trashed", func() {
+			svc.restoreFn = func(ctx context.Context, id uint32) (*Todo, error) { return nil, ErrParentTrashed }
+
+			req := httptest.NewRequest(http.MethodPost, "/todos/3/restore", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusConflict))
+			var resp Problem
 			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
-			Expect(resp.Error.Code).To(Equal(ErrUnexpected.Error()))
+			Expect(resp.Code).To(Equal(ErrParentTrashed.Error()))
+		})
+
+		It("Empties the trash", func() {
+			called := false
+			svc.emptyFn = func(ctx context.Context) error {
+				called = true
+				return nil
+			}
+
+			req := httptest.NewRequest(http.MethodDelete, "/trash", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusNoContent))
+			Expect(called).To(BeTrue())
+		})
+
+		It("Reports internal server error when emptying the trash fails", func() {
+			svc.emptyFn = func(ctx context.Context) error { return errors.New("database is down") }
+
+			req := httptest.NewRequest(http.MethodDelete, "/trash", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusInternalServerError))
 		})
 	})
 })
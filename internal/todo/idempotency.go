@@ -0,0 +1,155 @@
+package todo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultIdempotencyTTL is how long an Idempotency-Key is remembered, unless
+// configured otherwise.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+const maxIdempotencyKeyLength = 255
+
+// replayedHeaders are the response headers stored along with the response to
+// a request made with an Idempotency-Key.
+var replayedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// HandlerOption configures optional behaviour of the handler.
+type HandlerOption func(*Handler)
+
+// WithIdempotency makes POST /todos honor the Idempotency-Key header, storing
+// responses in st for ttl. A non-positive ttl means DefaultIdempotencyTTL.
+func WithIdempotency(st IdempotencyStore, ttl time.Duration) HandlerOption {
+	return func(h *Handler) {
+		if ttl <= 0 {
+			ttl = DefaultIdempotencyTTL
+		}
+		h.idempotency = st
+		h.idempotencyTTL = ttl
+	}
+}
+
+// idempotent is a middleware replaying the stored response of a request
+// retried with the same Idempotency-Key, so that retries don't repeat its
+// effects. A key may only be reused for the same request, as identified by
+// its method, path and body. Server errors are not stored, so that the
+// request can be retried.
+func (h *Handler) idempotent(ctx *gin.Context) {
+	key := ctx.GetHeader("Idempotency-Key")
+	if h.idempotency == nil || key == "" {
+		return
+	}
+
+	if len(key) > maxIdempotencyKeyLength {
+		r := NewErrorResponse(ErrBadIdempotencyKey.Error(), "Idempotency-Key must be at most 255 characters")
+		writeError(ctx, http.StatusBadRequest, r)
+		ctx.Abort()
+		return
+	}
+
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		r := NewErrorResponse(ErrBadJson.Error(), "invalid json input")
+		writeError(ctx, http.StatusBadRequest, r)
+		ctx.Abort()
+		return
+	}
+	ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+	fingerprint := requestFingerprint(ctx.Request.Method, ctx.Request.URL.Path, body)
+
+	c := ctx.Request.Context()
+	stored, err := h.idempotency.Begin(c, key, fingerprint, h.idempotencyTTL)
+	if err != nil {
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		ctx.Abort()
+		return
+	}
+
+	if stored != nil {
+		switch {
+		case stored.Fingerprint != fingerprint:
+			r := NewErrorResponse(ErrIdempotencyKeyReused.Error(), "Idempotency-Key was already used for a different request")
+			writeError(ctx, http.StatusUnprocessableEntity, r)
+			ctx.Abort()
+		case stored.Status == 0:
+			r := NewErrorResponse(ErrIdempotencyInProgress.Error(), "A request with this Idempotency-Key is still in progress")
+			writeError(ctx, http.StatusConflict, r)
+			ctx.Abort()
+		default:
+			for k, v := range stored.Header {
+				for _, s := range v {
+					ctx.Writer.Header().Add(k, s)
+				}
+			}
+			ctx.Header("Idempotent-Replayed", "true")
+			ctx.Writer.WriteHeader(stored.Status)
+			ctx.Writer.Write(stored.Body)
+			ctx.Abort()
+		}
+		return
+	}
+
+	// Give up the key if the request doesn't complete, e.g. on panic.
+	done := false
+	defer func() {
+		if !done {
+			if err := h.idempotency.Release(context.WithoutCancel(c), key); err != nil {
+				log.Printf("releasing idempotency key: %v", err)
+			}
+		}
+	}()
+
+	w := &recordingWriter{ResponseWriter: ctx.Writer}
+	ctx.Writer = w
+	ctx.Next()
+
+	if w.Status() >= http.StatusInternalServerError {
+		return
+	}
+
+	header := make(http.Header)
+	for _, k := range replayedHeaders {
+		if v := w.Header().Values(k); len(v) > 0 {
+			header[k] = v
+		}
+	}
+	r := StoredResponse{Fingerprint: fingerprint, Status: w.Status(), Header: header, Body: w.body.Bytes()}
+	if err := h.idempotency.Complete(context.WithoutCancel(c), key, r); err != nil {
+		log.Printf("storing idempotent response: %v", err)
+		return
+	}
+	done = true
+}
+
+func requestFingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, method+" "+path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recordingWriter keeps a copy of the body written to the response.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,120 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const idempotencyTable = "idempotency_keys"
+
+// IdempotencyStore persists the responses of requests made with an
+// Idempotency-Key, so that retries get the original response replayed. Keys
+// are chosen by clients, so each user of a context has keys of their own in
+// each workspace.
+type IdempotencyStore interface {
+	// Begin claims key for a request with the given fingerprint, for ttl.
+	// If the key is already claimed, the response stored for it is
+	// returned instead; its Status is zero while the first request is still
+	// in progress.
+	Begin(ctx context.Context, key, fingerprint string, ttl time.Duration) (*StoredResponse, error)
+	// Complete stores the response of the request which claimed key.
+	Complete(ctx context.Context, key string, r StoredResponse) error
+	// Release gives up a claim on key, so that the request can be retried.
+	Release(ctx context.Context, key string) error
+}
+
+// StoredResponse is the response of a request made with an Idempotency-Key,
+// along with the fingerprint of the request.
+type StoredResponse struct {
+	Fingerprint string
+	Status      int
+	Header      http.Header
+	Body        []byte
+}
+
+type sqlidempotencystore struct {
+	db *sql.DB
+}
+
+func NewIdempotencyStore(db *sql.DB) IdempotencyStore {
+	return &sqlidempotencystore{db: db}
+}
+
+func (s *sqlidempotencystore) Begin(ctx context.Context, key, fingerprint string, ttl time.Duration) (*StoredResponse, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Expired keys are cleaned up lazily, which also frees key if it expired.
+	query := fmt.Sprintf("DELETE FROM `%s` WHERE expires_at <= CURRENT_TIMESTAMP", idempotencyTable)
+	if _, err := s.db.ExecContext(ctx, query); err != nil {
+		return nil, err
+	}
+
+	query = fmt.Sprintf("INSERT INTO `%s` (workspace_id, owner_id, idempotency_key, fingerprint, expires_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP + INTERVAL ? SECOND)", idempotencyTable)
+	_, err = s.db.ExecContext(ctx, query, workspace, owner, key, fingerprint, int64(ttl/time.Second))
+	if err == nil {
+		return nil, nil
+	}
+	if !isDuplicateKey(err) {
+		return nil, err
+	}
+
+	var (
+		r       StoredResponse
+		status  sql.NullInt32
+		headers []byte
+	)
+	query = fmt.Sprintf("SELECT fingerprint, status, headers, body FROM `%s` WHERE workspace_id=? AND owner_id=? AND idempotency_key=?", idempotencyTable)
+	err = s.db.QueryRowContext(ctx, query, workspace, owner, key).Scan(&r.Fingerprint, &status, &headers, &r.Body)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// Released since the insert failed; report it as in progress
+			// and let the client retry.
+			return &StoredResponse{Fingerprint: fingerprint}, nil
+		}
+		return nil, err
+	}
+
+	r.Status = int(status.Int32)
+	if headers != nil {
+		if err := json.Unmarshal(headers, &r.Header); err != nil {
+			return nil, err
+		}
+	}
+
+	return &r, nil
+}
+
+func (s *sqlidempotencystore) Complete(ctx context.Context, key string, r StoredResponse) error {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	headers, err := json.Marshal(r.Header)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("UPDATE `%s` SET status = ?, headers = ?, body = ? WHERE workspace_id=? AND owner_id=? AND idempotency_key=?", idempotencyTable)
+	_, err = s.db.ExecContext(ctx, query, r.Status, headers, r.Body, workspace, owner, key)
+	return err
+}
+
+func (s *sqlidempotencystore) Release(ctx context.Context, key string) error {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("DELETE FROM `%s` WHERE workspace_id=? AND owner_id=? AND idempotency_key=?", idempotencyTable)
+	_, err = s.db.ExecContext(ctx, query, workspace, owner, key)
+	return err
+}
@@ -0,0 +1,247 @@
+package todo_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+// memIdempotencyStore is an in-memory IdempotencyStore ignoring expiry.
+type memIdempotencyStore struct {
+	responses map[string]*StoredResponse
+	ttl       time.Duration
+	released  []string
+}
+
+func (m *memIdempotencyStore) Begin(ctx context.Context, key, fingerprint string, ttl time.Duration) (*StoredResponse, error) {
+	m.ttl = ttl
+	if r, ok := m.responses[key]; ok {
+		return r, nil
+	}
+	m.responses[key] = &StoredResponse{Fingerprint: fingerprint}
+	return nil, nil
+}
+
+func (m *memIdempotencyStore) Complete(ctx context.Context, key string, r StoredResponse) error {
+	m.responses[key] = &r
+	return nil
+}
+
+func (m *memIdempotencyStore) Release(ctx context.Context, key string) error {
+	delete(m.responses, key)
+	m.released = append(m.released, key)
+	return nil
+}
+
+var _ = Describe("idempotency", Label("idempotency"), func() {
+	Describe("handler", func() {
+		var (
+			svc     *mockService
+			store   *memIdempotencyStore
+			router  *gin.Engine
+			created int
+		)
+
+		post := func(key, payload string) *httptest.ResponseRecorder {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+			if key != "" {
+				req.Header.Set("Idempotency-Key", key)
+			}
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		BeforeEach(func() {
+			gin.SetMode(gin.TestMode)
+			created = 0
+			svc = &mockService{
+				createFn: func(ctx context.Context, in TodoInput) (*Todo, error) {
+					created++
+					return &Todo{ID: uint32(created), Text: *in.Text, Version: 1}, nil
+				},
+			}
+			store = &memIdempotencyStore{responses: map[string]*StoredResponse{}}
+			router = gin.New()
+			NewHandler(svc, WithIdempotency(store, time.Hour)).Register(router)
+		})
+
+		It("replays the original response on retries", func() {
+			first := post("abc", `{"text":"stretch"}`)
+			Expect(first.Code).To(Equal(http.StatusCreated))
+			Expect(store.ttl).To(Equal(time.Hour))
+
+			retry := post("abc", `{"text":"stretch"}`)
+			Expect(created).To(Equal(1))
+			Expect(retry.Code).To(Equal(http.StatusCreated))
+			Expect(retry.Body.String()).To(Equal(first.Body.String()))
+			Expect(retry.Header().Get("Location")).To(Equal("/todos/1"))
+			Expect(retry.Header().Get("ETag")).To(Equal(`"1"`))
+			Expect(retry.Header().Get("Content-Type")).To(Equal(first.Header().Get("Content-Type")))
+			Expect(retry.Header().Get("Idempotent-Replayed")).To(Equal("true"))
+			Expect(first.Header().Get("Idempotent-Replayed")).To(BeEmpty())
+		})
+
+		It("creates a todo per request without a key", func() {
+			post("", `{"text":"stretch"}`)
+			post("", `{"text":"stretch"}`)
+			Expect(created).To(Equal(2))
+			Expect(store.responses).To(BeEmpty())
+		})
+
+		It("rejects a key reused with a different body", func() {
+			post("abc", `{"text":"stretch"}`)
+
+			rr := post("abc", `{"text":"jog"}`)
+			Expect(created).To(Equal(1))
+			Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+			Expect(rr.Body.String()).To(ContainSubstring("idempotency_key_reused"))
+		})
+
+		It("reports a conflict while the first request is in progress", func() {
+			post("abc", `{"text":"stretch"}`)
+			store.responses["abc"].Status = 0
+
+			rr := post("abc", `{"text":"stretch"}`)
+			Expect(rr.Code).To(Equal(http.StatusConflict))
+			Expect(rr.Body.String()).To(ContainSubstring("idempotency_key_in_progress"))
+		})
+
+		It("stores client errors", func() {
+			first := post("abc", `{"text":1}`)
+			Expect(first.Code).To(Equal(http.StatusBadRequest))
+
+			retry := post("abc", `{"text":1}`)
+			Expect(retry.Code).To(Equal(http.StatusBadRequest))
+			Expect(retry.Header().Get("Idempotent-Replayed")).To(Equal("true"))
+		})
+
+		It("releases the key on server errors", func() {
+			svc.createFn = func(ctx context.Context, in TodoInput) (*Todo, error) {
+				return nil, errors.New("boom")
+			}
+
+			rr := post("abc", `{"text":"stretch"}`)
+			Expect(rr.Code).To(Equal(http.StatusInternalServerError))
+			Expect(store.released).To(Equal([]string{"abc"}))
+			Expect(store.responses).To(BeEmpty())
+		})
+
+		It("rejects overlong keys", func() {
+			rr := post(strings.Repeat("k", 256), `{"text":"stretch"}`)
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+			Expect(rr.Body.String()).To(ContainSubstring("bad_idempotency_key"))
+			Expect(created).To(BeZero())
+		})
+	})
+
+	Describe("store", func() {
+		const (
+			cleanupQuery  = "DELETE FROM `idempotency_keys` WHERE expires_at <= CURRENT_TIMESTAMP"
+			insertQuery   = "INSERT INTO `idempotency_keys` (workspace_id, owner_id, idempotency_key, fingerprint, expires_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP + INTERVAL ? SECOND)"
+			selectQuery   = "SELECT fingerprint, status, headers, body FROM `idempotency_keys` WHERE workspace_id=? AND owner_id=? AND idempotency_key=?"
+			completeQuery = "UPDATE `idempotency_keys` SET status = ?, headers = ?, body = ? WHERE workspace_id=? AND owner_id=? AND idempotency_key=?"
+			releaseQuery  = "DELETE FROM `idempotency_keys` WHERE workspace_id=? AND owner_id=? AND idempotency_key=?"
+		)
+
+		var (
+			ctx   context.Context
+			db    *sql.DB
+			mock  sqlmock.Sqlmock
+			store IdempotencyStore
+		)
+
+		BeforeEach(func() {
+			var err error
+			ctx = WithWorkspace(WithUser(context.Background(), testOwner), testWorkspace)
+			db, mock, err = sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			store = NewIdempotencyStore(db)
+			mock.ExpectExec(regexp.QuoteMeta(cleanupQuery)).WillReturnResult(sqlmock.NewResult(0, 0))
+		})
+
+		AfterEach(func() {
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			db.Close()
+		})
+
+		It("claims a new key", func() {
+			mock.ExpectExec(regexp.QuoteMeta(insertQuery)).
+				WithArgs(testWorkspace, testOwner, "abc", "fp", int64(3600)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			r, err := store.Begin(ctx, "abc", "fp", time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r).To(BeNil())
+		})
+
+		It("returns the response stored for a claimed key", func() {
+			mock.ExpectExec(regexp.QuoteMeta(insertQuery)).
+				WillReturnError(&mysql.MySQLError{Number: 1062})
+			mock.ExpectQuery(regexp.QuoteMeta(selectQuery)).
+				WithArgs(testWorkspace, testOwner, "abc").
+				WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "status", "headers", "body"}).
+					AddRow("fp", 201, `{"Location":["/todos/1"]}`, `{"id":1}`))
+
+			r, err := store.Begin(ctx, "abc", "fp", time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*r).To(Equal(StoredResponse{
+				Fingerprint: "fp",
+				Status:      201,
+				Header:      http.Header{"Location": {"/todos/1"}},
+				Body:        []byte(`{"id":1}`),
+			}))
+		})
+
+		It("reports a key still in progress", func() {
+			mock.ExpectExec(regexp.QuoteMeta(insertQuery)).
+				WillReturnError(&mysql.MySQLError{Number: 1062})
+			mock.ExpectQuery(regexp.QuoteMeta(selectQuery)).
+				WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "status", "headers", "body"}).
+					AddRow("fp", nil, nil, nil))
+
+			r, err := store.Begin(ctx, "abc", "fp", time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Status).To(BeZero())
+		})
+
+		It("completes and releases keys", func() {
+			// The cleanup is only run by Begin.
+			db.ExecContext(ctx, cleanupQuery)
+			mock.ExpectExec(regexp.QuoteMeta(completeQuery)).
+				WithArgs(201, []byte(`{"Location":["/todos/1"]}`), []byte(`{"id":1}`), testWorkspace, testOwner, "abc").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(regexp.QuoteMeta(releaseQuery)).
+				WithArgs(testWorkspace, testOwner, "xyz").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			r := StoredResponse{Status: 201, Header: http.Header{"Location": {"/todos/1"}}, Body: []byte(`{"id":1}`)}
+			Expect(store.Complete(ctx, "abc", r)).To(Succeed())
+			Expect(store.Release(ctx, "xyz")).To(Succeed())
+		})
+
+		It("keeps the keys of each workspace apart", func() {
+			mock.ExpectExec(regexp.QuoteMeta(insertQuery)).
+				WithArgs(testWorkspace+1, testOwner, "abc", "fp", int64(3600)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			r, err := store.Begin(WithWorkspace(ctx, testWorkspace+1), "abc", "fp", time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,274 @@
+package todo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ListHandler struct {
+	svc   ListService
+	todos Service
+}
+
+// NewListHandler returns a handler for the lists resource. Todos nested under
+// a list are served through todos.
+func NewListHandler(s ListService, todos Service) *ListHandler {
+	return &ListHandler{svc: s, todos: todos}
+}
+
+func (h *ListHandler) Register(r gin.IRoutes) {
+	r.GET("/lists", h.getAll)
+	r.GET("/lists/:id", h.getById)
+	r.POST("/lists", h.post)
+	r.PATCH("/lists/:id", h.patch)
+	r.DELETE("/lists/:id", h.delete)
+	r.GET("/lists/:id/todos", h.getTodos)
+	r.POST("/lists/:id/todos", h.postTodo)
+}
+
+// Scopes returns the scope API tokens need for each route, keyed by the
+// method followed by the path.
+func (h *ListHandler) Scopes() map[string]string {
+	return scopesByMethod(
+		"GET /lists", "GET /lists/:id", "POST /lists", "PATCH /lists/:id",
+		"DELETE /lists/:id", "GET /lists/:id/todos", "POST /lists/:id/todos",
+	)
+}
+
+func (h *ListHandler) getAll(ctx *gin.Context) {
+	var archived *bool
+	if v, ok := ctx.GetQuery("archived"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			r := NewErrorResponse(ErrBadQuery.Error(), "`archived` must be true or false")
+			writeError(ctx, http.StatusBadRequest, r)
+			return
+		}
+		archived = &b
+	}
+
+	c := ctx.Request.Context()
+	lists, err := h.svc.GetAll(c, archived)
+	if err != nil {
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, lists)
+}
+
+func (h *ListHandler) getById(ctx *gin.Context) {
+	i := ctx.Param("id")
+	id, err := strconv.ParseUint(i, 10, 32)
+	if err != nil {
+		r := NewErrorResponse(ErrBadId.Error(), "ID must be an integer")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	l, err := h.svc.GetById(c, uint32(id))
+	if err != nil {
+		if errors.Is(err, ErrListNotFound) {
+			msg := fmt.Sprintf("No resource found with ID = %d", id)
+			r := NewErrorResponse(ErrListNotFound.Error(), msg)
+			writeError(ctx, http.StatusNotFound, r)
+			return
+		}
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, l)
+}
+
+func (h *ListHandler) post(ctx *gin.Context) {
+	if ctx.ContentType() != "application/json" {
+		r := NewErrorResponse(ErrUnsupportedMediaType.Error(), "Content-Type must be application/json")
+		writeError(ctx, http.StatusUnsupportedMediaType, r)
+		return
+	}
+
+	var newList ListInput
+	err := decodeIntoInput(ctx, &newList)
+	if err != nil {
+		r := NewErrorResponse(ErrBadJson.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	if newList.Name == nil {
+		r := NewErrorResponse(ErrBadJson.Error(), "missing required `name` field")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	l, err := h.svc.Create(c, newList)
+	if err != nil {
+		if e := inputError(err); e != nil {
+			r := newInputErrorResponse(e, err)
+			writeError(ctx, http.StatusUnprocessableEntity, r)
+			return
+		}
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.Header("Location", fmt.Sprintf("/lists/%d", l.ID))
+	ctx.JSON(http.StatusCreated, l)
+}
+
+func (h *ListHandler) patch(ctx *gin.Context) {
+	if ctx.ContentType() != "application/json" {
+		r := NewErrorResponse(ErrUnsupportedMediaType.Error(), "Content-Type must be application/json")
+		writeError(ctx, http.StatusUnsupportedMediaType, r)
+		return
+	}
+
+	i := ctx.Param("id")
+	id, err := strconv.ParseUint(i, 10, 32)
+	if err != nil {
+		r := NewErrorResponse(ErrBadId.Error(), "ID must be an integer")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	var updatedList ListInput
+	err = decodeIntoInput(ctx, &updatedList)
+	if err != nil {
+		r := NewErrorResponse(ErrBadJson.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	if updatedList == (ListInput{}) {
+		msg := "missing at least one field to update"
+		r := NewErrorResponse(ErrBadJson.Error(), msg)
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	l, err := h.svc.Update(c, uint32(id), updatedList)
+	if err != nil {
+		if errors.Is(err, ErrForbidden) {
+			r := NewErrorResponse(ErrForbidden.Error(), "Only the owners of the list can change it")
+			writeError(ctx, http.StatusForbidden, r)
+			return
+		}
+		if e := inputError(err); e != nil {
+			r := newInputErrorResponse(e, err)
+			writeError(ctx, http.StatusUnprocessableEntity, r)
+			return
+		}
+		if errors.Is(err, ErrListNotFound) {
+			msg := fmt.Sprintf("No resource found with ID = %d", id)
+			r := NewErrorResponse(ErrListNotFound.Error(), msg)
+			writeError(ctx, http.StatusNotFound, r)
+			return
+		}
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, l)
+}
+
+func (h *ListHandler) delete(ctx *gin.Context) {
+	i := ctx.Param("id")
+	id, err := strconv.ParseUint(i, 10, 32)
+	if err != nil {
+		r := NewErrorResponse(ErrBadId.Error(), "ID must be an integer")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	err = h.svc.Delete(c, uint32(id))
+	if err != nil {
+		if errors.Is(err, ErrForbidden) {
+			r := NewErrorResponse(ErrForbidden.Error(), "Only the owners of the list can change it")
+			writeError(ctx, http.StatusForbidden, r)
+			return
+		}
+		if errors.Is(err, ErrListDefault) {
+			r := NewErrorResponse(ErrListDefault.Error(), "The default list cannot be deleted")
+			writeError(ctx, http.StatusConflict, r)
+			return
+		}
+		if errors.Is(err, ErrListNotFound) {
+			msg := fmt.Sprintf("No resource found with ID = %d", id)
+			r := NewErrorResponse(ErrListNotFound.Error(), msg)
+			writeError(ctx, http.StatusNotFound, r)
+			return
+		}
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.JSON(http.StatusNoContent, nil)
+}
+
+func (h *ListHandler) getTodos(ctx *gin.Context) {
+	p, err := parseListParams(ctx)
+	if err != nil {
+		r := NewErrorResponse(ErrBadQuery.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	id, ok := h.findList(ctx)
+	if !ok {
+		return
+	}
+
+	p.ListID = &id
+	listTodos(ctx, h.todos, p)
+}
+
+func (h *ListHandler) postTodo(ctx *gin.Context) {
+	id, ok := h.findList(ctx)
+	if !ok {
+		return
+	}
+
+	createTodo(ctx, h.todos, &id)
+}
+
+// findList parses the list ID in the path and checks that the list exists. If
+// not, it writes the error response and returns false.
+func (h *ListHandler) findList(ctx *gin.Context) (uint32, bool) {
+	i := ctx.Param("id")
+	id, err := strconv.ParseUint(i, 10, 32)
+	if err != nil {
+		r := NewErrorResponse(ErrBadId.Error(), "ID must be an integer")
+		writeError(ctx, http.StatusBadRequest, r)
+		return 0, false
+	}
+
+	c := ctx.Request.Context()
+	_, err = h.svc.GetById(c, uint32(id))
+	if err != nil {
+		if errors.Is(err, ErrListNotFound) {
+			msg := fmt.Sprintf("No resource found with ID = %d", id)
+			r := NewErrorResponse(ErrListNotFound.Error(), msg)
+			writeError(ctx, http.StatusNotFound, r)
+			return 0, false
+		}
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return 0, false
+	}
+
+	return uint32(id), true
+}
@@ -0,0 +1,226 @@
+package todo_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+type mockListService struct {
+	getAllFn  func(context.Context, *bool) ([]List, error)
+	getByIDFn func(context.Context, uint32) (*List, error)
+	createFn  func(context.Context, ListInput) (*List, error)
+	updateFn  func(context.Context, uint32, ListInput) (*List, error)
+	deleteFn  func(context.Context, uint32) error
+}
+
+var _ ListService = (*mockListService)(nil)
+
+func (m *mockListService) GetAll(ctx context.Context, archived *bool) ([]List, error) {
+	if m.getAllFn != nil {
+		return m.getAllFn(ctx, archived)
+	}
+	return []List{}, nil
+}
+
+func (m *mockListService) GetById(ctx context.Context, id uint32) (*List, error) {
+	if m.getByIDFn != nil {
+		return m.getByIDFn(ctx, id)
+	}
+	return &List{ID: id}, nil
+}
+
+func (m *mockListService) Create(ctx context.Context, in ListInput) (*List, error) {
+	if m.createFn != nil {
+		return m.createFn(ctx, in)
+	}
+	return nil, nil
+}
+
+func (m *mockListService) Update(ctx context.Context, id uint32, in ListInput) (*List, error) {
+	if m.updateFn != nil {
+		return m.updateFn(ctx, id, in)
+	}
+	return nil, nil
+}
+
+func (m *mockListService) Delete(ctx context.Context, id uint32) error {
+	if m.deleteFn != nil {
+		return m.deleteFn(ctx, id)
+	}
+	return nil
+}
+
+var _ = Describe("list handler", Label("list-handler"), func() {
+	var (
+		svc    *mockListService
+		todos  *mockService
+		router *gin.Engine
+		rr     *httptest.ResponseRecorder
+	)
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		svc = &mockListService{}
+		todos = &mockService{}
+		router = gin.New()
+		NewListHandler(svc, todos).Register(router)
+		rr = httptest.NewRecorder()
+	})
+
+	Describe("GET /lists", func() {
+		It("Passes the archived filter to the service", func() {
+			svc.getAllFn = func(ctx context.Context, archived *bool) ([]List, error) {
+				Expect(archived).To(PointTo(BeFalse()))
+				return []List{{ID: 1, Name: "Inbox"}}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/lists?archived=false", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			var out []List
+			Expect(json.Unmarshal(rr.Body.Bytes(), &out)).To(Succeed())
+			Expect(out).To(HaveLen(1))
+		})
+
+		It("Reports bad request for a malformed archived filter", func() {
+			req := httptest.NewRequest(http.MethodGet, "/lists?archived=maybe", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Describe("POST /lists", func() {
+		It("Creates a list", func() {
+			svc.createFn = func(ctx context.Context, in ListInput) (*List, error) {
+				Expect(in.Name).To(PointTo(Equal("Work")))
+				return &List{ID: 2, Name: *in.Name}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/lists", strings.NewReader(`{"name":"Work"}`))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusCreated))
+			Expect(rr.Header().Get("Location")).To(Equal("/lists/2"))
+		})
+
+		It("Reports unprocessable entity for an invalid name", func() {
+			svc.createFn = func(ctx context.Context, in ListInput) (*List, error) { return nil, ErrListNameInvalid }
+
+			req := httptest.NewRequest(http.MethodPost, "/lists", strings.NewReader(`{"name":"  "}`))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+		})
+	})
+
+	Describe("PATCH /lists/:id", func() {
+		It("Archives a list", func() {
+			svc.updateFn = func(ctx context.Context, id uint32, in ListInput) (*List, error) {
+				Expect(in.Archived).To(PointTo(BeTrue()))
+				return &List{ID: id, Archived: true}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodPatch, "/lists/2", strings.NewReader(`{"archived":true}`))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+		})
+
+		It("Reports not found", func() {
+			svc.updateFn = func(ctx context.Context, id uint32, in ListInput) (*List, error) { return nil, ErrListNotFound }
+
+			req := httptest.NewRequest(http.MethodPatch, "/lists/2", strings.NewReader(`{"position":3}`))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("DELETE /lists/:id", func() {
+		It("Reports conflict for the default list", func() {
+			svc.deleteFn = func(ctx context.Context, id uint32) error { return ErrListDefault }
+
+			req := httptest.NewRequest(http.MethodDelete, "/lists/1", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusConflict))
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Code).To(Equal(ErrListDefault.Error()))
+		})
+	})
+
+	Describe("GET /lists/:id/todos", func() {
+		It("Lists the todos of the list", func() {
+			todos.getAllFn = func(ctx context.Context, p ListParams) (*Page, error) {
+				Expect(p.ListID).To(PointTo(BeEquivalentTo(3)))
+				Expect(p.Completed).To(PointTo(BeFalse()))
+				return &Page{Todos: []Todo{{ID: 5, ListID: 3}}, Total: 1}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/lists/3/todos?completed=false&list_id=7", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Header().Get("X-Total-Count")).To(Equal("1"))
+		})
+
+		It("Reports not found for a missing list", func() {
+			svc.getByIDFn = func(ctx context.Context, id uint32) (*List, error) { return nil, ErrListNotFound }
+			todos.getAllFn = func(ctx context.Context, p ListParams) (*Page, error) {
+				Fail("todos should not be listed")
+				return nil, nil
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/lists/3/todos", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusNotFound))
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Code).To(Equal(ErrListNotFound.Error()))
+		})
+
+		It("Reports internal server error", func() {
+			svc.getByIDFn = func(ctx context.Context, id uint32) (*List, error) { return nil, errors.New("database is down") }
+
+			req := httptest.NewRequest(http.MethodGet, "/lists/3/todos", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusInternalServerError))
+		})
+	})
+
+	Describe("POST /lists/:id/todos", func() {
+		It("Creates the todo in the list", func() {
+			todos.createFn = func(ctx context.Context, in TodoInput) (*Todo, error) {
+				Expect(in.ListID).To(PointTo(BeEquivalentTo(3)))
+				return &Todo{ID: 8, ListID: *in.ListID}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/lists/3/todos", strings.NewReader(`{"text":"buy milk","list_id":7}`))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusCreated))
+			Expect(rr.Header().Get("Location")).To(Equal("/todos/8"))
+		})
+	})
+})
@@ -0,0 +1,173 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+const listsTable = "lists"
+
+// listColumns lists the columns selected for a List, in the order listFields
+// expects. The role of the user is that of their membership, unless they own
+// the list.
+const listColumns = "l.id, l.name, l.description, l.color, l.archived, l.position, l.owner_id, COALESCE(IF(l.owner_id = ?, 'owner', m.role), ''), l.created_at, l.updated_at"
+
+// visibleLists selects the lists a user sees within a workspace: those without
+// an owner, which belong to no workspace, and their own and those shared with
+// them in the workspace. It takes the ID of the user twice, then the workspace
+// and the user again.
+var visibleLists = fmt.Sprintf("SELECT %s FROM `%s` l LEFT JOIN `%s` m ON m.list_id = l.id AND m.user_id = ? WHERE (l.owner_id IS NULL OR (l.workspace_id = ? AND (l.owner_id = ? OR m.user_id IS NOT NULL)))", listColumns, listsTable, listMembersTable)
+
+// ListRepository stores lists. Only the lists a user sees are read on their
+// behalf, while who may change them is up to the ListService.
+type ListRepository interface {
+	List(ctx context.Context, archived *bool) ([]List, error)
+	Get(ctx context.Context, id uint32) (*List, error)
+	Create(ctx context.Context, in ListInput) (*List, error)
+	Update(ctx context.Context, id uint32, in ListInput) (*List, error)
+}
+
+type sqllistrepo struct {
+	db *sql.DB
+}
+
+func NewListRepo(db *sql.DB) ListRepository {
+	return &sqllistrepo{db: db}
+}
+
+// List returns the lists the user sees in display order, optionally only
+// those with the given archived flag.
+func (r *sqllistrepo) List(ctx context.Context, archived *bool) ([]List, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := visibleLists
+	args := []any{owner, owner, workspace, owner}
+	if archived != nil {
+		query += " AND l.archived = ?"
+		args = append(args, *archived)
+	}
+	query += " ORDER BY l.position ASC, l.id ASC"
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := []List{}
+	for rows.Next() {
+		var l List
+		if err := rows.Scan(listFields(&l)...); err != nil {
+			return nil, err
+		}
+		lists = append(lists, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lists, nil
+}
+
+// Get returns list id, if the user sees it.
+func (r *sqllistrepo) Get(ctx context.Context, id uint32) (*List, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := visibleLists + " AND l.id=?"
+
+	var l List
+	err = r.db.QueryRowContext(ctx, query, owner, owner, workspace, owner, id).Scan(listFields(&l)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrListNotFound
+		}
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+// Create inserts a list owned by the user in the workspace. Lists created
+// without a position are placed after all the others of the user in the
+// workspace.
+func (r *sqllistrepo) Create(ctx context.Context, in ListInput) (*List, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The workspace stays locked until the list is inserted, so lists created
+	// at the same time aren't given the same position.
+	var locked uint32
+	query := fmt.Sprintf("SELECT id FROM `%s` WHERE id=? FOR UPDATE", workspacesTable)
+	if err := tx.QueryRowContext(ctx, query, workspace).Scan(&locked); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrWorkspaceNotFound
+		}
+		return nil, err
+	}
+
+	query = fmt.Sprintf("INSERT INTO `%[1]s` (name, description, color, archived, position, workspace_id, owner_id) SELECT ?, ?, ?, IFNULL(?, FALSE), IFNULL(?, COALESCE(MAX(position) + 1, 0)), ?, ? FROM `%[1]s` WHERE workspace_id <=> ? AND owner_id <=> ?", listsTable)
+
+	result, err := tx.ExecContext(ctx, query, in.Name, in.Description, in.Color, in.Archived, in.Position, workspace, owner, workspace, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return r.Get(ctx, uint32(id))
+}
+
+// Update changes list id if the user owns it in the workspace. Lists without an
+// owner and those shared with the user are left as they are.
+func (r *sqllistrepo) Update(ctx context.Context, id uint32, in ListInput) (*List, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("UPDATE `%s` SET name = IFNULL(?, name), description = IFNULL(?, description), color = IFNULL(?, color), archived = IFNULL(?, archived), position = IFNULL(?, position) WHERE id=? AND workspace_id=? AND owner_id=?", listsTable)
+
+	result, err := r.db.ExecContext(ctx, query, in.Name, in.Description, in.Color, in.Archived, in.Position, id, workspace, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rows > 1 {
+		log.Print("unexpected: multiple rows affected")
+		return nil, ErrUnexpected
+	}
+
+	return r.Get(ctx, id)
+}
+
+func listFields(l *List) []any {
+	return []any{&l.ID, &l.Name, &l.Description, &l.Color, &l.Archived, &l.Position, &l.OwnerID, &l.Role, &l.CreatedAt, &l.UpdatedAt}
+}
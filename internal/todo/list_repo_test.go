@@ -0,0 +1,144 @@
+package todo_test
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+var _ = Describe("list repo", Label("list-repo"), func() {
+	var (
+		ctx  context.Context
+		db   *sql.DB
+		mock sqlmock.Sqlmock
+		repo ListRepository
+		now  time.Time
+		rows *sqlmock.Rows
+	)
+
+	const (
+		selectQuery = "SELECT l.id, l.name, l.description, l.color, l.archived, l.position, l.owner_id, COALESCE(IF(l.owner_id = ?, 'owner', m.role), ''), l.created_at, l.updated_at FROM `lists` l LEFT JOIN `list_members` m ON m.list_id = l.id AND m.user_id = ? WHERE (l.owner_id IS NULL OR (l.workspace_id = ? AND (l.owner_id = ? OR m.user_id IS NOT NULL)))"
+		getQuery    = selectQuery + " AND l.id=?"
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = WithWorkspace(WithUser(context.Background(), testOwner), testWorkspace)
+		db, mock, err = sqlmock.New()
+		Expect(err).NotTo(HaveOccurred())
+		repo = NewListRepo(db)
+		now = time.Now().UTC().Truncate(time.Second)
+		rows = sqlmock.NewRows([]string{"id", "name", "description", "color", "archived", "position", "owner_id", "role", "created_at", "updated_at"})
+	})
+
+	AfterEach(func() {
+		mock.ExpectClose()
+		Expect(db.Close()).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("lists the lists the user sees in display order", func() {
+		mock.ExpectQuery(regexp.QuoteMeta(selectQuery+" ORDER BY l.position ASC, l.id ASC")).
+			WithArgs(testOwner, testOwner, testWorkspace, testOwner).
+			WillReturnRows(rows.
+				AddRow(1, "Inbox", nil, nil, false, 0, nil, "", now, now).
+				AddRow(2, "Work", "Day job", "#336699", false, 1, testOwner, "owner", now, now).
+				AddRow(3, "Groceries", nil, nil, false, 2, 8, "editor", now, now))
+
+		lists, err := repo.List(ctx, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lists).To(HaveLen(3))
+		Expect(lists[0].Name).To(Equal("Inbox"))
+		Expect(lists[0].OwnerID).To(BeNil())
+		Expect(lists[0].Role).To(BeEmpty())
+		Expect(*lists[1].Description).To(Equal("Day job"))
+		Expect(lists[1].Role).To(Equal(RoleOwner))
+		Expect(*lists[2].OwnerID).To(BeEquivalentTo(8))
+		Expect(lists[2].Role).To(Equal(RoleEditor))
+	})
+
+	It("refuses to list lists without a user", func() {
+		_, err := repo.List(context.Background(), nil)
+		Expect(err).To(MatchError(ErrUnauthenticated))
+	})
+
+	It("filters lists by archived flag", func() {
+		mock.ExpectQuery(regexp.QuoteMeta(selectQuery+" AND l.archived = ? ORDER BY l.position ASC, l.id ASC")).
+			WithArgs(testOwner, testOwner, testWorkspace, testOwner, true).
+			WillReturnRows(rows)
+
+		archived := true
+		lists, err := repo.List(ctx, &archived)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lists).To(BeEmpty())
+	})
+
+	It("returns list not found errors", func() {
+		mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WithArgs(testOwner, testOwner, testWorkspace, testOwner, 9).WillReturnError(sql.ErrNoRows)
+
+		l, err := repo.Get(ctx, 9)
+		Expect(err).To(MatchError(ErrListNotFound))
+		Expect(l).To(BeNil())
+	})
+
+	It("appends new lists owned by the user after their others in the workspace", func() {
+		name := "Groceries"
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM `workspaces` WHERE id=? FOR UPDATE")).
+			WithArgs(testWorkspace).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(testWorkspace))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `lists` (name, description, color, archived, position, workspace_id, owner_id) SELECT ?, ?, ?, IFNULL(?, FALSE), IFNULL(?, COALESCE(MAX(position) + 1, 0)), ?, ? FROM `lists` WHERE workspace_id <=> ? AND owner_id <=> ?")).
+			WithArgs(&name, nil, nil, nil, nil, testWorkspace, testOwner, testWorkspace, testOwner).
+			WillReturnResult(sqlmock.NewResult(3, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WithArgs(testOwner, testOwner, testWorkspace, testOwner, 3).WillReturnRows(rows.AddRow(3, name, nil, nil, false, 2, testOwner, "owner", now, now))
+
+		l, err := repo.Create(ctx, ListInput{Name: &name})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(l.ID).To(BeEquivalentTo(3))
+		Expect(l.Position).To(Equal(2))
+		Expect(*l.OwnerID).To(BeEquivalentTo(testOwner))
+	})
+
+	It("doesn't create lists in missing workspaces", func() {
+		name := "Groceries"
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM `workspaces` WHERE id=? FOR UPDATE")).
+			WithArgs(testWorkspace).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectRollback()
+
+		l, err := repo.Create(ctx, ListInput{Name: &name})
+		Expect(err).To(MatchError(ErrWorkspaceNotFound))
+		Expect(l).To(BeNil())
+	})
+
+	It("updates only the given fields", func() {
+		archived := true
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `lists` SET name = IFNULL(?, name), description = IFNULL(?, description), color = IFNULL(?, color), archived = IFNULL(?, archived), position = IFNULL(?, position) WHERE id=? AND workspace_id=? AND owner_id=?")).
+			WithArgs(nil, nil, nil, &archived, nil, 2, testWorkspace, testOwner).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WithArgs(testOwner, testOwner, testWorkspace, testOwner, 2).WillReturnRows(rows.AddRow(2, "Work", nil, nil, true, 1, testOwner, "owner", now, now))
+
+		l, err := repo.Update(ctx, 2, ListInput{Archived: &archived})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(l.Archived).To(BeTrue())
+	})
+
+	It("doesn't reach the lists of the user in other workspaces", func() {
+		other := WithWorkspace(ctx, testWorkspace+1)
+		mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WithArgs(testOwner, testOwner, testWorkspace+1, testOwner, 2).WillReturnError(sql.ErrNoRows)
+
+		_, err := repo.Get(other, 2)
+		Expect(err).To(MatchError(ErrListNotFound))
+		_, err = repo.List(WithUser(context.Background(), testOwner), nil)
+		Expect(err).To(MatchError(ErrWorkspaceRequired))
+	})
+})
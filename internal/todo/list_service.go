@@ -0,0 +1,126 @@
+package todo
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+)
+
+const MaxListNameLength = 255
+
+type ListService interface {
+	GetAll(ctx context.Context, archived *bool) ([]List, error)
+	GetById(ctx context.Context, id uint32) (*List, error)
+	Create(ctx context.Context, in ListInput) (*List, error)
+	Update(ctx context.Context, id uint32, in ListInput) (*List, error)
+	Delete(ctx context.Context, id uint32) error
+}
+
+type listService struct {
+	repo        ListRepository
+	todos       Service
+	defaultList uint32
+}
+
+// NewListService returns a ListService. The list with ID defaultList receives
+// todos created without a list and can't be deleted. Deleting any other list
+// goes through todos, which trashes the todos in it.
+func NewListService(r ListRepository, todos Service, defaultList uint32) ListService {
+	return &listService{repo: r, todos: todos, defaultList: defaultList}
+}
+
+func (s *listService) GetAll(ctx context.Context, archived *bool) ([]List, error) {
+	lists, err := s.repo.List(ctx, archived)
+	if err != nil {
+		return nil, err
+	}
+
+	return lists, nil
+}
+
+func (s *listService) GetById(ctx context.Context, id uint32) (*List, error) {
+	l, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (s *listService) Create(ctx context.Context, in ListInput) (*List, error) {
+	if err := normalizeListInput(&in); err != nil {
+		return nil, err
+	}
+
+	l, err := s.repo.Create(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (s *listService) Update(ctx context.Context, id uint32, in ListInput) (*List, error) {
+	if err := normalizeListInput(&in); err != nil {
+		return nil, err
+	}
+	if err := s.checkOwner(ctx, id); err != nil {
+		return nil, err
+	}
+
+	l, err := s.repo.Update(ctx, id, in)
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (s *listService) Delete(ctx context.Context, id uint32) error {
+	if id == s.defaultList {
+		return ErrListDefault
+	}
+	if err := s.checkOwner(ctx, id); err != nil {
+		return err
+	}
+
+	err := s.todos.DeleteList(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkOwner verifies that the user may change list id, which only its owner
+// may. Lists common to every user, which have no owner, are read-only.
+func (s *listService) checkOwner(ctx context.Context, id uint32) error {
+	l, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if l.OwnerID == nil || !l.Role.Includes(RoleOwner) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+func normalizeListInput(in *ListInput) error {
+	if in.Name != nil {
+		name := strings.TrimSpace(*in.Name)
+		if name == "" || utf8.RuneCountInString(name) > MaxListNameLength {
+			return ErrListNameInvalid
+		}
+		in.Name = &name
+	}
+
+	if in.Color != nil {
+		if !colorPattern.MatchString(*in.Color) {
+			return ErrListColorInvalid
+		}
+		color := strings.ToLower(*in.Color)
+		in.Color = &color
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package todo_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+type mockListRepo struct {
+	getFn    func(context.Context, uint32) (*List, error)
+	createFn func(context.Context, ListInput) (*List, error)
+}
+
+var _ ListRepository = (*mockListRepo)(nil)
+
+func (m *mockListRepo) List(ctx context.Context, archived *bool) ([]List, error) {
+	return []List{}, nil
+}
+
+func (m *mockListRepo) Get(ctx context.Context, id uint32) (*List, error) {
+	if m.getFn != nil {
+		return m.getFn(ctx, id)
+	}
+	owner := uint32(7)
+	return &List{ID: id, OwnerID: &owner, Role: RoleOwner}, nil
+}
+
+func (m *mockListRepo) Create(ctx context.Context, in ListInput) (*List, error) {
+	if m.createFn != nil {
+		return m.createFn(ctx, in)
+	}
+	return &List{}, nil
+}
+
+func (m *mockListRepo) Update(ctx context.Context, id uint32, in ListInput) (*List, error) {
+	return &List{ID: id}, nil
+}
+
+var _ = Describe("list service", Label("list-service"), func() {
+	var (
+		ctx   context.Context
+		repo  *mockListRepo
+		todos *mockService
+		svc   ListService
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		repo = &mockListRepo{}
+		todos = &mockService{}
+		svc = NewListService(repo, todos, DefaultListID)
+	})
+
+	It("trims names and lowercases colors", func() {
+		name := "  Work "
+		color := "#AABBCC"
+		repo.createFn = func(ctx context.Context, in ListInput) (*List, error) {
+			Expect(in.Name).To(PointTo(Equal("Work")))
+			Expect(in.Color).To(PointTo(Equal("#aabbcc")))
+			return &List{}, nil
+		}
+
+		_, err := svc.Create(ctx, ListInput{Name: &name, Color: &color})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("rejects blank names", func() {
+		name := "   "
+
+		_, err := svc.Create(ctx, ListInput{Name: &name})
+		Expect(err).To(MatchError(ErrListNameInvalid))
+	})
+
+	It("rejects malformed colors", func() {
+		color := "blue"
+
+		_, err := svc.Update(ctx, 2, ListInput{Color: &color})
+		Expect(err).To(MatchError(ErrListColorInvalid))
+	})
+
+	It("refuses to delete the default list", func() {
+		todos.delListFn = func(ctx context.Context, id uint32) error {
+			Fail("todo service should not be called")
+			return nil
+		}
+
+		Expect(svc.Delete(ctx, DefaultListID)).To(MatchError(ErrListDefault))
+	})
+
+	It("deletes lists through the todo service", func() {
+		deleted := uint32(0)
+		todos.delListFn = func(ctx context.Context, id uint32) error {
+			deleted = id
+			return nil
+		}
+
+		Expect(svc.Delete(ctx, 2)).To(Succeed())
+		Expect(deleted).To(BeEquivalentTo(2))
+	})
+
+	It("keeps lists without an owner read-only", func() {
+		repo.getFn = func(ctx context.Context, id uint32) (*List, error) {
+			return &List{ID: id}, nil
+		}
+		todos.delListFn = func(ctx context.Context, id uint32) error {
+			Fail("todo service should not be called")
+			return nil
+		}
+		name := "Chores"
+
+		_, err := svc.Update(ctx, 2, ListInput{Name: &name})
+		Expect(err).To(MatchError(ErrForbidden))
+		Expect(svc.Delete(ctx, 2)).To(MatchError(ErrForbidden))
+	})
+
+	It("only lets the owners of shared lists change them", func() {
+		owner := uint32(8)
+		repo.getFn = func(ctx context.Context, id uint32) (*List, error) {
+			return &List{ID: id, OwnerID: &owner, Role: RoleEditor}, nil
+		}
+		todos.delListFn = func(ctx context.Context, id uint32) error {
+			Fail("todo service should not be called")
+			return nil
+		}
+		name := "Chores"
+
+		_, err := svc.Update(ctx, 2, ListInput{Name: &name})
+		Expect(err).To(MatchError(ErrForbidden))
+		Expect(svc.Delete(ctx, 2)).To(MatchError(ErrForbidden))
+
+		repo.getFn = func(ctx context.Context, id uint32) (*List, error) {
+			return &List{ID: id, OwnerID: &owner, Role: RoleOwner}, nil
+		}
+		_, err = svc.Update(ctx, 2, ListInput{Name: &name})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
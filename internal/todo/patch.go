@@ -0,0 +1,407 @@
+package todo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Media types of the documents accepted by PATCH /todos/{id}.
+const (
+	mediaTypeJSON       = "application/json"
+	mediaTypeMergePatch = "application/merge-patch+json"
+	mediaTypeJSONPatch  = "application/json-patch+json"
+)
+
+// Fields of a todo that a patch may change, and those it may not. Nulls clear
+// the nullable ones.
+var (
+	requiredFields = []string{"text", "completed", "priority", "list_id"}
+	nullableFields = []string{"start_at", "due_at", "parent_id", "recurrence", "tags"}
+	readOnlyFields = []string{"id", "owner_id", "series_id", "occurrence", "progress", "created_at", "updated_at", "deleted_at", "version", "due_date_only", "children"}
+)
+
+// patchError is an error applying a patch document to a todo. Err is one of
+// ErrBadJson, ErrBadPatch, ErrPatchTestFailed, ErrReadOnlyField or
+// ErrInputInvalid.
+type patchError struct {
+	Err error
+	Msg string
+}
+
+func (e *patchError) Error() string {
+	return e.Msg
+}
+
+func (e *patchError) Unwrap() error {
+	return e.Err
+}
+
+func patchErrorf(err error, format string, args ...any) error {
+	return &patchError{Err: err, Msg: fmt.Sprintf(format, args...)}
+}
+
+// patchOp is an operation of a JSON Patch (RFC 6902). Value is nil if the
+// operation has none, and the JSON null otherwise.
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// patchTodo applies a JSON Merge Patch or a JSON Patch document, as told by
+// its media type, to the JSON representation of t. It returns the input
+// updating t to the patched representation, which is empty if the patch
+// changes nothing.
+func patchTodo(t *Todo, mediaType string, body []byte) (TodoInput, error) {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return TodoInput{}, err
+	}
+	var orig, doc map[string]any
+	if err := json.Unmarshal(b, &orig); err != nil {
+		return TodoInput{}, err
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return TodoInput{}, err
+	}
+
+	var patched any
+	switch mediaType {
+	case mediaTypeMergePatch:
+		var patch any
+		if err := json.Unmarshal(body, &patch); err != nil {
+			return TodoInput{}, patchErrorf(ErrBadJson, "invalid json input")
+		}
+		patched = mergePatch(doc, patch)
+	case mediaTypeJSONPatch:
+		var ops []patchOp
+		if err := json.Unmarshal(body, &ops); err != nil {
+			return TodoInput{}, patchErrorf(ErrBadPatch, "a JSON Patch must be an array of operations")
+		}
+		patched, err = applyJSONPatch(doc, ops)
+		if err != nil {
+			return TodoInput{}, err
+		}
+	default:
+		return TodoInput{}, fmt.Errorf("unsupported patch media type %q", mediaType)
+	}
+
+	m, ok := patched.(map[string]any)
+	if !ok {
+		return TodoInput{}, patchErrorf(ErrInputInvalid, "a todo must be a JSON object")
+	}
+	return patchInput(orig, m)
+}
+
+// patchInput returns the input turning the todo orig into patched. A missing
+// field is taken as null.
+func patchInput(orig, patched map[string]any) (TodoInput, error) {
+	keys := slices.Sorted(maps.Keys(orig))
+	for k := range patched {
+		if _, ok := orig[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+
+	var in TodoInput
+	for _, k := range keys {
+		v := patched[k]
+		if reflect.DeepEqual(orig[k], v) {
+			continue
+		}
+
+		switch {
+		case slices.Contains(readOnlyFields, k):
+			return TodoInput{}, patchErrorf(ErrReadOnlyField, "field %q is read-only", k)
+		case slices.Contains(requiredFields, k):
+			if v == nil {
+				return TodoInput{}, patchErrorf(ErrInputInvalid, "field %q cannot be null", k)
+			}
+		case slices.Contains(nullableFields, k):
+			if v == nil {
+				clearField(&in, k)
+				continue
+			}
+		default:
+			return TodoInput{}, patchErrorf(ErrInputInvalid, "unknown field %q", k)
+		}
+
+		b, err := json.Marshal(map[string]any{k: v})
+		if err != nil {
+			return TodoInput{}, err
+		}
+		if err := json.Unmarshal(b, &in); err != nil {
+			return TodoInput{}, patchErrorf(ErrInputInvalid, "invalid value for field %q", k)
+		}
+	}
+
+	return in, nil
+}
+
+// clearField sets the nullable field k of an update to the value clearing it.
+func clearField(in *TodoInput, k string) {
+	switch k {
+	case "start_at":
+		in.StartAt = &DateTime{}
+	case "due_at":
+		in.DueAt = &DateTime{}
+	case "parent_id":
+		var none uint32
+		in.ParentID = &none
+	case "recurrence":
+		var none string
+		in.Recurrence = &none
+	case "tags":
+		in.Tags = &[]string{}
+	}
+}
+
+// mergePatch applies a JSON Merge Patch (RFC 7396) to target. Nulls in the
+// patch remove members of the target.
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// applyJSONPatch applies the operations of a JSON Patch (RFC 6902) to doc in
+// turn. The patch fails as a whole if any operation does, including a failed
+// test.
+func applyJSONPatch(doc any, ops []patchOp) (any, error) {
+	for i, op := range ops {
+		var err error
+		doc, err = applyOp(doc, op)
+		if err != nil {
+			var pe *patchError
+			if errors.As(err, &pe) {
+				pe.Msg = fmt.Sprintf("operation %d: %s", i, pe.Msg)
+			}
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func applyOp(doc any, op patchOp) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, patchErrorf(ErrBadPatch, "%q requires a value", op.Op)
+		}
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, patchErrorf(ErrBadPatch, "invalid value")
+		}
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" && len(from) < len(path) && slices.Equal(from, path[:len(from)]) {
+			return nil, patchErrorf(ErrBadPatch, "cannot move %q into itself", op.From)
+		}
+		if value, err = getPointer(doc, from); err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			doc, err = removePointer(doc, from)
+		} else {
+			value, err = deepCopy(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch op.Op {
+	case "add", "move", "copy":
+		return addPointer(doc, path, value)
+	case "remove":
+		return removePointer(doc, path)
+	case "replace":
+		if len(path) == 0 {
+			return value, nil
+		}
+		doc, err := removePointer(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		return addPointer(doc, path, value)
+	case "test":
+		got, err := getPointer(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(got, value) {
+			return nil, patchErrorf(ErrPatchTestFailed, "%q does not match the tested value", op.Path)
+		}
+		return doc, nil
+	}
+	return nil, patchErrorf(ErrBadPatch, "unknown op %q", op.Op)
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its unescaped reference
+// tokens.
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, patchErrorf(ErrBadPatch, "path %q must start with a slash", p)
+	}
+
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		if strings.Contains(strings.NewReplacer("~0", "", "~1", "").Replace(t), "~") {
+			return nil, patchErrorf(ErrBadPatch, "path %q has an invalid escape", p)
+		}
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses a reference token indexing an array of length n. With
+// appending set, "-" and n refer to the position after the last element.
+func arrayIndex(t string, n int, appending bool) (int, error) {
+	if appending && t == "-" {
+		return n, nil
+	}
+	i, err := strconv.Atoi(t)
+	if err != nil || i < 0 || (t != "0" && strings.HasPrefix(t, "0")) {
+		return 0, patchErrorf(ErrBadPatch, "invalid array index %q", t)
+	}
+	if i > n || (i == n && !appending) {
+		return 0, patchErrorf(ErrBadPatch, "array index %d is out of bounds", i)
+	}
+	return i, nil
+}
+
+// getPointer returns the value at path.
+func getPointer(doc any, path []string) (any, error) {
+	for _, t := range path {
+		switch c := doc.(type) {
+		case map[string]any:
+			v, ok := c[t]
+			if !ok {
+				return nil, patchErrorf(ErrBadPatch, "member %q does not exist", t)
+			}
+			doc = v
+		case []any:
+			i, err := arrayIndex(t, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = c[i]
+		default:
+			return nil, patchErrorf(ErrBadPatch, "cannot index into a scalar with %q", t)
+		}
+	}
+	return doc, nil
+}
+
+// updatePointer replaces the parent of the location at path with the result
+// of fn, given the parent and the last reference token.
+func updatePointer(doc any, path []string, fn func(parent any, t string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+
+	child, err := getPointer(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	child, err = updatePointer(child, path[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c := doc.(type) {
+	case map[string]any:
+		c[path[0]] = child
+	case []any:
+		i, _ := arrayIndex(path[0], len(c), false)
+		c[i] = child
+	}
+	return doc, nil
+}
+
+// addPointer adds value at path, replacing an existing member of an object or
+// shifting the elements of an array.
+func addPointer(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return updatePointer(doc, path, func(parent any, t string) (any, error) {
+		switch c := parent.(type) {
+		case map[string]any:
+			c[t] = value
+			return c, nil
+		case []any:
+			i, err := arrayIndex(t, len(c), true)
+			if err != nil {
+				return nil, err
+			}
+			return slices.Insert(c, i, value), nil
+		}
+		return nil, patchErrorf(ErrBadPatch, "cannot add %q to a scalar", t)
+	})
+}
+
+// removePointer removes the value at path, which must exist.
+func removePointer(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, patchErrorf(ErrBadPatch, "cannot remove the whole document")
+	}
+
+	return updatePointer(doc, path, func(parent any, t string) (any, error) {
+		if _, err := getPointer(parent, []string{t}); err != nil {
+			return nil, err
+		}
+		switch c := parent.(type) {
+		case map[string]any:
+			delete(c, t)
+			return c, nil
+		case []any:
+			i, _ := arrayIndex(t, len(c), false)
+			return slices.Delete(c, i, i+1), nil
+		}
+		return parent, nil
+	})
+}
+
+func deepCopy(v any) (any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var c any
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
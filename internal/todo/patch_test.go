@@ -0,0 +1,199 @@
+package todo_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+var _ = Describe("patch documents", Label("handler", "patch"), func() {
+	var (
+		svc     *mockService
+		router  *gin.Engine
+		cur     Todo
+		updates []TodoInput
+	)
+
+	patch := func(contentType, payload string, header ...string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPatch, "/todos/3", strings.NewReader(payload))
+		req.Header.Set("Content-Type", contentType)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	errorCode := func(rr *httptest.ResponseRecorder) string {
+		var resp Problem
+		Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+		return resp.Code
+	}
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		due := time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)
+		parent := uint32(1)
+		rule := "FREQ=DAILY"
+		cur = Todo{
+			ID:         3,
+			Text:       "water the plants",
+			Priority:   PriorityLow,
+			DueAt:      &due,
+			ListID:     1,
+			ParentID:   &parent,
+			Recurrence: &rule,
+			Tags:       []string{"home", "garden"},
+			Occurrence: 1,
+			Version:    4,
+		}
+		updates = nil
+		svc = &mockService{
+			getByIDFn: func(ctx context.Context, id uint32) (*Todo, error) {
+				if id != cur.ID {
+					return nil, ErrTodoNotFound
+				}
+				t := cur
+				return &t, nil
+			},
+			updateFn: func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+				updates = append(updates, in)
+				t := cur
+				t.Version++
+				return &t, nil
+			},
+		}
+		router = gin.New()
+		NewHandler(svc).Register(router)
+	})
+
+	Describe("JSON Merge Patch", func() {
+		It("updates the given fields and clears those set to null", func() {
+			rr := patch("application/merge-patch+json", `{"text":"water the roses","due_at":null,"parent_id":null,"recurrence":null,"tags":null}`)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Header().Get("ETag")).To(Equal(`"5"`))
+			Expect(updates).To(HaveLen(1))
+			in := updates[0]
+			Expect(in.Text).To(PointTo(Equal("water the roses")))
+			Expect(in.DueAt).To(PointTo(Equal(DateTime{})))
+			Expect(in.ParentID).To(PointTo(BeZero()))
+			Expect(in.Recurrence).To(PointTo(BeEmpty()))
+			Expect(in.Tags).To(PointTo(BeEmpty()))
+			Expect(in.Completed).To(BeNil())
+			Expect(in.StartAt).To(BeNil())
+			Expect(in.Version).To(PointTo(Equal(uint32(4))))
+		})
+
+		It("leaves the todo alone if nothing changes", func() {
+			rr := patch("application/merge-patch+json", `{"text":"water the plants","start_at":null}`)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Header().Get("ETag")).To(Equal(`"4"`))
+			Expect(updates).To(BeEmpty())
+		})
+
+		DescribeTable("rejects invalid patches",
+			func(payload string, status int, code error) {
+				rr := patch("application/merge-patch+json", payload)
+				Expect(rr.Code).To(Equal(status))
+				Expect(errorCode(rr)).To(Equal(code.Error()))
+				Expect(updates).To(BeEmpty())
+			},
+			Entry("malformed json", `{"text":`, http.StatusBadRequest, ErrBadJson),
+			Entry("null required field", `{"text":null}`, http.StatusUnprocessableEntity, ErrInputInvalid),
+			Entry("read-only field", `{"version":9}`, http.StatusUnprocessableEntity, ErrReadOnlyField),
+			Entry("unknown field", `{"colour":"red"}`, http.StatusUnprocessableEntity, ErrInputInvalid),
+			Entry("wrong type", `{"completed":"yes"}`, http.StatusUnprocessableEntity, ErrInputInvalid),
+			Entry("not an object", `[]`, http.StatusUnprocessableEntity, ErrInputInvalid),
+		)
+	})
+
+	Describe("JSON Patch", func() {
+		It("applies the operations in turn", func() {
+			rr := patch("application/json-patch+json", `[
+				{"op":"test","path":"/text","value":"water the plants"},
+				{"op":"replace","path":"/completed","value":true},
+				{"op":"remove","path":"/due_at"},
+				{"op":"add","path":"/tags/-","value":"weekly"},
+				{"op":"remove","path":"/tags/0"},
+				{"op":"copy","from":"/text","path":"/recurrence"},
+				{"op":"replace","path":"/recurrence","value":"FREQ=WEEKLY"}
+			]`)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(updates).To(HaveLen(1))
+			in := updates[0]
+			Expect(in.Text).To(BeNil())
+			Expect(in.Completed).To(PointTo(BeTrue()))
+			Expect(in.DueAt).To(PointTo(Equal(DateTime{})))
+			Expect(in.Tags).To(PointTo(Equal([]string{"garden", "weekly"})))
+			Expect(in.Recurrence).To(PointTo(Equal("FREQ=WEEKLY")))
+		})
+
+		It("reports a conflict if a test fails", func() {
+			rr := patch("application/json-patch+json", `[
+				{"op":"test","path":"/completed","value":true},
+				{"op":"remove","path":"/completed"}
+			]`)
+
+			Expect(rr.Code).To(Equal(http.StatusConflict))
+			Expect(errorCode(rr)).To(Equal(ErrPatchTestFailed.Error()))
+			Expect(updates).To(BeEmpty())
+		})
+
+		DescribeTable("rejects invalid patches",
+			func(payload string, status int, code error) {
+				rr := patch("application/json-patch+json", payload)
+				Expect(rr.Code).To(Equal(status))
+				Expect(errorCode(rr)).To(Equal(code.Error()))
+				Expect(updates).To(BeEmpty())
+			},
+			Entry("not an array", `{"op":"remove","path":"/due_at"}`, http.StatusBadRequest, ErrBadPatch),
+			Entry("unknown op", `[{"op":"frob","path":"/text"}]`, http.StatusBadRequest, ErrBadPatch),
+			Entry("missing value", `[{"op":"add","path":"/text"}]`, http.StatusBadRequest, ErrBadPatch),
+			Entry("relative path", `[{"op":"remove","path":"due_at"}]`, http.StatusBadRequest, ErrBadPatch),
+			Entry("missing member", `[{"op":"remove","path":"/nope"}]`, http.StatusBadRequest, ErrBadPatch),
+			Entry("index out of bounds", `[{"op":"add","path":"/tags/5","value":"x"}]`, http.StatusBadRequest, ErrBadPatch),
+			Entry("removing a required field", `[{"op":"remove","path":"/text"}]`, http.StatusUnprocessableEntity, ErrInputInvalid),
+			Entry("changing a read-only field", `[{"op":"replace","path":"/id","value":4}]`, http.StatusUnprocessableEntity, ErrReadOnlyField),
+		)
+	})
+
+	Describe("preconditions", func() {
+		It("rejects a patch if the todo is no longer at the version in If-Match", func() {
+			rr := patch("application/merge-patch+json", `{"completed":true}`, "If-Match", `"3"`)
+
+			Expect(rr.Code).To(Equal(http.StatusPreconditionFailed))
+			Expect(updates).To(BeEmpty())
+		})
+
+		It("reports a missing todo", func() {
+			svc.getByIDFn = func(ctx context.Context, id uint32) (*Todo, error) { return nil, ErrTodoNotFound }
+			rr := patch("application/merge-patch+json", `{"completed":true}`)
+
+			Expect(rr.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("reports a todo modified while the patch was applied", func() {
+			svc.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+				return nil, ErrVersionMismatch
+			}
+			rr := patch("application/merge-patch+json", `{"completed":true}`)
+
+			Expect(rr.Code).To(Equal(http.StatusPreconditionFailed))
+			Expect(errorCode(rr)).To(Equal(ErrVersionMismatch.Error()))
+		})
+	})
+})
@@ -0,0 +1,82 @@
+package todo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// problemContentType is the media type of RFC 9457 problem details.
+const problemContentType = "application/problem+json"
+
+// Problem is an error response in the format of RFC 9457 (Problem Details for
+// HTTP APIs). Type identifies the kind of problem by its Code, the error code
+// of the legacy format, and Errors lists the fields of the request which
+// violate a validation rule.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// legacyErrors reports whether the client asked for error responses in the
+// legacy shape of ErrorResponse, with the X-Error-Format: legacy header.
+func legacyErrors(ctx *gin.Context) bool {
+	return strings.EqualFold(strings.TrimSpace(ctx.GetHeader("X-Error-Format")), "legacy")
+}
+
+// writeError writes the error response r with the given status, as problem
+// details unless the client asked for the legacy shape.
+func writeError(ctx *gin.Context, status int, r *ErrorResponse) {
+	if legacyErrors(ctx) {
+		ctx.JSON(status, r)
+		return
+	}
+
+	b, err := json.Marshal(newProblem(status, r, ctx.Request.URL.Path))
+	if err != nil {
+		ctx.Status(http.StatusInternalServerError)
+		return
+	}
+	ctx.Data(status, problemContentType, b)
+}
+
+// WriteMethodNotAllowed writes the error response of a request whose route
+// doesn't take its method.
+func WriteMethodNotAllowed(ctx *gin.Context) {
+	r := NewErrorResponse(ErrMethodNotAllowed.Error(), fmt.Sprintf("%s is not allowed on this route", ctx.Request.Method))
+	writeError(ctx, http.StatusMethodNotAllowed, r)
+}
+
+// newProblem returns the problem details of the error response r with the
+// given status, for the request to instance.
+func newProblem(status int, r *ErrorResponse, instance string) *Problem {
+	return &Problem{
+		Type:     "/problems/" + r.Error.Code,
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   r.Error.Message,
+		Instance: instance,
+		Code:     r.Error.Code,
+		Errors:   r.fields,
+	}
+}
+
+// newInputErrorResponse returns the response reporting the input error err,
+// which inputError matched to e, along with the fields it concerns.
+func newInputErrorResponse(e, err error) *ErrorResponse {
+	r := NewErrorResponse(e.Error(), err.Error())
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		r.fields = ve.Errors
+	}
+	return r
+}
@@ -0,0 +1,98 @@
+package todo_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+var _ = Describe("error responses", Label("handler", "problem"), func() {
+	var (
+		router *gin.Engine
+		rr     *httptest.ResponseRecorder
+	)
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		svc := NewService(&mockRepo{})
+		router = gin.New()
+		NewHandler(svc).Register(router)
+		rr = httptest.NewRecorder()
+	})
+
+	post := func(payload string, header ...string) {
+		req := httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		router.ServeHTTP(rr, req)
+	}
+
+	It("are problem details", func() {
+		req := httptest.NewRequest(http.MethodGet, "/todos/abc", nil)
+		router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		Expect(rr.Header().Get("Content-Type")).To(Equal("application/problem+json"))
+		var resp Problem
+		Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+		Expect(resp).To(Equal(Problem{
+			Type:     "/problems/bad_id",
+			Title:    "Bad Request",
+			Status:   http.StatusBadRequest,
+			Detail:   "ID must be an integer",
+			Instance: "/todos/abc",
+			Code:     "bad_id",
+		}))
+	})
+
+	It("list the fields violating validation rules", func() {
+		post(`{"text":"","priority":"critical"}`)
+
+		Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+		var resp Problem
+		Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+		Expect(resp.Code).To(Equal(ErrTextEmpty.Error()))
+		Expect(resp.Errors).To(HaveLen(2))
+		Expect(rr.Body.String()).To(ContainSubstring(`"errors":[{"field":"text","rule":"text_empty","message":"text must not be empty"},{"field":"priority","rule":"priority_invalid",`))
+	})
+
+	It("keep the legacy shape for clients asking for it", func() {
+		post(`{"text":""}`, "X-Error-Format", "legacy")
+
+		Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+		Expect(rr.Header().Get("Content-Type")).To(HavePrefix("application/json"))
+		var resp ErrorResponse
+		Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+		Expect(resp.Error.Code).To(Equal(ErrTextEmpty.Error()))
+		Expect(resp.Error.Message).To(Equal("text must not be empty"))
+		Expect(resp.Error.Timestamp).NotTo(BeEmpty())
+		Expect(rr.Body.String()).NotTo(ContainSubstring("errors"))
+	})
+
+	It("report errors of the idempotency middleware", func() {
+		router = gin.New()
+		NewHandler(&mockService{}, WithIdempotency(&memIdempotencyStore{responses: map[string]*StoredResponse{}}, 0)).Register(router)
+
+		post(`{"text":"stretch"}`, "Idempotency-Key", strings.Repeat("k", 300))
+
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		Expect(rr.Header().Get("Content-Type")).To(Equal("application/problem+json"))
+	})
+
+	It("aren't used for successful requests", func() {
+		req := httptest.NewRequest(http.MethodGet, "/todos", nil)
+		router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("Content-Type")).To(HavePrefix("application/json"))
+	})
+})
@@ -0,0 +1,52 @@
+package todo
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Purger permanently deletes todos once they have been in the trash for longer
+// than the retention period.
+type Purger struct {
+	repo      Repository
+	retention time.Duration
+	now       func() time.Time
+}
+
+// NewPurger returns a purger for the trash of r. A non-positive retention
+// falls back to DefaultTrashRetention.
+func NewPurger(r Repository, retention time.Duration) *Purger {
+	if retention <= 0 {
+		retention = DefaultTrashRetention
+	}
+	return &Purger{repo: r, retention: retention, now: time.Now}
+}
+
+// Purge deletes the todos of every user that have been trashed for longer
+// than the retention period and returns how many were deleted.
+func (p *Purger) Purge(ctx context.Context) (int, error) {
+	before := p.now().Add(-p.retention)
+	return p.repo.Purge(allUsers(ctx), &before)
+}
+
+// Run purges the trash right away and then every interval, until ctx is done.
+func (p *Purger) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := p.Purge(ctx)
+		if err != nil {
+			log.Printf("purging trash failed: %v", err)
+		} else if n > 0 {
+			log.Printf("purged %d todos from the trash", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package todo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseListParams reads the filtering, sorting and paging query parameters of
+// a todo listing. Unknown parameters are ignored, but every known parameter
+// must be well-formed.
+func parseListParams(ctx *gin.Context) (ListParams, error) {
+	var p ListParams
+
+	if v, ok := ctx.GetQuery("list_id"); ok {
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return p, fmt.Errorf("`list_id` must be an integer")
+		}
+		list := uint32(id)
+		p.ListID = &list
+	}
+
+	if v, ok := ctx.GetQuery("series_id"); ok {
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return p, fmt.Errorf("`series_id` must be an integer")
+		}
+		series := uint32(id)
+		p.SeriesID = &series
+	}
+
+	if v, ok := ctx.GetQuery("completed"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return p, fmt.Errorf("`completed` must be true or false")
+		}
+		p.Completed = &b
+	}
+
+	times := []struct {
+		name string
+		dst  **time.Time
+	}{
+		{"created_after", &p.CreatedAfter},
+		{"created_before", &p.CreatedBefore},
+		{"updated_after", &p.UpdatedAfter},
+		{"updated_before", &p.UpdatedBefore},
+	}
+	for _, t := range times {
+		v, ok := ctx.GetQuery(t.name)
+		if !ok {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return p, fmt.Errorf("`%s` must be an RFC3339 timestamp", t.name)
+		}
+		*t.dst = &ts
+	}
+
+	if v, ok := ctx.GetQuery("due"); ok {
+		switch v {
+		case DueOverdue, DueToday, DueThisWeek:
+		default:
+			return p, fmt.Errorf("`due` must be one of %s, %s, %s", DueOverdue, DueToday, DueThisWeek)
+		}
+		if v == DueOverdue && p.Completed != nil && *p.Completed {
+			return p, fmt.Errorf("`due=%s` cannot be combined with `completed=true`", DueOverdue)
+		}
+		p.Due = v
+	}
+
+	if v, ok := ctx.GetQuery("tz"); ok {
+		loc, err := time.LoadLocation(v)
+		if err != nil || v == "" || v == "Local" {
+			return p, fmt.Errorf("`tz` must be an IANA time zone name")
+		}
+		p.Location = loc
+	}
+
+	if v, ok := ctx.GetQueryArray("tag"); ok {
+		tags, err := normalizeTagNames(v)
+		if err != nil {
+			return p, fmt.Errorf("`tag` must be between 1 and %d characters", MaxTagNameLength)
+		}
+		p.Tags = tags
+	}
+
+	if v, ok := ctx.GetQuery("tag_mode"); ok {
+		if v != TagModeAny && v != TagModeAll {
+			return p, fmt.Errorf("`tag_mode` must be one of %s, %s", TagModeAny, TagModeAll)
+		}
+		p.TagMode = v
+	}
+
+	expand, err := parseExpand(ctx)
+	if err != nil {
+		return p, err
+	}
+	p.Expand = expand
+
+	if v, ok := ctx.GetQuery("sort"); ok {
+		s, err := parseSort(v)
+		if err != nil {
+			return p, err
+		}
+		p.Sort = s
+	} else {
+		p.Sort = Sort{Field: SortByID}
+	}
+
+	if v, ok := ctx.GetQuery("limit"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > MaxLimit {
+			return p, fmt.Errorf("`limit` must be an integer between 1 and %d", MaxLimit)
+		}
+		p.Limit = n
+	}
+
+	if v, ok := ctx.GetQuery("offset"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("`offset` must be a non-negative integer")
+		}
+		p.Offset = n
+	}
+
+	if v, ok := ctx.GetQuery("cursor"); ok {
+		if p.Offset != 0 {
+			return p, fmt.Errorf("`cursor` and `offset` are mutually exclusive")
+		}
+		p.Cursor = v
+	}
+
+	return p, nil
+}
+
+// parseSearchParams reads the query parameters of a full-text search. The
+// query itself, q, is required.
+func parseSearchParams(ctx *gin.Context) (SearchParams, error) {
+	var p SearchParams
+
+	q, err := ParseSearchQuery(ctx.Query("q"))
+	if err != nil {
+		return p, err
+	}
+	p.Query = q
+
+	if v, ok := ctx.GetQuery("list_id"); ok {
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return p, fmt.Errorf("`list_id` must be an integer")
+		}
+		list := uint32(id)
+		p.ListID = &list
+	}
+
+	if v, ok := ctx.GetQuery("completed"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return p, fmt.Errorf("`completed` must be true or false")
+		}
+		p.Completed = &b
+	}
+
+	if v, ok := ctx.GetQuery("limit"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > MaxLimit {
+			return p, fmt.Errorf("`limit` must be an integer between 1 and %d", MaxLimit)
+		}
+		p.Limit = n
+	}
+
+	return p, nil
+}
+
+// parseSort parses a sort field, optionally prefixed with '-' for descending
+// order.
+func parseSort(v string) (Sort, error) {
+	s := Sort{Field: strings.TrimPrefix(v, "-"), Desc: strings.HasPrefix(v, "-")}
+
+	switch s.Field {
+	case SortByID, SortByCreatedAt, SortByUpdatedAt, SortByPriority:
+		return s, nil
+	}
+	return s, fmt.Errorf("`sort` must be one of %s, %s, %s, %s (prefix with '-' for descending)", SortByID, SortByCreatedAt, SortByUpdatedAt, SortByPriority)
+}
+
+// parseExpand reports whether the todos in the response should carry their
+// subtree, as requested by expand=children.
+func parseExpand(ctx *gin.Context) (bool, error) {
+	v, ok := ctx.GetQuery("expand")
+	if !ok {
+		return false, nil
+	}
+	if v != "children" {
+		return false, fmt.Errorf("`expand` must be children")
+	}
+	return true, nil
+}
+
+// parseCascade reports whether a change of completion status should apply to
+// the whole subtree of a todo, as requested by cascade=true.
+func parseCascade(ctx *gin.Context) (bool, error) {
+	v, ok := ctx.GetQuery("cascade")
+	if !ok {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("`cascade` must be true or false")
+	}
+	return b, nil
+}
+
+// parseScope reports whether an update of a recurring todo should apply to its
+// whole series, as requested by scope=series, rather than to this occurrence
+// only.
+func parseScope(ctx *gin.Context) (bool, error) {
+	v, ok := ctx.GetQuery("scope")
+	if !ok {
+		return false, nil
+	}
+	switch v {
+	case ScopeThis:
+		return false, nil
+	case ScopeSeries:
+		return true, nil
+	}
+	return false, fmt.Errorf("`scope` must be one of %s, %s", ScopeThis, ScopeSeries)
+}
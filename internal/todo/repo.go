@@ -1,113 +1,886 @@
 package todo
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	table           = "todos"
+	tombstonesTable = "todo_tombstones"
+	sequenceTable   = "change_sequence"
 )
 
-const table = "todos"
+// columns lists the columns selected for a Todo, in the order todoFields
+// expects.
+const columns = "id, text, completed, created_at, updated_at, due_at, start_at, priority, list_id, parent_id, recurrence, series_id, occurrence, deleted_at, version, owner_id, due_date_only"
 
+// Repository stores todos. Every operation is scoped to the user and the
+// workspace of its context (see WithUser and WithWorkspace), and fails with
+// ErrUnauthenticated or ErrWorkspaceRequired without them, so that the todos
+// of other users and other workspaces are out of reach even by ID.
 type Repository interface {
-	List(ctx context.Context) ([]Todo, error)
+	List(ctx context.Context, p ListParams) ([]Todo, error)
+	Count(ctx context.Context, p ListParams) (int, error)
+	ListAfter(ctx context.Context, p ListParams, c Cursor) ([]Todo, error)
 	Get(ctx context.Context, id uint32) (*Todo, error)
 	Create(ctx context.Context, in TodoInput) (*Todo, error)
-	Update(ctx context.Context, id uint32, in TodoInput) (*Todo, error)
-	Delete(ctx context.Context, id uint32) error
+	Update(ctx context.Context, id uint32, in TodoInput) (*Todo, *Affected, error)
+	Delete(ctx context.Context, id uint32, version *uint32) (*Affected, error)
+	Restore(ctx context.Context, id uint32) (*Todo, *Affected, error)
+	DeleteList(ctx context.Context, id, into uint32) ([]Todo, error)
+	Purge(ctx context.Context, before *time.Time) (int, error)
+	Ancestors(ctx context.Context, id uint32) ([]uint32, error)
+	Descendants(ctx context.Context, ids []uint32) ([]Todo, error)
+
+	// Changes returns up to limit of the todos changed after the position
+	// of the token in the change sequence, in its order. Trashed and purged
+	// todos come as tombstones, unless tombstones is false.
+	Changes(ctx context.Context, after ChangeToken, limit int, tombstones bool) ([]Change, error)
+
+	// Enqueue records e in the outbox of webhook deliveries, queuing a
+	// delivery to every active webhook receiving events of its type.
+	Enqueue(ctx context.Context, e Event) error
+
+	// Transact runs fn with a Repository whose operations all take part in
+	// a single transaction, committed if fn succeeds and rolled back
+	// otherwise. Each write remains atomic on its own, so fn may carry on
+	// after one of them failed.
+	Transact(ctx context.Context, fn func(Repository) error) error
+}
+
+// Searcher runs full-text searches over the live todos of the user of the
+// context and of the lists shared with them, within its workspace, returning
+// the matches ranked by relevance.
+// Snippets are left for the caller to fill in.
+type Searcher interface {
+	Search(ctx context.Context, p SearchParams) ([]SearchResult, error)
+}
+
+// querier is the subset of *sql.DB and *sql.Tx used by the repository, so the
+// same helpers can run inside and outside of a transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
 type sqlrepo struct {
 	db *sql.DB
+	tx *sql.Tx // set within Transact
 }
 
 func NewRepo(db *sql.DB) Repository {
 	return &sqlrepo{db: db}
 }
 
-func (r *sqlrepo) List(ctx context.Context) ([]Todo, error) {
-	query := fmt.Sprintf("SELECT id, text, completed, created_at, updated_at FROM `%s`", table)
+func (r *sqlrepo) List(ctx context.Context, p ListParams) ([]Todo, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	where, args := whereClause(workspace, owner, p)
+	query := fmt.Sprintf("SELECT %s FROM `%s`%s%s", columns, table, where, orderClause(p.Sort))
+	if p.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, p.Limit, p.Offset)
+	}
+
+	return queryTodos(ctx, r.conn(), query, args...)
+}
+
+// ListAfter returns up to p.Limit todos following the cursor's boundary row in
+// the cursor's sort order, using a keyset predicate rather than an offset so
+// that concurrent inserts and deletes don't shift the page. Backward cursors
+// are fetched in reverse and flipped, so todos are always returned in display
+// order. p.Sort and p.Offset are ignored.
+func (r *sqlrepo) ListAfter(ctx context.Context, p ListParams, c Cursor) ([]Todo, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	where, args := whereClause(workspace, owner, p)
+
+	col, ok := sortColumns[c.Sort.Field]
+	if !ok || !keysetSortable(c.Sort.Field) {
+		return nil, ErrBadCursor
+	}
+
+	// Walking backward flips both the comparison and the ordering.
+	desc := c.Sort.Desc != c.Backward
+	op := ">"
+	if desc {
+		op = "<"
+	}
+
+	var seek string
+	if col == "id" {
+		seek = fmt.Sprintf("id %s ?", op)
+		args = append(args, c.ID)
+	} else {
+		seek = fmt.Sprintf("(%s, id) %s (?, ?)", col, op)
+		args = append(args, c.Value, c.ID)
+	}
+	where += " AND " + seek
 
-	rows, err := r.db.QueryContext(ctx, query)
+	query := fmt.Sprintf("SELECT %s FROM `%s`%s%s", columns, table, where, orderClause(Sort{Field: c.Sort.Field, Desc: desc}))
+	if p.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, p.Limit)
+	}
+
+	todos, err := queryTodos(ctx, r.conn(), query, args...)
 	if err != nil {
 		return nil, err
 	}
+
+	if c.Backward {
+		slices.Reverse(todos)
+	}
+
+	return todos, nil
+}
+
+func (r *sqlrepo) Count(ctx context.Context, p ListParams) (int, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	where, args := whereClause(workspace, owner, p)
+	query := fmt.Sprintf("SELECT COUNT(*) FROM `%s`%s", table, where)
+
+	var n int
+	err = r.conn().QueryRowContext(ctx, query, args...).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (r *sqlrepo) Get(ctx context.Context, id uint32) (*Todo, error) {
+	return getTodo(ctx, r.conn(), id)
+}
+
+func (r *sqlrepo) Create(ctx context.Context, in TodoInput) (*Todo, error) {
+	var t *Todo
+	err := r.transact(ctx, func(q querier) error {
+		seq, err := nextChange(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		id, err := insertTodo(ctx, q, in, seq)
+		if err != nil {
+			return err
+		}
+
+		t, err = getTodo(ctx, q, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Update updates a todo, completing its subtasks along with it if in cascades,
+// applying the series-wide fields of in to the rest of its series if in says
+// so, and spawning the next occurrence in.Next unless the workspace is full.
+// The occurrence is reported as created, and the other todos as updated.
+func (r *sqlrepo) Update(ctx context.Context, id uint32, in TodoInput) (*Todo, *Affected, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		t        *Todo
+		affected Affected
+	)
+	err = r.transact(ctx, func(q querier) error {
+		seq, err := nextChange(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		// A todo that starts recurring becomes the first occurrence of its
+		// own series.
+		query := fmt.Sprintf("UPDATE `%s` SET version = version + 1, change_seq = ?, text = IFNULL(?, text), completed = IFNULL(?, completed), due_at = IF(?, ?, due_at), due_date_only = IF(?, ?, due_date_only), start_at = IF(?, ?, start_at), priority = IFNULL(?, priority), list_id = IFNULL(?, list_id), parent_id = IF(? IS NULL, parent_id, NULLIF(?, 0)), recurrence = IF(? IS NULL, recurrence, NULLIF(?, '')), series_id = IF(NULLIF(?, '') IS NULL, series_id, IFNULL(series_id, id)) WHERE id=? AND workspace_id=? AND owner_id=? AND deleted_at IS NULL", table)
+		args := []any{seq, in.Text, in.Completed, in.DueAt != nil, in.DueAt, in.DueAt != nil, in.DueAt.dateOnly(), in.StartAt != nil, in.StartAt, in.Priority, in.ListID, in.ParentID, in.ParentID, in.Recurrence, in.Recurrence, in.Recurrence, id, workspace, owner}
+		if in.Version != nil {
+			query += " AND version = ?"
+			args = append(args, *in.Version)
+		}
+
+		result, err := q.ExecContext(ctx, query, args...)
+		if err != nil {
+			return referenceError(err)
+		}
+
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows > 1 {
+			log.Print("unexpected: multiple rows affected")
+			return ErrUnexpected
+		}
+		// Nothing depending on the todo is written unless it was found.
+		if rows == 0 {
+			if in.Version != nil {
+				return versionError(ctx, q, id)
+			}
+			return ErrTodoNotFound
+		}
+
+		// Trashed descendants are left as they are, like the ones below them.
+		if in.Cascade && in.Completed != nil {
+			query := fmt.Sprintf("WITH RECURSIVE sub AS (SELECT id FROM `%[1]s` WHERE parent_id = ? AND workspace_id = ? AND owner_id = ? AND deleted_at IS NULL UNION ALL SELECT t.id FROM `%[1]s` t JOIN sub ON t.parent_id = sub.id WHERE t.deleted_at IS NULL) UPDATE `%[1]s` t JOIN sub ON t.id = sub.id SET t.completed = ?, t.version = t.version + 1, t.change_seq = ?", table)
+			if _, err := q.ExecContext(ctx, query, id, workspace, owner, in.Completed, seq); err != nil {
+				return err
+			}
+		}
+
+		if in.Tags != nil {
+			if err := setTags(ctx, q, id, *in.Tags); err != nil {
+				return err
+			}
+		}
+
+		if in.Series {
+			if err := updateSeries(ctx, q, id, in, seq); err != nil {
+				return err
+			}
+		}
+
+		var next uint32
+		if in.Next != nil {
+			// The unique series occurrence key stops a todo that is
+			// reopened and completed again from spawning a duplicate.
+			// A full workspace doesn't stop the completion either, it
+			// only goes without the next occurrence.
+			next, err = insertTodo(ctx, q, *in.Next, seq)
+			if err != nil && !isDuplicateKey(err) && !errors.Is(err, ErrQuotaExceeded) {
+				return err
+			}
+		}
+
+		if (in.Cascade && in.Completed != nil) || in.Series || in.Next != nil {
+			others, err := changedWith(ctx, q, seq, id, false)
+			if err != nil {
+				return err
+			}
+			for _, o := range others {
+				if o.ID == next {
+					affected.Created = append(affected.Created, o)
+				} else {
+					affected.Updated = append(affected.Updated, o)
+				}
+			}
+		}
+
+		t, err = getTodo(ctx, q, id)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return t, &affected, nil
+}
+
+// insertTodo inserts a todo of the user of ctx along with its tags as change
+// seq, and returns its ID. A recurring todo outside of any series starts a
+// series of its own. It fails with ErrQuotaExceeded if the workspace of ctx
+// already holds as many todos as it may.
+func insertTodo(ctx context.Context, q querier, in TodoInput, seq uint64) (uint32, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// The workspace stays locked until the transaction of seq ends, so no
+	// other todo is inserted into it between counting and inserting. Trashed
+	// todos count too, since restoring them doesn't check the quota; they
+	// stop counting once purged.
+	query := fmt.Sprintf("SELECT w.max_todos IS NOT NULL AND w.max_todos <= (SELECT COUNT(*) FROM `%s` WHERE workspace_id = w.id) FROM `%s` w WHERE w.id=? FOR UPDATE", table, workspacesTable)
+	var full bool
+	if err := q.QueryRowContext(ctx, query, workspace).Scan(&full); err != nil {
+		return 0, err
+	}
+	if full {
+		return 0, ErrQuotaExceeded
+	}
+
+	query = fmt.Sprintf("INSERT INTO `%s` (workspace_id, owner_id, text, completed, due_at, due_date_only, start_at, priority, list_id, parent_id, recurrence, series_id, occurrence, change_seq) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", table)
+
+	result, err := q.ExecContext(ctx, query, workspace, owner, in.Text, in.Completed, in.DueAt, in.DueAt.dateOnly(), in.StartAt, in.Priority, in.ListID, in.ParentID, in.Recurrence, in.SeriesID, max(in.Occurrence, 1), seq)
+	if err != nil {
+		return 0, referenceError(err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if in.Recurrence != nil && in.SeriesID == nil {
+		query := fmt.Sprintf("UPDATE `%s` SET series_id = id WHERE id=?", table)
+		if _, err := q.ExecContext(ctx, query, id); err != nil {
+			return 0, err
+		}
+	}
+
+	if in.Tags != nil {
+		if err := setTags(ctx, q, uint32(id), *in.Tags); err != nil {
+			return 0, err
+		}
+	}
+
+	return uint32(id), nil
+}
+
+// updateSeries applies the series-wide fields of in to the other occurrences
+// of the series of todo id, as part of change seq.
+func updateSeries(ctx context.Context, q querier, id uint32, in TodoInput, seq uint64) error {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("SELECT id FROM `%[1]s` WHERE series_id = (SELECT series_id FROM `%[1]s` WHERE id = ?) AND id <> ? AND workspace_id = ? AND owner_id = ? AND deleted_at IS NULL", table)
+	rows, err := q.QueryContext(ctx, query, id, id, workspace, owner)
+	if err != nil {
+		return err
+	}
 	defer rows.Close()
 
-	todos := []Todo{}
+	var others []any
 	for rows.Next() {
-		var t Todo
-		if err := rows.Scan(&t.ID, &t.Text, &t.Completed, &t.CreatedAt, &t.UpdatedAt); err != nil {
-			return nil, err
+		var other uint32
+		if err := rows.Scan(&other); err != nil {
+			return err
 		}
-		todos = append(todos, t)
+		others = append(others, other)
 	}
 	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(others) == 0 {
+		return nil
+	}
+
+	query = fmt.Sprintf("UPDATE `%s` SET version = version + 1, change_seq = ?, text = IFNULL(?, text), priority = IFNULL(?, priority), list_id = IFNULL(?, list_id), recurrence = IF(? IS NULL, recurrence, NULLIF(?, '')) WHERE id IN (%s)", table, placeholders(len(others)))
+	args := append([]any{seq, in.Text, in.Priority, in.ListID, in.Recurrence, in.Recurrence}, others...)
+	if _, err := q.ExecContext(ctx, query, args...); err != nil {
+		return referenceError(err)
+	}
+
+	if in.Tags != nil {
+		for _, other := range others {
+			if err := setTags(ctx, q, other.(uint32), *in.Tags); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Delete moves a todo to the trash along with its subtree, which it reports as
+// deleted. All of them get the same deletion time, which is how Restore finds
+// them again. If version is set, the todo is only trashed while it is still at
+// that version.
+func (r *sqlrepo) Delete(ctx context.Context, id uint32, version *uint32) (*Affected, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
 		return nil, err
 	}
 
-	return todos, nil
+	var affected Affected
+	err = r.transact(ctx, func(q querier) error {
+		seq, err := nextChange(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		anchor := "id = ? AND workspace_id = ? AND owner_id = ? AND deleted_at IS NULL"
+		args := []any{id, workspace, owner}
+		if version != nil {
+			anchor += " AND version = ?"
+			args = append(args, *version)
+		}
+
+		query := fmt.Sprintf("WITH RECURSIVE sub AS (SELECT id FROM `%[1]s` WHERE %[2]s UNION ALL SELECT t.id FROM `%[1]s` t JOIN sub ON t.parent_id = sub.id WHERE t.deleted_at IS NULL) UPDATE `%[1]s` t JOIN sub ON t.id = sub.id SET t.deleted_at = CURRENT_TIMESTAMP, t.version = t.version + 1, t.change_seq = ?", table, anchor)
+		args = append(args, seq)
+		result, err := q.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			if version != nil {
+				return versionError(ctx, q, id)
+			}
+			return ErrTodoNotFound
+		}
+
+		affected.Deleted, err = changedWith(ctx, q, seq, id, true)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &affected, nil
 }
 
-func (r *sqlrepo) Get(ctx context.Context, id uint32) (*Todo, error) {
-	query := fmt.Sprintf("SELECT id, text, completed, created_at, updated_at FROM `%s` WHERE id=?", table)
+// Restore takes a todo out of the trash along with the part of its subtree
+// that was trashed with it, which it reports as created. Restoring a todo that
+// isn't trashed does nothing, while a todo whose parent is still trashed can't
+// be restored.
+func (r *sqlrepo) Restore(ctx context.Context, id uint32) (*Todo, *Affected, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	var t Todo
-	err := r.db.QueryRowContext(ctx, query, id).Scan(&t.ID, &t.Text, &t.Completed, &t.CreatedAt, &t.UpdatedAt)
+	var (
+		t        *Todo
+		affected Affected
+	)
+	err = r.transact(ctx, func(q querier) error {
+		var (
+			deletedAt     *time.Time
+			parentDeleted *time.Time
+		)
+		query := fmt.Sprintf("SELECT t.deleted_at, p.deleted_at FROM `%[1]s` t LEFT JOIN `%[1]s` p ON p.id = t.parent_id WHERE t.id=? AND t.workspace_id=? AND t.owner_id=?", table)
+		err := q.QueryRowContext(ctx, query, id, workspace, owner).Scan(&deletedAt, &parentDeleted)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrTodoNotFound
+			}
+			return err
+		}
+		if parentDeleted != nil {
+			return ErrParentTrashed
+		}
+
+		if deletedAt != nil {
+			seq, err := nextChange(ctx, q)
+			if err != nil {
+				return err
+			}
+
+			query := fmt.Sprintf("WITH RECURSIVE sub AS (SELECT id FROM `%[1]s` WHERE id = ? UNION ALL SELECT t.id FROM `%[1]s` t JOIN sub ON t.parent_id = sub.id WHERE t.deleted_at = ?) UPDATE `%[1]s` t JOIN sub ON t.id = sub.id SET t.deleted_at = NULL, t.version = t.version + 1, t.change_seq = ?", table)
+			if _, err := q.ExecContext(ctx, query, id, *deletedAt, seq); err != nil {
+				return err
+			}
+			if affected.Created, err = changedWith(ctx, q, seq, id, false); err != nil {
+				return err
+			}
+		}
+
+		t, err = getTodo(ctx, q, id)
+		return err
+	})
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrTodoNotFound
+		return nil, nil, err
+	}
+
+	return t, &affected, nil
+}
+
+// DeleteList deletes list id, first moving its todos to list into and to the
+// trash, from which they are restored into that list. It returns the todos it
+// trashed, as they were before. Todos are only ever trashed this way, never
+// deleted along with their list.
+func (r *sqlrepo) DeleteList(ctx context.Context, id, into uint32) ([]Todo, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var trashed []Todo
+	err = r.transact(ctx, func(q querier) error {
+		seq, err := nextChange(ctx, q)
+		if err != nil {
+			return err
 		}
+
+		query := fmt.Sprintf("SELECT %s FROM `%s` WHERE list_id = ? AND workspace_id = ? AND owner_id = ? AND deleted_at IS NULL FOR UPDATE", columns, table)
+		if trashed, err = queryTodos(ctx, q, query, id, workspace, owner); err != nil {
+			return err
+		}
+
+		// Todos trashed earlier move too, keeping their deletion time.
+		query = fmt.Sprintf("UPDATE `%s` SET list_id = ?, deleted_at = IFNULL(deleted_at, CURRENT_TIMESTAMP), version = version + 1, change_seq = ? WHERE list_id = ? AND workspace_id = ? AND owner_id = ?", table)
+		if _, err := q.ExecContext(ctx, query, into, seq, id, workspace, owner); err != nil {
+			return err
+		}
+
+		query = fmt.Sprintf("DELETE FROM `%s` WHERE id=? AND workspace_id=? AND owner_id=?", listsTable)
+		result, err := q.ExecContext(ctx, query, id, workspace, owner)
+		if err != nil {
+			return err
+		}
+
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrListNotFound
+		} else if rows != 1 {
+			log.Print("unexpected: multiple rows affected")
+			return ErrUnexpected
+		}
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	return &t, nil
+	return trashed, nil
 }
 
-func (r *sqlrepo) Create(ctx context.Context, in TodoInput) (*Todo, error) {
-	query := fmt.Sprintf("INSERT INTO `%s` (text, completed) VALUES (?, ?)", table)
+// Purge permanently deletes the todos trashed before the given time, or all
+// trashed todos if before is nil, and returns how many were deleted. They
+// leave tombstones behind, at the change which trashed them, so that syncing
+// clients still learn they are gone. Only the Purger empties the trash of
+// every user in every workspace at once.
+func (r *sqlrepo) Purge(ctx context.Context, before *time.Time) (int, error) {
+	var n int
+	err := r.transact(ctx, func(q querier) error {
+		query := fmt.Sprintf("INSERT INTO `%s` (todo_id, list_id, workspace_id, owner_id, change_seq, deleted_at) SELECT id, list_id, workspace_id, owner_id, change_seq, deleted_at FROM `%s` WHERE deleted_at IS NOT NULL", tombstonesTable, table)
+		var args []any
+		if !everyUser(ctx) {
+			workspace, owner, err := scopeOf(ctx)
+			if err != nil {
+				return err
+			}
+			query += " AND workspace_id = ? AND owner_id = ?"
+			args = append(args, workspace, owner)
+		}
+		if before != nil {
+			query += " AND deleted_at < ?"
+			args = append(args, *before)
+		}
+		if _, err := q.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+
+		// Todos are never undeleted once they have a tombstone, so the
+		// tombstones tell which todos to delete.
+		query = fmt.Sprintf("DELETE t FROM `%s` t JOIN `%s` b ON b.todo_id = t.id WHERE t.deleted_at IS NOT NULL", table, tombstonesTable)
+		result, err := q.ExecContext(ctx, query)
+		if err != nil {
+			return err
+		}
+
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		n = int(rows)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
 
-	result, err := r.db.ExecContext(ctx, query, in.Text, in.Completed)
+// Ancestors returns the ID of a todo followed by the IDs of its ancestors, up
+// to the root of its tree.
+func (r *sqlrepo) Ancestors(ctx context.Context, id uint32) ([]uint32, error) {
+	workspace, owner, err := scopeOf(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	id, err := result.LastInsertId()
+	query := fmt.Sprintf("WITH RECURSIVE anc AS (SELECT id, parent_id, 0 AS level FROM `%[1]s` WHERE id = ? AND workspace_id = ? AND owner_id = ? UNION ALL SELECT t.id, t.parent_id, anc.level + 1 FROM `%[1]s` t JOIN anc ON t.id = anc.parent_id) SELECT id FROM anc ORDER BY level", table)
+
+	rows, err := r.conn().QueryContext(ctx, query, id, workspace, owner)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	var t Todo
-	getQuery := fmt.Sprintf("SELECT id, text, completed, created_at, updated_at FROM `%s` WHERE id=?", table)
-	err = r.db.QueryRowContext(ctx, getQuery, id).Scan(&t.ID, &t.Text, &t.Completed, &t.CreatedAt, &t.UpdatedAt)
+	var ids []uint32
+	for rows.Next() {
+		var id uint32
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return nil, ErrTodoNotFound
+	}
+
+	return ids, nil
+}
+
+// Descendants returns all the live todos below the given ones, at any depth,
+// ordered by id.
+func (r *sqlrepo) Descendants(ctx context.Context, ids []uint32) ([]Todo, error) {
+	workspace, owner, err := scopeOf(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	return &t, nil
+	if len(ids) == 0 {
+		return []Todo{}, nil
+	}
+
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	args = append(args, workspace, owner)
+
+	query := fmt.Sprintf("WITH RECURSIVE sub AS (SELECT %[2]s FROM `%[1]s` WHERE parent_id IN (%[4]s) AND workspace_id = ? AND owner_id = ? AND deleted_at IS NULL UNION ALL SELECT %[3]s FROM `%[1]s` t JOIN sub ON t.parent_id = sub.id WHERE t.deleted_at IS NULL) SELECT %[2]s FROM sub ORDER BY id", table, columns, qualifiedColumns("t"), placeholders(len(ids)))
+	return queryTodos(ctx, r.conn(), query, args...)
 }
 
-func (r *sqlrepo) Update(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
-	query := fmt.Sprintf("UPDATE `%s` SET text = IFNULL(?, text), completed = IFNULL(?, completed) WHERE id=?", table)
+func (r *sqlrepo) Changes(ctx context.Context, after ChangeToken, limit int, tombstones bool) ([]Change, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Trashed todos are told apart by their deletion time. Purged todos
+	// only remain as tombstones.
+	query := fmt.Sprintf("SELECT change_seq, id, list_id, deleted_at FROM `%s` WHERE workspace_id = ? AND owner_id = ? AND (change_seq, id) > (?, ?)", table)
+	args := []any{workspace, owner, after.Seq, after.ID}
+	if tombstones {
+		query += fmt.Sprintf(" UNION ALL SELECT change_seq, todo_id, list_id, deleted_at FROM `%s` WHERE workspace_id = ? AND owner_id = ? AND (change_seq, todo_id) > (?, ?)", tombstonesTable)
+		args = append(args, workspace, owner, after.Seq, after.ID)
+	} else {
+		query += " AND deleted_at IS NULL"
+	}
+	query += " ORDER BY change_seq, id LIMIT ?"
+	args = append(args, limit)
 
-	result, err := r.db.ExecContext(ctx, query, in.Text, in.Completed, id)
+	rows, err := r.conn().QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	rows, err := result.RowsAffected()
+	changes := []Change{}
+	var live []any
+	for rows.Next() {
+		var (
+			c         Change
+			listID    uint32
+			deletedAt *time.Time
+		)
+		if err := rows.Scan(&c.Seq, &c.ID, &listID, &deletedAt); err != nil {
+			return nil, err
+		}
+		if deletedAt != nil {
+			c.Tombstone = &Tombstone{ID: c.ID, ListID: listID, DeletedAt: *deletedAt}
+		} else {
+			live = append(live, c.ID)
+		}
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if len(live) == 0 {
+		return changes, nil
+	}
+
+	// A todo changed again since it was listed comes in its latest state,
+	// and once more at its new place in the sequence.
+	query = fmt.Sprintf("SELECT %s FROM `%s` WHERE id IN (%s) AND workspace_id = ? AND owner_id = ?", columns, table, placeholders(len(live)))
+	todos, err := queryTodos(ctx, r.conn(), query, append(live, workspace, owner)...)
 	if err != nil {
 		return nil, err
 	}
-	if rows > 1 {
-		log.Print("unexpected: multiple rows affected")
-		return nil, ErrUnexpected
+
+	byID := make(map[uint32]*Todo, len(todos))
+	for i := range todos {
+		byID[todos[i].ID] = &todos[i]
+	}
+	for i, c := range changes {
+		if c.Tombstone != nil {
+			continue
+		}
+		t, ok := byID[c.ID]
+		switch {
+		case !ok:
+			// Purged meanwhile; its tombstone comes later in the
+			// sequence.
+			continue
+		case t.DeletedAt != nil:
+			changes[i].Tombstone = &Tombstone{ID: t.ID, ListID: t.ListID, DeletedAt: *t.DeletedAt}
+		default:
+			changes[i].Todo = t
+		}
+	}
+
+	return changes, nil
+}
+
+// Enqueue only queues deliveries to the webhooks of the user of ctx, who owns
+// the todo of the event, in its workspace.
+func (r *sqlrepo) Enqueue(ctx context.Context, e Event) error {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("INSERT INTO `%s` (webhook_id, event, payload) SELECT id, ?, ? FROM `%s` WHERE workspace_id = ? AND owner_id = ? AND active AND (JSON_LENGTH(events) = 0 OR JSON_CONTAINS(events, JSON_QUOTE(?)))", deliveriesTable, webhooksTable)
+	_, err = r.conn().ExecContext(ctx, query, e.Type, payload, workspace, owner, e.Type)
+	return err
+}
+
+func (r *sqlrepo) Transact(ctx context.Context, fn func(Repository) error) error {
+	if r.tx != nil {
+		return fn(r)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(&sqlrepo{db: r.db, tx: tx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// nextChange draws the next number of the change sequence of the workspace of
+// ctx, which all the writes of a change are tagged with. q must be a
+// transaction: the counter stays locked until it ends, so that the changes of
+// a workspace commit in the order of their numbers and a sync never skips one
+// that committed late. Each workspace has a counter of its own, so that writes
+// to different workspaces don't wait on one another.
+func nextChange(ctx context.Context, q querier) (uint64, error) {
+	workspace, err := workspaceOf(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	query := fmt.Sprintf("INSERT INTO `%s` (workspace_id, seq) VALUES (?, LAST_INSERT_ID(1)) ON DUPLICATE KEY UPDATE seq = LAST_INSERT_ID(seq + 1)", sequenceTable)
+	result, err := q.ExecContext(ctx, query, workspace)
+	if err != nil {
+		return 0, err
+	}
+
+	seq, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(seq), nil
+}
+
+// scopeOf returns the workspace and the user whose todos ctx reaches.
+func scopeOf(ctx context.Context) (workspace, owner uint32, err error) {
+	if owner, err = ownerOf(ctx); err != nil {
+		return 0, 0, err
+	}
+	if workspace, err = workspaceOf(ctx); err != nil {
+		return 0, 0, err
+	}
+	return workspace, owner, nil
+}
+
+// conn returns the transaction of the repository, if any, or its database.
+func (r *sqlrepo) conn() querier {
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.db
+}
+
+// transact runs fn in a transaction, which is committed if fn succeeds and
+// rolled back otherwise. Within Transact, fn runs under a savepoint instead.
+func (r *sqlrepo) transact(ctx context.Context, fn func(q querier) error) error {
+	if r.tx != nil {
+		return savepoint(ctx, r.tx, func() error { return fn(r.tx) })
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// savepoint runs fn under a savepoint of tx, rolling back to it if fn fails.
+func savepoint(ctx context.Context, tx *sql.Tx, fn func() error) error {
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT write"); err != nil {
+		return err
+	}
+
+	if err := fn(); err != nil {
+		tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT write")
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT write")
+	return err
+}
+
+// getTodo returns the todo of the user of ctx with the given ID unless it is
+// trashed.
+func getTodo(ctx context.Context, q querier, id uint32) (*Todo, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
 	}
 
+	query := fmt.Sprintf("SELECT %s FROM `%s` WHERE id=? AND workspace_id=? AND owner_id=? AND deleted_at IS NULL", columns, table)
+
 	var t Todo
-	getQuery := fmt.Sprintf("SELECT id, text, completed, created_at, updated_at FROM `%s` WHERE id=?", table)
-	err = r.db.QueryRowContext(ctx, getQuery, id).Scan(&t.ID, &t.Text, &t.Completed, &t.CreatedAt, &t.UpdatedAt)
+	err = q.QueryRowContext(ctx, query, id, workspace, owner).Scan(todoFields(&t)...)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrTodoNotFound
@@ -115,26 +888,260 @@ func (r *sqlrepo) Update(ctx context.Context, id uint32, in TodoInput) (*Todo, e
 		return nil, err
 	}
 
-	return &t, nil
+	todos := []Todo{t}
+	if err := loadDetails(ctx, q, todos); err != nil {
+		return nil, err
+	}
+
+	return &todos[0], nil
 }
 
-func (r *sqlrepo) Delete(ctx context.Context, id uint32) error {
-	query := fmt.Sprintf("DELETE FROM `%s` WHERE id=?", table)
-	result, err := r.db.ExecContext(ctx, query, id)
+// versionError tells apart the reasons a conditional write of todo id matched
+// no row: ErrTodoNotFound if the todo is gone, ErrVersionMismatch otherwise.
+func versionError(ctx context.Context, q querier, id uint32) error {
+	workspace, owner, err := scopeOf(ctx)
 	if err != nil {
 		return err
 	}
 
-	rows, err := result.RowsAffected()
+	query := fmt.Sprintf("SELECT 1 FROM `%s` WHERE id=? AND workspace_id=? AND owner_id=? AND deleted_at IS NULL", table)
+
+	var found int
+	err = q.QueryRowContext(ctx, query, id, workspace, owner).Scan(&found)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrTodoNotFound
+		}
 		return err
 	}
-	if rows == 0 {
-		return ErrTodoNotFound
-	} else if rows != 1 {
-		log.Print("unexpected: multiple rows affected")
-		return ErrUnexpected
+
+	return ErrVersionMismatch
+}
+
+// changedWith returns the todos of the user of ctx other than todo id written
+// as part of change seq, either those in the trash or those out of it.
+func changedWith(ctx context.Context, q querier, seq uint64, id uint32, trashed bool) ([]Todo, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	query := fmt.Sprintf("SELECT %s FROM `%s` WHERE workspace_id = ? AND owner_id = ? AND change_seq = ? AND id <> ? AND (deleted_at IS NOT NULL) = ? ORDER BY id", columns, table)
+	return queryTodos(ctx, q, query, workspace, owner, seq, id, trashed)
+}
+
+// queryTodos runs a query selecting columns and returns the scanned todos with
+// their details loaded.
+func queryTodos(ctx context.Context, q querier, query string, args ...any) ([]Todo, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	todos := []Todo{}
+	for rows.Next() {
+		var t Todo
+		if err := rows.Scan(todoFields(&t)...); err != nil {
+			return nil, err
+		}
+		todos = append(todos, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := loadDetails(ctx, q, todos); err != nil {
+		return nil, err
+	}
+
+	return todos, nil
+}
+
+// loadDetails fills in the fields of todos that live outside of the todos
+// row, with a fixed number of queries regardless of the number of todos.
+func loadDetails(ctx context.Context, q querier, todos []Todo) error {
+	if err := loadTags(ctx, q, todos); err != nil {
+		return err
+	}
+	return loadProgress(ctx, q, todos)
+}
+
+// loadProgress counts the live direct children of todos, and how many of them
+// are completed, with a single query.
+func loadProgress(ctx context.Context, q querier, todos []Todo) error {
+	if len(todos) == 0 {
+		return nil
+	}
+
+	ids := make([]any, len(todos))
+	index := make(map[uint32]int, len(todos))
+	for i := range todos {
+		todos[i].Progress = Progress{}
+		ids[i] = todos[i].ID
+		index[todos[i].ID] = i
+	}
+
+	query := fmt.Sprintf("SELECT parent_id, COUNT(*), COALESCE(SUM(completed), 0) FROM `%s` WHERE parent_id IN (%s) AND deleted_at IS NULL GROUP BY parent_id", table, placeholders(len(ids)))
+	rows, err := q.QueryContext(ctx, query, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id uint32
+			p  Progress
+		)
+		if err := rows.Scan(&id, &p.Total, &p.Completed); err != nil {
+			return err
+		}
+		if i, ok := index[id]; ok {
+			todos[i].Progress = p
+		}
+	}
+
+	return rows.Err()
+}
+
+// qualifiedColumns returns columns with each column qualified by alias.
+func qualifiedColumns(alias string) string {
+	cols := strings.Split(columns, ", ")
+	for i, c := range cols {
+		cols[i] = alias + "." + c
+	}
+	return strings.Join(cols, ", ")
+}
+
+// referenceError maps a violation of the foreign key on the list or parent of
+// a todo to the error for the missing row. Other errors, including violations
+// of other foreign keys, are returned unchanged.
+func referenceError(err error) error {
+	var me *mysql.MySQLError
+	if !errors.As(err, &me) || me.Number != 1452 {
+		return err
+	}
+	switch {
+	case strings.Contains(me.Message, "fk_todos_parent"):
+		return ErrParentNotFound
+	case strings.Contains(me.Message, "fk_todos_list"):
+		return ErrListNotFound
+	}
+	return err
+}
+
+// todoFields returns the scan destinations for the fields of t, matching the
+// order of columns.
+func todoFields(t *Todo) []any {
+	return []any{&t.ID, &t.Text, &t.Completed, &t.CreatedAt, &t.UpdatedAt, &t.DueAt, &t.StartAt, &t.Priority, &t.ListID, &t.ParentID, &t.Recurrence, &t.SeriesID, &t.Occurrence, &t.DeletedAt, &t.Version, &t.OwnerID, &t.DueDateOnly}
+}
+
+// whereClause builds the WHERE clause (including the leading keyword) for the
+// todos of owner in workspace matching the filters set in p, along with the
+// matching placeholder arguments. The clause is never empty since it always
+// selects either live or trashed todos.
+func whereClause(workspace, owner uint32, p ListParams) (string, []any) {
+	conds := []string{"workspace_id = ?", "owner_id = ?", "deleted_at IS NULL"}
+	args := []any{workspace, owner}
+
+	if p.Trashed {
+		conds[2] = "deleted_at IS NOT NULL"
+	}
+
+	if p.ListID != nil {
+		conds = append(conds, "list_id = ?")
+		args = append(args, *p.ListID)
+	}
+	if p.ParentID != nil {
+		conds = append(conds, "parent_id = ?")
+		args = append(args, *p.ParentID)
+	}
+	if p.SeriesID != nil {
+		conds = append(conds, "series_id = ?")
+		args = append(args, *p.SeriesID)
+	}
+	if p.Completed != nil {
+		conds = append(conds, "completed = ?")
+		args = append(args, *p.Completed)
+	}
+	if p.CreatedAfter != nil {
+		conds = append(conds, "created_at > ?")
+		args = append(args, *p.CreatedAfter)
+	}
+	if p.CreatedBefore != nil {
+		conds = append(conds, "created_at < ?")
+		args = append(args, *p.CreatedBefore)
+	}
+	if p.UpdatedAfter != nil {
+		conds = append(conds, "updated_at > ?")
+		args = append(args, *p.UpdatedAfter)
+	}
+	if p.UpdatedBefore != nil {
+		conds = append(conds, "updated_at < ?")
+		args = append(args, *p.UpdatedBefore)
+	}
+	if p.DueFrom != nil || p.DueBefore != nil {
+		// Date-only due dates are calendar dates, which fall within the
+		// window by date rather than by instant.
+		timed, dated := []string{"NOT due_date_only"}, []string{"due_date_only"}
+		var timedArgs, datedArgs []any
+		if p.DueFrom != nil {
+			timed = append(timed, "due_at >= ?")
+			dated = append(dated, "due_at >= ?")
+			timedArgs = append(timedArgs, *p.DueFrom)
+			datedArgs = append(datedArgs, *cmp.Or(p.DueDateFrom, p.DueFrom))
+		}
+		if p.DueBefore != nil {
+			timed = append(timed, "due_at < ?")
+			dated = append(dated, "due_at < ?")
+			timedArgs = append(timedArgs, *p.DueBefore)
+			datedArgs = append(datedArgs, *cmp.Or(p.DueDateBefore, p.DueBefore))
+		}
+		conds = append(conds, fmt.Sprintf("((%s) OR (%s))", strings.Join(timed, " AND "), strings.Join(dated, " AND ")))
+		args = append(append(args, timedArgs...), datedArgs...)
+	}
+	if len(p.Tags) > 0 {
+		sub := fmt.Sprintf("SELECT tt.todo_id FROM `%s` tt JOIN `%s` g ON g.id = tt.tag_id WHERE g.name IN (%s)", todoTagsTable, tagsTable, placeholders(len(p.Tags)))
+		for _, t := range p.Tags {
+			args = append(args, t)
+		}
+		if p.TagMode == TagModeAll {
+			sub += " GROUP BY tt.todo_id HAVING COUNT(DISTINCT g.id) = ?"
+			args = append(args, len(p.Tags))
+		}
+		conds = append(conds, fmt.Sprintf("id IN (%s)", sub))
+	}
+
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// sortColumns maps the sort fields accepted by the API to their columns. It
+// doubles as a whitelist since column names can't be passed as placeholders.
+var sortColumns = map[string]string{
+	SortByID:        "id",
+	SortByCreatedAt: "created_at",
+	SortByUpdatedAt: "updated_at",
+	SortByPriority:  "priority",
+}
+
+func orderClause(s Sort) string {
+	col, ok := sortColumns[s.Field]
+	if !ok {
+		col = "id"
+	}
+
+	dir, rev := "ASC", "DESC"
+	if s.Desc {
+		dir, rev = rev, dir
+	}
+
+	switch col {
+	case "priority":
+		// The priority ENUM orders by declaration, i.e. from none to urgent.
+		return fmt.Sprintf(" ORDER BY priority %s, due_at IS NULL %s, due_at %s, id %s", rev, dir, dir, dir)
+	case "id":
+		return fmt.Sprintf(" ORDER BY id %s", dir)
+	}
+	return fmt.Sprintf(" ORDER BY %s %s, id %s", col, dir, dir)
 }
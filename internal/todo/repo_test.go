@@ -3,35 +3,99 @@ package todo_test
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"regexp"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
 
 	. "github.com/anas-salha/2do/internal/todo"
 )
 
+// todoColumns mirrors the columns the repository selects for a todo.
+var todoColumns = []string{"id", "text", "completed", "created_at", "updated_at", "due_at", "start_at", "priority", "list_id", "parent_id", "recurrence", "series_id", "occurrence", "deleted_at", "version", "owner_id", "due_date_only"}
+
+// tagsQuery is the query loading the tags of a batch of todos.
+const tagsQuery = "SELECT tt.todo_id, g.name FROM `todo_tags` tt JOIN `tags` g ON g.id = tt.tag_id WHERE tt.todo_id IN"
+
+// progressQuery is the query counting the children of a batch of todos.
+const progressQuery = "SELECT parent_id, COUNT(*), COALESCE(SUM(completed), 0) FROM `todos` WHERE parent_id IN"
+
+// todoDefaults holds the values of the optional columns following updated_at
+// for a freshly created todo.
+var todoDefaults = []driver.Value{nil, nil, "none", 1, nil, nil, nil, 1, nil, 1, testOwner, false}
+
+// todoRow returns the values of a todo row. The optional columns following
+// updated_at take their defaults unless overridden by rest.
+func todoRow(id, text, completed any, createdAt, updatedAt time.Time, rest ...driver.Value) []driver.Value {
+	row := []driver.Value{id, text, completed, createdAt, updatedAt}
+	row = append(row, todoDefaults...)
+	copy(row[5:], rest)
+	return row
+}
+
+// testOwner is the user the repository acts on behalf of in tests.
+const testOwner = 7
+
+// testWorkspace is the workspace the repository acts within in tests.
+const testWorkspace = 3
+
+// expectQuota expects an insert to check whether the workspace is full.
+func expectQuota(mock sqlmock.Sqlmock, full bool) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT w.max_todos IS NOT NULL AND w.max_todos <= (SELECT COUNT(*) FROM `todos` WHERE workspace_id = w.id) FROM `workspaces` w WHERE w.id=? FOR UPDATE")).
+		WithArgs(testWorkspace).
+		WillReturnRows(sqlmock.NewRows([]string{"full"}).AddRow(full))
+}
+
+// expectNextChange expects a write to draw seq from the change sequence.
+func expectNextChange(mock sqlmock.Sqlmock, seq int64) {
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `change_sequence` (workspace_id, seq) VALUES (?, LAST_INSERT_ID(1)) ON DUPLICATE KEY UPDATE seq = LAST_INSERT_ID(seq + 1)")).
+		WillReturnResult(sqlmock.NewResult(seq, 1))
+}
+
+// expectAffected expects a write of change seq to todo id to look up the
+// other todos it wrote, in the trash or out of it, finding the given rows.
+func expectAffected(mock sqlmock.Sqlmock, seq int64, id uint32, trashed bool, found ...[]driver.Value) {
+	rows := sqlmock.NewRows(todoColumns)
+	for _, row := range found {
+		rows.AddRow(row...)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, text, completed, created_at, updated_at, due_at, start_at, priority, list_id, parent_id, recurrence, series_id, occurrence, deleted_at, version, owner_id, due_date_only FROM `todos` WHERE workspace_id = ? AND owner_id = ? AND change_seq = ? AND id <> ? AND (deleted_at IS NOT NULL) = ? ORDER BY id")).
+		WithArgs(testWorkspace, testOwner, seq, id, trashed).
+		WillReturnRows(rows)
+	if len(found) > 0 {
+		mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(sqlmock.NewRows([]string{"todo_id", "name"}))
+		mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(sqlmock.NewRows([]string{"parent_id", "total", "completed"}))
+	}
+}
+
 var _ = Describe("repo", Label("repo"), func() {
 	var (
-		ctx  context.Context
-		db   *sql.DB
-		mock sqlmock.Sqlmock
-		repo Repository
-		now  time.Time
-		rows *sqlmock.Rows
+		ctx          context.Context
+		db           *sql.DB
+		mock         sqlmock.Sqlmock
+		repo         Repository
+		now          time.Time
+		rows         *sqlmock.Rows
+		tagRows      *sqlmock.Rows
+		progressRows *sqlmock.Rows
 	)
 
 	BeforeEach(func() {
 		var err error
-		ctx = context.Background()
+		ctx = WithWorkspace(WithUser(context.Background(), testOwner), testWorkspace)
 		db, mock, err = sqlmock.New()
 		Expect(err).NotTo(HaveOccurred())
 		repo = NewRepo(db)
 		now = time.Now().UTC().Truncate(time.Second)
-		rows = sqlmock.NewRows([]string{"id", "text", "completed", "created_at", "updated_at"})
+		rows = sqlmock.NewRows(todoColumns)
+		tagRows = sqlmock.NewRows([]string{"todo_id", "name"})
+		progressRows = sqlmock.NewRows([]string{"parent_id", "total", "completed"})
 	})
 
 	AfterEach(func() {
@@ -44,88 +108,356 @@ var _ = Describe("repo", Label("repo"), func() {
 		var query string
 
 		BeforeEach(func() {
-			query = "SELECT id, text, completed, created_at, updated_at FROM `todos`"
+			query = "SELECT id, text, completed, created_at, updated_at, due_at, start_at, priority, list_id, parent_id, recurrence, series_id, occurrence, deleted_at, version, owner_id, due_date_only FROM `todos`"
 		})
 
 		It("lists no todos (empty) successfully", func() {
 			mock.ExpectQuery(query).WillReturnRows(rows)
 
-			todos, err := repo.List(ctx)
+			todos, err := repo.List(ctx, ListParams{})
 			Expect(err).NotTo(HaveOccurred())
 			Expect(todos).To(BeEmpty())
 		})
 
 		It("lists 2 todos successfully", func() {
 			rows = rows.
-				AddRow(1, "walk the dog", false, now, now).
-				AddRow(2, "buy groceries", true, now, now)
+				AddRow(todoRow(1, "walk the dog", false, now, now)...).
+				AddRow(todoRow(2, "buy groceries", true, now, now)...)
 			mock.ExpectQuery(query).WillReturnRows(rows)
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).
+				WithArgs(1, 2).
+				WillReturnRows(tagRows.AddRow(2, "errands").AddRow(2, "home"))
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
 
-			todos, err := repo.List(ctx)
+			todos, err := repo.List(ctx, ListParams{})
 			Expect(err).NotTo(HaveOccurred())
 			Expect(todos).To(HaveLen(2))
 			Expect(todos[0].Text).To(Equal("walk the dog"))
 			Expect(todos[0].Completed).To(BeFalse())
 			Expect(todos[1].Text).To(Equal("buy groceries"))
 			Expect(todos[1].Completed).To(BeTrue())
+			Expect(todos[0].Tags).To(BeEmpty())
+			Expect(todos[1].Tags).To(Equal([]string{"errands", "home"}))
 		})
 
 		It("propagates query errors", func() {
 			expected := errors.New("list failed")
 			mock.ExpectQuery(query).WillReturnError(expected)
 
-			todos, err := repo.List(ctx)
+			todos, err := repo.List(ctx, ListParams{})
 			Expect(err).To(MatchError(expected))
 			Expect(todos).To(BeNil())
 		})
 
 		It("propagates scan errors", func() {
 			rows = rows.
-				AddRow(1, "walk the dog", false, now, now).
-				AddRow(2, nil, true, now, now) // text is nil, should cause scan error
+				AddRow(todoRow(1, "walk the dog", false, now, now)...).
+				AddRow(todoRow(2, nil, true, now, now)...) // text is nil, should cause scan error
 			mock.ExpectQuery(query).WillReturnRows(rows)
 
-			todos, err := repo.List(ctx)
+			todos, err := repo.List(ctx, ListParams{})
 			Expect(err).To(HaveOccurred())
 			Expect(todos).To(BeNil())
 		})
 
 		It("propagates row iteration errors", func() {
 			rows = rows.
-				AddRow(1, "walk the dog", false, now, now).
+				AddRow(todoRow(1, "walk the dog", false, now, now)...).
 				RowError(0, errors.New("row iteration error")).
-				AddRow(2, "buy groceries", true, now, now)
+				AddRow(todoRow(2, "buy groceries", true, now, now)...)
 
 			mock.ExpectQuery(query).WillReturnRows(rows)
 
-			todos, err := repo.List(ctx)
+			todos, err := repo.List(ctx, ListParams{})
 			Expect(err).To(MatchError("row iteration error"))
 			Expect(todos).To(BeNil())
 		})
+
+		It("pushes filters, sorting and paging down into the query", func() {
+			completed := true
+			after := now.Add(-time.Hour)
+			before := now
+			p := ListParams{
+				Completed:     &completed,
+				CreatedAfter:  &after,
+				UpdatedBefore: &before,
+				Sort:          Sort{Field: SortByCreatedAt, Desc: true},
+				Limit:         10,
+				Offset:        20,
+			}
+
+			mock.ExpectQuery(regexp.QuoteMeta(query+" WHERE workspace_id = ? AND owner_id = ? AND deleted_at IS NULL AND completed = ? AND created_at > ? AND updated_at < ? ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?")).
+				WithArgs(testWorkspace, testOwner, true, after, before, 10, 20).
+				WillReturnRows(rows)
+
+			todos, err := repo.List(ctx, p)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(todos).To(BeEmpty())
+		})
+
+		It("bounds the due date window", func() {
+			from := now
+			before := now.Add(24 * time.Hour)
+
+			mock.ExpectQuery(regexp.QuoteMeta(query+" WHERE workspace_id = ? AND owner_id = ? AND deleted_at IS NULL AND ((NOT due_date_only AND due_at >= ? AND due_at < ?) OR (due_date_only AND due_at >= ? AND due_at < ?)) ORDER BY id ASC")).
+				WithArgs(testWorkspace, testOwner, from, before, from, before).
+				WillReturnRows(rows)
+
+			_, err := repo.List(ctx, ListParams{DueFrom: &from, DueBefore: &before})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("bounds date-only due dates by the dates of the window", func() {
+			la, err := time.LoadLocation("America/Los_Angeles")
+			Expect(err).NotTo(HaveOccurred())
+			from := time.Date(2025, 9, 17, 0, 0, 0, 0, la)
+			before := from.AddDate(0, 0, 1)
+			fromDate := time.Date(2025, 9, 17, 0, 0, 0, 0, time.UTC)
+			beforeDate := fromDate.AddDate(0, 0, 1)
+
+			mock.ExpectQuery(regexp.QuoteMeta(query+" WHERE workspace_id = ? AND owner_id = ? AND deleted_at IS NULL AND ((NOT due_date_only AND due_at < ?) OR (due_date_only AND due_at < ?)) ORDER BY id ASC")).
+				WithArgs(testWorkspace, testOwner, before, beforeDate).
+				WillReturnRows(rows)
+			mock.ExpectQuery(regexp.QuoteMeta(query+" WHERE workspace_id = ? AND owner_id = ? AND deleted_at IS NULL AND ((NOT due_date_only AND due_at >= ? AND due_at < ?) OR (due_date_only AND due_at >= ? AND due_at < ?)) ORDER BY id ASC")).
+				WithArgs(testWorkspace, testOwner, from, before, fromDate, beforeDate).
+				WillReturnRows(rows)
+
+			_, err = repo.List(ctx, ListParams{DueBefore: &before, DueDateBefore: &beforeDate})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = repo.List(ctx, ListParams{DueFrom: &from, DueBefore: &before, DueDateFrom: &fromDate, DueDateBefore: &beforeDate})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("filters by list", func() {
+			list := uint32(3)
+			mock.ExpectQuery(regexp.QuoteMeta(query+" WHERE workspace_id = ? AND owner_id = ? AND deleted_at IS NULL AND list_id = ? AND completed = ? ORDER BY id ASC")).
+				WithArgs(testWorkspace, testOwner, list, false).
+				WillReturnRows(rows)
+
+			completed := false
+			_, err := repo.List(ctx, ListParams{ListID: &list, Completed: &completed})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("filters by parent", func() {
+			parent := uint32(7)
+			mock.ExpectQuery(regexp.QuoteMeta(query+" WHERE workspace_id = ? AND owner_id = ? AND deleted_at IS NULL AND parent_id = ? ORDER BY id ASC")).
+				WithArgs(testWorkspace, testOwner, parent).
+				WillReturnRows(rows)
+
+			_, err := repo.List(ctx, ListParams{ParentID: &parent})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("filters by series", func() {
+			series := uint32(4)
+			mock.ExpectQuery(regexp.QuoteMeta(query+" WHERE workspace_id = ? AND owner_id = ? AND deleted_at IS NULL AND series_id = ? ORDER BY id ASC")).
+				WithArgs(testWorkspace, testOwner, series).
+				WillReturnRows(rows)
+
+			_, err := repo.List(ctx, ListParams{SeriesID: &series})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("reports the progress of parents", func() {
+			mock.ExpectQuery(query).WillReturnRows(rows.
+				AddRow(todoRow(1, "move house", false, now, now)...).
+				AddRow(todoRow(2, "pack books", true, now, now, nil, nil, "none", 1, 1)...))
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).
+				WithArgs(1, 2).
+				WillReturnRows(progressRows.AddRow(1, 3, "1"))
+
+			todos, err := repo.List(ctx, ListParams{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(todos[0].Progress).To(Equal(Progress{Completed: 1, Total: 3}))
+			Expect(todos[1].Progress).To(Equal(Progress{}))
+			Expect(todos[1].ParentID).To(PointTo(BeEquivalentTo(1)))
+		})
+
+		It("matches any of the given tags", func() {
+			mock.ExpectQuery(regexp.QuoteMeta(query+" WHERE workspace_id = ? AND owner_id = ? AND deleted_at IS NULL AND id IN (SELECT tt.todo_id FROM `todo_tags` tt JOIN `tags` g ON g.id = tt.tag_id WHERE g.name IN (?, ?)) ORDER BY id ASC")).
+				WithArgs(testWorkspace, testOwner, "home", "work").
+				WillReturnRows(rows)
+
+			_, err := repo.List(ctx, ListParams{Tags: []string{"home", "work"}, TagMode: TagModeAny})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("matches all of the given tags", func() {
+			mock.ExpectQuery(regexp.QuoteMeta(query+" WHERE workspace_id = ? AND owner_id = ? AND deleted_at IS NULL AND id IN (SELECT tt.todo_id FROM `todo_tags` tt JOIN `tags` g ON g.id = tt.tag_id WHERE g.name IN (?, ?) GROUP BY tt.todo_id HAVING COUNT(DISTINCT g.id) = ?) ORDER BY id ASC")).
+				WithArgs(testWorkspace, testOwner, "home", "work", 2).
+				WillReturnRows(rows)
+
+			_, err := repo.List(ctx, ListParams{Tags: []string{"home", "work"}, TagMode: TagModeAll})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("propagates tag loading errors", func() {
+			mock.ExpectQuery(query).WillReturnRows(rows.AddRow(todoRow(1, "walk the dog", false, now, now)...))
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnError(errors.New("tags failed"))
+
+			todos, err := repo.List(ctx, ListParams{})
+			Expect(err).To(MatchError("tags failed"))
+			Expect(todos).To(BeNil())
+		})
+
+		It("orders by priority, then by due date with undated todos last", func() {
+			mock.ExpectQuery(regexp.QuoteMeta(query + " WHERE workspace_id = ? AND owner_id = ? AND deleted_at IS NULL ORDER BY priority DESC, due_at IS NULL ASC, due_at ASC, id ASC")).
+				WillReturnRows(rows.AddRow(todoRow(1, "pay rent", false, now, now, now, nil, "urgent")...))
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+
+			todos, err := repo.List(ctx, ListParams{Sort: Sort{Field: SortByPriority}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(todos).To(HaveLen(1))
+			Expect(todos[0].Priority).To(Equal(PriorityUrgent))
+		})
+
+		It("reverses the whole priority ordering when descending", func() {
+			mock.ExpectQuery(regexp.QuoteMeta(query + " WHERE workspace_id = ? AND owner_id = ? AND deleted_at IS NULL ORDER BY priority ASC, due_at IS NULL DESC, due_at DESC, id DESC")).
+				WillReturnRows(rows)
+
+			_, err := repo.List(ctx, ListParams{Sort: Sort{Field: SortByPriority, Desc: true}})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("orders by id when no sort is given", func() {
+			mock.ExpectQuery(regexp.QuoteMeta(query + " WHERE workspace_id = ? AND owner_id = ? AND deleted_at IS NULL ORDER BY id ASC")).WillReturnRows(rows)
+
+			_, err := repo.List(ctx, ListParams{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("refuses to list without a user", func() {
+			todos, err := repo.List(context.Background(), ListParams{})
+			Expect(err).To(MatchError(ErrUnauthenticated))
+			Expect(todos).To(BeNil())
+		})
+	})
+
+	Describe("ListAfter", Label("list-after"), func() {
+		var query string
+
+		BeforeEach(func() {
+			query = "SELECT id, text, completed, created_at, updated_at, due_at, start_at, priority, list_id, parent_id, recurrence, series_id, occurrence, deleted_at, version, owner_id, due_date_only FROM `todos`"
+		})
+
+		It("seeks past the boundary row in ascending order", func() {
+			completed := false
+			c := Cursor{Sort: Sort{Field: SortByCreatedAt}, Value: now, ID: 4}
+
+			mock.ExpectQuery(regexp.QuoteMeta(query+" WHERE workspace_id = ? AND owner_id = ? AND deleted_at IS NULL AND completed = ? AND (created_at, id) > (?, ?) ORDER BY created_at ASC, id ASC LIMIT ?")).
+				WithArgs(testWorkspace, testOwner, false, now, 4, 2).
+				WillReturnRows(rows.AddRow(todoRow(5, "walk the dog", false, now, now)...).AddRow(todoRow(6, "buy groceries", false, now, now)...))
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+
+			todos, err := repo.ListAfter(ctx, ListParams{Completed: &completed, Limit: 2, Offset: 9}, c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(todos).To(HaveLen(2))
+			Expect(todos[0].ID).To(BeEquivalentTo(5))
+		})
+
+		It("seeks before the boundary row when paging backward and returns display order", func() {
+			c := Cursor{Sort: Sort{Field: SortByUpdatedAt, Desc: true}, Value: now, ID: 4, Backward: true}
+
+			mock.ExpectQuery(regexp.QuoteMeta(query+" WHERE workspace_id = ? AND owner_id = ? AND deleted_at IS NULL AND (updated_at, id) > (?, ?) ORDER BY updated_at ASC, id ASC LIMIT ?")).
+				WithArgs(testWorkspace, testOwner, now, 4, 2).
+				WillReturnRows(rows.AddRow(todoRow(5, "walk the dog", false, now, now)...).AddRow(todoRow(6, "buy groceries", false, now, now)...))
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+
+			todos, err := repo.ListAfter(ctx, ListParams{Limit: 2}, c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(todos).To(HaveLen(2))
+			Expect(todos[0].ID).To(BeEquivalentTo(6))
+			Expect(todos[1].ID).To(BeEquivalentTo(5))
+		})
+
+		It("seeks on id alone when sorting by id", func() {
+			c := Cursor{Sort: Sort{Field: SortByID, Desc: true}, ID: 4}
+
+			mock.ExpectQuery(regexp.QuoteMeta(query+" WHERE workspace_id = ? AND owner_id = ? AND deleted_at IS NULL AND id < ? ORDER BY id DESC LIMIT ?")).
+				WithArgs(testWorkspace, testOwner, 4, 10).
+				WillReturnRows(rows)
+
+			todos, err := repo.ListAfter(ctx, ListParams{Limit: 10}, c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(todos).To(BeEmpty())
+		})
+
+		It("rejects cursors on unknown sort fields", func() {
+			todos, err := repo.ListAfter(ctx, ListParams{}, Cursor{Sort: Sort{Field: "text"}})
+			Expect(err).To(MatchError(ErrBadCursor))
+			Expect(todos).To(BeNil())
+		})
+
+		It("rejects cursors on priority ordering", func() {
+			todos, err := repo.ListAfter(ctx, ListParams{}, Cursor{Sort: Sort{Field: SortByPriority}})
+			Expect(err).To(MatchError(ErrBadCursor))
+			Expect(todos).To(BeNil())
+		})
+
+		It("propagates query errors", func() {
+			expected := errors.New("list failed")
+			mock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnError(expected)
+
+			todos, err := repo.ListAfter(ctx, ListParams{}, Cursor{Sort: Sort{Field: SortByID}, ID: 1})
+			Expect(err).To(MatchError(expected))
+			Expect(todos).To(BeNil())
+		})
+	})
+
+	Describe("Count", Label("count"), func() {
+		It("counts todos matching the filters", func() {
+			completed := false
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM `todos` WHERE workspace_id = ? AND owner_id = ? AND deleted_at IS NULL AND completed = ?")).
+				WithArgs(testWorkspace, testOwner, false).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+
+			n, err := repo.Count(ctx, ListParams{Completed: &completed, Limit: 5, Offset: 5})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n).To(Equal(7))
+		})
+
+		It("propagates query errors", func() {
+			expected := errors.New("count failed")
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM `todos`")).WillReturnError(expected)
+
+			n, err := repo.Count(ctx, ListParams{})
+			Expect(err).To(MatchError(expected))
+			Expect(n).To(BeZero())
+		})
 	})
 
 	Describe("Get", Label("get"), func() {
 		var query string
 
 		BeforeEach(func() {
-			query = "SELECT id, text, completed, created_at, updated_at FROM `todos` WHERE id=?"
+			query = "SELECT id, text, completed, created_at, updated_at, due_at, start_at, priority, list_id, parent_id, recurrence, series_id, occurrence, deleted_at, version, owner_id, due_date_only FROM `todos` WHERE id=? AND workspace_id=? AND owner_id=? AND deleted_at IS NULL"
 		})
 
 		It("get todo successfully", func() {
 			id := uint32(1)
 			rows = rows.
-				AddRow(id, "walk the dog", false, now, now)
-			mock.ExpectQuery(query).WillReturnRows(rows)
+				AddRow(todoRow(id, "walk the dog", false, now, now)...)
+			mock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(rows)
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).
+				WithArgs(id).
+				WillReturnRows(tagRows.AddRow(id, "pets"))
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
 
 			todo, err := repo.Get(ctx, id)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(todo.Text).To(Equal("walk the dog"))
 			Expect(todo.Completed).To(BeFalse())
+			Expect(todo.Tags).To(Equal([]string{"pets"}))
 		})
 
 		It("propagates query errors", func() {
 			expected := errors.New("get failed")
-			mock.ExpectQuery(query).WillReturnError(expected)
+			mock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnError(expected)
 
 			todo, err := repo.Get(ctx, 1)
 			Expect(err).To(MatchError(expected))
@@ -133,7 +465,7 @@ var _ = Describe("repo", Label("repo"), func() {
 		})
 
 		It("returns todo not found errors", func() {
-			mock.ExpectQuery(query).WillReturnError(sql.ErrNoRows)
+			mock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnError(sql.ErrNoRows)
 
 			todo, err := repo.Get(ctx, 1)
 			Expect(err).To(MatchError(ErrTodoNotFound))
@@ -148,8 +480,8 @@ var _ = Describe("repo", Label("repo"), func() {
 		)
 
 		BeforeEach(func() {
-			query = "INSERT INTO `todos` (text, completed) VALUES (?, ?)"
-			getQuery = "SELECT id, text, completed, created_at, updated_at FROM `todos` WHERE id=?"
+			query = "INSERT INTO `todos` (workspace_id, owner_id, text, completed, due_at, due_date_only, start_at, priority, list_id, parent_id, recurrence, series_id, occurrence, change_seq) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+			getQuery = "SELECT id, text, completed, created_at, updated_at, due_at, start_at, priority, list_id, parent_id, recurrence, series_id, occurrence, deleted_at, version, owner_id, due_date_only FROM `todos` WHERE id=? AND workspace_id=? AND owner_id=? AND deleted_at IS NULL"
 		})
 
 		It("creates and returns a todo successfully", func() {
@@ -158,12 +490,18 @@ var _ = Describe("repo", Label("repo"), func() {
 			input := TodoInput{Text: &text, Completed: &completed}
 
 			lastInsertId := int64(3)
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			expectQuota(mock, false)
 			mock.ExpectExec(regexp.QuoteMeta(query)).
-				WithArgs(&text, &completed).
+				WithArgs(testWorkspace, testOwner, &text, &completed, nil, false, nil, nil, nil, nil, nil, nil, 1, 7).
 				WillReturnResult(sqlmock.NewResult(lastInsertId, 1))
 
-			rows = rows.AddRow(lastInsertId, text, true, now, now)
-			mock.ExpectQuery(getQuery).WillReturnRows(rows)
+			rows = rows.AddRow(todoRow(lastInsertId, text, true, now, now)...)
+			mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnRows(rows)
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+			mock.ExpectCommit()
 
 			todo, err := repo.Create(ctx, input)
 			Expect(err).NotTo(HaveOccurred())
@@ -173,15 +511,208 @@ var _ = Describe("repo", Label("repo"), func() {
 			Expect(todo.Completed).To(BeTrue())
 		})
 
+		It("stores and returns the schedule", func() {
+			text := "file taxes"
+			completed := false
+			start := DateTime{Time: now.Add(-24 * time.Hour)}
+			due := DateTime{Time: now}
+			input := TodoInput{Text: &text, Completed: &completed, StartAt: &start, DueAt: &due}
+
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			expectQuota(mock, false)
+			mock.ExpectExec(regexp.QuoteMeta(query)).
+				WithArgs(testWorkspace, testOwner, &text, &completed, due.Time, false, start.Time, nil, nil, nil, nil, nil, 1, 7).
+				WillReturnResult(sqlmock.NewResult(4, 1))
+
+			rows = rows.AddRow(todoRow(4, text, false, now, now, due.Time, start.Time)...)
+			mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnRows(rows)
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+			mock.ExpectCommit()
+
+			todo, err := repo.Create(ctx, input)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(todo.StartAt).To(PointTo(BeTemporally("==", start.Time)))
+			Expect(todo.DueAt).To(PointTo(BeTemporally("==", due.Time)))
+		})
+
+		It("stores date-only due dates as dates", func() {
+			text := "file taxes"
+			completed := false
+			due := DateTime{Time: time.Date(2025, 9, 17, 0, 0, 0, 0, time.UTC), DateOnly: true}
+			input := TodoInput{Text: &text, Completed: &completed, DueAt: &due}
+
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			expectQuota(mock, false)
+			mock.ExpectExec(regexp.QuoteMeta(query)).
+				WithArgs(testWorkspace, testOwner, &text, &completed, due.Time, true, nil, nil, nil, nil, nil, nil, 1, 7).
+				WillReturnResult(sqlmock.NewResult(4, 1))
+
+			rows = rows.AddRow(todoRow(4, text, false, now, now, due.Time, nil, "none", 1, nil, nil, nil, 1, nil, 1, testOwner, true)...)
+			mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnRows(rows)
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+			mock.ExpectCommit()
+
+			todo, err := repo.Create(ctx, input)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(todo.DueDateOnly).To(BeTrue())
+		})
+
+		It("starts a series for a recurring todo", func() {
+			text := "water plants"
+			completed := false
+			rule := "FREQ=WEEKLY"
+			input := TodoInput{Text: &text, Completed: &completed, Recurrence: &rule}
+
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			expectQuota(mock, false)
+			mock.ExpectExec(regexp.QuoteMeta(query)).
+				WithArgs(testWorkspace, testOwner, &text, &completed, nil, false, nil, nil, nil, nil, &rule, nil, 1, 7).
+				WillReturnResult(sqlmock.NewResult(6, 1))
+			mock.ExpectExec(regexp.QuoteMeta("UPDATE `todos` SET series_id = id WHERE id=?")).
+				WithArgs(6).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			rows = rows.AddRow(todoRow(6, text, false, now, now, nil, nil, "none", 1, nil, rule, 6, 1)...)
+			mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnRows(rows)
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+			mock.ExpectCommit()
+
+			todo, err := repo.Create(ctx, input)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(todo.Recurrence).To(PointTo(Equal(rule)))
+			Expect(todo.SeriesID).To(PointTo(BeEquivalentTo(6)))
+			Expect(todo.Occurrence).To(Equal(1))
+		})
+
+		It("creates missing tags and attaches them", func() {
+			text := "hit the gym"
+			completed := false
+			tags := []string{"health", "routine"}
+			input := TodoInput{Text: &text, Completed: &completed, Tags: &tags}
+
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			expectQuota(mock, false)
+			mock.ExpectExec(regexp.QuoteMeta(query)).
+				WithArgs(testWorkspace, testOwner, &text, &completed, nil, false, nil, nil, nil, nil, nil, nil, 1, 7).
+				WillReturnResult(sqlmock.NewResult(5, 1))
+			mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `todo_tags` WHERE todo_id=?")).
+				WithArgs(5).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `tags` (workspace_id, owner_id, name) VALUES (?, ?, ?), (?, ?, ?) ON DUPLICATE KEY UPDATE name = name")).
+				WithArgs(testWorkspace, testOwner, "health", testWorkspace, testOwner, "routine").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `todo_tags` (todo_id, tag_id) SELECT ?, id FROM `tags` WHERE workspace_id = ? AND owner_id = ? AND name IN (?, ?)")).
+				WithArgs(5, testWorkspace, testOwner, "health", "routine").
+				WillReturnResult(sqlmock.NewResult(0, 2))
+
+			mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnRows(rows.AddRow(todoRow(5, text, false, now, now)...))
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).
+				WithArgs(5).
+				WillReturnRows(tagRows.AddRow(5, "health").AddRow(5, "routine"))
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+			mock.ExpectCommit()
+
+			todo, err := repo.Create(ctx, input)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(todo.Tags).To(Equal(tags))
+		})
+
+		It("rolls back when attaching tags fails", func() {
+			text := "hit the gym"
+			completed := false
+			tags := []string{"health"}
+			input := TodoInput{Text: &text, Completed: &completed, Tags: &tags}
+
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			expectQuota(mock, false)
+			mock.ExpectExec(regexp.QuoteMeta(query)).
+				WithArgs(testWorkspace, testOwner, &text, &completed, nil, false, nil, nil, nil, nil, nil, nil, 1, 7).
+				WillReturnResult(sqlmock.NewResult(5, 1))
+			mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `todo_tags` WHERE todo_id=?")).
+				WillReturnError(errors.New("delete failed"))
+			mock.ExpectRollback()
+
+			todo, err := repo.Create(ctx, input)
+			Expect(err).To(MatchError("delete failed"))
+			Expect(todo).To(BeNil())
+		})
+
+		It("reports missing lists", func() {
+			text := "dummy todo"
+			completed := false
+			list := uint32(42)
+			input := TodoInput{Text: &text, Completed: &completed, ListID: &list}
+
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			expectQuota(mock, false)
+			mock.ExpectExec(regexp.QuoteMeta(query)).
+				WithArgs(testWorkspace, testOwner, &text, &completed, nil, false, nil, nil, &list, nil, nil, nil, 1, 7).
+				WillReturnError(&mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row: a foreign key constraint fails (CONSTRAINT `fk_todos_list`)"})
+			mock.ExpectRollback()
+
+			todo, err := repo.Create(ctx, input)
+			Expect(err).To(MatchError(ErrListNotFound))
+			Expect(todo).To(BeNil())
+		})
+
+		It("leaves violations of other foreign keys as they are", func() {
+			text := "dummy todo"
+			completed := false
+			input := TodoInput{Text: &text, Completed: &completed}
+			violation := &mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row: a foreign key constraint fails (CONSTRAINT `fk_todos_workspace`)"}
+
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			expectQuota(mock, false)
+			mock.ExpectExec(regexp.QuoteMeta(query)).WillReturnError(violation)
+			mock.ExpectRollback()
+
+			_, err := repo.Create(ctx, input)
+			Expect(err).To(MatchError(violation))
+			Expect(err).NotTo(MatchError(ErrListNotFound))
+		})
+
+		It("reports missing parents", func() {
+			text := "dummy todo"
+			completed := false
+			parent := uint32(42)
+			input := TodoInput{Text: &text, Completed: &completed, ParentID: &parent}
+
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			expectQuota(mock, false)
+			mock.ExpectExec(regexp.QuoteMeta(query)).
+				WithArgs(testWorkspace, testOwner, &text, &completed, nil, false, nil, nil, nil, &parent, nil, nil, 1, 7).
+				WillReturnError(&mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row: a foreign key constraint fails (CONSTRAINT `fk_todos_parent`)"})
+			mock.ExpectRollback()
+
+			todo, err := repo.Create(ctx, input)
+			Expect(err).To(MatchError(ErrParentNotFound))
+			Expect(todo).To(BeNil())
+		})
+
 		It("propagates insert errors", func() {
 			text := "dummy todo"
 			completed := false
 			input := TodoInput{Text: &text, Completed: &completed}
 
 			expected := errors.New("insert failed")
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			expectQuota(mock, false)
 			mock.ExpectExec(regexp.QuoteMeta(query)).
-				WithArgs(&text, &completed).
+				WithArgs(testWorkspace, testOwner, &text, &completed, nil, false, nil, nil, nil, nil, nil, nil, 1, 7).
 				WillReturnError(expected)
+			mock.ExpectRollback()
 
 			todo, err := repo.Create(ctx, input)
 			Expect(err).To(MatchError(expected))
@@ -194,9 +725,13 @@ var _ = Describe("repo", Label("repo"), func() {
 			input := TodoInput{Text: &text, Completed: &completed}
 
 			expected := errors.New("lastInsertId failed")
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			expectQuota(mock, false)
 			mock.ExpectExec(regexp.QuoteMeta(query)).
-				WithArgs(&text, &completed).
+				WithArgs(testWorkspace, testOwner, &text, &completed, nil, false, nil, nil, nil, nil, nil, nil, 1, 7).
 				WillReturnResult(sqlmock.NewErrorResult(expected))
+			mock.ExpectRollback()
 
 			todo, err := repo.Create(ctx, input)
 			Expect(err).To(MatchError(expected))
@@ -209,12 +744,16 @@ var _ = Describe("repo", Label("repo"), func() {
 			input := TodoInput{Text: &text, Completed: &completed}
 
 			lastInsertId := int64(3)
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			expectQuota(mock, false)
 			mock.ExpectExec(regexp.QuoteMeta(query)).
-				WithArgs(&text, &completed).
+				WithArgs(testWorkspace, testOwner, &text, &completed, nil, false, nil, nil, nil, nil, nil, nil, 1, 7).
 				WillReturnResult(sqlmock.NewResult(lastInsertId, 1))
 
 			expected := errors.New("get failed")
-			mock.ExpectQuery(getQuery).WillReturnError(expected)
+			mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnError(expected)
+			mock.ExpectRollback()
 
 			todo, err := repo.Create(ctx, input)
 			Expect(err).To(MatchError(expected))
@@ -229,8 +768,8 @@ var _ = Describe("repo", Label("repo"), func() {
 		)
 
 		BeforeEach(func() {
-			query = "UPDATE `todos` SET text = IFNULL(?, text), completed = IFNULL(?, completed) WHERE id=?"
-			getQuery = "SELECT id, text, completed, created_at, updated_at FROM `todos` WHERE id=?"
+			query = "UPDATE `todos` SET version = version + 1, change_seq = ?, text = IFNULL(?, text), completed = IFNULL(?, completed), due_at = IF(?, ?, due_at), due_date_only = IF(?, ?, due_date_only), start_at = IF(?, ?, start_at), priority = IFNULL(?, priority), list_id = IFNULL(?, list_id), parent_id = IF(? IS NULL, parent_id, NULLIF(?, 0)), recurrence = IF(? IS NULL, recurrence, NULLIF(?, '')), series_id = IF(NULLIF(?, '') IS NULL, series_id, IFNULL(series_id, id)) WHERE id=? AND workspace_id=? AND owner_id=? AND deleted_at IS NULL"
+			getQuery = "SELECT id, text, completed, created_at, updated_at, due_at, start_at, priority, list_id, parent_id, recurrence, series_id, occurrence, deleted_at, version, owner_id, due_date_only FROM `todos` WHERE id=? AND workspace_id=? AND owner_id=? AND deleted_at IS NULL"
 		})
 
 		It("updates only text and returns a todo successfully", func() {
@@ -238,14 +777,19 @@ var _ = Describe("repo", Label("repo"), func() {
 			text := "hit the gym"
 			input := TodoInput{Text: &text}
 
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
 			mock.ExpectExec(regexp.QuoteMeta(query)).
-				WithArgs(&text, nil, id).
+				WithArgs(7, &text, nil, false, nil, false, false, false, nil, nil, nil, nil, nil, nil, nil, nil, id, testWorkspace, testOwner).
 				WillReturnResult(sqlmock.NewResult(0, 1)) // RowsAffected = 1
 
-			rows = rows.AddRow(id, text, false, now, now)
-			mock.ExpectQuery(getQuery).WillReturnRows(rows)
+			rows = rows.AddRow(todoRow(id, text, false, now, now)...)
+			mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnRows(rows)
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+			mock.ExpectCommit()
 
-			todo, err := repo.Update(ctx, uint32(id), input)
+			todo, _, err := repo.Update(ctx, uint32(id), input)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(todo).NotTo(BeNil())
 			Expect(todo.Text).To(Equal(text))
@@ -258,14 +802,19 @@ var _ = Describe("repo", Label("repo"), func() {
 			input := TodoInput{Completed: &completed}
 			text := "dummy todo"
 
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
 			mock.ExpectExec(regexp.QuoteMeta(query)).
-				WithArgs(nil, &completed, id).
+				WithArgs(7, nil, &completed, false, nil, false, false, false, nil, nil, nil, nil, nil, nil, nil, nil, id, testWorkspace, testOwner).
 				WillReturnResult(sqlmock.NewResult(0, 1)) // RowsAffected = 1
 
-			rows = rows.AddRow(id, text, &completed, now, now)
-			mock.ExpectQuery(getQuery).WillReturnRows(rows)
+			rows = rows.AddRow(todoRow(id, text, &completed, now, now)...)
+			mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnRows(rows)
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+			mock.ExpectCommit()
 
-			todo, err := repo.Update(ctx, uint32(id), input)
+			todo, _, err := repo.Update(ctx, uint32(id), input)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(todo).NotTo(BeNil())
 			Expect(todo.Text).To(Equal(text))
@@ -278,95 +827,921 @@ var _ = Describe("repo", Label("repo"), func() {
 			text := "make dinner"
 			input := TodoInput{Text: &text, Completed: &completed}
 
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
 			mock.ExpectExec(regexp.QuoteMeta(query)).
-				WithArgs(&text, &completed, id).
+				WithArgs(7, &text, &completed, false, nil, false, false, false, nil, nil, nil, nil, nil, nil, nil, nil, id, testWorkspace, testOwner).
 				WillReturnResult(sqlmock.NewResult(0, 1)) // RowsAffected = 1
 
-			rows = rows.AddRow(id, text, &completed, now, now)
-			mock.ExpectQuery(getQuery).WillReturnRows(rows)
+			rows = rows.AddRow(todoRow(id, text, &completed, now, now)...)
+			mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnRows(rows)
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+			mock.ExpectCommit()
 
-			todo, err := repo.Update(ctx, uint32(id), input)
+			todo, _, err := repo.Update(ctx, uint32(id), input)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(todo).NotTo(BeNil())
 			Expect(todo.Text).To(Equal(text))
 			Expect(todo.Completed).To(BeTrue())
 		})
 
+		It("moves a todo to another list", func() {
+			id := 3
+			list := uint32(2)
+			input := TodoInput{ListID: &list}
+
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			mock.ExpectExec(regexp.QuoteMeta(query)).
+				WithArgs(7, nil, nil, false, nil, false, false, false, nil, nil, &list, nil, nil, nil, nil, nil, id, testWorkspace, testOwner).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnRows(rows.AddRow(todoRow(id, "hit the gym", false, now, now, nil, nil, "none", list)...))
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+			mock.ExpectCommit()
+
+			todo, _, err := repo.Update(ctx, uint32(id), input)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(todo.ListID).To(Equal(list))
+		})
+
+		It("cascades completion to all descendants", func() {
+			id := 3
+			completed := true
+			input := TodoInput{Completed: &completed, Cascade: true}
+
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			mock.ExpectExec(regexp.QuoteMeta(query)).
+				WithArgs(7, nil, &completed, false, nil, false, false, false, nil, nil, nil, nil, nil, nil, nil, nil, id, testWorkspace, testOwner).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(regexp.QuoteMeta("WITH RECURSIVE sub AS (SELECT id FROM `todos` WHERE parent_id = ? AND workspace_id = ? AND owner_id = ? AND deleted_at IS NULL UNION ALL SELECT t.id FROM `todos` t JOIN sub ON t.parent_id = sub.id WHERE t.deleted_at IS NULL) UPDATE `todos` t JOIN sub ON t.id = sub.id SET t.completed = ?, t.version = t.version + 1, t.change_seq = ?")).
+				WithArgs(id, testWorkspace, testOwner, &completed, 7).
+				WillReturnResult(sqlmock.NewResult(0, 4))
+			expectAffected(mock, 7, uint32(id), false, todoRow(4, "pack books", true, now, now), todoRow(5, "pack plates", true, now, now))
+
+			mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnRows(rows.AddRow(todoRow(id, "move house", true, now, now)...))
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows.AddRow(id, 4, "4"))
+			mock.ExpectCommit()
+
+			todo, affected, err := repo.Update(ctx, uint32(id), input)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(todo.Progress).To(Equal(Progress{Completed: 4, Total: 4}))
+			Expect(affected.Created).To(BeEmpty())
+			Expect(affected.Updated).To(HaveExactElements(
+				MatchFields(IgnoreExtras, Fields{"ID": BeEquivalentTo(4), "Completed": BeTrue()}),
+				MatchFields(IgnoreExtras, Fields{"ID": BeEquivalentTo(5), "Completed": BeTrue()}),
+			))
+		})
+
+		Describe("recurring todos", func() {
+			var (
+				insertQuery string
+				text        string
+				rule        string
+				completed   bool
+				next        TodoInput
+			)
+
+			BeforeEach(func() {
+				insertQuery = "INSERT INTO `todos` (workspace_id, owner_id, text, completed, due_at, due_date_only, start_at, priority, list_id, parent_id, recurrence, series_id, occurrence, change_seq) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+				text, rule, completed = "water plants", "FREQ=DAILY", true
+				series, open := uint32(2), false
+				next = TodoInput{Text: &text, Completed: &open, Recurrence: &rule, SeriesID: &series, Occurrence: 3}
+			})
+
+			It("creates the next occurrence along with the completion", func() {
+				id := 3
+				input := TodoInput{Completed: &completed, Next: &next}
+
+				mock.ExpectBegin()
+				expectNextChange(mock, 7)
+				mock.ExpectExec(regexp.QuoteMeta(query)).
+					WithArgs(7, nil, &completed, false, nil, false, false, false, nil, nil, nil, nil, nil, nil, nil, nil, id, testWorkspace, testOwner).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectQuota(mock, false)
+				mock.ExpectExec(regexp.QuoteMeta(insertQuery)).
+					WithArgs(testWorkspace, testOwner, &text, next.Completed, nil, false, nil, nil, nil, nil, &rule, next.SeriesID, 3, 7).
+					WillReturnResult(sqlmock.NewResult(4, 1))
+				expectAffected(mock, 7, uint32(id), false, todoRow(4, text, false, now, now, nil, nil, "none", 1, nil, rule, 2, 3))
+
+				mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnRows(rows.AddRow(todoRow(id, text, true, now, now, nil, nil, "none", 1, nil, rule, 2, 2)...))
+				mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+				mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+				mock.ExpectCommit()
+
+				todo, affected, err := repo.Update(ctx, uint32(id), input)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(todo.Completed).To(BeTrue())
+				Expect(affected.Created).To(HaveExactElements(MatchFields(IgnoreExtras, Fields{"ID": BeEquivalentTo(4), "Occurrence": BeEquivalentTo(3)})))
+				Expect(affected.Updated).To(BeEmpty())
+			})
+
+			It("doesn't duplicate an existing occurrence", func() {
+				id := 3
+				input := TodoInput{Completed: &completed, Next: &next}
+
+				mock.ExpectBegin()
+				expectNextChange(mock, 7)
+				mock.ExpectExec(regexp.QuoteMeta(query)).
+					WithArgs(7, nil, &completed, false, nil, false, false, false, nil, nil, nil, nil, nil, nil, nil, nil, id, testWorkspace, testOwner).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectQuota(mock, false)
+				mock.ExpectExec(regexp.QuoteMeta(insertQuery)).
+					WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry '2-3' for key 'uniq_todos_series_occurrence'"})
+				expectAffected(mock, 7, uint32(id), false)
+
+				mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnRows(rows.AddRow(todoRow(id, text, true, now, now)...))
+				mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+				mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+				mock.ExpectCommit()
+
+				_, _, err := repo.Update(ctx, uint32(id), input)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("completes the todo without its next occurrence when the workspace is full", func() {
+				id := 3
+				input := TodoInput{Completed: &completed, Next: &next}
+
+				mock.ExpectBegin()
+				expectNextChange(mock, 7)
+				mock.ExpectExec(regexp.QuoteMeta(query)).
+					WithArgs(7, nil, &completed, false, nil, false, false, false, nil, nil, nil, nil, nil, nil, nil, nil, id, testWorkspace, testOwner).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectQuota(mock, true)
+				expectAffected(mock, 7, uint32(id), false)
+
+				mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnRows(rows.AddRow(todoRow(id, text, true, now, now)...))
+				mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+				mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+				mock.ExpectCommit()
+
+				todo, affected, err := repo.Update(ctx, uint32(id), input)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(todo.Completed).To(BeTrue())
+				Expect(affected.Created).To(BeEmpty())
+			})
+
+			It("applies series-wide changes to the other occurrences", func() {
+				id := 3
+				priority := PriorityHigh
+				input := TodoInput{Text: &text, Completed: &completed, Priority: &priority, Series: true}
+
+				mock.ExpectBegin()
+				expectNextChange(mock, 7)
+				mock.ExpectExec(regexp.QuoteMeta(query)).
+					WithArgs(7, &text, &completed, false, nil, false, false, false, nil, &priority, nil, nil, nil, nil, nil, nil, id, testWorkspace, testOwner).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM `todos` WHERE series_id = (SELECT series_id FROM `todos` WHERE id = ?) AND id <> ? AND workspace_id = ? AND owner_id = ? AND deleted_at IS NULL")).
+					WithArgs(id, id, testWorkspace, testOwner).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2).AddRow(4))
+				mock.ExpectExec(regexp.QuoteMeta("UPDATE `todos` SET version = version + 1, change_seq = ?, text = IFNULL(?, text), priority = IFNULL(?, priority), list_id = IFNULL(?, list_id), recurrence = IF(? IS NULL, recurrence, NULLIF(?, '')) WHERE id IN (?, ?)")).
+					WithArgs(7, &text, &priority, nil, nil, nil, 2, 4).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				expectAffected(mock, 7, uint32(id), false, todoRow(2, text, true, now, now, nil, nil, "high"), todoRow(4, text, false, now, now, nil, nil, "high"))
+
+				mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnRows(rows.AddRow(todoRow(id, text, true, now, now, nil, nil, "high")...))
+				mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+				mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+				mock.ExpectCommit()
+
+				todo, affected, err := repo.Update(ctx, uint32(id), input)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(todo.Priority).To(Equal(PriorityHigh))
+				Expect(affected.Updated).To(HaveLen(2))
+			})
+		})
+
+		It("detaches a todo from its parent", func() {
+			id := 3
+			parent := uint32(0)
+			input := TodoInput{ParentID: &parent}
+
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			mock.ExpectExec(regexp.QuoteMeta(query)).
+				WithArgs(7, nil, nil, false, nil, false, false, false, nil, nil, nil, &parent, &parent, nil, nil, nil, id, testWorkspace, testOwner).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnRows(rows.AddRow(todoRow(id, "pack books", false, now, now)...))
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+			mock.ExpectCommit()
+
+			todo, _, err := repo.Update(ctx, uint32(id), input)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(todo.ParentID).To(BeNil())
+		})
+
+		It("clears the due date when given a zero date", func() {
+			id := 3
+			input := TodoInput{DueAt: &DateTime{}}
+
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			mock.ExpectExec(regexp.QuoteMeta(query)).
+				WithArgs(7, nil, nil, true, nil, true, false, false, nil, nil, nil, nil, nil, nil, nil, nil, id, testWorkspace, testOwner).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnRows(rows.AddRow(todoRow(id, "pack books", false, now, now)...))
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+			mock.ExpectCommit()
+
+			todo, _, err := repo.Update(ctx, uint32(id), input)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(todo.DueAt).To(BeNil())
+		})
+
+		It("clears all tags when given an empty list", func() {
+			id := 3
+			tags := []string{}
+			input := TodoInput{Tags: &tags}
+
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			mock.ExpectExec(regexp.QuoteMeta(query)).
+				WithArgs(7, nil, nil, false, nil, false, false, false, nil, nil, nil, nil, nil, nil, nil, nil, id, testWorkspace, testOwner).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `todo_tags` WHERE todo_id=?")).
+				WithArgs(id).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+
+			mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnRows(rows.AddRow(todoRow(id, "hit the gym", false, now, now)...))
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+			mock.ExpectCommit()
+
+			todo, _, err := repo.Update(ctx, uint32(id), input)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(todo.Tags).To(BeEmpty())
+		})
+
 		It("propagates update errors", func() {
 			expected := errors.New("update failed")
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
 			mock.ExpectExec(regexp.QuoteMeta(query)).WillReturnError(expected)
+			mock.ExpectRollback()
 
-			todo, err := repo.Update(ctx, 1, TodoInput{})
+			todo, _, err := repo.Update(ctx, 1, TodoInput{})
 			Expect(err).To(MatchError(expected))
 			Expect(todo).To(BeNil())
 		})
 
 		It("propagates get errors", func() {
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
 			mock.ExpectExec(regexp.QuoteMeta(query)).WillReturnResult(sqlmock.NewResult(0, 1)) // Mock success inserting
 
 			expected := errors.New("get failed")
-			mock.ExpectQuery(getQuery).WillReturnError(expected)
+			mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnError(expected)
+			mock.ExpectRollback()
 
-			todo, err := repo.Update(ctx, 1, TodoInput{})
+			todo, _, err := repo.Update(ctx, 1, TodoInput{})
 			Expect(err).To(MatchError(expected))
 			Expect(todo).To(BeNil())
 		})
 
 		It("returns todo not found error if row not found after insertion", func() {
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
 			mock.ExpectExec(regexp.QuoteMeta(query)).WillReturnResult(sqlmock.NewResult(0, 1)) // Mock success inserting
 
-			mock.ExpectQuery(getQuery).WillReturnError(sql.ErrNoRows)
+			mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnError(sql.ErrNoRows)
+			mock.ExpectRollback()
 
-			todo, err := repo.Update(ctx, 1, TodoInput{})
+			todo, _, err := repo.Update(ctx, 1, TodoInput{})
+			Expect(err).To(MatchError(ErrTodoNotFound))
+			Expect(todo).To(BeNil())
+		})
+
+		It("returns todo not found before writing tags, subtasks or occurrences of a missing todo", func() {
+			completed := true
+			tags := []string{"home"}
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			mock.ExpectExec(regexp.QuoteMeta(query)).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectRollback()
+
+			todo, _, err := repo.Update(ctx, 1, TodoInput{Completed: &completed, Cascade: true, Tags: &tags, Next: &TodoInput{}})
 			Expect(err).To(MatchError(ErrTodoNotFound))
 			Expect(todo).To(BeNil())
 		})
 
 		It("propagates error if multiple rows affected", func() {
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
 			mock.ExpectExec(regexp.QuoteMeta(query)).WillReturnResult(sqlmock.NewResult(0, 3))
+			mock.ExpectRollback()
 
-			todo, err := repo.Update(ctx, 1, TodoInput{})
+			todo, _, err := repo.Update(ctx, 1, TodoInput{})
 			Expect(err).To(MatchError(ErrUnexpected))
 			Expect(todo).To(BeNil())
 		})
 
+		Context("conditional on the version", func() {
+			var (
+				text    string
+				version uint32
+				input   TodoInput
+			)
+
+			BeforeEach(func() {
+				text = "hit the gym"
+				version = 4
+				input = TodoInput{Text: &text, Version: &version}
+			})
+
+			It("updates the todo at that version", func() {
+				mock.ExpectBegin()
+				expectNextChange(mock, 7)
+				mock.ExpectExec(regexp.QuoteMeta(query+" AND version = ?")).
+					WithArgs(7, &text, nil, false, nil, false, false, false, nil, nil, nil, nil, nil, nil, nil, nil, 3, testWorkspace, testOwner, version).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				rows = rows.AddRow(todoRow(3, text, false, now, now, nil, nil, "none", 1, nil, nil, nil, 1, nil, 5)...)
+				mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnRows(rows)
+				mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+				mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+				mock.ExpectCommit()
+
+				todo, _, err := repo.Update(ctx, 3, input)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(todo.Version).To(BeEquivalentTo(5))
+			})
+
+			It("returns a version mismatch if the todo has moved on", func() {
+				mock.ExpectBegin()
+				expectNextChange(mock, 7)
+				mock.ExpectExec(regexp.QuoteMeta(query + " AND version = ?")).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT 1 FROM `todos` WHERE id=? AND workspace_id=? AND owner_id=? AND deleted_at IS NULL")).
+					WithArgs(3, testWorkspace, testOwner).
+					WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+				mock.ExpectRollback()
+
+				todo, _, err := repo.Update(ctx, 3, input)
+				Expect(err).To(MatchError(ErrVersionMismatch))
+				Expect(todo).To(BeNil())
+			})
+
+			It("returns todo not found if the todo is gone", func() {
+				mock.ExpectBegin()
+				expectNextChange(mock, 7)
+				mock.ExpectExec(regexp.QuoteMeta(query + " AND version = ?")).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT 1 FROM `todos` WHERE id=? AND workspace_id=? AND owner_id=? AND deleted_at IS NULL")).
+					WillReturnRows(sqlmock.NewRows([]string{"1"}))
+				mock.ExpectRollback()
+
+				todo, _, err := repo.Update(ctx, 3, input)
+				Expect(err).To(MatchError(ErrTodoNotFound))
+				Expect(todo).To(BeNil())
+			})
+		})
+	})
+
+	Describe("Ancestors", Label("ancestors"), func() {
+		query := "WITH RECURSIVE anc AS (SELECT id, parent_id, 0 AS level FROM `todos` WHERE id = ? AND workspace_id = ? AND owner_id = ? UNION ALL SELECT t.id, t.parent_id, anc.level + 1 FROM `todos` t JOIN anc ON t.id = anc.parent_id) SELECT id FROM anc ORDER BY level"
+
+		It("walks up to the root", func() {
+			mock.ExpectQuery(regexp.QuoteMeta(query)).
+				WithArgs(5, testWorkspace, testOwner).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5).AddRow(3).AddRow(1))
+
+			ids, err := repo.Ancestors(ctx, 5)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ids).To(Equal([]uint32{5, 3, 1}))
+		})
+
+		It("returns todo not found errors", func() {
+			mock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+			ids, err := repo.Ancestors(ctx, 5)
+			Expect(err).To(MatchError(ErrTodoNotFound))
+			Expect(ids).To(BeNil())
+		})
+	})
+
+	Describe("Descendants", Label("descendants"), func() {
+		It("fetches the subtrees of all the todos at once", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("WITH RECURSIVE sub AS (SELECT id, text, completed, created_at, updated_at, due_at, start_at, priority, list_id, parent_id, recurrence, series_id, occurrence, deleted_at, version, owner_id, due_date_only FROM `todos` WHERE parent_id IN (?, ?) AND workspace_id = ? AND owner_id = ? AND deleted_at IS NULL UNION ALL SELECT t.id, t.text, t.completed, t.created_at, t.updated_at, t.due_at, t.start_at, t.priority, t.list_id, t.parent_id, t.recurrence, t.series_id, t.occurrence, t.deleted_at, t.version, t.owner_id, t.due_date_only FROM `todos` t JOIN sub ON t.parent_id = sub.id WHERE t.deleted_at IS NULL) SELECT id, text, completed, created_at, updated_at, due_at, start_at, priority, list_id, parent_id, recurrence, series_id, occurrence, deleted_at, version, owner_id, due_date_only FROM sub ORDER BY id")).
+				WithArgs(1, 2, testWorkspace, testOwner).
+				WillReturnRows(rows.AddRow(todoRow(3, "pack books", false, now, now, nil, nil, "none", 1, 1)...))
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+
+			todos, err := repo.Descendants(ctx, []uint32{1, 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(todos).To(HaveLen(1))
+		})
+
+		It("doesn't query without todos", func() {
+			todos, err := repo.Descendants(ctx, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(todos).To(BeEmpty())
+		})
 	})
 
 	Describe("Delete", Label("delete"), func() {
 		var query string
 
 		BeforeEach(func() {
-			query = "DELETE FROM `todos` WHERE id=?"
+			query = regexp.QuoteMeta("WITH RECURSIVE sub AS (SELECT id FROM `todos` WHERE id = ? AND workspace_id = ? AND owner_id = ? AND deleted_at IS NULL UNION ALL SELECT t.id FROM `todos` t JOIN sub ON t.parent_id = sub.id WHERE t.deleted_at IS NULL) UPDATE `todos` t JOIN sub ON t.id = sub.id SET t.deleted_at = CURRENT_TIMESTAMP, t.version = t.version + 1, t.change_seq = ?")
+		})
+
+		It("moves the todo to the trash", func() {
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			mock.ExpectExec(query).WithArgs(1, testWorkspace, testOwner, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+			expectAffected(mock, 7, 1, true)
+			mock.ExpectCommit()
+
+			_, err := repo.Delete(ctx, 1, nil)
+			Expect(err).NotTo(HaveOccurred())
 		})
 
-		It("deletes todo successfully", func() {
-			mock.ExpectExec(query).WillReturnResult(sqlmock.NewResult(0, 1))
+		It("moves the whole subtree to the trash", func() {
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			mock.ExpectExec(query).WithArgs(1, testWorkspace, testOwner, 7).WillReturnResult(sqlmock.NewResult(0, 3))
+			expectAffected(mock, 7, 1, true, todoRow(2, "pack books", false, now, now), todoRow(3, "pack plates", false, now, now))
+			mock.ExpectCommit()
 
-			err := repo.Delete(ctx, 1)
+			affected, err := repo.Delete(ctx, 1, nil)
 			Expect(err).NotTo(HaveOccurred())
+			Expect(affected.Deleted).To(HaveExactElements(
+				MatchFields(IgnoreExtras, Fields{"ID": BeEquivalentTo(2)}),
+				MatchFields(IgnoreExtras, Fields{"ID": BeEquivalentTo(3)}),
+			))
 		})
 
 		It("propagates delete errors", func() {
-			expected := errors.New("insert failed")
+			expected := errors.New("update failed")
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
 			mock.ExpectExec(query).WillReturnError(expected)
+			mock.ExpectRollback()
 
-			err := repo.Delete(ctx, 1)
+			_, err := repo.Delete(ctx, 1, nil)
 			Expect(err).To(MatchError(expected))
 		})
 
 		It("returns todo not found error if no rows affected", func() {
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
 			mock.ExpectExec(query).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectRollback()
 
-			err := repo.Delete(ctx, 1)
+			_, err := repo.Delete(ctx, 1, nil)
 			Expect(err).To(MatchError(ErrTodoNotFound))
 		})
 
-		It("propagates error if multiple rows affected", func() {
-			mock.ExpectExec(query).WillReturnResult(sqlmock.NewResult(0, 3))
+		Context("conditional on the version", func() {
+			var (
+				conditional string
+				version     uint32
+			)
+
+			BeforeEach(func() {
+				conditional = regexp.QuoteMeta("WITH RECURSIVE sub AS (SELECT id FROM `todos` WHERE id = ? AND workspace_id = ? AND owner_id = ? AND deleted_at IS NULL AND version = ? UNION ALL SELECT t.id FROM `todos` t JOIN sub ON t.parent_id = sub.id WHERE t.deleted_at IS NULL) UPDATE `todos` t JOIN sub ON t.id = sub.id SET t.deleted_at = CURRENT_TIMESTAMP, t.version = t.version + 1, t.change_seq = ?")
+				version = 2
+			})
+
+			It("trashes the todo at that version", func() {
+				mock.ExpectBegin()
+				expectNextChange(mock, 7)
+				mock.ExpectExec(conditional).WithArgs(1, testWorkspace, testOwner, version, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+				expectAffected(mock, 7, 1, true)
+				mock.ExpectCommit()
+
+				_, err := repo.Delete(ctx, 1, &version)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns a version mismatch if the todo has moved on", func() {
+				mock.ExpectBegin()
+				expectNextChange(mock, 7)
+				mock.ExpectExec(conditional).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT 1 FROM `todos` WHERE id=? AND workspace_id=? AND owner_id=? AND deleted_at IS NULL")).
+					WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+				mock.ExpectRollback()
+
+				_, err := repo.Delete(ctx, 1, &version)
+				Expect(err).To(MatchError(ErrVersionMismatch))
+			})
+		})
+	})
 
-			err := repo.Delete(ctx, 1)
-			Expect(err).To(MatchError(ErrUnexpected))
+	Describe("Transact", Label("transact"), func() {
+		var (
+			deleteQuery string
+			updateQuery string
+		)
+
+		// expectSavepoint expects a write within the transaction to set
+		// its savepoint and draw seq from the change sequence.
+		expectSavepoint := func(mock sqlmock.Sqlmock, seq int64) {
+			mock.ExpectExec("SAVEPOINT write").WillReturnResult(sqlmock.NewResult(0, 0))
+			expectNextChange(mock, seq)
+		}
+
+		BeforeEach(func() {
+			deleteQuery = regexp.QuoteMeta("WITH RECURSIVE sub AS (SELECT id FROM `todos` WHERE id = ? AND workspace_id = ? AND owner_id = ? AND deleted_at IS NULL UNION ALL")
+			updateQuery = regexp.QuoteMeta("UPDATE `todos` SET version = version + 1, change_seq = ?, text = IFNULL(?, text)")
 		})
 
+		It("runs the operations in a single transaction", func() {
+			mock.ExpectBegin()
+			expectSavepoint(mock, 7)
+			mock.ExpectExec(deleteQuery).WithArgs(1, testWorkspace, testOwner, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+			expectAffected(mock, 7, 1, true)
+			mock.ExpectExec("RELEASE SAVEPOINT write").WillReturnResult(sqlmock.NewResult(0, 0))
+			expectSavepoint(mock, 8)
+			mock.ExpectExec(deleteQuery).WithArgs(2, testWorkspace, testOwner, 8).WillReturnResult(sqlmock.NewResult(0, 1))
+			expectAffected(mock, 8, 2, true)
+			mock.ExpectExec("RELEASE SAVEPOINT write").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectCommit()
+
+			err := repo.Transact(ctx, func(r Repository) error {
+				_, err := r.Delete(ctx, 1, nil)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = r.Delete(ctx, 2, nil)
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("rolls a failed write back to its savepoint", func() {
+			expected := errors.New("update failed")
+			text := "buy milk"
+
+			mock.ExpectBegin()
+			expectSavepoint(mock, 7)
+			mock.ExpectExec(updateQuery).WillReturnError(expected)
+			mock.ExpectExec("ROLLBACK TO SAVEPOINT write").WillReturnResult(sqlmock.NewResult(0, 0))
+			expectSavepoint(mock, 8)
+			mock.ExpectExec(deleteQuery).WithArgs(2, testWorkspace, testOwner, 8).WillReturnResult(sqlmock.NewResult(0, 1))
+			expectAffected(mock, 8, 2, true)
+			mock.ExpectExec("RELEASE SAVEPOINT write").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectCommit()
+
+			err := repo.Transact(ctx, func(r Repository) error {
+				_, _, err := r.Update(ctx, 1, TodoInput{Text: &text})
+				Expect(err).To(MatchError(expected))
+				_, err = r.Delete(ctx, 2, nil)
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("rolls back everything if fn fails", func() {
+			expected := errors.New("bulk failed")
+
+			mock.ExpectBegin()
+			expectSavepoint(mock, 7)
+			mock.ExpectExec(deleteQuery).WithArgs(1, testWorkspace, testOwner, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+			expectAffected(mock, 7, 1, true)
+			mock.ExpectExec("RELEASE SAVEPOINT write").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectRollback()
+
+			err := repo.Transact(ctx, func(r Repository) error {
+				_, err := r.Delete(ctx, 1, nil)
+				Expect(err).NotTo(HaveOccurred())
+				return expected
+			})
+			Expect(err).To(MatchError(expected))
+		})
+	})
+
+	Describe("DeleteList", Label("delete-list"), func() {
+		var (
+			selectQuery string
+			moveQuery   string
+			deleteQuery string
+		)
+
+		BeforeEach(func() {
+			selectQuery = regexp.QuoteMeta("SELECT id, text, completed, created_at, updated_at, due_at, start_at, priority, list_id, parent_id, recurrence, series_id, occurrence, deleted_at, version, owner_id, due_date_only FROM `todos` WHERE list_id = ? AND workspace_id = ? AND owner_id = ? AND deleted_at IS NULL FOR UPDATE")
+			moveQuery = regexp.QuoteMeta("UPDATE `todos` SET list_id = ?, deleted_at = IFNULL(deleted_at, CURRENT_TIMESTAMP), version = version + 1, change_seq = ? WHERE list_id = ? AND workspace_id = ? AND owner_id = ?")
+			deleteQuery = regexp.QuoteMeta("DELETE FROM `lists` WHERE id=? AND workspace_id=? AND owner_id=?")
+		})
+
+		It("trashes the todos of the list into the default list before deleting it", func() {
+			mock.ExpectBegin()
+			expectNextChange(mock, 12)
+			mock.ExpectQuery(selectQuery).
+				WithArgs(4, testWorkspace, testOwner).
+				WillReturnRows(rows.AddRow(todoRow(5, "pack boxes", false, now, now)...))
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+			mock.ExpectExec(moveQuery).
+				WithArgs(DefaultListID, 12, 4, testWorkspace, testOwner).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectExec(deleteQuery).WithArgs(4, testWorkspace, testOwner).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			trashed, err := repo.DeleteList(ctx, 4, DefaultListID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(trashed).To(ConsistOf(MatchFields(IgnoreExtras, Fields{"ID": BeEquivalentTo(5), "Text": Equal("pack boxes")})))
+		})
+
+		It("returns list not found errors when deleting a missing list", func() {
+			mock.ExpectBegin()
+			expectNextChange(mock, 12)
+			mock.ExpectQuery(selectQuery).WillReturnRows(rows)
+			mock.ExpectExec(moveQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(deleteQuery).WithArgs(4, testWorkspace, testOwner).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectRollback()
+
+			_, err := repo.DeleteList(ctx, 4, DefaultListID)
+			Expect(err).To(MatchError(ErrListNotFound))
+		})
+	})
+
+	Describe("Restore", Label("restore"), func() {
+		var (
+			lookupQuery  string
+			restoreQuery string
+			getQuery     string
+		)
+
+		BeforeEach(func() {
+			lookupQuery = "SELECT t.deleted_at, p.deleted_at FROM `todos` t LEFT JOIN `todos` p ON p.id = t.parent_id WHERE t.id=? AND t.workspace_id=? AND t.owner_id=?"
+			restoreQuery = "WITH RECURSIVE sub AS (SELECT id FROM `todos` WHERE id = ? UNION ALL SELECT t.id FROM `todos` t JOIN sub ON t.parent_id = sub.id WHERE t.deleted_at = ?) UPDATE `todos` t JOIN sub ON t.id = sub.id SET t.deleted_at = NULL, t.version = t.version + 1, t.change_seq = ?"
+			getQuery = "SELECT id, text, completed, created_at, updated_at, due_at, start_at, priority, list_id, parent_id, recurrence, series_id, occurrence, deleted_at, version, owner_id, due_date_only FROM `todos` WHERE id=? AND workspace_id=? AND owner_id=? AND deleted_at IS NULL"
+		})
+
+		It("restores the todo along with the subtree trashed with it", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(lookupQuery)).
+				WithArgs(3, testWorkspace, testOwner).
+				WillReturnRows(sqlmock.NewRows([]string{"deleted_at", "deleted_at"}).AddRow(now, nil))
+			expectNextChange(mock, 7)
+			mock.ExpectExec(regexp.QuoteMeta(restoreQuery)).
+				WithArgs(3, now, 7).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+			expectAffected(mock, 7, 3, false, todoRow(4, "pack books", false, now, now, nil, nil, "none", 1, 3))
+			mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnRows(rows.AddRow(todoRow(3, "move house", false, now, now)...))
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows.AddRow(3, 1, "0"))
+			mock.ExpectCommit()
+
+			todo, affected, err := repo.Restore(ctx, 3)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(todo.DeletedAt).To(BeNil())
+			Expect(todo.Progress.Total).To(Equal(1))
+			Expect(affected.Created).To(HaveExactElements(MatchFields(IgnoreExtras, Fields{"ID": BeEquivalentTo(4)})))
+		})
+
+		It("leaves todos outside the trash alone", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(lookupQuery)).
+				WithArgs(3, testWorkspace, testOwner).
+				WillReturnRows(sqlmock.NewRows([]string{"deleted_at", "deleted_at"}).AddRow(nil, nil))
+			mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WillReturnRows(rows.AddRow(todoRow(3, "move house", false, now, now)...))
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+			mock.ExpectCommit()
+
+			_, _, err := repo.Restore(ctx, 3)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("refuses to restore a todo whose parent is trashed", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(lookupQuery)).
+				WithArgs(4, testWorkspace, testOwner).
+				WillReturnRows(sqlmock.NewRows([]string{"deleted_at", "deleted_at"}).AddRow(now, now))
+			mock.ExpectRollback()
+
+			todo, _, err := repo.Restore(ctx, 4)
+			Expect(err).To(MatchError(ErrParentTrashed))
+			Expect(todo).To(BeNil())
+		})
+
+		It("returns todo not found errors", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(lookupQuery)).WithArgs(9, testWorkspace, testOwner).WillReturnError(sql.ErrNoRows)
+			mock.ExpectRollback()
+
+			_, _, err := repo.Restore(ctx, 9)
+			Expect(err).To(MatchError(ErrTodoNotFound))
+		})
+	})
+
+	Describe("Purge", Label("purge"), func() {
+		var (
+			tombstoneQuery string
+			deleteQuery    string
+		)
+
+		BeforeEach(func() {
+			tombstoneQuery = "INSERT INTO `todo_tombstones` (todo_id, list_id, workspace_id, owner_id, change_seq, deleted_at) SELECT id, list_id, workspace_id, owner_id, change_seq, deleted_at FROM `todos` WHERE deleted_at IS NOT NULL"
+			deleteQuery = "DELETE t FROM `todos` t JOIN `todo_tombstones` b ON b.todo_id = t.id WHERE t.deleted_at IS NOT NULL"
+		})
+
+		It("deletes todos trashed before the given time", func() {
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta(tombstoneQuery+" AND workspace_id = ? AND owner_id = ? AND deleted_at < ?")).
+				WithArgs(testWorkspace, testOwner, now).
+				WillReturnResult(sqlmock.NewResult(0, 5))
+			mock.ExpectExec(regexp.QuoteMeta(deleteQuery)).
+				WillReturnResult(sqlmock.NewResult(0, 5))
+			mock.ExpectCommit()
+
+			n, err := repo.Purge(ctx, &now)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n).To(Equal(5))
+		})
+
+		It("empties the whole trash", func() {
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta(tombstoneQuery+" AND workspace_id = ? AND owner_id = ?")+"$").
+				WithArgs(testWorkspace, testOwner).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectExec(regexp.QuoteMeta(deleteQuery)).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectCommit()
+
+			n, err := repo.Purge(ctx, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n).To(Equal(2))
+		})
+	})
+
+	Describe("Changes", Label("changes"), func() {
+		var (
+			changeRows *sqlmock.Rows
+			getQuery   string
+		)
+
+		BeforeEach(func() {
+			changeRows = sqlmock.NewRows([]string{"change_seq", "id", "list_id", "deleted_at"})
+			getQuery = "SELECT id, text, completed, created_at, updated_at, due_at, start_at, priority, list_id, parent_id, recurrence, series_id, occurrence, deleted_at, version, owner_id, due_date_only FROM `todos` WHERE id IN (?, ?) AND workspace_id = ? AND owner_id = ?"
+		})
+
+		It("returns the todos and tombstones after the token in sequence order", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT change_seq, id, list_id, deleted_at FROM `todos` WHERE workspace_id = ? AND owner_id = ? AND (change_seq, id) > (?, ?) UNION ALL SELECT change_seq, todo_id, list_id, deleted_at FROM `todo_tombstones` WHERE workspace_id = ? AND owner_id = ? AND (change_seq, todo_id) > (?, ?) ORDER BY change_seq, id LIMIT ?")).
+				WithArgs(testWorkspace, testOwner, 4, 2, testWorkspace, testOwner, 4, 2, 10).
+				WillReturnRows(changeRows.AddRow(5, 1, 1, nil).AddRow(6, 2, 1, nil).AddRow(7, 3, 2, now))
+			mock.ExpectQuery(regexp.QuoteMeta(getQuery)).
+				WithArgs(1, 2, testWorkspace, testOwner).
+				WillReturnRows(rows.
+					AddRow(todoRow(1, "buy milk", false, now, now)...).
+					AddRow(todoRow(2, "walk dog", false, now, now, nil, nil, "none", 1, nil, nil, nil, 1, now)...))
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+
+			changes, err := repo.Changes(ctx, ChangeToken{Seq: 4, ID: 2}, 10, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changes).To(HaveLen(3))
+			Expect(changes[0].Seq).To(BeEquivalentTo(5))
+			Expect(changes[0].Todo.Text).To(Equal("buy milk"))
+			// Trashed after the todos were listed.
+			Expect(changes[1].Todo).To(BeNil())
+			Expect(changes[1].Tombstone).To(PointTo(MatchFields(IgnoreExtras, Fields{"ID": BeEquivalentTo(2)})))
+			Expect(changes[2].Tombstone).To(PointTo(MatchFields(IgnoreExtras, Fields{
+				"ID":     BeEquivalentTo(3),
+				"ListID": BeEquivalentTo(2),
+			})))
+		})
+
+		It("leaves out deletions unless asked for tombstones", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT change_seq, id, list_id, deleted_at FROM `todos` WHERE workspace_id = ? AND owner_id = ? AND (change_seq, id) > (?, ?) AND deleted_at IS NULL ORDER BY change_seq, id LIMIT ?")).
+				WithArgs(testWorkspace, testOwner, 0, 0, 10).
+				WillReturnRows(changeRows)
+
+			changes, err := repo.Changes(ctx, ChangeToken{}, 10, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changes).To(BeEmpty())
+		})
+	})
+
+	Describe("trash", Label("trash"), func() {
+		It("lists only trashed todos", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id, text, completed, created_at, updated_at, due_at, start_at, priority, list_id, parent_id, recurrence, series_id, occurrence, deleted_at, version, owner_id, due_date_only FROM `todos` WHERE workspace_id = ? AND owner_id = ? AND deleted_at IS NOT NULL ORDER BY id ASC")).
+				WillReturnRows(rows.AddRow(todoRow(3, "move house", false, now, now, nil, nil, "none", 1, nil, nil, nil, 1, now)...))
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(tagRows)
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(progressRows)
+
+			todos, err := repo.List(ctx, ListParams{Trashed: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(todos[0].DeletedAt).To(PointTo(BeTemporally("==", now)))
+		})
+	})
+
+	Describe("workspaces", Label("workspaces"), func() {
+		It("refuses to reach todos without a workspace", func() {
+			ctx := WithUser(context.Background(), testOwner)
+
+			_, err := repo.List(ctx, ListParams{})
+			Expect(err).To(MatchError(ErrWorkspaceRequired))
+			_, err = repo.ListAfter(ctx, ListParams{}, Cursor{ID: 1})
+			Expect(err).To(MatchError(ErrWorkspaceRequired))
+			_, err = repo.Count(ctx, ListParams{})
+			Expect(err).To(MatchError(ErrWorkspaceRequired))
+			_, err = repo.Get(ctx, 1)
+			Expect(err).To(MatchError(ErrWorkspaceRequired))
+			_, _, err = repo.Update(ctx, 1, TodoInput{})
+			Expect(err).To(MatchError(ErrWorkspaceRequired))
+			_, err = repo.Delete(ctx, 1, nil)
+			Expect(err).To(MatchError(ErrWorkspaceRequired))
+			_, _, err = repo.Restore(ctx, 1)
+			Expect(err).To(MatchError(ErrWorkspaceRequired))
+			_, err = repo.Ancestors(ctx, 1)
+			Expect(err).To(MatchError(ErrWorkspaceRequired))
+			_, err = repo.Descendants(ctx, []uint32{1})
+			Expect(err).To(MatchError(ErrWorkspaceRequired))
+			_, err = repo.Changes(ctx, ChangeToken{}, 10, true)
+			Expect(err).To(MatchError(ErrWorkspaceRequired))
+			Expect(repo.Enqueue(ctx, Event{Type: EventTodoCreated, TodoID: 1})).To(MatchError(ErrWorkspaceRequired))
+		})
+
+		It("doesn't create todos outside a workspace", func() {
+			text := "walk the dog"
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+
+			_, err := repo.Create(WithUser(context.Background(), testOwner), TodoInput{Text: &text})
+			Expect(err).To(MatchError(ErrWorkspaceRequired))
+		})
+
+		It("draws changes from the sequence of the workspace", func() {
+			text := "walk the dog"
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `change_sequence` (workspace_id, seq) VALUES (?, LAST_INSERT_ID(1)) ON DUPLICATE KEY UPDATE seq = LAST_INSERT_ID(seq + 1)")).
+				WithArgs(testWorkspace + 1).
+				WillReturnError(errors.New("lock wait timeout"))
+			mock.ExpectRollback()
+
+			_, err := repo.Create(WithWorkspace(ctx, testWorkspace+1), TodoInput{Text: &text})
+			Expect(err).To(MatchError("lock wait timeout"))
+		})
+
+		It("doesn't reach the todos of the user in other workspaces", func() {
+			other := WithWorkspace(ctx, testWorkspace+1)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id, text, completed, created_at, updated_at, due_at, start_at, priority, list_id, parent_id, recurrence, series_id, occurrence, deleted_at, version, owner_id, due_date_only FROM `todos` WHERE id=? AND workspace_id=? AND owner_id=? AND deleted_at IS NULL")).
+				WithArgs(1, testWorkspace+1, testOwner).
+				WillReturnError(sql.ErrNoRows)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id, text, completed, created_at, updated_at, due_at, start_at, priority, list_id, parent_id, recurrence, series_id, occurrence, deleted_at, version, owner_id, due_date_only FROM `todos` WHERE workspace_id = ? AND owner_id = ? AND deleted_at IS NULL ORDER BY id ASC")).
+				WithArgs(testWorkspace+1, testOwner).
+				WillReturnRows(rows)
+
+			_, err := repo.Get(other, 1)
+			Expect(err).To(MatchError(ErrTodoNotFound))
+			todos, err := repo.List(other, ListParams{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(todos).To(BeEmpty())
+		})
+
+		It("tags todos with the tags of the user in their workspace", func() {
+			other := WithWorkspace(ctx, testWorkspace+1)
+			text, tags := "walk the dog", []string{"pets"}
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT w.max_todos IS NOT NULL")).
+				WithArgs(testWorkspace + 1).
+				WillReturnRows(sqlmock.NewRows([]string{"full"}).AddRow(false))
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `todos`")).WillReturnResult(sqlmock.NewResult(5, 1))
+			mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `todo_tags` WHERE todo_id=?")).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `tags` (workspace_id, owner_id, name) VALUES (?, ?, ?)")).
+				WithArgs(testWorkspace+1, testOwner, "pets").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `todo_tags` (todo_id, tag_id) SELECT ?, id FROM `tags` WHERE workspace_id = ? AND owner_id = ? AND name IN (?)")).
+				WithArgs(5, testWorkspace+1, testOwner, "pets").
+				WillReturnError(errors.New("insert failed"))
+			mock.ExpectRollback()
+
+			_, err := repo.Create(other, TodoInput{Text: &text, Tags: &tags})
+			Expect(err).To(MatchError("insert failed"))
+		})
+
+		It("counts trashed todos against the quota of the workspace", func() {
+			text := "walk the dog"
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT w.max_todos IS NOT NULL AND w.max_todos <= (SELECT COUNT(*) FROM `todos` WHERE workspace_id = w.id) FROM `workspaces` w WHERE w.id=? FOR UPDATE")).
+				WithArgs(testWorkspace).
+				WillReturnRows(sqlmock.NewRows([]string{"full"}).AddRow(true))
+			mock.ExpectRollback()
+
+			_, err := repo.Create(ctx, TodoInput{Text: &text})
+			Expect(err).To(MatchError(ErrQuotaExceeded))
+		})
+
+		It("refuses to create todos beyond the quota of the workspace", func() {
+			text := "walk the dog"
+			mock.ExpectBegin()
+			expectNextChange(mock, 7)
+			expectQuota(mock, true)
+			mock.ExpectRollback()
+
+			todo, err := repo.Create(ctx, TodoInput{Text: &text})
+			Expect(err).To(MatchError(ErrQuotaExceeded))
+			Expect(todo).To(BeNil())
+		})
 	})
 })
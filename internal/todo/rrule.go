@@ -0,0 +1,301 @@
+package todo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurrence frequencies supported by RRule.
+const (
+	FreqDaily   = "DAILY"
+	FreqWeekly  = "WEEKLY"
+	FreqMonthly = "MONTHLY"
+)
+
+// RRule is the subset of an iCalendar (RFC 5545) recurrence rule supported for
+// recurring todos: FREQ (DAILY, WEEKLY or MONTHLY), INTERVAL, BYDAY, COUNT and
+// UNTIL. Weeks start on Monday and all dates are computed in UTC.
+type RRule struct {
+	Freq     string
+	Interval int
+	ByDay    []WeekdayNum
+	Count    int        // zero means unbounded
+	Until    *time.Time // inclusive
+}
+
+// WeekdayNum is a BYDAY entry. N, only allowed in monthly rules, selects the
+// Nth such weekday of the month, counting from the end if negative; zero
+// selects all of them.
+type WeekdayNum struct {
+	N       int
+	Weekday time.Weekday
+}
+
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+var weekdayNames = map[time.Weekday]string{
+	time.Monday:    "MO",
+	time.Tuesday:   "TU",
+	time.Wednesday: "WE",
+	time.Thursday:  "TH",
+	time.Friday:    "FR",
+	time.Saturday:  "SA",
+	time.Sunday:    "SU",
+}
+
+// maxPeriods bounds the number of periods Next looks through, so that rules
+// which rarely or never match can't loop forever.
+const maxPeriods = 1000
+
+// ParseRRule parses a recurrence rule such as "FREQ=WEEKLY;BYDAY=MO,WE". The
+// "RRULE:" prefix is optional and property names and values are case
+// insensitive. Errors wrap ErrRecurrenceInvalid.
+func ParseRRule(s string) (*RRule, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimPrefix(s, "RRULE:")
+	if s == "" {
+		return nil, fmt.Errorf("%w: empty rule", ErrRecurrenceInvalid)
+	}
+
+	r := &RRule{Interval: 1}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ";") {
+		k, v, ok := strings.Cut(part, "=")
+		if !ok || v == "" {
+			return nil, fmt.Errorf("%w: malformed part %q", ErrRecurrenceInvalid, part)
+		}
+		if seen[k] {
+			return nil, fmt.Errorf("%w: duplicate %s", ErrRecurrenceInvalid, k)
+		}
+		seen[k] = true
+
+		switch k {
+		case "FREQ":
+			switch v {
+			case FreqDaily, FreqWeekly, FreqMonthly:
+				r.Freq = v
+			default:
+				return nil, fmt.Errorf("%w: unsupported FREQ %s", ErrRecurrenceInvalid, v)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("%w: INTERVAL must be a positive integer", ErrRecurrenceInvalid)
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("%w: COUNT must be a positive integer", ErrRecurrenceInvalid)
+			}
+			r.Count = n
+		case "UNTIL":
+			t, err := parseUntil(v)
+			if err != nil {
+				return nil, err
+			}
+			r.Until = &t
+		case "BYDAY":
+			for _, d := range strings.Split(v, ",") {
+				wd, err := parseWeekdayNum(d)
+				if err != nil {
+					return nil, err
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		default:
+			return nil, fmt.Errorf("%w: unsupported property %s", ErrRecurrenceInvalid, k)
+		}
+	}
+
+	if r.Freq == "" {
+		return nil, fmt.Errorf("%w: missing FREQ", ErrRecurrenceInvalid)
+	}
+	if r.Count > 0 && r.Until != nil {
+		return nil, fmt.Errorf("%w: COUNT and UNTIL are mutually exclusive", ErrRecurrenceInvalid)
+	}
+	if r.Freq != FreqMonthly {
+		for _, d := range r.ByDay {
+			if d.N != 0 {
+				return nil, fmt.Errorf("%w: numbered BYDAY is only allowed with FREQ=MONTHLY", ErrRecurrenceInvalid)
+			}
+		}
+	}
+
+	return r, nil
+}
+
+func parseUntil(v string) (time.Time, error) {
+	if t, err := time.Parse("20060102T150405Z", v); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("20060102", v); err == nil {
+		// A date includes the whole day.
+		return t.Add(24*time.Hour - time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("%w: UNTIL must be a UTC date or date-time", ErrRecurrenceInvalid)
+}
+
+func parseWeekdayNum(s string) (WeekdayNum, error) {
+	if len(s) < 2 {
+		return WeekdayNum{}, fmt.Errorf("%w: unknown weekday %q", ErrRecurrenceInvalid, s)
+	}
+
+	wd, ok := weekdays[s[len(s)-2:]]
+	if !ok {
+		return WeekdayNum{}, fmt.Errorf("%w: unknown weekday %q", ErrRecurrenceInvalid, s)
+	}
+
+	var n int
+	if prefix := s[:len(s)-2]; prefix != "" {
+		var err error
+		n, err = strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return WeekdayNum{}, fmt.Errorf("%w: BYDAY ordinal must be between -5 and 5", ErrRecurrenceInvalid)
+		}
+	}
+
+	return WeekdayNum{N: n, Weekday: wd}, nil
+}
+
+// String returns the rule in its canonical form.
+func (r *RRule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, d := range r.ByDay {
+			days[i] = weekdayNames[d.Weekday]
+			if d.N != 0 {
+				days[i] = strconv.Itoa(d.N) + days[i]
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if r.Until != nil {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Next returns the first occurrence strictly after prev, itself taken to be
+// an occurrence of the rule, or false if the rule ends before then. COUNT is
+// not checked since prev doesn't say how many occurrences came before it.
+// Occurrences keep the time of day of prev.
+func (r *RRule) Next(prev time.Time) (time.Time, bool) {
+	prev = prev.UTC()
+	interval := max(r.Interval, 1)
+
+	for k := 0; k <= maxPeriods; k++ {
+		var candidates []time.Time
+		switch r.Freq {
+		case FreqDaily:
+			if k == 0 {
+				continue
+			}
+			d := prev.AddDate(0, 0, k*interval)
+			if r.matchesDay(d) {
+				candidates = []time.Time{d}
+			}
+		case FreqWeekly:
+			candidates = r.weekOccurrences(prev, k*interval)
+		case FreqMonthly:
+			candidates = r.monthOccurrences(prev, k*interval)
+		default:
+			return time.Time{}, false
+		}
+
+		for _, c := range candidates {
+			if !c.After(prev) {
+				continue
+			}
+			if r.Until != nil && c.After(*r.Until) {
+				return time.Time{}, false
+			}
+			return c, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+func (r *RRule) matchesDay(t time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, d := range r.ByDay {
+		if d.Weekday == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// weekOccurrences returns the occurrences in the week weeks after the week of
+// prev, in chronological order.
+func (r *RRule) weekOccurrences(prev time.Time, weeks int) []time.Time {
+	if len(r.ByDay) == 0 {
+		return []time.Time{prev.AddDate(0, 0, 7*weeks)}
+	}
+
+	// Weeks start on Monday.
+	offset := (int(prev.Weekday()) + 6) % 7
+	monday := prev.AddDate(0, 0, 7*weeks-offset)
+
+	var out []time.Time
+	for i := range 7 {
+		d := monday.AddDate(0, 0, i)
+		if r.matchesDay(d) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// monthOccurrences returns the occurrences in the month months after the month
+// of prev, in chronological order.
+func (r *RRule) monthOccurrences(prev time.Time, months int) []time.Time {
+	y, m, d := prev.Date()
+	first := time.Date(y, m+time.Month(months), 1, prev.Hour(), prev.Minute(), prev.Second(), 0, time.UTC)
+	days := first.AddDate(0, 1, -1).Day()
+
+	if len(r.ByDay) == 0 {
+		// Months too short for the day of prev are skipped.
+		if d > days {
+			return nil
+		}
+		return []time.Time{first.AddDate(0, 0, d-1)}
+	}
+
+	var out []time.Time
+	for day := 1; day <= days; day++ {
+		t := first.AddDate(0, 0, day-1)
+		for _, wd := range r.ByDay {
+			if wd.Weekday != t.Weekday() {
+				continue
+			}
+			nth := (day-1)/7 + 1
+			nthLast := -((days-day)/7 + 1)
+			if wd.N == 0 || wd.N == nth || wd.N == nthLast {
+				out = append(out, t)
+				break
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,99 @@
+package todo_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+var _ = Describe("rrule", Label("rrule"), func() {
+	// at returns the given UTC date at 09:00.
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 9, 0, 0, 0, time.UTC)
+	}
+
+	Describe("ParseRRule", func() {
+		It("parses and canonicalizes a rule", func() {
+			r, err := ParseRRule("RRULE:freq=weekly;byday=mo,fr;interval=2;until=20251231")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Freq).To(Equal(FreqWeekly))
+			Expect(r.Interval).To(Equal(2))
+			Expect(r.ByDay).To(Equal([]WeekdayNum{{Weekday: time.Monday}, {Weekday: time.Friday}}))
+			Expect(r.String()).To(Equal("FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR;UNTIL=20251231T235959Z"))
+		})
+
+		It("parses numbered weekdays in monthly rules", func() {
+			r, err := ParseRRule("FREQ=MONTHLY;BYDAY=2TU,-1FR;COUNT=6")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.ByDay).To(Equal([]WeekdayNum{{N: 2, Weekday: time.Tuesday}, {N: -1, Weekday: time.Friday}}))
+			Expect(r.Count).To(Equal(6))
+			Expect(r.String()).To(Equal("FREQ=MONTHLY;BYDAY=2TU,-1FR;COUNT=6"))
+		})
+
+		DescribeTable("rejects invalid rules",
+			func(rule string) {
+				_, err := ParseRRule(rule)
+				Expect(err).To(MatchError(ErrRecurrenceInvalid))
+			},
+			Entry("empty", ""),
+			Entry("missing FREQ", "INTERVAL=2"),
+			Entry("unsupported FREQ", "FREQ=YEARLY"),
+			Entry("unsupported property", "FREQ=DAILY;BYHOUR=9"),
+			Entry("malformed part", "FREQ=DAILY;COUNT"),
+			Entry("duplicate property", "FREQ=DAILY;FREQ=WEEKLY"),
+			Entry("zero interval", "FREQ=DAILY;INTERVAL=0"),
+			Entry("negative count", "FREQ=DAILY;COUNT=-1"),
+			Entry("malformed until", "FREQ=DAILY;UNTIL=tomorrow"),
+			Entry("count and until", "FREQ=DAILY;COUNT=3;UNTIL=20251231"),
+			Entry("unknown weekday", "FREQ=WEEKLY;BYDAY=XX"),
+			Entry("ordinal out of range", "FREQ=MONTHLY;BYDAY=6MO"),
+			Entry("ordinal in weekly rule", "FREQ=WEEKLY;BYDAY=-1FR"),
+		)
+	})
+
+	Describe("Next", func() {
+		next := func(rule string, prev time.Time) (time.Time, bool) {
+			r, err := ParseRRule(rule)
+			Expect(err).NotTo(HaveOccurred())
+			return r.Next(prev)
+		}
+
+		DescribeTable("computes the following occurrence",
+			func(rule string, prev, want time.Time) {
+				got, ok := next(rule, prev)
+				Expect(ok).To(BeTrue())
+				Expect(got).To(Equal(want))
+			},
+			Entry("daily", "FREQ=DAILY", at(2025, 9, 17), at(2025, 9, 18)),
+			Entry("every third day", "FREQ=DAILY;INTERVAL=3", at(2025, 9, 30), at(2025, 10, 3)),
+			Entry("weekdays", "FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR", at(2025, 9, 19), at(2025, 9, 22)),
+			Entry("weekly", "FREQ=WEEKLY", at(2025, 9, 17), at(2025, 9, 24)),
+			Entry("weekly, later the same week", "FREQ=WEEKLY;BYDAY=MO,TH", at(2025, 9, 15), at(2025, 9, 18)),
+			Entry("weekly, wrapping to next week", "FREQ=WEEKLY;BYDAY=MO,TH", at(2025, 9, 18), at(2025, 9, 22)),
+			Entry("fortnightly, skipping a week", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH", at(2025, 9, 18), at(2025, 9, 29)),
+			Entry("weekly, Sunday closes the week", "FREQ=WEEKLY;BYDAY=SU", at(2025, 9, 21), at(2025, 9, 28)),
+			Entry("monthly", "FREQ=MONTHLY", at(2025, 9, 17), at(2025, 10, 17)),
+			Entry("monthly, skipping short months", "FREQ=MONTHLY", at(2025, 1, 31), at(2025, 3, 31)),
+			Entry("quarterly", "FREQ=MONTHLY;INTERVAL=3", at(2025, 11, 5), at(2026, 2, 5)),
+			Entry("second Tuesday", "FREQ=MONTHLY;BYDAY=2TU", at(2025, 9, 9), at(2025, 10, 14)),
+			Entry("last Friday", "FREQ=MONTHLY;BYDAY=-1FR", at(2025, 9, 26), at(2025, 10, 31)),
+			Entry("every Monday of the month", "FREQ=MONTHLY;BYDAY=MO", at(2025, 9, 29), at(2025, 10, 6)),
+			Entry("until, inclusive", "FREQ=DAILY;UNTIL=20250918", at(2025, 9, 17), at(2025, 9, 18)),
+		)
+
+		It("stops after UNTIL", func() {
+			_, ok := next("FREQ=DAILY;UNTIL=20250917T120000Z", at(2025, 9, 17))
+			Expect(ok).To(BeFalse())
+		})
+
+		It("keeps the time of day", func() {
+			prev := time.Date(2025, 9, 17, 18, 45, 30, 0, time.UTC)
+			got, ok := next("FREQ=WEEKLY;BYDAY=FR", prev)
+			Expect(ok).To(BeTrue())
+			Expect(got).To(Equal(time.Date(2025, 9, 19, 18, 45, 30, 0, time.UTC)))
+		})
+	})
+})
@@ -0,0 +1,294 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"html"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// DefaultSearchLimit is the number of results returned by a search unless the
+// client asks for a different number.
+const DefaultSearchLimit = 20
+
+// A snippet is at most snippetLength bytes long, not counting markup. Longer
+// texts are cut down to an excerpt starting about snippetContext bytes before
+// the first match.
+const (
+	snippetLength  = 160
+	snippetContext = 40
+)
+
+// SearchParams describes a full-text search over live todos. ListID and
+// Completed narrow down the results like in a listing, and at most Limit of
+// the best ranked results are returned.
+type SearchParams struct {
+	Query     SearchQuery
+	ListID    *uint32
+	Completed *bool
+	Limit     int
+}
+
+// SearchQuery is a parsed search query. A todo matches if it matches every
+// term.
+type SearchQuery []SearchTerm
+
+// SearchTerm is a word, or a phrase of consecutive words, to look for in the
+// text of a todo. Words are lowercase. If Prefix is set, the term is a single
+// word matching any word it is a prefix of.
+type SearchTerm struct {
+	Words  []string
+	Prefix bool
+}
+
+// SearchResult is a todo matching a search, with its relevance (higher is
+// better) and an excerpt of its text in which the matches are highlighted.
+type SearchResult struct {
+	Todo    Todo    `json:"todo"`
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+// ParseSearchQuery parses a search query made of words, prefixes such as
+// "gro*" and double-quoted phrases. Words are split on anything other than
+// letters and digits, so punctuation is ignored.
+func ParseSearchQuery(s string) (SearchQuery, error) {
+	var q SearchQuery
+	for i, part := range strings.Split(s, `"`) {
+		// Odd parts sit between quotes. An unbalanced quote runs until the
+		// end of the query.
+		if i%2 == 1 {
+			if words := tokenWords(part); len(words) > 0 {
+				q = append(q, SearchTerm{Words: words})
+			}
+			continue
+		}
+
+		for _, f := range strings.Fields(part) {
+			words := tokenWords(f)
+			if len(words) == 0 {
+				continue
+			}
+			prefix := strings.HasSuffix(f, "*") && len(words) == 1
+			q = append(q, SearchTerm{Words: words, Prefix: prefix})
+		}
+	}
+
+	if len(q) == 0 {
+		return nil, errors.New("`q` must contain at least one word")
+	}
+	return q, nil
+}
+
+// boolean returns the query in MySQL's boolean full-text syntax, requiring
+// every term.
+func (q SearchQuery) boolean() string {
+	parts := make([]string, len(q))
+	for i, t := range q {
+		switch {
+		case len(t.Words) > 1:
+			parts[i] = `+"` + strings.Join(t.Words, " ") + `"`
+		case t.Prefix:
+			parts[i] = "+" + t.Words[0] + "*"
+		default:
+			parts[i] = "+" + t.Words[0]
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// token is a word of a text, lowercased, along with its byte offsets in the
+// text.
+type token struct {
+	word       string
+	start, end int
+}
+
+func tokenize(s string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range s {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case inWord && start < 0:
+			start = i
+		case !inWord && start >= 0:
+			tokens = append(tokens, token{strings.ToLower(s[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(s[start:]), start, len(s)})
+	}
+	return tokens
+}
+
+func tokenWords(s string) []string {
+	tokens := tokenize(s)
+	words := make([]string, len(tokens))
+	for i, t := range tokens {
+		words[i] = t.word
+	}
+	return words
+}
+
+// matches returns the ranges of tokens matched by the term, as pairs of
+// indices of their first and last token.
+func (t SearchTerm) matches(tokens []token) [][2]int {
+	var out [][2]int
+	for i := 0; i+len(t.Words) <= len(tokens); i++ {
+		ok := true
+		for j, w := range t.Words {
+			got := tokens[i+j].word
+			if got != w && !(t.Prefix && strings.HasPrefix(got, w)) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			out = append(out, [2]int{i, i + len(t.Words) - 1})
+		}
+	}
+	return out
+}
+
+// highlight returns an excerpt of text around the first match of q, with the
+// matches wrapped in <mark> tags. The rest of the text is HTML-escaped.
+func highlight(text string, q SearchQuery) string {
+	tokens := tokenize(text)
+
+	// Byte ranges of the matches, merged where only whitespace separates
+	// them.
+	var spans [][2]int
+	for _, t := range q {
+		for _, m := range t.matches(tokens) {
+			spans = append(spans, [2]int{tokens[m[0]].start, tokens[m[1]].end})
+		}
+	}
+	slices.SortFunc(spans, func(a, b [2]int) int { return a[0] - b[0] })
+	var merged [][2]int
+	for _, s := range spans {
+		if n := len(merged); n > 0 && (s[0] <= merged[n-1][1] || strings.TrimSpace(text[merged[n-1][1]:s[0]]) == "") {
+			merged[n-1][1] = max(merged[n-1][1], s[1])
+			continue
+		}
+		merged = append(merged, s)
+	}
+
+	from, to := excerpt(text, tokens, merged)
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, s := range merged {
+		if s[0] < from || s[1] > to {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:s[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[s[0]:s[1]]))
+		b.WriteString("</mark>")
+		pos = s[1]
+	}
+	b.WriteString(html.EscapeString(text[pos:to]))
+	if to < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// excerpt returns the byte range of text making up its snippet. Ranges start
+// and end on word boundaries so that multi-byte characters are never split.
+func excerpt(text string, tokens []token, spans [][2]int) (int, int) {
+	if len(text) <= snippetLength {
+		return 0, len(text)
+	}
+
+	from := 0
+	if len(spans) > 0 && spans[0][0] > snippetContext {
+		want := spans[0][0] - snippetContext
+		for _, t := range tokens {
+			if t.start >= want {
+				from = t.start
+				break
+			}
+		}
+	}
+
+	to := from
+	for _, t := range tokens {
+		if t.start < from {
+			continue
+		}
+		if t.end-from > snippetLength {
+			break
+		}
+		to = t.end
+	}
+	if to == from {
+		// A single word longer than a snippet is kept whole.
+		for _, t := range tokens {
+			if t.start == from {
+				to = t.end
+			}
+		}
+	}
+	return from, to
+}
+
+// tokenSearcher is the in-process fallback Searcher for backends without
+// full-text indexing. It tokenizes the text of every live todo matching the
+// filters, so it is only suited to small data sets.
+type tokenSearcher struct {
+	repo Repository
+}
+
+// NewTokenSearcher returns a Searcher which scans the todos of r rather than
+// relying on a full-text index. Todos are ranked by the number of matches.
+func NewTokenSearcher(r Repository) Searcher {
+	return &tokenSearcher{repo: r}
+}
+
+func (s *tokenSearcher) Search(ctx context.Context, p SearchParams) ([]SearchResult, error) {
+	todos, err := s.repo.List(ctx, ListParams{ListID: p.ListID, Completed: p.Completed})
+	if err != nil {
+		return nil, err
+	}
+
+	results := []SearchResult{}
+	for _, t := range todos {
+		tokens := tokenize(t.Text)
+		score := 0
+		for _, term := range p.Query {
+			n := len(term.matches(tokens))
+			if n == 0 {
+				score = 0
+				break
+			}
+			score += n
+		}
+		if score > 0 {
+			results = append(results, SearchResult{Todo: t, Score: float64(score)})
+		}
+	}
+
+	// The todos come ordered by id, which the stable sort keeps for ties.
+	slices.SortStableFunc(results, func(a, b SearchResult) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		}
+		return 0
+	})
+	if p.Limit > 0 && len(results) > p.Limit {
+		results = results[:p.Limit]
+	}
+
+	return results, nil
+}
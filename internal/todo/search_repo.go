@@ -0,0 +1,79 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// sqlsearcher searches the FULLTEXT index on the text of todos.
+type sqlsearcher struct {
+	db *sql.DB
+}
+
+// NewSearcher returns a Searcher backed by MySQL's full-text search. Words
+// shorter than the server's innodb_ft_min_token_size and stopwords are not
+// indexed, so they never match.
+func NewSearcher(db *sql.DB) Searcher {
+	return &sqlsearcher{db: db}
+}
+
+func (s *sqlsearcher) Search(ctx context.Context, p SearchParams) ([]SearchResult, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	q := p.Query.boolean()
+	where := fmt.Sprintf(" WHERE workspace_id = ? AND (owner_id = ? OR list_id IN (SELECT list_id FROM `%s` WHERE user_id = ?)) AND deleted_at IS NULL AND MATCH(text) AGAINST(? IN BOOLEAN MODE)", listMembersTable)
+	args := []any{q, workspace, owner, owner, q}
+	if p.ListID != nil {
+		where += " AND list_id = ?"
+		args = append(args, *p.ListID)
+	}
+	if p.Completed != nil {
+		where += " AND completed = ?"
+		args = append(args, *p.Completed)
+	}
+
+	query := fmt.Sprintf("SELECT %s, MATCH(text) AGAINST(? IN BOOLEAN MODE) AS score FROM `%s`%s ORDER BY score DESC, id ASC", columns, table, where)
+	if p.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, p.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		todos  = []Todo{}
+		scores []float64
+	)
+	for rows.Next() {
+		var (
+			t     Todo
+			score float64
+		)
+		if err := rows.Scan(append(todoFields(&t), &score)...); err != nil {
+			return nil, err
+		}
+		todos = append(todos, t)
+		scores = append(scores, score)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := loadDetails(ctx, s.db, todos); err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, len(todos))
+	for i, t := range todos {
+		results[i] = SearchResult{Todo: t, Score: scores[i]}
+	}
+	return results, nil
+}
@@ -0,0 +1,186 @@
+package todo_test
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+var _ = Describe("search", Label("search"), func() {
+	var ctx context.Context
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	Describe("ParseSearchQuery", func() {
+		It("parses words, prefixes and phrases", func() {
+			q, err := ParseSearchQuery(`Milk  gro* "Pick up, the kids" e-mail`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(q).To(Equal(SearchQuery{
+				{Words: []string{"milk"}},
+				{Words: []string{"gro"}, Prefix: true},
+				{Words: []string{"pick", "up", "the", "kids"}},
+				{Words: []string{"e", "mail"}},
+			}))
+		})
+
+		It("runs an unbalanced quote to the end", func() {
+			q, err := ParseSearchQuery(`dog "walk the`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(q).To(Equal(SearchQuery{{Words: []string{"dog"}}, {Words: []string{"walk", "the"}}}))
+		})
+
+		DescribeTable("rejects queries without words",
+			func(q string) {
+				_, err := ParseSearchQuery(q)
+				Expect(err).To(HaveOccurred())
+			},
+			Entry("empty", ""),
+			Entry("blank", "   "),
+			Entry("operators only", `+-* "" ()`),
+		)
+	})
+
+	Describe("token searcher", func() {
+		var (
+			repo     *mockRepo
+			searcher Searcher
+		)
+
+		BeforeEach(func() {
+			repo = &mockRepo{
+				listFn: func(ctx context.Context, p ListParams) ([]Todo, error) {
+					return []Todo{
+						{ID: 1, Text: "Buy milk"},
+						{ID: 2, Text: "Call mom about the milk, then buy more milk"},
+						{ID: 3, Text: "Walk the dog"},
+						{ID: 4, Text: "Milk buy"},
+					}, nil
+				},
+			}
+			searcher = NewTokenSearcher(repo)
+		})
+
+		search := func(q string) []uint32 {
+			query, err := ParseSearchQuery(q)
+			Expect(err).NotTo(HaveOccurred())
+			results, err := searcher.Search(ctx, SearchParams{Query: query})
+			Expect(err).NotTo(HaveOccurred())
+			ids := []uint32{}
+			for _, r := range results {
+				ids = append(ids, r.Todo.ID)
+			}
+			return ids
+		}
+
+		It("requires every term and ranks by number of matches", func() {
+			Expect(search("milk buy")).To(Equal([]uint32{2, 1, 4}))
+		})
+
+		It("matches phrases in order", func() {
+			Expect(search(`"buy milk"`)).To(Equal([]uint32{1}))
+		})
+
+		It("matches prefixes", func() {
+			Expect(search("wal* d*")).To(Equal([]uint32{3}))
+			Expect(search("wal")).To(BeEmpty())
+		})
+
+		It("passes the filters to the repository and honors the limit", func() {
+			completed := true
+			repo.listFn = func(ctx context.Context, p ListParams) ([]Todo, error) {
+				Expect(p.Completed).To(Equal(&completed))
+				return []Todo{{ID: 1, Text: "milk"}, {ID: 2, Text: "milk"}}, nil
+			}
+
+			query, _ := ParseSearchQuery("milk")
+			results, err := searcher.Search(ctx, SearchParams{Query: query, Completed: &completed, Limit: 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].Todo.ID).To(BeEquivalentTo(1))
+		})
+	})
+
+	Describe("service", func() {
+		var svc Service
+
+		BeforeEach(func() {
+			repo := &mockRepo{
+				listFn: func(ctx context.Context, p ListParams) ([]Todo, error) {
+					return []Todo{
+						{ID: 1, Text: "Buy <b>milk</b> & buy eggs"},
+						{ID: 2, Text: strings.Repeat("lorem ipsum ", 10) + "buy the milk " + strings.Repeat("dolor sit amet ", 10)},
+					}, nil
+				},
+			}
+			svc = NewService(repo)
+		})
+
+		It("highlights the matches", func() {
+			query, _ := ParseSearchQuery(`buy "the milk"`)
+			results, err := svc.Search(ctx, SearchParams{Query: query})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].Snippet).To(Equal("…lorem ipsum lorem ipsum lorem ipsum <mark>buy the milk</mark> dolor sit amet dolor sit amet dolor sit amet dolor sit amet dolor sit amet dolor sit amet dolor sit amet dolor…"))
+		})
+
+		It("escapes the rest of the text", func() {
+			query, _ := ParseSearchQuery("buy milk")
+			results, err := svc.Search(ctx, SearchParams{Query: query})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(2))
+			Expect(results[0].Snippet).To(Equal("<mark>Buy</mark> &lt;b&gt;<mark>milk</mark>&lt;/b&gt; &amp; <mark>buy</mark> eggs"))
+		})
+	})
+
+	Describe("MySQL searcher", func() {
+		var (
+			db       *sql.DB
+			mock     sqlmock.Sqlmock
+			searcher Searcher
+		)
+
+		BeforeEach(func() {
+			var err error
+			db, mock, err = sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			searcher = NewSearcher(db)
+		})
+
+		AfterEach(func() {
+			mock.ExpectClose()
+			Expect(db.Close()).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("ranks the matches of the full-text index", func() {
+			now := time.Now().UTC()
+			query, _ := ParseSearchQuery(`"buy milk" gro*`)
+			list := uint32(2)
+			boolean := `+"buy milk" +gro*`
+
+			rows := sqlmock.NewRows(append(todoColumns, "score")).
+				AddRow(append(todoRow(7, "buy milk at the grocer", false, now, now), 2.5)...)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id, text, completed, created_at, updated_at, due_at, start_at, priority, list_id, parent_id, recurrence, series_id, occurrence, deleted_at, version, owner_id, due_date_only, MATCH(text) AGAINST(? IN BOOLEAN MODE) AS score FROM `todos` WHERE workspace_id = ? AND (owner_id = ? OR list_id IN (SELECT list_id FROM `list_members` WHERE user_id = ?)) AND deleted_at IS NULL AND MATCH(text) AGAINST(? IN BOOLEAN MODE) AND list_id = ? ORDER BY score DESC, id ASC LIMIT ?")).
+				WithArgs(boolean, testWorkspace, testOwner, testOwner, boolean, list, 10).
+				WillReturnRows(rows)
+			mock.ExpectQuery(regexp.QuoteMeta(tagsQuery)).WillReturnRows(sqlmock.NewRows([]string{"todo_id", "name"}))
+			mock.ExpectQuery(regexp.QuoteMeta(progressQuery)).WillReturnRows(sqlmock.NewRows([]string{"parent_id", "total", "completed"}))
+
+			results, err := searcher.Search(WithWorkspace(WithUser(ctx, testOwner), testWorkspace), SearchParams{Query: query, ListID: &list, Limit: 10})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].Todo.ID).To(BeEquivalentTo(7))
+			Expect(results[0].Score).To(Equal(2.5))
+		})
+	})
+})
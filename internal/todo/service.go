@@ -2,34 +2,313 @@ package todo
 
 import (
 	"context"
+	"errors"
+	"slices"
+	"time"
 )
 
 type Service interface {
-	GetAll(ctx context.Context) ([]Todo, error)
+	GetAll(ctx context.Context, p ListParams) (*Page, error)
 	GetById(ctx context.Context, id uint32) (*Todo, error)
 	Create(ctx context.Context, in TodoInput) (*Todo, error)
 	Update(ctx context.Context, id uint32, in TodoInput) (*Todo, error)
-	Delete(ctx context.Context, id uint32) error
+	Delete(ctx context.Context, id uint32, version *uint32) error
+	Restore(ctx context.Context, id uint32) (*Todo, error)
+	EmptyTrash(ctx context.Context) error
+	ExpandChildren(ctx context.Context, todos []Todo) error
+	Search(ctx context.Context, p SearchParams) ([]SearchResult, error)
+	Bulk(ctx context.Context, ops []BulkOp, atomic bool) ([]BulkResult, error)
+	CompleteAll(ctx context.Context, p ListParams) (int, error)
+	DeleteAll(ctx context.Context, p ListParams) (int, error)
+	DeleteList(ctx context.Context, id uint32) error
+	Pull(ctx context.Context, token string, limit int) (*ChangeSet, error)
+	Push(ctx context.Context, changes []SyncChange) ([]BulkResult, error)
 }
 
 type service struct {
-	repo Repository
+	repo        Repository
+	searcher    Searcher
+	cursors     *cursorCodec
+	now         func() time.Time
+	defaultList uint32
+	maxDepth    int
+	textRules   TextRules
+	events      *EventBus
+	outbox      bool
+	shares      ShareRepository
+
+	// pending collects the events of a transaction, which are only
+	// published once it commits.
+	pending *[]Event
+}
+
+// Option configures optional behaviour of the service.
+type Option func(*service)
+
+// WithCursorSecret sets the key used to sign pagination cursors. Without it a
+// random key is generated, so cursors are only valid for the lifetime of the
+// process.
+func WithCursorSecret(secret []byte) Option {
+	return func(s *service) {
+		s.cursors = newCursorCodec(secret)
+	}
+}
+
+// WithClock replaces the source of the current time, which is used to resolve
+// relative due date windows.
+func WithClock(now func() time.Time) Option {
+	return func(s *service) {
+		s.now = now
+	}
+}
+
+// WithDefaultList sets the list that receives todos created without one. It
+// defaults to DefaultListID.
+func WithDefaultList(id uint32) Option {
+	return func(s *service) {
+		s.defaultList = id
+	}
+}
+
+// WithMaxDepth sets the number of levels a todo tree may span, counting the
+// root. It defaults to DefaultMaxDepth.
+func WithMaxDepth(n int) Option {
+	return func(s *service) {
+		s.maxDepth = n
+	}
+}
+
+// WithTextRules sets the length bounds of the text of todos. It defaults to
+// DefaultTextRules.
+func WithTextRules(r TextRules) Option {
+	return func(s *service) {
+		s.textRules = r
+	}
+}
+
+// WithSearcher sets the backend of full-text searches. Without it todos are
+// searched in process, see NewTokenSearcher.
+func WithSearcher(sr Searcher) Option {
+	return func(s *service) {
+		s.searcher = sr
+	}
+}
+
+// WithEventBus makes the service publish an event to b whenever a todo is
+// created, updated or deleted.
+func WithEventBus(b *EventBus) Option {
+	return func(s *service) {
+		s.events = b
+	}
+}
+
+// WithOutbox makes every mutation record its events in the outbox of the
+// repository, within the transaction of the mutation, for delivery to
+// webhooks.
+func WithOutbox() Option {
+	return func(s *service) {
+		s.outbox = true
+	}
+}
+
+// WithSharing lets users reach the todos of the lists shared with them, as
+// recorded in r. Reading them needs the viewer role, and writing them the
+// editor role, and the users of shared lists act on behalf of their owner,
+// who owns every todo in them. Searches and event streams cover the shared
+// todos too, while syncing remains limited to the todos of each user.
+func WithSharing(r ShareRepository) Option {
+	return func(s *service) {
+		s.shares = r
+	}
+}
+
+func NewService(r Repository, opts ...Option) Service {
+	s := &service{
+		repo:        r,
+		now:         time.Now,
+		defaultList: DefaultListID,
+		maxDepth:    DefaultMaxDepth,
+		textRules:   DefaultTextRules,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.cursors == nil {
+		s.cursors = newCursorCodec(nil)
+	}
+	if s.searcher == nil {
+		s.searcher = NewTokenSearcher(r)
+	}
+	return s
 }
 
-func NewService(r Repository) Service {
-	return &service{repo: r}
+func (s *service) GetAll(ctx context.Context, p ListParams) (*Page, error) {
+	if p.Limit <= 0 {
+		p.Limit = DefaultLimit
+	} else if p.Limit > MaxLimit {
+		p.Limit = MaxLimit
+	}
+	s.resolveDue(&p)
+
+	var err error
+	if p.ListID != nil {
+		ctx, err = s.forList(ctx, *p.ListID, RoleViewer)
+	} else if p.ParentID != nil {
+		ctx, err = s.forTodo(ctx, *p.ParentID, RoleViewer)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var page *Page
+	if p.Cursor != "" {
+		page, err = s.listAfter(ctx, p)
+	} else {
+		page, err = s.listOffset(ctx, p)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	page.Total, err = s.repo.Count(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Expand {
+		if err := s.ExpandChildren(ctx, page.Todos); err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+// resolveDue turns the due date window named by p.Due into concrete bounds.
+// Day and week boundaries are taken in p.Location so that "today" means the
+// client's today.
+func (s *service) resolveDue(p *ListParams) {
+	loc := p.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	now := s.now().In(loc)
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, loc)
+
+	var from, before time.Time
+	switch p.Due {
+	case DueOverdue:
+		// Todos due on a date are overdue from the following day on.
+		before = now
+		beforeDate := asDate(today)
+		if p.Completed == nil {
+			completed := false
+			p.Completed = &completed
+		}
+		p.DueBefore = &before
+		p.DueDateBefore = &beforeDate
+		return
+	case DueToday:
+		from = today
+		before = today.AddDate(0, 0, 1)
+	case DueThisWeek:
+		// Weeks start on Monday.
+		offset := (int(today.Weekday()) + 6) % 7
+		from = today.AddDate(0, 0, -offset)
+		before = from.AddDate(0, 0, 7)
+	default:
+		return
+	}
+	fromDate, beforeDate := asDate(from), asDate(before)
+	p.DueFrom = &from
+	p.DueBefore = &before
+	p.DueDateFrom = &fromDate
+	p.DueDateBefore = &beforeDate
 }
 
-func (s *service) GetAll(ctx context.Context) ([]Todo, error) {
-	todos, err := s.repo.List(ctx)
+// asDate returns the calendar date of t in its location as midnight UTC, the
+// way date-only due dates are stored.
+func asDate(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func (s *service) listOffset(ctx context.Context, p ListParams) (*Page, error) {
+	// Fetch one extra row to find out whether there is a next page.
+	q := p
+	q.Limit++
+	todos, err := s.repo.List(ctx, q)
 	if err != nil {
 		return nil, err
 	}
 
-	return todos, nil
+	page := &Page{}
+	more := len(todos) > p.Limit
+	if more {
+		todos = todos[:p.Limit]
+	}
+	page.Todos = todos
+
+	if !keysetSortable(p.Sort.Field) {
+		return page, nil
+	}
+	if more {
+		page.NextCursor = s.cursors.encode(cursorAt(todos[len(todos)-1], p.Sort, false))
+	}
+	if p.Offset > 0 && len(todos) > 0 {
+		page.PrevCursor = s.cursors.encode(cursorAt(todos[0], p.Sort, true))
+	}
+
+	return page, nil
+}
+
+func (s *service) listAfter(ctx context.Context, p ListParams) (*Page, error) {
+	cur, err := s.cursors.decode(p.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if cur.Sort != p.Sort {
+		return nil, ErrBadCursor
+	}
+
+	// Fetch one extra row to find out whether there is a page beyond this one.
+	q := p
+	q.Limit++
+	todos, err := s.repo.ListAfter(ctx, q, *cur)
+	if err != nil {
+		return nil, err
+	}
+
+	more := len(todos) > p.Limit
+	if more && cur.Backward {
+		todos = todos[len(todos)-p.Limit:]
+	} else if more {
+		todos = todos[:p.Limit]
+	}
+
+	page := &Page{Todos: todos}
+	if len(todos) == 0 {
+		return page, nil
+	}
+
+	// Coming from a cursor means there are rows on the side we came from.
+	if more || cur.Backward {
+		page.NextCursor = s.cursors.encode(cursorAt(todos[len(todos)-1], p.Sort, false))
+	}
+	if more || !cur.Backward {
+		page.PrevCursor = s.cursors.encode(cursorAt(todos[0], p.Sort, true))
+	}
+
+	return page, nil
 }
 
 func (s *service) GetById(ctx context.Context, id uint32) (*Todo, error) {
+	ctx, err := s.forTodo(ctx, id, RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+
 	t, err := s.repo.Get(ctx, id)
 	if err != nil {
 		return nil, err
@@ -39,7 +318,59 @@ func (s *service) GetById(ctx context.Context, id uint32) (*Todo, error) {
 }
 
 func (s *service) Create(ctx context.Context, in TodoInput) (*Todo, error) {
-	t, err := s.repo.Create(ctx, in)
+	if err := s.validateTodo(&in); err != nil {
+		return nil, err
+	}
+	if in.StartAt != nil && in.DueAt != nil && in.StartAt.After(in.DueAt.Time) {
+		return nil, ErrStartAfterDue
+	}
+	if in.Tags != nil {
+		tags, err := normalizeTagNames(*in.Tags)
+		if err != nil {
+			return nil, err
+		}
+		in.Tags = &tags
+	}
+	if in.ParentID != nil && *in.ParentID == 0 {
+		in.ParentID = nil
+	}
+	if in.Recurrence != nil && *in.Recurrence == "" {
+		in.Recurrence = nil
+	}
+	if in.Recurrence != nil {
+		rule, err := canonicalRule(*in.Recurrence)
+		if err != nil {
+			return nil, err
+		}
+		in.Recurrence = &rule
+	}
+	ctx, err := s.forNew(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	if in.ParentID != nil {
+		parent, err := s.checkParent(ctx, 0, *in.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		// Subtasks stay with their parent unless told otherwise.
+		if in.ListID == nil {
+			in.ListID = &parent.ListID
+		}
+	}
+	if in.ListID == nil {
+		list := s.defaultList
+		in.ListID = &list
+	}
+
+	var t *Todo
+	err = s.write(ctx, func(tx *service) error {
+		var err error
+		if t, err = tx.repo.Create(ctx, in); err != nil {
+			return err
+		}
+		return tx.record(ctx, EventTodoCreated, t.ID, t)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -48,7 +379,62 @@ func (s *service) Create(ctx context.Context, in TodoInput) (*Todo, error) {
 }
 
 func (s *service) Update(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
-	t, err := s.repo.Update(ctx, id, in)
+	if err := s.validateTodo(&in); err != nil {
+		return nil, err
+	}
+	acting, err := s.forTodo(ctx, id, RoleEditor)
+	if err != nil {
+		return nil, err
+	}
+	if in.ListID != nil {
+		if err := s.checkMove(ctx, acting, *in.ListID); err != nil {
+			return nil, err
+		}
+	}
+	ctx = acting
+	if err := s.checkSchedule(ctx, id, in); err != nil {
+		return nil, err
+	}
+	if in.ParentID != nil && *in.ParentID != 0 {
+		if _, err := s.checkParent(ctx, id, *in.ParentID); err != nil {
+			return nil, err
+		}
+	}
+	if in.Recurrence != nil && *in.Recurrence != "" {
+		rule, err := canonicalRule(*in.Recurrence)
+		if err != nil {
+			return nil, err
+		}
+		in.Recurrence = &rule
+	}
+	if in.Tags != nil {
+		tags, err := normalizeTagNames(*in.Tags)
+		if err != nil {
+			return nil, err
+		}
+		in.Tags = &tags
+	}
+
+	next, err := s.nextOccurrence(ctx, id, in)
+	if err != nil {
+		return nil, err
+	}
+	in.Next = next
+
+	var t *Todo
+	err = s.write(ctx, func(tx *service) error {
+		var (
+			affected *Affected
+			err      error
+		)
+		if t, affected, err = tx.repo.Update(ctx, id, in); err != nil {
+			return err
+		}
+		if err := tx.record(ctx, EventTodoUpdated, t.ID, t); err != nil {
+			return err
+		}
+		return tx.recordAffected(ctx, affected)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -56,11 +442,442 @@ func (s *service) Update(ctx context.Context, id uint32, in TodoInput) (*Todo, e
 	return t, nil
 }
 
-func (s *service) Delete(ctx context.Context, id uint32) error {
-	err := s.repo.Delete(ctx, id)
+// forNew returns a copy of ctx acting on behalf of the owner of the todos of
+// the list a new todo goes in, which is that of its parent unless told
+// otherwise.
+func (s *service) forNew(ctx context.Context, in TodoInput) (context.Context, error) {
+	if in.ListID != nil {
+		return s.forList(ctx, *in.ListID, RoleEditor)
+	}
+	if in.ParentID == nil {
+		return ctx, nil
+	}
+	ctx, err := s.forTodo(ctx, *in.ParentID, RoleEditor)
+	if errors.Is(err, ErrTodoNotFound) {
+		return nil, ErrParentNotFound
+	}
+	return ctx, err
+}
+
+// checkMove verifies that the user of ctx can move a todo they reach through
+// acting to list listID. Todos keep their owner, so they only move between
+// lists whose todos are owned by the same user.
+func (s *service) checkMove(ctx, acting context.Context, listID uint32) error {
+	to, err := s.forList(ctx, listID, RoleEditor)
 	if err != nil {
 		return err
 	}
+	from, _ := UserID(acting)
+	if owner, _ := UserID(to); owner != from {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// checkSchedule verifies that an update leaves the todo's start date no later
+// than its due date, filling in whichever side the update leaves untouched.
+func (s *service) checkSchedule(ctx context.Context, id uint32, in TodoInput) error {
+	if in.StartAt == nil && in.DueAt == nil {
+		return nil
+	}
 
+	var start, due *time.Time
+	if in.StartAt == nil || in.DueAt == nil {
+		cur, err := s.repo.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		start, due = cur.StartAt, cur.DueAt
+	}
+	start, due = in.StartAt.or(start), in.DueAt.or(due)
+
+	if start != nil && due != nil && start.After(*due) {
+		return ErrStartAfterDue
+	}
 	return nil
 }
+
+// checkParent verifies that the todo id (0 for a new todo) can be placed under
+// parentID without creating a cycle or exceeding the maximum depth, and returns
+// the parent.
+func (s *service) checkParent(ctx context.Context, id, parentID uint32) (*Todo, error) {
+	if parentID == id {
+		return nil, ErrParentCycle
+	}
+
+	parent, err := s.repo.Get(ctx, parentID)
+	if err != nil {
+		if errors.Is(err, ErrTodoNotFound) {
+			return nil, ErrParentNotFound
+		}
+		return nil, err
+	}
+
+	ancestors, err := s.repo.Ancestors(ctx, parentID)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(ancestors, id) {
+		return nil, ErrParentCycle
+	}
+
+	// A new todo is a leaf, while an existing one brings its subtree along.
+	height := 1
+	if id != 0 {
+		descendants, err := s.repo.Descendants(ctx, []uint32{id})
+		if err != nil {
+			return nil, err
+		}
+		height = treeHeight(id, childrenByParent(descendants))
+	}
+	if len(ancestors)+height > s.maxDepth {
+		return nil, ErrDepthExceeded
+	}
+
+	return parent, nil
+}
+
+// canonicalRule validates a recurrence rule and returns its canonical form.
+func canonicalRule(s string) (string, error) {
+	rule, err := ParseRRule(s)
+	if err != nil {
+		return "", err
+	}
+	return rule.String(), nil
+}
+
+// nextOccurrence returns the occurrence that follows todo id if in completes
+// it and the todo recurs, or nil otherwise. The next occurrence is a copy of
+// the todo as the update leaves it, with its dates moved forward to the next
+// date of the rule.
+func (s *service) nextOccurrence(ctx context.Context, id uint32, in TodoInput) (*TodoInput, error) {
+	if in.Completed == nil || !*in.Completed {
+		return nil, nil
+	}
+
+	cur, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	recurrence := cur.Recurrence
+	if in.Recurrence != nil {
+		recurrence = in.Recurrence
+	}
+	if cur.Completed || recurrence == nil || *recurrence == "" {
+		return nil, nil
+	}
+
+	rule, err := ParseRRule(*recurrence)
+	if err != nil {
+		return nil, err
+	}
+	if rule.Count > 0 && cur.Occurrence >= rule.Count {
+		return nil, nil
+	}
+
+	start, due := in.StartAt.or(cur.StartAt), in.DueAt.or(cur.DueAt)
+	dueDateOnly := cur.DueDateOnly
+	if in.DueAt != nil {
+		dueDateOnly = in.DueAt.DateOnly
+	}
+
+	// Undated todos recur from the time they are completed.
+	anchor := s.now()
+	if due != nil {
+		anchor = *due
+	} else if start != nil {
+		anchor = *start
+	}
+	at, ok := rule.Next(anchor)
+	if !ok {
+		return nil, nil
+	}
+
+	completed := false
+	series := cur.ID
+	if cur.SeriesID != nil {
+		series = *cur.SeriesID
+	}
+	next := &TodoInput{
+		Text:       &cur.Text,
+		Completed:  &completed,
+		Priority:   &cur.Priority,
+		ListID:     &cur.ListID,
+		ParentID:   cur.ParentID,
+		Recurrence: recurrence,
+		Tags:       &cur.Tags,
+		SeriesID:   &series,
+		Occurrence: cur.Occurrence + 1,
+	}
+	if in.Text != nil {
+		next.Text = in.Text
+	}
+	if in.Priority != nil {
+		next.Priority = in.Priority
+	}
+	if in.ListID != nil {
+		next.ListID = in.ListID
+	}
+	if in.ParentID != nil {
+		next.ParentID = in.ParentID
+		if *in.ParentID == 0 {
+			next.ParentID = nil
+		}
+	}
+	if in.Tags != nil {
+		next.Tags = in.Tags
+	}
+
+	// The start date keeps its distance to the due date.
+	shift := at.Sub(anchor)
+	if start != nil {
+		next.StartAt = &DateTime{Time: start.Add(shift)}
+	}
+	if due != nil || start == nil {
+		next.DueAt = &DateTime{Time: at, DateOnly: dueDateOnly}
+	}
+
+	return next, nil
+}
+
+// ExpandChildren fills in the Children of todos with their whole subtree.
+func (s *service) ExpandChildren(ctx context.Context, todos []Todo) error {
+	// The todos of a shared list are all owned by its owner, as are their
+	// children.
+	if len(todos) > 0 {
+		var err error
+		if ctx, err = s.forTodo(ctx, todos[0].ID, RoleViewer); err != nil {
+			return err
+		}
+	}
+
+	ids := make([]uint32, len(todos))
+	for i, t := range todos {
+		ids[i] = t.ID
+	}
+
+	descendants, err := s.repo.Descendants(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	byParent := childrenByParent(descendants)
+	for i := range todos {
+		todos[i].Children = subtree(todos[i].ID, byParent)
+	}
+
+	return nil
+}
+
+// childrenByParent groups todos by their parent, keeping their order.
+func childrenByParent(todos []Todo) map[uint32][]Todo {
+	m := make(map[uint32][]Todo)
+	for _, t := range todos {
+		if t.ParentID != nil {
+			m[*t.ParentID] = append(m[*t.ParentID], t)
+		}
+	}
+	return m
+}
+
+// subtree returns the children of id with their own children filled in.
+func subtree(id uint32, byParent map[uint32][]Todo) []Todo {
+	children := byParent[id]
+	for i := range children {
+		children[i].Children = subtree(children[i].ID, byParent)
+	}
+	return children
+}
+
+// treeHeight returns the number of levels of the tree rooted at id.
+func treeHeight(id uint32, byParent map[uint32][]Todo) int {
+	h := 0
+	for _, c := range byParent[id] {
+		h = max(h, treeHeight(c.ID, byParent))
+	}
+	return h + 1
+}
+
+func (s *service) Delete(ctx context.Context, id uint32, version *uint32) error {
+	ctx, err := s.forTodo(ctx, id, RoleEditor)
+	if err != nil {
+		return err
+	}
+
+	return s.write(ctx, func(tx *service) error {
+		// The event of a deletion tells which list the todo was in.
+		var t *Todo
+		if tx.events != nil || tx.outbox {
+			var err error
+			if t, err = tx.repo.Get(ctx, id); err != nil {
+				return err
+			}
+		}
+
+		affected, err := tx.repo.Delete(ctx, id, version)
+		if err != nil {
+			return err
+		}
+		if err := tx.record(ctx, EventTodoDeleted, id, t); err != nil {
+			return err
+		}
+		return tx.recordAffected(ctx, affected)
+	})
+}
+
+// DeleteList deletes list id, moving its todos to the trash of the default
+// list with an event for each of them.
+func (s *service) DeleteList(ctx context.Context, id uint32) error {
+	ctx, err := s.forList(ctx, id, RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	return s.write(ctx, func(tx *service) error {
+		trashed, err := tx.repo.DeleteList(ctx, id, s.defaultList)
+		if err != nil {
+			return err
+		}
+		for i := range trashed {
+			if err := tx.record(ctx, EventTodoDeleted, trashed[i].ID, &trashed[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *service) Restore(ctx context.Context, id uint32) (*Todo, error) {
+	ctx, err := s.forTodo(ctx, id, RoleEditor)
+	if err != nil {
+		return nil, err
+	}
+
+	var t *Todo
+	err = s.write(ctx, func(tx *service) error {
+		var (
+			affected *Affected
+			err      error
+		)
+		if t, affected, err = tx.repo.Restore(ctx, id); err != nil {
+			return err
+		}
+		// A restored todo shows up in listings again, as if it was
+		// created.
+		if err := tx.record(ctx, EventTodoCreated, t.ID, t); err != nil {
+			return err
+		}
+		return tx.recordAffected(ctx, affected)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (s *service) EmptyTrash(ctx context.Context) error {
+	_, err := s.repo.Purge(ctx, nil)
+	return err
+}
+
+// write runs fn, a write to the repository followed by the record of its
+// events. With the outbox, both take part in a single transaction, so that
+// events are recorded if and only if the write is committed.
+func (s *service) write(ctx context.Context, fn func(tx *service) error) error {
+	if !s.outbox {
+		return fn(s)
+	}
+	return s.transact(ctx, fn)
+}
+
+// record announces a change to todo id in the outbox and on the event bus,
+// whichever the service has. t is the todo after the change, or before a
+// deletion. Events on the bus name the members of the list of the todo, whose
+// streams get them too. Within a transaction, the event is only published on
+// the bus once the transaction commits.
+func (s *service) record(ctx context.Context, typ string, id uint32, t *Todo) error {
+	if s.events == nil && !s.outbox {
+		return nil
+	}
+
+	e := Event{Type: typ, TodoID: id, Time: s.now().UTC()}
+	e.WorkspaceID, _ = WorkspaceID(ctx)
+	if t != nil {
+		e.OwnerID = t.OwnerID
+		e.ListID = t.ListID
+		if typ != EventTodoDeleted {
+			e.Todo = t
+		}
+	}
+	if s.events != nil && s.shares != nil && e.ListID != 0 {
+		members, err := s.shares.Members(ctx, e.ListID)
+		if err != nil {
+			return err
+		}
+		for _, m := range members {
+			if m.UserID != e.OwnerID {
+				e.MemberIDs = append(e.MemberIDs, m.UserID)
+			}
+		}
+	}
+
+	if s.outbox {
+		if err := s.repo.Enqueue(ctx, e); err != nil {
+			return err
+		}
+	}
+	if s.events == nil {
+		return nil
+	}
+	if s.pending != nil {
+		*s.pending = append(*s.pending, e)
+		return nil
+	}
+	s.events.Publish(e)
+	return nil
+}
+
+// recordAffected records an event for each of the todos a write affected
+// besides the todo it was made to.
+func (s *service) recordAffected(ctx context.Context, a *Affected) error {
+	if a == nil {
+		return nil
+	}
+	for i := range a.Created {
+		if err := s.record(ctx, EventTodoCreated, a.Created[i].ID, &a.Created[i]); err != nil {
+			return err
+		}
+	}
+	for i := range a.Updated {
+		if err := s.record(ctx, EventTodoUpdated, a.Updated[i].ID, &a.Updated[i]); err != nil {
+			return err
+		}
+	}
+	for i := range a.Deleted {
+		if err := s.record(ctx, EventTodoDeleted, a.Deleted[i].ID, &a.Deleted[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *service) Search(ctx context.Context, p SearchParams) ([]SearchResult, error) {
+	if p.Limit <= 0 {
+		p.Limit = DefaultSearchLimit
+	} else if p.Limit > MaxLimit {
+		p.Limit = MaxLimit
+	}
+
+	results, err := s.searcher.Search(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		results[i].Snippet = highlight(results[i].Todo.Text, p.Query)
+	}
+
+	return results, nil
+}
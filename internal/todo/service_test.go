@@ -0,0 +1,913 @@
+package todo_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+type mockRepo struct {
+	listFn      func(context.Context, ListParams) ([]Todo, error)
+	countFn     func(context.Context, ListParams) (int, error)
+	listAfterFn func(context.Context, ListParams, Cursor) ([]Todo, error)
+	getFn       func(context.Context, uint32) (*Todo, error)
+	createFn    func(context.Context, TodoInput) (*Todo, error)
+	updateFn    func(context.Context, uint32, TodoInput) (*Todo, error)
+	deleteFn    func(context.Context, uint32, *uint32) error
+	restoreFn   func(context.Context, uint32) (*Todo, error)
+	delListFn   func(context.Context, uint32, uint32) ([]Todo, error)
+	purgeFn     func(context.Context, *time.Time) (int, error)
+	ancestorsFn func(context.Context, uint32) ([]uint32, error)
+	descendFn   func(context.Context, []uint32) ([]Todo, error)
+	changesFn   func(context.Context, ChangeToken, int, bool) ([]Change, error)
+	enqueueFn   func(context.Context, Event) error
+	transacted  int
+
+	// affected is what writes report to have affected besides their todo.
+	affected *Affected
+}
+
+var _ Repository = (*mockRepo)(nil)
+
+func (m *mockRepo) List(ctx context.Context, p ListParams) ([]Todo, error) {
+	if m.listFn != nil {
+		return m.listFn(ctx, p)
+	}
+	return []Todo{}, nil
+}
+
+func (m *mockRepo) Count(ctx context.Context, p ListParams) (int, error) {
+	if m.countFn != nil {
+		return m.countFn(ctx, p)
+	}
+	return 0, nil
+}
+
+func (m *mockRepo) ListAfter(ctx context.Context, p ListParams, c Cursor) ([]Todo, error) {
+	if m.listAfterFn != nil {
+		return m.listAfterFn(ctx, p, c)
+	}
+	return []Todo{}, nil
+}
+
+func (m *mockRepo) Get(ctx context.Context, id uint32) (*Todo, error) {
+	if m.getFn != nil {
+		return m.getFn(ctx, id)
+	}
+	return nil, ErrTodoNotFound
+}
+
+func (m *mockRepo) Create(ctx context.Context, in TodoInput) (*Todo, error) {
+	if m.createFn != nil {
+		return m.createFn(ctx, in)
+	}
+	return &Todo{}, nil
+}
+
+func (m *mockRepo) Update(ctx context.Context, id uint32, in TodoInput) (*Todo, *Affected, error) {
+	if m.updateFn != nil {
+		t, err := m.updateFn(ctx, id, in)
+		return t, m.affected, err
+	}
+	return &Todo{ID: id}, m.affected, nil
+}
+
+func (m *mockRepo) Delete(ctx context.Context, id uint32, version *uint32) (*Affected, error) {
+	if m.deleteFn != nil {
+		return m.affected, m.deleteFn(ctx, id, version)
+	}
+	return m.affected, nil
+}
+
+func (m *mockRepo) Restore(ctx context.Context, id uint32) (*Todo, *Affected, error) {
+	if m.restoreFn != nil {
+		t, err := m.restoreFn(ctx, id)
+		return t, m.affected, err
+	}
+	return &Todo{ID: id}, m.affected, nil
+}
+
+func (m *mockRepo) DeleteList(ctx context.Context, id, into uint32) ([]Todo, error) {
+	if m.delListFn != nil {
+		return m.delListFn(ctx, id, into)
+	}
+	return []Todo{}, nil
+}
+
+func (m *mockRepo) Purge(ctx context.Context, before *time.Time) (int, error) {
+	if m.purgeFn != nil {
+		return m.purgeFn(ctx, before)
+	}
+	return 0, nil
+}
+
+func (m *mockRepo) Ancestors(ctx context.Context, id uint32) ([]uint32, error) {
+	if m.ancestorsFn != nil {
+		return m.ancestorsFn(ctx, id)
+	}
+	return []uint32{id}, nil
+}
+
+func (m *mockRepo) Descendants(ctx context.Context, ids []uint32) ([]Todo, error) {
+	if m.descendFn != nil {
+		return m.descendFn(ctx, ids)
+	}
+	return []Todo{}, nil
+}
+
+func (m *mockRepo) Changes(ctx context.Context, after ChangeToken, limit int, tombstones bool) ([]Change, error) {
+	if m.changesFn != nil {
+		return m.changesFn(ctx, after, limit, tombstones)
+	}
+	return []Change{}, nil
+}
+
+func (m *mockRepo) Enqueue(ctx context.Context, e Event) error {
+	if m.enqueueFn != nil {
+		return m.enqueueFn(ctx, e)
+	}
+	return nil
+}
+
+// Transact runs fn against the mock itself, counting the transactions.
+func (m *mockRepo) Transact(ctx context.Context, fn func(Repository) error) error {
+	m.transacted++
+	return fn(m)
+}
+
+// todoRange returns todos with consecutive ids in [from, to].
+func todoRange(from, to uint32) []Todo {
+	todos := []Todo{}
+	for id := from; id <= to; id++ {
+		todos = append(todos, Todo{ID: id})
+	}
+	return todos
+}
+
+var _ = Describe("service", Label("service"), func() {
+	var (
+		ctx  context.Context
+		repo *mockRepo
+		svc  Service
+		now  time.Time
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		repo = &mockRepo{}
+		// Wednesday, 23:30 in UTC but already Thursday in Berlin.
+		now = time.Date(2025, 9, 17, 23, 30, 0, 0, time.UTC)
+		svc = NewService(repo, WithCursorSecret([]byte("test secret")), WithClock(func() time.Time { return now }))
+	})
+
+	Describe("GetAll", Label("get-all"), func() {
+		byID := Sort{Field: SortByID}
+
+		It("applies the default limit and reports the total", func() {
+			repo.listFn = func(ctx context.Context, p ListParams) ([]Todo, error) {
+				Expect(p.Limit).To(Equal(DefaultLimit + 1))
+				return todoRange(1, 3), nil
+			}
+			repo.countFn = func(ctx context.Context, p ListParams) (int, error) { return 3, nil }
+
+			page, err := svc.GetAll(ctx, ListParams{Sort: byID})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(page.Todos).To(HaveLen(3))
+			Expect(page.Total).To(Equal(3))
+			Expect(page.NextCursor).To(BeEmpty())
+			Expect(page.PrevCursor).To(BeEmpty())
+		})
+
+		It("clamps the limit", func() {
+			repo.listFn = func(ctx context.Context, p ListParams) ([]Todo, error) {
+				Expect(p.Limit).To(Equal(MaxLimit + 1))
+				return []Todo{}, nil
+			}
+
+			_, err := svc.GetAll(ctx, ListParams{Sort: byID, Limit: MaxLimit * 2})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("pages forward and backward with cursors", func() {
+			repo.listFn = func(ctx context.Context, p ListParams) ([]Todo, error) { return todoRange(1, 3), nil }
+			page, err := svc.GetAll(ctx, ListParams{Sort: byID, Limit: 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(page.Todos).To(Equal(todoRange(1, 2)))
+			Expect(page.PrevCursor).To(BeEmpty())
+			Expect(page.NextCursor).NotTo(BeEmpty())
+
+			repo.listAfterFn = func(ctx context.Context, p ListParams, c Cursor) ([]Todo, error) {
+				Expect(c).To(Equal(Cursor{Sort: byID, ID: 2}))
+				Expect(p.Limit).To(Equal(3))
+				return todoRange(3, 4), nil
+			}
+			page, err = svc.GetAll(ctx, ListParams{Sort: byID, Limit: 2, Cursor: page.NextCursor})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(page.Todos).To(Equal(todoRange(3, 4)))
+			Expect(page.NextCursor).To(BeEmpty())
+			Expect(page.PrevCursor).NotTo(BeEmpty())
+
+			repo.listAfterFn = func(ctx context.Context, p ListParams, c Cursor) ([]Todo, error) {
+				Expect(c).To(Equal(Cursor{Sort: byID, ID: 3, Backward: true}))
+				return todoRange(0, 2), nil
+			}
+			page, err = svc.GetAll(ctx, ListParams{Sort: byID, Limit: 2, Cursor: page.PrevCursor})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(page.Todos).To(Equal(todoRange(1, 2)))
+			Expect(page.NextCursor).NotTo(BeEmpty())
+			Expect(page.PrevCursor).NotTo(BeEmpty())
+		})
+
+		It("doesn't hand out cursors for priority ordering", func() {
+			repo.listFn = func(ctx context.Context, p ListParams) ([]Todo, error) { return todoRange(1, 3), nil }
+
+			page, err := svc.GetAll(ctx, ListParams{Sort: Sort{Field: SortByPriority}, Limit: 2, Offset: 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(page.Todos).To(HaveLen(2))
+			Expect(page.NextCursor).To(BeEmpty())
+			Expect(page.PrevCursor).To(BeEmpty())
+		})
+
+		It("rejects tampered cursors", func() {
+			repo.listFn = func(ctx context.Context, p ListParams) ([]Todo, error) { return todoRange(1, 3), nil }
+			page, err := svc.GetAll(ctx, ListParams{Sort: byID, Limit: 2})
+			Expect(err).NotTo(HaveOccurred())
+
+			payload, sig, _ := strings.Cut(page.NextCursor, ".")
+			forged := strings.ToUpper(payload[:1]) + strings.ToLower(payload[1:]) + "." + sig
+			_, err = svc.GetAll(ctx, ListParams{Sort: byID, Limit: 2, Cursor: forged})
+			Expect(err).To(MatchError(ErrBadCursor))
+
+			_, err = svc.GetAll(ctx, ListParams{Sort: byID, Limit: 2, Cursor: "garbage"})
+			Expect(err).To(MatchError(ErrBadCursor))
+		})
+
+		It("rejects cursors signed with another secret", func() {
+			repo.listFn = func(ctx context.Context, p ListParams) ([]Todo, error) { return todoRange(1, 3), nil }
+			page, err := NewService(repo, WithCursorSecret([]byte("other"))).GetAll(ctx, ListParams{Sort: byID, Limit: 2})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = svc.GetAll(ctx, ListParams{Sort: byID, Limit: 2, Cursor: page.NextCursor})
+			Expect(err).To(MatchError(ErrBadCursor))
+		})
+
+		It("rejects cursors issued for another sort", func() {
+			repo.listFn = func(ctx context.Context, p ListParams) ([]Todo, error) { return todoRange(1, 3), nil }
+			page, err := svc.GetAll(ctx, ListParams{Sort: byID, Limit: 2})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = svc.GetAll(ctx, ListParams{Sort: Sort{Field: SortByCreatedAt}, Limit: 2, Cursor: page.NextCursor})
+			Expect(err).To(MatchError(ErrBadCursor))
+		})
+
+		Describe("due date windows", func() {
+			var got ListParams
+
+			BeforeEach(func() {
+				repo.listFn = func(ctx context.Context, p ListParams) ([]Todo, error) {
+					got = p
+					return []Todo{}, nil
+				}
+			})
+
+			It("limits overdue todos to incomplete ones due before now", func() {
+				_, err := svc.GetAll(ctx, ListParams{Due: DueOverdue})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(got.DueFrom).To(BeNil())
+				Expect(got.DueBefore).To(PointTo(BeTemporally("==", now)))
+				Expect(got.Completed).To(PointTo(BeFalse()))
+			})
+
+			It("computes today in UTC by default", func() {
+				_, err := svc.GetAll(ctx, ListParams{Due: DueToday})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(got.DueFrom).To(PointTo(BeTemporally("==", time.Date(2025, 9, 17, 0, 0, 0, 0, time.UTC))))
+				Expect(got.DueBefore).To(PointTo(BeTemporally("==", time.Date(2025, 9, 18, 0, 0, 0, 0, time.UTC))))
+				Expect(got.Completed).To(BeNil())
+			})
+
+			It("computes today in the client's time zone", func() {
+				berlin, err := time.LoadLocation("Europe/Berlin")
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = svc.GetAll(ctx, ListParams{Due: DueToday, Location: berlin})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(got.DueFrom).To(PointTo(BeTemporally("==", time.Date(2025, 9, 18, 0, 0, 0, 0, berlin))))
+				Expect(got.DueBefore).To(PointTo(BeTemporally("==", time.Date(2025, 9, 19, 0, 0, 0, 0, berlin))))
+			})
+
+			It("bounds date-only due dates by the dates of the client's day", func() {
+				la, err := time.LoadLocation("America/Los_Angeles")
+				Expect(err).NotTo(HaveOccurred())
+
+				// A todo due on the 17th is stored as midnight UTC, hours
+				// before the day starts in Los Angeles.
+				_, err = svc.GetAll(ctx, ListParams{Due: DueToday, Location: la})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(got.DueFrom).To(PointTo(BeTemporally("==", time.Date(2025, 9, 17, 0, 0, 0, 0, la))))
+				Expect(got.DueBefore).To(PointTo(BeTemporally("==", time.Date(2025, 9, 18, 0, 0, 0, 0, la))))
+				Expect(got.DueDateFrom).To(PointTo(Equal(time.Date(2025, 9, 17, 0, 0, 0, 0, time.UTC))))
+				Expect(got.DueDateBefore).To(PointTo(Equal(time.Date(2025, 9, 18, 0, 0, 0, 0, time.UTC))))
+
+				_, err = svc.GetAll(ctx, ListParams{Due: DueOverdue, Location: la})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(got.DueBefore).To(PointTo(BeTemporally("==", now)))
+				Expect(got.DueDateBefore).To(PointTo(Equal(time.Date(2025, 9, 17, 0, 0, 0, 0, time.UTC))))
+			})
+
+			It("computes this week from Monday", func() {
+				_, err := svc.GetAll(ctx, ListParams{Due: DueThisWeek})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(got.DueFrom).To(PointTo(BeTemporally("==", time.Date(2025, 9, 15, 0, 0, 0, 0, time.UTC))))
+				Expect(got.DueBefore).To(PointTo(BeTemporally("==", time.Date(2025, 9, 22, 0, 0, 0, 0, time.UTC))))
+			})
+		})
+	})
+
+	Describe("validation", Label("validation"), func() {
+		BeforeEach(func() {
+			repo.createFn = func(ctx context.Context, in TodoInput) (*Todo, error) {
+				Fail("repository should not be called")
+				return nil, nil
+			}
+		})
+
+		It("rejects empty text", func() {
+			text := ""
+
+			_, err := svc.Create(ctx, TodoInput{Text: &text})
+			Expect(err).To(MatchError(ErrTextEmpty))
+			Expect(err).To(MatchError(ErrInputInvalid))
+		})
+
+		It("rejects overlong text", func() {
+			text := strings.Repeat("ü", DefaultTextRules.MaxLength+1)
+
+			_, err := svc.Create(ctx, TodoInput{Text: &text})
+			Expect(err).To(MatchError(ErrTodoTooLong))
+		})
+
+		It("accepts text of the maximum length in characters", func() {
+			text := strings.Repeat("ü", DefaultTextRules.MaxLength)
+			repo.createFn = func(ctx context.Context, in TodoInput) (*Todo, error) {
+				return &Todo{ID: 1, Text: *in.Text}, nil
+			}
+
+			_, err := svc.Create(ctx, TodoInput{Text: &text})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("counts length in grapheme clusters", func() {
+			text := strings.Repeat("👍🏽", DefaultTextRules.MaxLength)
+			repo.createFn = func(ctx context.Context, in TodoInput) (*Todo, error) {
+				return &Todo{ID: 1, Text: *in.Text}, nil
+			}
+
+			_, err := svc.Create(ctx, TodoInput{Text: &text})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("trims and normalizes text before storing it", func() {
+			text := "  cafe\u0301 \n"
+			repo.createFn = func(ctx context.Context, in TodoInput) (*Todo, error) {
+				Expect(*in.Text).To(Equal("café"))
+				return &Todo{ID: 1, Text: *in.Text}, nil
+			}
+
+			got, err := svc.Create(ctx, TodoInput{Text: &text})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.Text).To(Equal("café"))
+		})
+
+		It("rejects whitespace-only text as empty", func() {
+			text := " \t\n "
+
+			_, err := svc.Create(ctx, TodoInput{Text: &text})
+			Expect(err).To(MatchError(ErrTextEmpty))
+		})
+
+		It("rejects control characters but allows line breaks and tabs", func() {
+			text := "buy\x00milk"
+
+			_, err := svc.Create(ctx, TodoInput{Text: &text})
+			Expect(err).To(MatchError(ErrTextControlChars))
+
+			text = "buy\tmilk\r\nand eggs"
+			repo.createFn = func(ctx context.Context, in TodoInput) (*Todo, error) {
+				return &Todo{ID: 1, Text: *in.Text}, nil
+			}
+			_, err = svc.Create(ctx, TodoInput{Text: &text})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("applies the configured text rules", func() {
+			svc := NewService(repo, WithTextRules(TextRules{MinLength: 3, MaxLength: 5}))
+
+			text := "ab"
+			_, err := svc.Create(ctx, TodoInput{Text: &text})
+			Expect(err).To(MatchError(ErrTodoTooShort))
+
+			text = "abcdef"
+			_, err = svc.Create(ctx, TodoInput{Text: &text})
+			Expect(err).To(MatchError(ErrTodoTooLong))
+		})
+
+		It("reports every violation at once", func() {
+			text := ""
+			p := Priority("critical")
+
+			_, err := svc.Update(ctx, 3, TodoInput{Text: &text, Priority: &p})
+			var ve *ValidationError
+			Expect(errors.As(err, &ve)).To(BeTrue())
+			Expect(ve.Errors).To(HaveLen(2))
+			Expect(ve.Errors[0]).To(MatchFields(IgnoreExtras, Fields{"Field": Equal("text"), "Rule": Equal("text_empty")}))
+			Expect(ve.Errors[1]).To(MatchFields(IgnoreExtras, Fields{"Field": Equal("priority"), "Rule": Equal("priority_invalid")}))
+		})
+	})
+
+	Describe("Create", Label("create"), func() {
+		It("rejects a start date after the due date", func() {
+			text := "file taxes"
+			start := DateTime{Time: now}
+			due := DateTime{Time: now.Add(-time.Hour)}
+			repo.createFn = func(ctx context.Context, in TodoInput) (*Todo, error) {
+				Fail("repository should not be called")
+				return nil, nil
+			}
+
+			t, err := svc.Create(ctx, TodoInput{Text: &text, StartAt: &start, DueAt: &due})
+			Expect(err).To(MatchError(ErrStartAfterDue))
+			Expect(t).To(BeNil())
+		})
+
+		It("rejects unknown priorities", func() {
+			text := "file taxes"
+			p := Priority("P1")
+
+			t, err := svc.Create(ctx, TodoInput{Text: &text, Priority: &p})
+			Expect(err).To(MatchError(ErrPriorityInvalid))
+			Expect(t).To(BeNil())
+		})
+
+		It("accepts a start date equal to the due date", func() {
+			text := "file taxes"
+			at := DateTime{Time: now}
+
+			_, err := svc.Create(ctx, TodoInput{Text: &text, StartAt: &at, DueAt: &at})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("files todos without a list in the default list", func() {
+			text := "file taxes"
+			repo.createFn = func(ctx context.Context, in TodoInput) (*Todo, error) {
+				Expect(in.ListID).To(PointTo(Equal(DefaultListID)))
+				return &Todo{}, nil
+			}
+
+			_, err := svc.Create(ctx, TodoInput{Text: &text})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("files todos without a list in the configured default list", func() {
+			text := "file taxes"
+			repo.createFn = func(ctx context.Context, in TodoInput) (*Todo, error) {
+				Expect(in.ListID).To(PointTo(BeEquivalentTo(9)))
+				return &Todo{}, nil
+			}
+
+			_, err := NewService(repo, WithDefaultList(9)).Create(ctx, TodoInput{Text: &text})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("keeps the given list", func() {
+			text := "file taxes"
+			list := uint32(4)
+			repo.createFn = func(ctx context.Context, in TodoInput) (*Todo, error) {
+				Expect(in.ListID).To(PointTo(BeEquivalentTo(4)))
+				return &Todo{}, nil
+			}
+
+			_, err := svc.Create(ctx, TodoInput{Text: &text, ListID: &list})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Describe("subtasks", Label("subtasks"), func() {
+		var parent uint32
+
+		BeforeEach(func() {
+			parent = 2
+			repo.getFn = func(ctx context.Context, id uint32) (*Todo, error) {
+				return &Todo{ID: id, ListID: 6}, nil
+			}
+		})
+
+		It("files subtasks in their parent's list", func() {
+			text := "pack books"
+			repo.createFn = func(ctx context.Context, in TodoInput) (*Todo, error) {
+				Expect(in.ParentID).To(PointTo(Equal(parent)))
+				Expect(in.ListID).To(PointTo(BeEquivalentTo(6)))
+				return &Todo{}, nil
+			}
+
+			_, err := svc.Create(ctx, TodoInput{Text: &text, ParentID: &parent})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("rejects missing parents", func() {
+			text := "pack books"
+			repo.getFn = nil
+
+			_, err := svc.Create(ctx, TodoInput{Text: &text, ParentID: &parent})
+			Expect(err).To(MatchError(ErrParentNotFound))
+		})
+
+		It("rejects subtasks beyond the maximum depth", func() {
+			text := "pack books"
+			repo.ancestorsFn = func(ctx context.Context, id uint32) ([]uint32, error) {
+				return []uint32{id, 1}, nil
+			}
+
+			_, err := NewService(repo, WithMaxDepth(3)).Create(ctx, TodoInput{Text: &text, ParentID: &parent})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = NewService(repo, WithMaxDepth(2)).Create(ctx, TodoInput{Text: &text, ParentID: &parent})
+			Expect(err).To(MatchError(ErrDepthExceeded))
+		})
+
+		It("rejects a todo as its own parent", func() {
+			_, err := svc.Update(ctx, parent, TodoInput{ParentID: &parent})
+			Expect(err).To(MatchError(ErrParentCycle))
+		})
+
+		It("rejects moving a todo under one of its descendants", func() {
+			repo.ancestorsFn = func(ctx context.Context, id uint32) ([]uint32, error) {
+				return []uint32{id, 9, 1}, nil
+			}
+			repo.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+				Fail("repository should not be called")
+				return nil, nil
+			}
+
+			_, err := svc.Update(ctx, 9, TodoInput{ParentID: &parent})
+			Expect(err).To(MatchError(ErrParentCycle))
+		})
+
+		It("counts the subtree being moved against the maximum depth", func() {
+			one, four := uint32(1), uint32(4)
+			repo.descendFn = func(ctx context.Context, ids []uint32) ([]Todo, error) {
+				Expect(ids).To(Equal([]uint32{1}))
+				return []Todo{{ID: 4, ParentID: &one}, {ID: 5, ParentID: &four}}, nil
+			}
+
+			// The parent is a root and the subtree spans three levels.
+			_, err := NewService(repo, WithMaxDepth(4)).Update(ctx, 1, TodoInput{ParentID: &parent})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = NewService(repo, WithMaxDepth(3)).Update(ctx, 1, TodoInput{ParentID: &parent})
+			Expect(err).To(MatchError(ErrDepthExceeded))
+		})
+
+		It("doesn't check anything when detaching a todo", func() {
+			zero := uint32(0)
+			repo.getFn = func(ctx context.Context, id uint32) (*Todo, error) {
+				Fail("repository should not be called")
+				return nil, nil
+			}
+
+			_, err := svc.Update(ctx, 4, TodoInput{ParentID: &zero})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("expands the subtrees of todos", func() {
+			one, three := uint32(1), uint32(3)
+			repo.descendFn = func(ctx context.Context, ids []uint32) ([]Todo, error) {
+				return []Todo{{ID: 3, ParentID: &one}, {ID: 4, ParentID: &three}, {ID: 5, ParentID: &one}}, nil
+			}
+
+			todos := []Todo{{ID: 1}, {ID: 2}}
+			Expect(svc.ExpandChildren(ctx, todos)).To(Succeed())
+			Expect(todos[0].Children).To(HaveLen(2))
+			Expect(todos[0].Children[0].ID).To(BeEquivalentTo(3))
+			Expect(todos[0].Children[0].Children).To(ConsistOf(HaveField("ID", BeEquivalentTo(4))))
+			Expect(todos[0].Children[1].ID).To(BeEquivalentTo(5))
+			Expect(todos[1].Children).To(BeEmpty())
+		})
+
+		It("expands listings on request", func() {
+			repo.listFn = func(ctx context.Context, p ListParams) ([]Todo, error) { return todoRange(1, 1), nil }
+			one := uint32(1)
+			repo.descendFn = func(ctx context.Context, ids []uint32) ([]Todo, error) {
+				Expect(ids).To(Equal([]uint32{1}))
+				return []Todo{{ID: 2, ParentID: &one}}, nil
+			}
+
+			page, err := svc.GetAll(ctx, ListParams{Expand: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(page.Todos[0].Children).To(HaveLen(1))
+		})
+	})
+
+	Describe("Update", Label("update"), func() {
+		It("rejects unknown priorities", func() {
+			p := Priority("critical")
+
+			t, err := svc.Update(ctx, 3, TodoInput{Priority: &p})
+			Expect(err).To(MatchError(ErrPriorityInvalid))
+			Expect(t).To(BeNil())
+		})
+
+		It("checks a new start date against the stored due date", func() {
+			due := now
+			repo.getFn = func(ctx context.Context, id uint32) (*Todo, error) {
+				return &Todo{ID: id, DueAt: &due}, nil
+			}
+			start := DateTime{Time: now.Add(time.Hour)}
+
+			_, err := svc.Update(ctx, 3, TodoInput{StartAt: &start})
+			Expect(err).To(MatchError(ErrStartAfterDue))
+		})
+
+		It("doesn't check a new start date against a due date being cleared", func() {
+			due := now
+			repo.getFn = func(ctx context.Context, id uint32) (*Todo, error) {
+				return &Todo{ID: id, DueAt: &due}, nil
+			}
+			start := DateTime{Time: now.Add(time.Hour)}
+
+			_, err := svc.Update(ctx, 3, TodoInput{StartAt: &start, DueAt: &DateTime{}})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("doesn't look up the todo when the schedule is untouched", func() {
+			text := "file taxes"
+			repo.getFn = func(ctx context.Context, id uint32) (*Todo, error) {
+				Fail("repository Get should not be called")
+				return nil, nil
+			}
+
+			t, err := svc.Update(ctx, 3, TodoInput{Text: &text})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t.ID).To(BeEquivalentTo(3))
+		})
+
+		It("propagates lookup errors", func() {
+			start := DateTime{Time: now}
+
+			_, err := svc.Update(ctx, 3, TodoInput{StartAt: &start})
+			Expect(err).To(MatchError(ErrTodoNotFound))
+		})
+	})
+
+	Describe("trash", Label("trash"), func() {
+		It("restores todos", func() {
+			repo.restoreFn = func(ctx context.Context, id uint32) (*Todo, error) { return nil, ErrParentTrashed }
+
+			t, err := svc.Restore(ctx, 4)
+			Expect(err).To(MatchError(ErrParentTrashed))
+			Expect(t).To(BeNil())
+		})
+
+		It("empties the whole trash", func() {
+			repo.purgeFn = func(ctx context.Context, before *time.Time) (int, error) {
+				Expect(before).To(BeNil())
+				return 3, nil
+			}
+
+			Expect(svc.EmptyTrash(ctx)).To(Succeed())
+		})
+
+		It("purges todos trashed for longer than the retention period", func() {
+			repo.purgeFn = func(ctx context.Context, before *time.Time) (int, error) {
+				Expect(before).To(PointTo(BeTemporally("~", time.Now().Add(-48*time.Hour), time.Second)))
+				return 2, nil
+			}
+
+			n, err := NewPurger(repo, 48*time.Hour).Purge(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n).To(Equal(2))
+		})
+
+		It("falls back to the default retention period", func() {
+			repo.purgeFn = func(ctx context.Context, before *time.Time) (int, error) {
+				Expect(before).To(PointTo(BeTemporally("~", time.Now().Add(-DefaultTrashRetention), time.Second)))
+				return 0, nil
+			}
+
+			_, err := NewPurger(repo, 0).Purge(ctx)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Describe("recurrence", Label("recurrence"), func() {
+		var (
+			cur       *Todo
+			completed bool
+		)
+
+		BeforeEach(func() {
+			// Weekly on Mondays and Thursdays, due Thursday with a one hour
+			// lead time.
+			rule := "FREQ=WEEKLY;BYDAY=MO,TH"
+			series := uint32(3)
+			start := time.Date(2025, 9, 18, 8, 0, 0, 0, time.UTC)
+			due := start.Add(time.Hour)
+			cur = &Todo{ID: 7, Text: "water plants", Priority: PriorityLow, ListID: 2, Recurrence: &rule, SeriesID: &series, Occurrence: 4, StartAt: &start, DueAt: &due, Tags: []string{"home"}}
+			repo.getFn = func(ctx context.Context, id uint32) (*Todo, error) { return cur, nil }
+			completed = true
+		})
+
+		It("canonicalizes rules of new todos", func() {
+			text, rule := "water plants", "freq=weekly;byday=mo"
+			repo.createFn = func(ctx context.Context, in TodoInput) (*Todo, error) {
+				Expect(in.Recurrence).To(PointTo(Equal("FREQ=WEEKLY;BYDAY=MO")))
+				return &Todo{}, nil
+			}
+
+			_, err := svc.Create(ctx, TodoInput{Text: &text, Recurrence: &rule})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("rejects invalid rules", func() {
+			rule := "FREQ=HOURLY"
+
+			_, err := svc.Update(ctx, 7, TodoInput{Recurrence: &rule})
+			Expect(err).To(MatchError(ErrRecurrenceInvalid))
+		})
+
+		It("creates the next occurrence on completion", func() {
+			repo.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+				Expect(in.Next).NotTo(BeNil())
+				next := in.Next
+				Expect(next.Completed).To(PointTo(BeFalse()))
+				Expect(next.Text).To(PointTo(Equal("water plants")))
+				Expect(next.Priority).To(PointTo(Equal(PriorityLow)))
+				Expect(next.ListID).To(PointTo(BeEquivalentTo(2)))
+				Expect(next.Tags).To(PointTo(Equal([]string{"home"})))
+				Expect(next.Recurrence).To(PointTo(Equal("FREQ=WEEKLY;BYDAY=MO,TH")))
+				Expect(next.SeriesID).To(PointTo(BeEquivalentTo(3)))
+				Expect(next.Occurrence).To(Equal(5))
+				Expect(next.DueAt.Time).To(Equal(time.Date(2025, 9, 22, 9, 0, 0, 0, time.UTC)))
+				Expect(next.StartAt.Time).To(Equal(time.Date(2025, 9, 22, 8, 0, 0, 0, time.UTC)))
+				return &Todo{ID: id, Completed: true}, nil
+			}
+
+			_, err := svc.Update(ctx, 7, TodoInput{Completed: &completed})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("carries the changes of the completing update over", func() {
+			text := "water all plants"
+			tags := []string{" garden ", "garden"}
+			repo.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+				Expect(in.Next.Text).To(PointTo(Equal(text)))
+				Expect(in.Next.Tags).To(PointTo(Equal([]string{"garden"})))
+				return &Todo{ID: id}, nil
+			}
+
+			_, err := svc.Update(ctx, 7, TodoInput{Completed: &completed, Text: &text, Tags: &tags})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("recurs undated todos from the time they are completed", func() {
+			cur.StartAt, cur.DueAt = nil, nil
+			repo.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+				// now is a Wednesday.
+				Expect(in.Next.DueAt.Time).To(Equal(now.AddDate(0, 0, 1)))
+				Expect(in.Next.StartAt).To(BeNil())
+				return &Todo{ID: id}, nil
+			}
+
+			_, err := svc.Update(ctx, 7, TodoInput{Completed: &completed})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		DescribeTable("doesn't create an occurrence",
+			func(change func(*Todo, *TodoInput)) {
+				in := TodoInput{Completed: &completed}
+				change(cur, &in)
+				repo.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+					Expect(in.Next).To(BeNil())
+					return &Todo{ID: id}, nil
+				}
+
+				_, err := svc.Update(ctx, 7, in)
+				Expect(err).NotTo(HaveOccurred())
+			},
+			Entry("for a todo that doesn't recur", func(t *Todo, in *TodoInput) { t.Recurrence = nil }),
+			Entry("for a todo that is already completed", func(t *Todo, in *TodoInput) { t.Completed = true }),
+			Entry("when reopening a todo", func(t *Todo, in *TodoInput) { *in.Completed = false }),
+			Entry("when the update stops the recurrence", func(t *Todo, in *TodoInput) {
+				stop := ""
+				in.Recurrence = &stop
+			}),
+			Entry("after the last occurrence", func(t *Todo, in *TodoInput) {
+				rule := "FREQ=DAILY;COUNT=4"
+				t.Recurrence = &rule
+			}),
+			Entry("after the end of the rule", func(t *Todo, in *TodoInput) {
+				rule := "FREQ=DAILY;UNTIL=20250918"
+				t.Recurrence = &rule
+			}),
+		)
+	})
+
+	Describe("bulk", Label("bulk"), func() {
+		var (
+			text string
+			done bool
+		)
+
+		BeforeEach(func() {
+			text, done = "buy milk", true
+			repo.getFn = func(ctx context.Context, id uint32) (*Todo, error) { return &Todo{ID: id}, nil }
+		})
+
+		It("runs every operation within one transaction", func() {
+			version := uint32(3)
+			repo.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+				Expect(in.Version).To(PointTo(Equal(version)))
+				return &Todo{ID: id, Completed: true}, nil
+			}
+			repo.deleteFn = func(ctx context.Context, id uint32, v *uint32) error {
+				Expect(v).To(BeNil())
+				return ErrTodoNotFound
+			}
+
+			results, err := svc.Bulk(ctx, []BulkOp{
+				{Op: BulkCreate, Todo: TodoInput{Text: &text}},
+				{Op: BulkUpdate, ID: 4, Version: &version, Todo: TodoInput{Completed: &done}},
+				{Op: BulkDelete, ID: 5},
+			}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(repo.transacted).To(Equal(1))
+			Expect(results).To(HaveLen(3))
+			Expect(results[0].Err).NotTo(HaveOccurred())
+			Expect(results[1].Todo.Completed).To(BeTrue())
+			Expect(results[2].Err).To(MatchError(ErrTodoNotFound))
+		})
+
+		It("stops at the first failed operation when atomic", func() {
+			bad := Priority("asap")
+			repo.deleteFn = func(ctx context.Context, id uint32, v *uint32) error {
+				Fail("operations after a failure should not run")
+				return nil
+			}
+
+			_, err := svc.Bulk(ctx, []BulkOp{
+				{Op: BulkCreate, Todo: TodoInput{Text: &text}},
+				{Op: BulkUpdate, ID: 4, Todo: TodoInput{Priority: &bad}},
+				{Op: BulkDelete, ID: 5},
+			}, true)
+			var be *BulkError
+			Expect(errors.As(err, &be)).To(BeTrue())
+			Expect(be.Index).To(Equal(1))
+			Expect(err).To(MatchError(ErrPriorityInvalid))
+		})
+
+		It("completes all matching todos", func() {
+			list := uint32(2)
+			repo.listFn = func(ctx context.Context, p ListParams) ([]Todo, error) {
+				Expect(p.ListID).To(PointTo(Equal(list)))
+				Expect(p.Completed).To(PointTo(BeFalse()))
+				Expect(p.Limit).To(BeZero())
+				return todoRange(1, 3), nil
+			}
+			var updated []uint32
+			repo.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+				Expect(in.Completed).To(PointTo(BeTrue()))
+				updated = append(updated, id)
+				return &Todo{ID: id}, nil
+			}
+
+			n, err := svc.CompleteAll(ctx, ListParams{ListID: &list, Limit: 10})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n).To(Equal(3))
+			Expect(updated).To(Equal([]uint32{1, 2, 3}))
+		})
+
+		It("trashes all matching todos, skipping those trashed with an ancestor", func() {
+			repo.listFn = func(ctx context.Context, p ListParams) ([]Todo, error) {
+				Expect(p.Completed).To(PointTo(BeTrue()))
+				return todoRange(1, 3), nil
+			}
+			repo.deleteFn = func(ctx context.Context, id uint32, v *uint32) error {
+				if id == 2 {
+					return ErrTodoNotFound
+				}
+				return nil
+			}
+
+			n, err := svc.DeleteAll(ctx, ListParams{Completed: &done})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n).To(Equal(2))
+		})
+	})
+})
@@ -0,0 +1,236 @@
+package todo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ShareHandler serves the members of lists and the invitations to them. Its
+// routes declare no scope, so API tokens can't be used to share lists.
+type ShareHandler struct{ svc ShareService }
+
+func NewShareHandler(s ShareService) *ShareHandler {
+	return &ShareHandler{svc: s}
+}
+
+func (h *ShareHandler) Register(r gin.IRoutes) {
+	r.GET("/lists/:id/members", h.getMembers)
+	r.PUT("/lists/:id/members/:user_id", h.putMember)
+	r.DELETE("/lists/:id/members/:user_id", h.deleteMember)
+	r.POST("/lists/:id/invitations", h.postInvitation)
+	r.GET("/invitations", h.getInvitations)
+	r.POST("/invitations/:id/accept", h.accept)
+	r.POST("/invitations/:id/decline", h.decline)
+}
+
+// memberInput is the body of PUT /lists/:id/members/:user_id.
+type memberInput struct {
+	Role *Role `json:"role"`
+}
+
+func (h *ShareHandler) getMembers(ctx *gin.Context) {
+	id, ok := pathID(ctx, "id")
+	if !ok {
+		return
+	}
+
+	c := ctx.Request.Context()
+	members, err := h.svc.Members(c, id)
+	if err != nil {
+		writeShareError(ctx, err, id)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, members)
+}
+
+func (h *ShareHandler) putMember(ctx *gin.Context) {
+	if ctx.ContentType() != "application/json" {
+		r := NewErrorResponse(ErrUnsupportedMediaType.Error(), "Content-Type must be application/json")
+		writeError(ctx, http.StatusUnsupportedMediaType, r)
+		return
+	}
+
+	id, ok := pathID(ctx, "id")
+	if !ok {
+		return
+	}
+	userID, ok := pathID(ctx, "user_id")
+	if !ok {
+		return
+	}
+
+	var in memberInput
+	err := decodeIntoInput(ctx, &in)
+	if err != nil {
+		r := NewErrorResponse(ErrBadJson.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	if in.Role == nil {
+		r := NewErrorResponse(ErrBadJson.Error(), "missing required `role` field")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	m, err := h.svc.SetRole(c, id, userID, *in.Role)
+	if err != nil {
+		writeShareError(ctx, err, id)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, m)
+}
+
+func (h *ShareHandler) deleteMember(ctx *gin.Context) {
+	id, ok := pathID(ctx, "id")
+	if !ok {
+		return
+	}
+	userID, ok := pathID(ctx, "user_id")
+	if !ok {
+		return
+	}
+
+	c := ctx.Request.Context()
+	err := h.svc.RemoveMember(c, id, userID)
+	if err != nil {
+		writeShareError(ctx, err, id)
+		return
+	}
+
+	ctx.JSON(http.StatusNoContent, nil)
+}
+
+func (h *ShareHandler) postInvitation(ctx *gin.Context) {
+	if ctx.ContentType() != "application/json" {
+		r := NewErrorResponse(ErrUnsupportedMediaType.Error(), "Content-Type must be application/json")
+		writeError(ctx, http.StatusUnsupportedMediaType, r)
+		return
+	}
+
+	id, ok := pathID(ctx, "id")
+	if !ok {
+		return
+	}
+
+	var newInvitation InvitationInput
+	err := decodeIntoInput(ctx, &newInvitation)
+	if err != nil {
+		r := NewErrorResponse(ErrBadJson.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	inv, err := h.svc.Invite(c, id, newInvitation)
+	if err != nil {
+		writeShareError(ctx, err, id)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, inv)
+}
+
+func (h *ShareHandler) getInvitations(ctx *gin.Context) {
+	c := ctx.Request.Context()
+
+	invitations, err := h.svc.Invitations(c)
+	if err != nil {
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, invitations)
+}
+
+func (h *ShareHandler) accept(ctx *gin.Context) {
+	id, ok := pathID(ctx, "id")
+	if !ok {
+		return
+	}
+
+	c := ctx.Request.Context()
+	err := h.svc.Accept(c, id)
+	if err != nil {
+		writeShareError(ctx, err, id)
+		return
+	}
+
+	ctx.JSON(http.StatusNoContent, nil)
+}
+
+func (h *ShareHandler) decline(ctx *gin.Context) {
+	id, ok := pathID(ctx, "id")
+	if !ok {
+		return
+	}
+
+	c := ctx.Request.Context()
+	err := h.svc.Decline(c, id)
+	if err != nil {
+		writeShareError(ctx, err, id)
+		return
+	}
+
+	ctx.JSON(http.StatusNoContent, nil)
+}
+
+// pathID parses the ID in path parameter name. If it isn't one, it writes the
+// error response and returns false.
+func pathID(ctx *gin.Context, name string) (uint32, bool) {
+	id, err := strconv.ParseUint(ctx.Param(name), 10, 32)
+	if err != nil {
+		r := NewErrorResponse(ErrBadId.Error(), "ID must be an integer")
+		writeError(ctx, http.StatusBadRequest, r)
+		return 0, false
+	}
+	return uint32(id), true
+}
+
+// writeShareError writes the response reporting err, the error of an
+// operation on the list or invitation with ID id.
+func writeShareError(ctx *gin.Context, err error, id uint32) {
+	if e := inputError(err); e != nil {
+		r := newInputErrorResponse(e, err)
+		writeError(ctx, http.StatusUnprocessableEntity, r)
+		return
+	}
+	if errors.Is(err, ErrListNotFound) {
+		msg := fmt.Sprintf("No list found with ID = %d", id)
+		r := NewErrorResponse(ErrListNotFound.Error(), msg)
+		writeError(ctx, http.StatusNotFound, r)
+		return
+	}
+	if errors.Is(err, ErrInviteNotFound) {
+		msg := fmt.Sprintf("No invitation found with ID = %d", id)
+		r := NewErrorResponse(ErrInviteNotFound.Error(), msg)
+		writeError(ctx, http.StatusNotFound, r)
+		return
+	}
+	if errors.Is(err, ErrMemberNotFound) {
+		r := NewErrorResponse(ErrMemberNotFound.Error(), "The user isn't a member of the list")
+		writeError(ctx, http.StatusNotFound, r)
+		return
+	}
+	if errors.Is(err, ErrMemberExists) {
+		r := NewErrorResponse(ErrMemberExists.Error(), "The user is already a member of the list")
+		writeError(ctx, http.StatusConflict, r)
+		return
+	}
+	if errors.Is(err, ErrForbidden) {
+		msg := "The list isn't shared with the user, or their role on it doesn't allow this"
+		r := NewErrorResponse(ErrForbidden.Error(), msg)
+		writeError(ctx, http.StatusForbidden, r)
+		return
+	}
+	r := NewErrorResponse(ErrUnexpected.Error(), "")
+	writeError(ctx, http.StatusInternalServerError, r)
+}
@@ -0,0 +1,281 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const (
+	listMembersTable     = "list_members"
+	listInvitationsTable = "list_invitations"
+)
+
+// invitationColumns lists the columns selected for an Invitation, in the
+// order invitationFields expects, from the invitations joined with their list
+// and users by selectInvitations.
+const invitationColumns = "i.id, i.list_id, l.name, inviter.email, invitee.email, i.role, i.created_at"
+
+var selectInvitations = fmt.Sprintf("SELECT %s FROM `%s` i JOIN `%s` l ON l.id = i.list_id JOIN `%s` inviter ON inviter.id = i.inviter_id JOIN `%s` invitee ON invitee.id = i.invitee_id", invitationColumns, listInvitationsTable, listsTable, usersTable, usersTable)
+
+// Access is what a user may do with the todos of a list: act on behalf of
+// OwnerID, who owns them, within Role. OwnerID is zero for the lists common to
+// every user, where each has their own todos.
+type Access struct {
+	OwnerID uint32
+	Role    Role
+}
+
+// ShareRepository stores who the lists are shared with, and the invitations
+// to them. Access is read for the user of the context.
+type ShareRepository interface {
+	// ListAccess returns the access of the user to list id, or
+	// ErrListNotFound if they don't see it.
+	ListAccess(ctx context.Context, id uint32) (*Access, error)
+	// TodoAccess returns the access of the user to todo id, which is either
+	// theirs or in a list shared with them by its owner, or ErrTodoNotFound
+	// if it is neither or belongs to another workspace.
+	TodoAccess(ctx context.Context, id uint32) (*Access, error)
+	// Members returns the owner and members of list id.
+	Members(ctx context.Context, id uint32) ([]Member, error)
+	SetRole(ctx context.Context, id, userID uint32, role Role) error
+	RemoveMember(ctx context.Context, id, userID uint32) error
+	// CreateInvitation invites a user to list id on behalf of the user of
+	// the context, replacing any invitation of theirs still pending.
+	CreateInvitation(ctx context.Context, id, inviteeID uint32, role Role) (*Invitation, error)
+	// Invitations returns the pending invitations of the user.
+	Invitations(ctx context.Context) ([]Invitation, error)
+	// AcceptInvitation makes the user a member of the list they were
+	// invited to, and DeleteInvitation declines the invitation.
+	AcceptInvitation(ctx context.Context, id uint32) error
+	DeleteInvitation(ctx context.Context, id uint32) error
+}
+
+type sqlsharerepo struct {
+	db *sql.DB
+}
+
+func NewShareRepo(db *sql.DB) ShareRepository {
+	return &sqlsharerepo{db: db}
+}
+
+func (r *sqlsharerepo) ListAccess(ctx context.Context, id uint32) (*Access, error) {
+	workspace, user, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("SELECT l.owner_id, COALESCE(IF(l.owner_id = ?, 'owner', m.role), '') FROM `%s` l LEFT JOIN `%s` m ON m.list_id = l.id AND m.user_id = ? WHERE l.id=? AND (l.owner_id IS NULL OR (l.workspace_id = ? AND (l.owner_id = ? OR m.user_id IS NOT NULL)))", listsTable, listMembersTable)
+
+	var (
+		a     Access
+		owner sql.NullInt64
+	)
+	err = r.db.QueryRowContext(ctx, query, user, user, id, workspace, user).Scan(&owner, &a.Role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrListNotFound
+		}
+		return nil, err
+	}
+	a.OwnerID = uint32(owner.Int64)
+
+	return &a, nil
+}
+
+func (r *sqlsharerepo) TodoAccess(ctx context.Context, id uint32) (*Access, error) {
+	workspace, user, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The todos of a shared list are those of its owner, others' being left
+	// from before it was owned.
+	query := fmt.Sprintf("SELECT t.owner_id, IF(t.owner_id = ?, 'owner', m.role) FROM `%s` t JOIN `%s` l ON l.id = t.list_id LEFT JOIN `%s` m ON m.list_id = l.id AND m.user_id = ? WHERE t.id=? AND t.workspace_id=? AND (t.owner_id = ? OR (t.owner_id = l.owner_id AND l.workspace_id = t.workspace_id AND m.user_id IS NOT NULL))", table, listsTable, listMembersTable)
+
+	var a Access
+	err = r.db.QueryRowContext(ctx, query, user, user, id, workspace, user).Scan(&a.OwnerID, &a.Role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTodoNotFound
+		}
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+func (r *sqlsharerepo) Members(ctx context.Context, id uint32) ([]Member, error) {
+	query := fmt.Sprintf("SELECT u.id, u.email, 'owner', l.created_at FROM `%[1]s` l JOIN `%[2]s` u ON u.id = l.owner_id WHERE l.id=? UNION ALL SELECT u.id, u.email, m.role, m.created_at FROM `%[3]s` m JOIN `%[2]s` u ON u.id = m.user_id WHERE m.list_id=? ORDER BY 4, 1", listsTable, usersTable, listMembersTable)
+
+	rows, err := r.db.QueryContext(ctx, query, id, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []Member{}
+	for rows.Next() {
+		var m Member
+		if err := rows.Scan(&m.UserID, &m.Email, &m.Role, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// SetRole changes the role of a member of list id. The ShareService checks
+// that they are one, since an update leaving the role as it was affects no
+// row.
+func (r *sqlsharerepo) SetRole(ctx context.Context, id, userID uint32, role Role) error {
+	query := fmt.Sprintf("UPDATE `%s` SET role=? WHERE list_id=? AND user_id=?", listMembersTable)
+
+	_, err := r.db.ExecContext(ctx, query, role, id, userID)
+	return err
+}
+
+func (r *sqlsharerepo) RemoveMember(ctx context.Context, id, userID uint32) error {
+	query := fmt.Sprintf("DELETE FROM `%s` WHERE list_id=? AND user_id=?", listMembersTable)
+
+	result, err := r.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrMemberNotFound
+	}
+
+	return nil
+}
+
+func (r *sqlsharerepo) CreateInvitation(ctx context.Context, id, inviteeID uint32, role Role) (*Invitation, error) {
+	inviter, err := ownerOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// LAST_INSERT_ID(id) makes the ID of a replaced invitation the last
+	// inserted one.
+	query := fmt.Sprintf("INSERT INTO `%s` (list_id, inviter_id, invitee_id, role) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), inviter_id = VALUES(inviter_id), role = VALUES(role)", listInvitationsTable)
+
+	result, err := r.db.ExecContext(ctx, query, id, inviter, inviteeID, role)
+	if err != nil {
+		return nil, err
+	}
+
+	invitationID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	var inv Invitation
+	err = r.db.QueryRowContext(ctx, selectInvitations+" WHERE i.id=?", invitationID).Scan(invitationFields(&inv)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &inv, nil
+}
+
+func (r *sqlsharerepo) Invitations(ctx context.Context) ([]Invitation, error) {
+	user, err := ownerOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, selectInvitations+" WHERE i.invitee_id=? ORDER BY i.id", user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []Invitation{}
+	for rows.Next() {
+		var inv Invitation
+		if err := rows.Scan(invitationFields(&inv)...); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+func (r *sqlsharerepo) AcceptInvitation(ctx context.Context, id uint32) error {
+	user, err := ownerOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		listID uint32
+		role   Role
+	)
+	query := fmt.Sprintf("SELECT list_id, role FROM `%s` WHERE id=? AND invitee_id=? FOR UPDATE", listInvitationsTable)
+	if err := tx.QueryRowContext(ctx, query, id, user).Scan(&listID, &role); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInviteNotFound
+		}
+		return err
+	}
+
+	query = fmt.Sprintf("INSERT INTO `%s` (list_id, user_id, role) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE role = VALUES(role)", listMembersTable)
+	if _, err := tx.ExecContext(ctx, query, listID, user, role); err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf("DELETE FROM `%s` WHERE id=?", listInvitationsTable)
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *sqlsharerepo) DeleteInvitation(ctx context.Context, id uint32) error {
+	user, err := ownerOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("DELETE FROM `%s` WHERE id=? AND invitee_id=?", listInvitationsTable)
+
+	result, err := r.db.ExecContext(ctx, query, id, user)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrInviteNotFound
+	}
+
+	return nil
+}
+
+func invitationFields(inv *Invitation) []any {
+	return []any{&inv.ID, &inv.ListID, &inv.ListName, &inv.Inviter, &inv.Invitee, &inv.Role, &inv.CreatedAt}
+}
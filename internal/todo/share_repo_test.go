@@ -0,0 +1,112 @@
+package todo_test
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+var _ = Describe("share repo", Label("share-repo"), func() {
+	var (
+		ctx  context.Context
+		db   *sql.DB
+		mock sqlmock.Sqlmock
+		repo ShareRepository
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = WithWorkspace(WithUser(context.Background(), testOwner), testWorkspace)
+		db, mock, err = sqlmock.New()
+		Expect(err).NotTo(HaveOccurred())
+		repo = NewShareRepo(db)
+	})
+
+	AfterEach(func() {
+		mock.ExpectClose()
+		Expect(db.Close()).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("reads the access of the user to lists", func() {
+		query := "SELECT l.owner_id, COALESCE(IF(l.owner_id = ?, 'owner', m.role), '') FROM `lists` l LEFT JOIN `list_members` m ON m.list_id = l.id AND m.user_id = ? WHERE l.id=? AND (l.owner_id IS NULL OR (l.workspace_id = ? AND (l.owner_id = ? OR m.user_id IS NOT NULL)))"
+		mock.ExpectQuery(regexp.QuoteMeta(query)).
+			WithArgs(testOwner, testOwner, 2, testWorkspace, testOwner).
+			WillReturnRows(sqlmock.NewRows([]string{"owner_id", "role"}).AddRow(8, "editor"))
+		mock.ExpectQuery(regexp.QuoteMeta(query)).
+			WithArgs(testOwner, testOwner, 1, testWorkspace, testOwner).
+			WillReturnRows(sqlmock.NewRows([]string{"owner_id", "role"}).AddRow(nil, ""))
+		mock.ExpectQuery(regexp.QuoteMeta(query)).
+			WithArgs(testOwner, testOwner, 3, testWorkspace, testOwner).
+			WillReturnError(sql.ErrNoRows)
+
+		a, err := repo.ListAccess(ctx, 2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*a).To(Equal(Access{OwnerID: 8, Role: RoleEditor}))
+
+		a, err = repo.ListAccess(ctx, 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*a).To(Equal(Access{}))
+
+		_, err = repo.ListAccess(ctx, 3)
+		Expect(err).To(MatchError(ErrListNotFound))
+	})
+
+	It("only reaches the todos of others through the lists they share", func() {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT t.owner_id, IF(t.owner_id = ?, 'owner', m.role) FROM `todos` t JOIN `lists` l ON l.id = t.list_id LEFT JOIN `list_members` m ON m.list_id = l.id AND m.user_id = ? WHERE t.id=? AND t.workspace_id=? AND (t.owner_id = ? OR (t.owner_id = l.owner_id AND l.workspace_id = t.workspace_id AND m.user_id IS NOT NULL))")).
+			WithArgs(testOwner, testOwner, 20, testWorkspace, testOwner).
+			WillReturnError(sql.ErrNoRows)
+
+		_, err := repo.TodoAccess(ctx, 20)
+		Expect(err).To(MatchError(ErrTodoNotFound))
+	})
+
+	It("refuses to read access without a user", func() {
+		_, err := repo.ListAccess(context.Background(), 2)
+		Expect(err).To(MatchError(ErrUnauthenticated))
+	})
+
+	It("makes the invitees accepting invitations members", func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT list_id, role FROM `list_invitations` WHERE id=? AND invitee_id=? FOR UPDATE")).
+			WithArgs(5, testOwner).
+			WillReturnRows(sqlmock.NewRows([]string{"list_id", "role"}).AddRow(2, "viewer"))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `list_members` (list_id, user_id, role) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE role = VALUES(role)")).
+			WithArgs(2, testOwner, RoleViewer).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `list_invitations` WHERE id=?")).
+			WithArgs(5).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		Expect(repo.AcceptInvitation(ctx, 5)).To(Succeed())
+	})
+
+	It("returns invitation not found errors for the invitations of others", func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT list_id, role FROM `list_invitations` WHERE id=? AND invitee_id=?")).
+			WithArgs(5, testOwner).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `list_invitations` WHERE id=? AND invitee_id=?")).
+			WithArgs(5, testOwner).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		Expect(repo.AcceptInvitation(ctx, 5)).To(MatchError(ErrInviteNotFound))
+		Expect(repo.DeleteInvitation(ctx, 5)).To(MatchError(ErrInviteNotFound))
+	})
+
+	It("returns member not found errors when removing non-members", func() {
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `list_members` WHERE list_id=? AND user_id=?")).
+			WithArgs(2, 9).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		Expect(repo.RemoveMember(ctx, 2, 9)).To(MatchError(ErrMemberNotFound))
+	})
+})
@@ -0,0 +1,238 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ShareService shares lists with other users. Only the owners of a list may
+// change who it is shared with, while its members may see who else it is
+// shared with, and leave. Lists common to every user can't be shared.
+type ShareService interface {
+	Members(ctx context.Context, listID uint32) ([]Member, error)
+	SetRole(ctx context.Context, listID, userID uint32, role Role) (*Member, error)
+	RemoveMember(ctx context.Context, listID, userID uint32) error
+	Invite(ctx context.Context, listID uint32, in InvitationInput) (*Invitation, error)
+	// Invitations returns the pending invitations of the user, which they
+	// either Accept or Decline.
+	Invitations(ctx context.Context) ([]Invitation, error)
+	Accept(ctx context.Context, id uint32) error
+	Decline(ctx context.Context, id uint32) error
+}
+
+type shareService struct {
+	repo  ShareRepository
+	users UserRepository
+}
+
+func NewShareService(r ShareRepository, users UserRepository) ShareService {
+	return &shareService{repo: r, users: users}
+}
+
+func (s *shareService) Members(ctx context.Context, listID uint32) ([]Member, error) {
+	if _, err := s.access(ctx, listID, RoleViewer); err != nil {
+		return nil, err
+	}
+
+	members, err := s.repo.Members(ctx, listID)
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func (s *shareService) SetRole(ctx context.Context, listID, userID uint32, role Role) (*Member, error) {
+	if !role.Valid() {
+		return nil, invalidRole()
+	}
+	a, err := s.access(ctx, listID, RoleOwner)
+	if err != nil {
+		return nil, err
+	}
+	// The list stays with its owner.
+	if userID == a.OwnerID {
+		return nil, ErrForbidden
+	}
+
+	m, err := s.member(ctx, listID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.SetRole(ctx, listID, userID, role); err != nil {
+		return nil, err
+	}
+
+	m.Role = role
+	return m, nil
+}
+
+// RemoveMember takes list listID away from a member. Members may leave a list
+// they don't own.
+func (s *shareService) RemoveMember(ctx context.Context, listID, userID uint32) error {
+	need := RoleOwner
+	if user, _ := UserID(ctx); user == userID {
+		need = RoleViewer
+	}
+	a, err := s.access(ctx, listID, need)
+	if err != nil {
+		return err
+	}
+	if userID == a.OwnerID {
+		return ErrForbidden
+	}
+
+	return s.repo.RemoveMember(ctx, listID, userID)
+}
+
+func (s *shareService) Invite(ctx context.Context, listID uint32, in InvitationInput) (*Invitation, error) {
+	if err := validateInvitation(&in); err != nil {
+		return nil, err
+	}
+	if _, err := s.access(ctx, listID, RoleOwner); err != nil {
+		return nil, err
+	}
+
+	u, _, err := s.users.GetByEmail(ctx, *in.Email)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			msg := "email must be that of a registered user"
+			return nil, &ValidationError{Errors: []FieldError{newFieldError("email", ErrInviteeNotFound, msg)}}
+		}
+		return nil, err
+	}
+
+	_, err = s.member(ctx, listID, u.ID)
+	if err == nil {
+		return nil, ErrMemberExists
+	}
+	if !errors.Is(err, ErrMemberNotFound) {
+		return nil, err
+	}
+
+	inv, err := s.repo.CreateInvitation(ctx, listID, u.ID, *in.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	return inv, nil
+}
+
+func (s *shareService) Invitations(ctx context.Context) ([]Invitation, error) {
+	invitations, err := s.repo.Invitations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+func (s *shareService) Accept(ctx context.Context, id uint32) error {
+	return s.repo.AcceptInvitation(ctx, id)
+}
+
+func (s *shareService) Decline(ctx context.Context, id uint32) error {
+	return s.repo.DeleteInvitation(ctx, id)
+}
+
+// access returns the access of the user to list listID, or fails with
+// ErrForbidden unless the list is shared and their role includes need.
+func (s *shareService) access(ctx context.Context, listID uint32, need Role) (*Access, error) {
+	a, err := s.repo.ListAccess(ctx, listID)
+	if err != nil {
+		return nil, err
+	}
+	if a.OwnerID == 0 || !a.Role.Includes(need) {
+		return nil, ErrForbidden
+	}
+	return a, nil
+}
+
+// member returns user userID among the owner and members of list listID, or
+// ErrMemberNotFound.
+func (s *shareService) member(ctx context.Context, listID, userID uint32) (*Member, error) {
+	members, err := s.repo.Members(ctx, listID)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		if m.UserID == userID {
+			return &m, nil
+		}
+	}
+	return nil, ErrMemberNotFound
+}
+
+// validateInvitation normalizes the email of in and checks both fields, which
+// are required. All violations are reported at once, as a *ValidationError.
+func validateInvitation(in *InvitationInput) error {
+	var errs []FieldError
+	if in.Email != nil {
+		email := normalizeEmail(*in.Email)
+		in.Email = &email
+	}
+	if in.Email == nil || !validEmail(*in.Email) {
+		msg := fmt.Sprintf("email must be a valid email address of at most %d characters", MaxEmailLength)
+		errs = append(errs, newFieldError("email", ErrEmailInvalid, msg))
+	}
+	if in.Role == nil || !in.Role.Valid() {
+		errs = append(errs, invalidRole().Errors...)
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+func invalidRole() *ValidationError {
+	msg := fmt.Sprintf("role must be one of %s, %s or %s", RoleViewer, RoleEditor, RoleOwner)
+	return &ValidationError{Errors: []FieldError{newFieldError("role", ErrRoleInvalid, msg)}}
+}
+
+// forList returns a copy of ctx acting on behalf of the owner of the todos of
+// list id, or fails with ErrForbidden if the role of the user doesn't include
+// need. Without sharing, and for the lists common to every user, users act on
+// their own behalf.
+func (s *service) forList(ctx context.Context, id uint32, need Role) (context.Context, error) {
+	if s.shares == nil {
+		return ctx, nil
+	}
+	a, err := s.shares.ListAccess(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return authorize(ctx, a, need)
+}
+
+// forTodo returns a copy of ctx acting on behalf of the owner of todo id, like
+// forList does for the list it is in.
+func (s *service) forTodo(ctx context.Context, id uint32, need Role) (context.Context, error) {
+	if s.shares == nil {
+		return ctx, nil
+	}
+	a, err := s.shares.TodoAccess(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return authorize(ctx, a, need)
+}
+
+// authorize returns a copy of ctx acting on behalf of a.OwnerID with the
+// scopes of the principal of ctx, if a includes need.
+func authorize(ctx context.Context, a *Access, need Role) (context.Context, error) {
+	if a.OwnerID == 0 {
+		return ctx, nil
+	}
+	if !a.Role.Includes(need) {
+		return nil, ErrForbidden
+	}
+
+	p, _ := PrincipalOf(ctx)
+	if p.UserID == a.OwnerID {
+		return ctx, nil
+	}
+	p.UserID = a.OwnerID
+	return WithPrincipal(ctx, p), nil
+}
@@ -0,0 +1,450 @@
+package todo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+// Users of the sharing tests, besides testOwner.
+const (
+	testEditor = 8
+	testViewer = 9
+)
+
+// mockShareRepo keeps the owners and members of lists, and the invitations
+// to them, in memory. todos holds the owner and list of the todos reached.
+type mockShareRepo struct {
+	owners      map[uint32]uint32
+	members     map[uint32]map[uint32]Role
+	todos       map[uint32]Todo
+	invitations map[uint32]Invitation
+	invitees    map[uint32]uint32
+	nextID      uint32
+}
+
+var _ ShareRepository = (*mockShareRepo)(nil)
+
+func newMockShareRepo() *mockShareRepo {
+	return &mockShareRepo{
+		owners:      map[uint32]uint32{},
+		members:     map[uint32]map[uint32]Role{},
+		todos:       map[uint32]Todo{},
+		invitations: map[uint32]Invitation{},
+		invitees:    map[uint32]uint32{},
+	}
+}
+
+func (m *mockShareRepo) ListAccess(ctx context.Context, id uint32) (*Access, error) {
+	user, _ := UserID(ctx)
+	owner, ok := m.owners[id]
+	switch {
+	case !ok:
+		return nil, ErrListNotFound
+	case owner == 0:
+		return &Access{}, nil
+	case owner == user:
+		return &Access{OwnerID: owner, Role: RoleOwner}, nil
+	}
+	if role, ok := m.members[id][user]; ok {
+		return &Access{OwnerID: owner, Role: role}, nil
+	}
+	return nil, ErrListNotFound
+}
+
+func (m *mockShareRepo) TodoAccess(ctx context.Context, id uint32) (*Access, error) {
+	user, _ := UserID(ctx)
+	t, ok := m.todos[id]
+	if !ok {
+		return nil, ErrTodoNotFound
+	}
+	if t.OwnerID == user {
+		return &Access{OwnerID: user, Role: RoleOwner}, nil
+	}
+	if role, ok := m.members[t.ListID][user]; ok && m.owners[t.ListID] == t.OwnerID {
+		return &Access{OwnerID: t.OwnerID, Role: role}, nil
+	}
+	return nil, ErrTodoNotFound
+}
+
+func (m *mockShareRepo) Members(ctx context.Context, id uint32) ([]Member, error) {
+	members := []Member{{UserID: m.owners[id], Role: RoleOwner}}
+	for user, role := range m.members[id] {
+		members = append(members, Member{UserID: user, Role: role})
+	}
+	slices.SortFunc(members[1:], func(a, b Member) int { return int(a.UserID) - int(b.UserID) })
+	return members, nil
+}
+
+func (m *mockShareRepo) SetRole(ctx context.Context, id, userID uint32, role Role) error {
+	m.members[id][userID] = role
+	return nil
+}
+
+func (m *mockShareRepo) RemoveMember(ctx context.Context, id, userID uint32) error {
+	if _, ok := m.members[id][userID]; !ok {
+		return ErrMemberNotFound
+	}
+	delete(m.members[id], userID)
+	return nil
+}
+
+func (m *mockShareRepo) CreateInvitation(ctx context.Context, id, inviteeID uint32, role Role) (*Invitation, error) {
+	m.nextID++
+	inv := Invitation{ID: m.nextID, ListID: id, Role: role}
+	m.invitations[inv.ID] = inv
+	m.invitees[inv.ID] = inviteeID
+	return &inv, nil
+}
+
+func (m *mockShareRepo) Invitations(ctx context.Context) ([]Invitation, error) {
+	user, _ := UserID(ctx)
+	invitations := []Invitation{}
+	for id, inv := range m.invitations {
+		if m.invitees[id] == user {
+			invitations = append(invitations, inv)
+		}
+	}
+	return invitations, nil
+}
+
+func (m *mockShareRepo) AcceptInvitation(ctx context.Context, id uint32) error {
+	user, _ := UserID(ctx)
+	inv, ok := m.invitations[id]
+	if !ok || m.invitees[id] != user {
+		return ErrInviteNotFound
+	}
+	if m.members[inv.ListID] == nil {
+		m.members[inv.ListID] = map[uint32]Role{}
+	}
+	m.members[inv.ListID][user] = inv.Role
+	delete(m.invitations, id)
+	return nil
+}
+
+func (m *mockShareRepo) DeleteInvitation(ctx context.Context, id uint32) error {
+	user, _ := UserID(ctx)
+	if _, ok := m.invitations[id]; !ok || m.invitees[id] != user {
+		return ErrInviteNotFound
+	}
+	delete(m.invitations, id)
+	return nil
+}
+
+var _ = Describe("sharing", Label("sharing"), func() {
+	var shares *mockShareRepo
+
+	// List 1 is common to every user, list 2 is shared by testOwner with an
+	// editor and a viewer, and list 3 is the editor's own. Todo 20 is in list
+	// 2 and todo 30 in list 3.
+	BeforeEach(func() {
+		shares = newMockShareRepo()
+		shares.owners[1] = 0
+		shares.owners[2] = testOwner
+		shares.owners[3] = testEditor
+		shares.members[2] = map[uint32]Role{testEditor: RoleEditor, testViewer: RoleViewer}
+		shares.todos[20] = Todo{ID: 20, OwnerID: testOwner, ListID: 2}
+		shares.todos[30] = Todo{ID: 30, OwnerID: testEditor, ListID: 3}
+	})
+
+	Describe("todos", func() {
+		var (
+			repo *mockRepo
+			svc  Service
+		)
+
+		// actingAs records the user each repository call acts on behalf of.
+		var actingAs []uint32
+		record := func(ctx context.Context) {
+			id, _ := UserID(ctx)
+			actingAs = append(actingAs, id)
+		}
+
+		BeforeEach(func() {
+			actingAs = nil
+			repo = &mockRepo{
+				getFn: func(ctx context.Context, id uint32) (*Todo, error) {
+					record(ctx)
+					t := shares.todos[id]
+					return &t, nil
+				},
+				listFn: func(ctx context.Context, p ListParams) ([]Todo, error) {
+					record(ctx)
+					return []Todo{}, nil
+				},
+				createFn: func(ctx context.Context, in TodoInput) (*Todo, error) {
+					record(ctx)
+					return &Todo{ID: 21, ListID: *in.ListID}, nil
+				},
+				updateFn: func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+					record(ctx)
+					return &Todo{ID: id}, nil
+				},
+				deleteFn: func(ctx context.Context, id uint32, version *uint32) error {
+					record(ctx)
+					return nil
+				},
+			}
+			svc = NewService(repo, WithSharing(shares))
+		})
+
+		as := func(user uint32) context.Context {
+			return WithUser(context.Background(), user)
+		}
+
+		It("lets members read the todos of shared lists on behalf of their owner", func() {
+			list := uint32(2)
+
+			_, err := svc.GetAll(as(testViewer), ListParams{ListID: &list})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = svc.GetById(as(testViewer), 20)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(actingAs).To(HaveEach(BeEquivalentTo(testOwner)))
+		})
+
+		It("keeps the scopes of members acting on behalf of the owner", func() {
+			ctx := WithPrincipal(context.Background(), Principal{UserID: testViewer, Scopes: []string{ScopeTodosRead}})
+			repo.getFn = func(ctx context.Context, id uint32) (*Todo, error) {
+				p, _ := PrincipalOf(ctx)
+				Expect(p).To(Equal(Principal{UserID: testOwner, Scopes: []string{ScopeTodosRead}}))
+				return &Todo{ID: id}, nil
+			}
+
+			_, err := svc.GetById(ctx, 20)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("hides the lists and todos which aren't shared with the user", func() {
+			list := uint32(2)
+
+			_, err := svc.GetAll(as(10), ListParams{ListID: &list})
+			Expect(err).To(MatchError(ErrListNotFound))
+			_, err = svc.GetById(as(10), 20)
+			Expect(err).To(MatchError(ErrTodoNotFound))
+			Expect(actingAs).To(BeEmpty())
+		})
+
+		It("lets editors write the todos of shared lists", func() {
+			list, text := uint32(2), "buy milk"
+
+			_, err := svc.Create(as(testEditor), TodoInput{Text: &text, ListID: &list})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = svc.Update(as(testEditor), 20, TodoInput{Text: &text})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(svc.Delete(as(testEditor), 20, nil)).To(Succeed())
+			Expect(actingAs).To(HaveEach(BeEquivalentTo(testOwner)))
+		})
+
+		It("forbids viewers to write the todos of shared lists", func() {
+			list, text := uint32(2), "buy milk"
+
+			_, err := svc.Create(as(testViewer), TodoInput{Text: &text, ListID: &list})
+			Expect(err).To(MatchError(ErrForbidden))
+			_, err = svc.Update(as(testViewer), 20, TodoInput{Text: &text})
+			Expect(err).To(MatchError(ErrForbidden))
+			Expect(svc.Delete(as(testViewer), 20, nil)).To(MatchError(ErrForbidden))
+			Expect(actingAs).To(BeEmpty())
+		})
+
+		It("files subtasks on behalf of the owner of their parent", func() {
+			parent, text := uint32(20), "skim milk"
+			repo.getFn = func(ctx context.Context, id uint32) (*Todo, error) {
+				record(ctx)
+				return &Todo{ID: id, ListID: 2}, nil
+			}
+			repo.ancestorsFn = func(ctx context.Context, id uint32) ([]uint32, error) {
+				return []uint32{id}, nil
+			}
+
+			_, err := svc.Create(as(testEditor), TodoInput{Text: &text, ParentID: &parent})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(actingAs).To(HaveEach(BeEquivalentTo(testOwner)))
+		})
+
+		It("only moves todos between lists whose todos have the same owner", func() {
+			own, shared, common := uint32(3), uint32(2), uint32(1)
+
+			_, err := svc.Update(as(testEditor), 20, TodoInput{ListID: &own})
+			Expect(err).To(MatchError(ErrForbidden))
+			_, err = svc.Update(as(testEditor), 20, TodoInput{ListID: &common})
+			Expect(err).To(MatchError(ErrForbidden))
+			_, err = svc.Update(as(testEditor), 30, TodoInput{ListID: &shared})
+			Expect(err).To(MatchError(ErrForbidden))
+			Expect(actingAs).To(BeEmpty())
+		})
+
+		It("leaves the todos of common lists to each user", func() {
+			list, text := uint32(1), "buy milk"
+
+			_, err := svc.Create(as(testViewer), TodoInput{Text: &text, ListID: &list})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(actingAs).To(Equal([]uint32{testViewer}))
+		})
+	})
+
+	Describe("service", func() {
+		var (
+			users *mockUserRepo
+			svc   ShareService
+		)
+
+		BeforeEach(func() {
+			users = newMockUserRepo()
+			users.users[testOwner] = User{ID: testOwner, Email: "ada@example.com"}
+			users.users[testEditor] = User{ID: testEditor, Email: "bob@example.com"}
+			users.users[10] = User{ID: 10, Email: "carol@example.com"}
+			svc = NewShareService(shares, users)
+		})
+
+		invite := func(email string, role Role) InvitationInput {
+			return InvitationInput{Email: &email, Role: &role}
+		}
+
+		It("lets users accept the invitations of owners", func() {
+			owner, carol := WithUser(context.Background(), testOwner), WithUser(context.Background(), 10)
+
+			inv, err := svc.Invite(owner, 2, invite(" Carol@Example.com ", RoleEditor))
+			Expect(err).NotTo(HaveOccurred())
+			invitations, err := svc.Invitations(carol)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(invitations).To(HaveLen(1))
+
+			Expect(svc.Accept(carol, inv.ID)).To(Succeed())
+			members, err := svc.Members(carol, 2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(members).To(ContainElement(Member{UserID: 10, Role: RoleEditor}))
+			Expect(svc.Decline(carol, inv.ID)).To(MatchError(ErrInviteNotFound))
+		})
+
+		It("lets users decline invitations", func() {
+			inv, err := svc.Invite(WithUser(context.Background(), testOwner), 2, invite("carol@example.com", RoleViewer))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(svc.Accept(WithUser(context.Background(), testEditor), inv.ID)).To(MatchError(ErrInviteNotFound))
+			Expect(svc.Decline(WithUser(context.Background(), 10), inv.ID)).To(Succeed())
+			Expect(shares.members[2]).NotTo(HaveKey(BeEquivalentTo(10)))
+		})
+
+		It("only lets owners share lists", func() {
+			_, err := svc.Invite(WithUser(context.Background(), testEditor), 2, invite("carol@example.com", RoleViewer))
+			Expect(err).To(MatchError(ErrForbidden))
+			_, err = svc.SetRole(WithUser(context.Background(), testEditor), 2, testViewer, RoleOwner)
+			Expect(err).To(MatchError(ErrForbidden))
+			Expect(svc.RemoveMember(WithUser(context.Background(), testEditor), 2, testViewer)).To(MatchError(ErrForbidden))
+		})
+
+		It("doesn't share lists common to every user", func() {
+			_, err := svc.Invite(WithUser(context.Background(), testOwner), 1, invite("carol@example.com", RoleViewer))
+			Expect(err).To(MatchError(ErrForbidden))
+		})
+
+		It("rejects invitations of unknown users and roles", func() {
+			_, err := svc.Invite(WithUser(context.Background(), testOwner), 2, invite("dan@example.com", "admin"))
+
+			var ve *ValidationError
+			Expect(err).To(BeAssignableToTypeOf(ve))
+			Expect(err).To(MatchError(ErrRoleInvalid))
+			Expect(err).NotTo(MatchError(ErrInviteeNotFound))
+
+			_, err = svc.Invite(WithUser(context.Background(), testOwner), 2, invite("dan@example.com", RoleViewer))
+			Expect(err).To(MatchError(ErrInviteeNotFound))
+		})
+
+		It("doesn't invite members again", func() {
+			_, err := svc.Invite(WithUser(context.Background(), testOwner), 2, invite("bob@example.com", RoleOwner))
+			Expect(err).To(MatchError(ErrMemberExists))
+			_, err = svc.Invite(WithUser(context.Background(), testOwner), 2, invite("ada@example.com", RoleOwner))
+			Expect(err).To(MatchError(ErrMemberExists))
+		})
+
+		It("changes the roles of members, but not of the owner", func() {
+			owner := WithUser(context.Background(), testOwner)
+
+			m, err := svc.SetRole(owner, 2, testViewer, RoleEditor)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(m.Role).To(Equal(RoleEditor))
+			_, err = svc.SetRole(owner, 2, 10, RoleEditor)
+			Expect(err).To(MatchError(ErrMemberNotFound))
+			_, err = svc.SetRole(owner, 2, testOwner, RoleViewer)
+			Expect(err).To(MatchError(ErrForbidden))
+		})
+
+		It("lets members leave, but not the owner", func() {
+			Expect(svc.RemoveMember(WithUser(context.Background(), testViewer), 2, testViewer)).To(Succeed())
+			Expect(svc.RemoveMember(WithUser(context.Background(), testOwner), 2, testOwner)).To(MatchError(ErrForbidden))
+		})
+	})
+
+	Describe("handler", func() {
+		var (
+			router *gin.Engine
+			rr     *httptest.ResponseRecorder
+		)
+
+		BeforeEach(func() {
+			users := newMockUserRepo()
+			users.users[10] = User{ID: 10, Email: "carol@example.com"}
+			gin.SetMode(gin.TestMode)
+			router = gin.New()
+			router.Use(asUser(testEditor))
+			NewShareHandler(NewShareService(shares, users)).Register(router)
+			rr = httptest.NewRecorder()
+		})
+
+		send := func(method, url, body string) {
+			req := httptest.NewRequest(method, url, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+		}
+
+		It("lists the members of shared lists", func() {
+			send(http.MethodGet, "/lists/2/members", "")
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Body.String()).To(ContainSubstring(`"role":"editor"`))
+		})
+
+		It("reports writes the role of the user doesn't allow as forbidden", func() {
+			send(http.MethodPost, "/lists/2/invitations", `{"email": "carol@example.com", "role": "viewer"}`)
+
+			Expect(rr.Code).To(Equal(http.StatusForbidden))
+			Expect(rr.Body.String()).To(ContainSubstring(ErrForbidden.Error()))
+		})
+
+		It("invites users to the lists of the user", func() {
+			send(http.MethodPost, "/lists/3/invitations", `{"email": "carol@example.com", "role": "viewer"}`)
+
+			Expect(rr.Code).To(Equal(http.StatusCreated))
+			Expect(shares.invitees).To(ContainElement(BeEquivalentTo(10)))
+		})
+
+		It("reports unknown roles as unprocessable", func() {
+			send(http.MethodPut, "/lists/3/members/10", `{"role": "admin"}`)
+
+			Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+			Expect(rr.Body.String()).To(ContainSubstring(ErrRoleInvalid.Error()))
+		})
+
+		It("hides the lists which aren't shared with the user", func() {
+			send(http.MethodGet, "/lists/4/members", "")
+
+			Expect(rr.Code).To(Equal(http.StatusNotFound))
+			Expect(rr.Body.String()).To(ContainSubstring(ErrListNotFound.Error()))
+		})
+
+		It("reports unknown invitations as not found", func() {
+			send(http.MethodPost, "/invitations/5/accept", "")
+
+			Expect(rr.Code).To(Equal(http.StatusNotFound))
+			Expect(rr.Body.String()).To(ContainSubstring(ErrInviteNotFound.Error()))
+		})
+	})
+})
@@ -0,0 +1,286 @@
+package todo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Operations of the WebSocket channel besides the mutations of bulk requests.
+const (
+	SocketSubscribe   = "subscribe"
+	SocketUnsubscribe = "unsubscribe"
+)
+
+// Types of the messages sent over the WebSocket channel.
+const (
+	SocketAck   = "ack"
+	SocketError = "error"
+	SocketEvent = "event"
+)
+
+const (
+	// socketOpTimeout bounds the time a mutation sent over the WebSocket
+	// channel may take, like the request timeout does for REST calls.
+	socketOpTimeout = 2 * time.Second
+
+	socketWriteTimeout   = 10 * time.Second
+	maxSocketMessageSize = 64 << 10
+)
+
+// SocketRequest is a message sent by a client over the WebSocket channel. It
+// is an operation of a bulk request, or a change to the todos and lists the
+// client is subscribed to. RequestID is chosen by the client and echoed in
+// the reply.
+type SocketRequest struct {
+	RequestID string   `json:"request_id"`
+	Todos     []uint32 `json:"todos"`
+	Lists     []uint32 `json:"lists"`
+	BulkOp
+}
+
+// SocketMessage is a message sent to a client over the WebSocket channel: the
+// ack or error replying to one of its requests, or the event of a change to a
+// todo it is subscribed to.
+type SocketMessage struct {
+	Type      string   `json:"type"`
+	RequestID string   `json:"request_id,omitempty"`
+	Todo      *Todo    `json:"todo,omitempty"`
+	Event     *Event   `json:"event,omitempty"`
+	Error     *Problem `json:"error,omitempty"`
+}
+
+// WithAllowedOrigins sets the origins of the pages allowed to open the
+// WebSocket channel, "*" allowing any. By default only pages of the same
+// origin are.
+func WithAllowedOrigins(origins []string) HandlerOption {
+	return func(h *Handler) {
+		h.upgrader.CheckOrigin = func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			return origin == "" || slices.Contains(origins, "*") || slices.Contains(origins, origin)
+		}
+	}
+}
+
+// socket serves the WebSocket channel, on which a client subscribes to
+// changes to some todos and lists and sends mutations, which go through the
+// service like those of the REST endpoints.
+func (h *Handler) socket(ctx *gin.Context) {
+	conn, err := h.upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		// The upgrader has already replied.
+		return
+	}
+	defer conn.Close()
+
+	sub := h.events.Subscribe(nil)
+	defer sub.Cancel()
+
+	// The connection is hijacked, so the request context is no longer
+	// cancelled when the client goes away.
+	c, cancel := context.WithCancel(ctx.Request.Context())
+	defer cancel()
+
+	owner, _ := UserID(c)
+	workspace, _ := WorkspaceID(c)
+	s := &socketSession{
+		svc:       h.svc,
+		owner:     owner,
+		workspace: workspace,
+		instance:  ctx.Request.URL.Path,
+		todos:     map[uint32]bool{},
+		lists:     map[uint32]bool{},
+		known:     map[uint32]uint32{},
+	}
+	replies := make(chan SocketMessage)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		conn.SetReadLimit(maxSocketMessageSize)
+		conn.SetReadDeadline(time.Now().Add(2 * h.heartbeat))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * h.heartbeat))
+		})
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			select {
+			case replies <- s.handle(c, data):
+			case <-c.Done():
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(h.heartbeat)
+	defer ping.Stop()
+
+	for {
+		var msg SocketMessage
+		select {
+		case <-done:
+			return
+		case msg = <-replies:
+		case e, ok := <-sub.Events:
+			if !ok {
+				// Dropped for falling behind. The client reconnects and
+				// reloads what it is subscribed to.
+				deadline := time.Now().Add(socketWriteTimeout)
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too far behind"), deadline)
+				return
+			}
+			if !s.matches(e) {
+				continue
+			}
+			msg = SocketMessage{Type: SocketEvent, Event: &e}
+		case <-ping.C:
+			deadline := time.Now().Add(socketWriteTimeout)
+			if err := conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				return
+			}
+			continue
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+		if err := conn.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+}
+
+// socketSession is the state of a WebSocket connection: the todos and lists
+// the client is subscribed to, among those the user who opened it sees in its
+// workspace.
+type socketSession struct {
+	svc       Service
+	owner     uint32
+	workspace uint32
+	instance  string
+
+	mu    sync.Mutex
+	todos map[uint32]bool
+	lists map[uint32]bool
+	// known maps the todos seen in a subscribed list to that list. They are
+	// followed out of it, so that the client learns where they went.
+	known map[uint32]uint32
+}
+
+// handle runs the request in data and returns the reply.
+func (s *socketSession) handle(ctx context.Context, data []byte) SocketMessage {
+	var req SocketRequest
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil || decoder.More() {
+		return s.errorMessage("", http.StatusBadRequest, NewErrorResponse(ErrBadJson.Error(), "invalid json input"))
+	}
+
+	switch req.Op {
+	case SocketSubscribe, SocketUnsubscribe:
+		if len(req.Todos) == 0 && len(req.Lists) == 0 {
+			r := NewErrorResponse(ErrBadJson.Error(), "missing `todos` or `lists` to "+req.Op)
+			return s.errorMessage(req.RequestID, http.StatusBadRequest, r)
+		}
+		s.subscribe(req.Todos, req.Lists, req.Op == SocketSubscribe)
+		return SocketMessage{Type: SocketAck, RequestID: req.RequestID}
+	case BulkCreate, BulkUpdate, BulkDelete:
+	default:
+		msg := fmt.Sprintf("`op` must be one of %s, %s, %s, %s, %s", SocketSubscribe, SocketUnsubscribe, BulkCreate, BulkUpdate, BulkDelete)
+		return s.errorMessage(req.RequestID, http.StatusBadRequest, NewErrorResponse(ErrBadJson.Error(), msg))
+	}
+
+	op := req.BulkOp
+	if err := checkBulkOp(&op); err != nil {
+		return s.errorMessage(req.RequestID, http.StatusBadRequest, NewErrorResponse(ErrBadJson.Error(), err.Error()))
+	}
+	if p, _ := PrincipalOf(ctx); !p.Allows(ScopeTodosWrite) {
+		msg := fmt.Sprintf("The %s scope is required", ScopeTodosWrite)
+		return s.errorMessage(req.RequestID, http.StatusForbidden, NewErrorResponse(ErrInsufficientScope.Error(), msg))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, socketOpTimeout)
+	defer cancel()
+
+	var (
+		t   *Todo
+		err error
+	)
+	switch op.Op {
+	case BulkCreate:
+		t, err = s.svc.Create(ctx, op.Todo)
+	case BulkUpdate:
+		in := op.Todo
+		in.Version = op.Version
+		t, err = s.svc.Update(ctx, op.ID, in)
+	case BulkDelete:
+		err = s.svc.Delete(ctx, op.ID, op.Version)
+	}
+	if err != nil {
+		status, r := todoError(err, op.ID, op.Todo)
+		return s.errorMessage(req.RequestID, status, r)
+	}
+
+	return SocketMessage{Type: SocketAck, RequestID: req.RequestID, Todo: t}
+}
+
+func (s *socketSession) errorMessage(requestID string, status int, r *ErrorResponse) SocketMessage {
+	return SocketMessage{Type: SocketError, RequestID: requestID, Error: newProblem(status, r, s.instance)}
+}
+
+// subscribe adds todos and lists to the subscriptions, or removes them.
+func (s *socketSession) subscribe(todos, lists []uint32, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range todos {
+		if add {
+			s.todos[id] = true
+		} else {
+			delete(s.todos, id)
+		}
+	}
+	for _, id := range lists {
+		if add {
+			s.lists[id] = true
+			continue
+		}
+		delete(s.lists, id)
+		for todo, list := range s.known {
+			if list == id {
+				delete(s.known, todo)
+			}
+		}
+	}
+}
+
+// matches reports whether e concerns a todo the client is subscribed to,
+// directly or through its list.
+func (s *socketSession) matches(e Event) bool {
+	if !e.visibleTo(s.owner, s.workspace) {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, known := s.known[e.TodoID]
+	inList := e.ListID != 0 && s.lists[e.ListID]
+	if inList && e.Type != EventTodoDeleted {
+		s.known[e.TodoID] = e.ListID
+	} else {
+		delete(s.known, e.TodoID)
+	}
+
+	return s.todos[e.TodoID] || inList || known
+}
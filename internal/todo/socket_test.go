@@ -0,0 +1,227 @@
+package todo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+var _ = Describe("socket", Label("socket"), func() {
+	var (
+		svc    *mockService
+		bus    *EventBus
+		server *httptest.Server
+	)
+
+	dial := func(header http.Header) (*websocket.Conn, *http.Response, error) {
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/todos/ws"
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if err == nil {
+			DeferCleanup(conn.Close)
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		}
+		return conn, resp, err
+	}
+
+	connect := func() *websocket.Conn {
+		conn, _, err := dial(nil)
+		Expect(err).NotTo(HaveOccurred())
+		return conn
+	}
+
+	send := func(conn *websocket.Conn, req string) SocketMessage {
+		Expect(conn.WriteMessage(websocket.TextMessage, []byte(req))).To(Succeed())
+		var msg SocketMessage
+		Expect(conn.ReadJSON(&msg)).To(Succeed())
+		return msg
+	}
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		svc = &mockService{}
+		bus = NewEventBus(10)
+		router := gin.New()
+		router.Use(asUser(testOwner))
+		NewHandler(svc, WithEventStream(bus, time.Second), WithAllowedOrigins([]string{"http://localhost:8081"})).Register(router)
+		server = httptest.NewServer(router)
+		DeferCleanup(server.Close)
+	})
+
+	It("acks mutations with the resulting todo", func() {
+		svc.createFn = func(ctx context.Context, in TodoInput) (*Todo, error) {
+			Expect(*in.Completed).To(BeFalse())
+			Expect(*in.Priority).To(Equal(PriorityNone))
+			return &Todo{ID: 5, Text: *in.Text}, nil
+		}
+		conn := connect()
+
+		msg := send(conn, `{"request_id":"c1","op":"create","todo":{"text":"buy milk"}}`)
+		Expect(msg).To(MatchFields(IgnoreExtras, Fields{
+			"Type":      Equal("ack"),
+			"RequestID": Equal("c1"),
+			"Todo":      PointTo(MatchFields(IgnoreExtras, Fields{"ID": BeEquivalentTo(5), "Text": Equal("buy milk")})),
+		}))
+	})
+
+	It("passes the version of updates and deletes on", func() {
+		svc.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+			Expect(id).To(BeEquivalentTo(5))
+			Expect(in.Version).To(PointTo(BeEquivalentTo(2)))
+			return &Todo{ID: id, Completed: *in.Completed}, nil
+		}
+		svc.deleteFn = func(ctx context.Context, id uint32, version *uint32) error {
+			Expect(version).To(PointTo(BeEquivalentTo(3)))
+			return nil
+		}
+		conn := connect()
+
+		msg := send(conn, `{"request_id":"u1","op":"update","id":5,"version":2,"todo":{"completed":true}}`)
+		Expect(msg.Type).To(Equal("ack"))
+		Expect(msg.Todo.Completed).To(BeTrue())
+
+		msg = send(conn, `{"request_id":"d1","op":"delete","id":5,"version":3}`)
+		Expect(msg).To(MatchFields(IgnoreExtras, Fields{"Type": Equal("ack"), "RequestID": Equal("d1"), "Todo": BeNil()}))
+	})
+
+	It("reports service errors like the REST endpoints", func() {
+		svc.createFn = func(ctx context.Context, in TodoInput) (*Todo, error) {
+			return nil, &ValidationError{Errors: []FieldError{{Field: "text", Rule: "text_empty", Message: "text must not be empty"}}}
+		}
+		svc.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+			return nil, ErrVersionMismatch
+		}
+		conn := connect()
+
+		msg := send(conn, `{"request_id":"c1","op":"create","todo":{"text":" "}}`)
+		Expect(msg.Type).To(Equal("error"))
+		Expect(msg.RequestID).To(Equal("c1"))
+		Expect(msg.Error).To(PointTo(MatchFields(IgnoreExtras, Fields{
+			"Status": Equal(http.StatusUnprocessableEntity),
+			"Code":   Equal("input_invalid"),
+			"Errors": HaveLen(1),
+		})))
+
+		msg = send(conn, `{"request_id":"u1","op":"update","id":5,"version":1,"todo":{"text":"x"}}`)
+		Expect(msg.Error).To(PointTo(MatchFields(IgnoreExtras, Fields{"Status": Equal(http.StatusPreconditionFailed), "Code": Equal("version_mismatch")})))
+	})
+
+	It("rejects malformed requests", func() {
+		conn := connect()
+
+		msg := send(conn, `{"request_id":"x","op":"frob"}`)
+		Expect(msg.Error).To(PointTo(MatchFields(IgnoreExtras, Fields{"Status": Equal(http.StatusBadRequest), "Code": Equal("bad_json")})))
+		Expect(msg.RequestID).To(Equal("x"))
+
+		msg = send(conn, `{"request_id":"y","op":"update","id":5}`)
+		Expect(msg.Error.Detail).To(Equal("missing at least one field to update"))
+
+		msg = send(conn, `{"request_id":"z","op":"subscribe"}`)
+		Expect(msg.Error.Code).To(Equal("bad_json"))
+
+		msg = send(conn, `not json`)
+		Expect(msg.Type).To(Equal("error"))
+	})
+
+	It("sends the events of subscribed todos and lists only", func() {
+		conn := connect()
+		Expect(send(conn, `{"request_id":"s1","op":"subscribe","todos":[1],"lists":[2]}`).Type).To(Equal("ack"))
+
+		bus.Publish(Event{Type: EventTodoUpdated, TodoID: 3, ListID: 9, OwnerID: testOwner})
+		bus.Publish(Event{Type: EventTodoUpdated, TodoID: 1, ListID: 9, OwnerID: testOwner})
+		bus.Publish(Event{Type: EventTodoCreated, TodoID: 4, ListID: 2, OwnerID: testOwner})
+
+		var msg SocketMessage
+		Expect(conn.ReadJSON(&msg)).To(Succeed())
+		Expect(msg.Type).To(Equal("event"))
+		Expect(msg.Event).To(PointTo(MatchFields(IgnoreExtras, Fields{"Type": Equal("todo.updated"), "TodoID": BeEquivalentTo(1)})))
+		Expect(conn.ReadJSON(&msg)).To(Succeed())
+		Expect(msg.Event).To(PointTo(MatchFields(IgnoreExtras, Fields{"Type": Equal("todo.created"), "TodoID": BeEquivalentTo(4)})))
+	})
+
+	It("follows todos moved out of a subscribed list", func() {
+		conn := connect()
+		send(conn, `{"request_id":"s1","op":"subscribe","lists":[2]}`)
+
+		bus.Publish(Event{Type: EventTodoCreated, TodoID: 4, ListID: 2, OwnerID: testOwner})
+		bus.Publish(Event{Type: EventTodoUpdated, TodoID: 4, ListID: 3, OwnerID: testOwner})
+		bus.Publish(Event{Type: EventTodoDeleted, TodoID: 4, ListID: 3, OwnerID: testOwner})
+		bus.Publish(Event{Type: EventTodoCreated, TodoID: 5, ListID: 2, OwnerID: testOwner})
+
+		var msg SocketMessage
+		Expect(conn.ReadJSON(&msg)).To(Succeed())
+		Expect(msg.Event.TodoID).To(BeEquivalentTo(4))
+		Expect(conn.ReadJSON(&msg)).To(Succeed())
+		Expect(msg.Event).To(PointTo(MatchFields(IgnoreExtras, Fields{"TodoID": BeEquivalentTo(4), "ListID": BeEquivalentTo(3)})))
+		Expect(conn.ReadJSON(&msg)).To(Succeed())
+		Expect(msg.Event.TodoID).To(BeEquivalentTo(5))
+	})
+
+	It("stops sending the events of unsubscribed lists", func() {
+		conn := connect()
+		send(conn, `{"request_id":"s1","op":"subscribe","lists":[2]}`)
+		send(conn, `{"request_id":"s2","op":"unsubscribe","lists":[2]}`)
+		send(conn, `{"request_id":"s3","op":"subscribe","todos":[9]}`)
+
+		bus.Publish(Event{Type: EventTodoCreated, TodoID: 4, ListID: 2, OwnerID: testOwner})
+		bus.Publish(Event{Type: EventTodoUpdated, TodoID: 9, ListID: 2, OwnerID: testOwner})
+
+		var msg SocketMessage
+		Expect(conn.ReadJSON(&msg)).To(Succeed())
+		Expect(msg.Event.TodoID).To(BeEquivalentTo(9))
+	})
+
+	It("leaves out the events of other users", func() {
+		conn := connect()
+		send(conn, `{"request_id":"s1","op":"subscribe","todos":[1]}`)
+
+		bus.Publish(Event{Type: EventTodoUpdated, TodoID: 1, ListID: 1, OwnerID: testOwner + 1})
+		bus.Publish(Event{Type: EventTodoDeleted, TodoID: 1, ListID: 1, OwnerID: testOwner})
+
+		var msg SocketMessage
+		Expect(conn.ReadJSON(&msg)).To(Succeed())
+		Expect(msg.Event.Type).To(Equal(EventTodoDeleted))
+	})
+
+	It("sends the events of the lists shared with the user", func() {
+		conn := connect()
+		send(conn, `{"request_id":"s1","op":"subscribe","lists":[2]}`)
+
+		bus.Publish(Event{Type: EventTodoUpdated, TodoID: 1, ListID: 2, OwnerID: testOwner + 1, MemberIDs: []uint32{testOwner + 2}})
+		bus.Publish(Event{Type: EventTodoDeleted, TodoID: 1, ListID: 2, OwnerID: testOwner + 1, MemberIDs: []uint32{testOwner}})
+
+		var msg SocketMessage
+		Expect(conn.ReadJSON(&msg)).To(Succeed())
+		Expect(msg.Event.Type).To(Equal(EventTodoDeleted))
+	})
+
+	It("leaves out the events of the user in other workspaces", func() {
+		conn := connect()
+		send(conn, `{"request_id":"s1","op":"subscribe","todos":[1]}`)
+
+		bus.Publish(Event{Type: EventTodoUpdated, TodoID: 1, ListID: 1, OwnerID: testOwner, WorkspaceID: testWorkspace})
+		bus.Publish(Event{Type: EventTodoDeleted, TodoID: 1, ListID: 1, OwnerID: testOwner})
+
+		var msg SocketMessage
+		Expect(conn.ReadJSON(&msg)).To(Succeed())
+		Expect(msg.Event.Type).To(Equal(EventTodoDeleted))
+	})
+
+	It("only accepts the allowed origins", func() {
+		_, resp, err := dial(http.Header{"Origin": {"http://evil.example"}})
+		Expect(err).To(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+
+		_, _, err = dial(http.Header{"Origin": {"http://localhost:8081"}})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
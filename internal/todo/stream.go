@@ -0,0 +1,125 @@
+package todo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultHeartbeatInterval is how often an idle event stream sends a comment
+// to keep proxies from closing it, unless configured otherwise.
+const DefaultHeartbeatInterval = 15 * time.Second
+
+// eventReset tells a client resuming a stream that it missed events which
+// can no longer be replayed, so it has to reload the todos.
+const eventReset = "reset"
+
+// WithEventStream serves the events of b as Server-Sent Events on
+// GET /todos/events and over the WebSocket channel on GET /todos/ws. Idle
+// streams get a heartbeat, and WebSocket connections a ping, at the given
+// interval. A non-positive interval means DefaultHeartbeatInterval.
+func WithEventStream(b *EventBus, heartbeat time.Duration) HandlerOption {
+	return func(h *Handler) {
+		if heartbeat <= 0 {
+			heartbeat = DefaultHeartbeatInterval
+		}
+		h.events = b
+		h.heartbeat = heartbeat
+	}
+}
+
+// Streams returns the routes serving long-lived responses, which must not be
+// subject to request timeouts.
+func (h *Handler) Streams() []string {
+	if h.events == nil {
+		return nil
+	}
+	return []string{"/todos/events", "/todos/ws"}
+}
+
+// stream writes the changes to the todos of the user and of the lists shared
+// with them as they happen. A client reconnecting with the Last-Event-ID
+// header gets the events it missed first, or a reset event if they are no
+// longer buffered.
+func (h *Handler) stream(ctx *gin.Context) {
+	var lastID *uint64
+	if v := ctx.GetHeader("Last-Event-ID"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			r := NewErrorResponse(ErrBadEventID.Error(), "Last-Event-ID must be the ID of an event")
+			writeError(ctx, http.StatusBadRequest, r)
+			return
+		}
+		lastID = &id
+	}
+
+	owner, _ := UserID(ctx.Request.Context())
+	workspace, _ := WorkspaceID(ctx.Request.Context())
+	sub := h.events.Subscribe(lastID)
+	defer sub.Cancel()
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Status(http.StatusOK)
+
+	w := ctx.Writer
+	if sub.Missed {
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: {}\n\n", sub.LastID, eventReset); err != nil {
+			return
+		}
+	} else {
+		for _, e := range sub.Replay {
+			if !e.visibleTo(owner, workspace) {
+				continue
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+		}
+	}
+	w.Flush()
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+
+	done := ctx.Request.Context().Done()
+	for {
+		select {
+		case <-done:
+			return
+		case e, ok := <-sub.Events:
+			if !ok {
+				// Dropped for falling behind. The client reconnects
+				// and resumes from the last event it got.
+				return
+			}
+			if !e.visibleTo(owner, workspace) {
+				continue
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+			heartbeat.Reset(h.heartbeat)
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		w.Flush()
+	}
+}
+
+func writeEvent(w io.Writer, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
@@ -0,0 +1,257 @@
+package todo
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Number of changes returned by a sync unless the client asks for a different
+// number.
+const DefaultSyncLimit = 100
+
+// encodeChangeToken turns a position in the change sequence into an opaque
+// token. Tokens aren't signed, unlike cursors: a forged token only makes its
+// client skip or repeat changes, and signing them would force every client
+// into a full sync once the key changes.
+func encodeChangeToken(t ChangeToken) string {
+	payload, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+func decodeChangeToken(token string) (*ChangeToken, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrBadSyncToken
+	}
+
+	var t ChangeToken
+	if err := json.Unmarshal(payload, &t); err != nil {
+		return nil, ErrBadSyncToken
+	}
+	return &t, nil
+}
+
+// Pull returns up to limit of the changes made after token, or of all the
+// todos if token is empty, along with the token to resume from.
+func (s *service) Pull(ctx context.Context, token string, limit int) (*ChangeSet, error) {
+	if limit <= 0 {
+		limit = DefaultSyncLimit
+	} else if limit > MaxLimit {
+		limit = MaxLimit
+	}
+
+	var after ChangeToken
+	if token != "" {
+		t, err := decodeChangeToken(token)
+		if err != nil {
+			return nil, err
+		}
+		after = *t
+	}
+
+	// A client syncing for the first time has nothing to delete.
+	changes, err := s.repo.Changes(ctx, after, limit+1, token != "")
+	if err != nil {
+		return nil, err
+	}
+
+	set := &ChangeSet{Todos: []Todo{}, Tombstones: []Tombstone{}}
+	if len(changes) > limit {
+		set.More = true
+		changes = changes[:limit]
+	}
+	for _, c := range changes {
+		switch {
+		case c.Todo != nil:
+			set.Todos = append(set.Todos, *c.Todo)
+		case c.Tombstone != nil:
+			set.Tombstones = append(set.Tombstones, *c.Tombstone)
+		}
+		after = ChangeToken{Seq: c.Seq, ID: c.ID}
+	}
+	set.Token = encodeChangeToken(after)
+
+	return set, nil
+}
+
+// Push applies the changes made by a client while offline in order, each on
+// its own, within a single transaction. A change to a todo which changed on
+// the server after the client made it is not applied, and reported as a
+// *ConflictError.
+func (s *service) Push(ctx context.Context, changes []SyncChange) ([]BulkResult, error) {
+	results := make([]BulkResult, len(changes))
+	err := s.transact(ctx, func(tx *service) error {
+		for i, c := range changes {
+			t, err := tx.push(ctx, c)
+			results[i] = BulkResult{Todo: t, Err: err}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (s *service) push(ctx context.Context, c SyncChange) (*Todo, error) {
+	if c.Op == BulkCreate {
+		return s.apply(ctx, c.BulkOp)
+	}
+
+	current, err := s.repo.Get(ctx, c.ID)
+	if errors.Is(err, ErrTodoNotFound) {
+		if c.Op == BulkDelete {
+			// Deleted on both sides.
+			return nil, nil
+		}
+		return nil, &ConflictError{}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if current.UpdatedAt.After(c.ChangedAt) {
+		return nil, &ConflictError{Todo: current}
+	}
+
+	// Unless the client asks for a version of its own, the todo must still
+	// be the one just checked when it is written.
+	op := c.BulkOp
+	if op.Version == nil {
+		op.Version = &current.Version
+	}
+	return s.apply(ctx, op)
+}
+
+func (h *Handler) pull(ctx *gin.Context) {
+	var (
+		limit int
+		err   error
+	)
+	if v, ok := ctx.GetQuery("limit"); ok {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			msg := fmt.Sprintf("`limit` must be an integer between 1 and %d", MaxLimit)
+			r := NewErrorResponse(ErrBadQuery.Error(), msg)
+			writeError(ctx, http.StatusBadRequest, r)
+			return
+		}
+	}
+
+	c := ctx.Request.Context()
+	set, err := h.svc.Pull(c, ctx.Query("since"), limit)
+	if err != nil {
+		if errors.Is(err, ErrBadSyncToken) {
+			msg := "`since` must be a token returned by an earlier sync"
+			r := NewErrorResponse(ErrBadSyncToken.Error(), msg)
+			writeError(ctx, http.StatusBadRequest, r)
+			return
+		}
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, set)
+}
+
+// pushRequest is the body of POST /sync.
+type pushRequest struct {
+	Changes []SyncChange `json:"changes"`
+}
+
+// pushResponse reports the outcome of every change of a push, like a bulk
+// request does, and lists the conflicting changes on their own.
+type pushResponse struct {
+	Results   []bulkItem     `json:"results"`
+	Conflicts []syncConflict `json:"conflicts"`
+}
+
+// syncConflict is a change which was not applied, by its index in the
+// request, along with the todo as it is on the server, or nil if it was
+// deleted.
+type syncConflict struct {
+	Index int    `json:"index"`
+	ID    uint32 `json:"id"`
+	Todo  *Todo  `json:"todo"`
+}
+
+func (h *Handler) push(ctx *gin.Context) {
+	if ctx.ContentType() != "application/json" {
+		r := NewErrorResponse(ErrUnsupportedMediaType.Error(), "Content-Type must be application/json")
+		writeError(ctx, http.StatusUnsupportedMediaType, r)
+		return
+	}
+
+	var req pushRequest
+	decoder := json.NewDecoder(ctx.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil || decoder.More() {
+		r := NewErrorResponse(ErrBadJson.Error(), "invalid json input")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	if len(req.Changes) == 0 || len(req.Changes) > MaxBulkOps {
+		msg := fmt.Sprintf("`changes` must hold between 1 and %d changes", MaxBulkOps)
+		r := NewErrorResponse(ErrBadJson.Error(), msg)
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	for i := range req.Changes {
+		err := checkBulkOp(&req.Changes[i].BulkOp)
+		if err == nil && req.Changes[i].ChangedAt.IsZero() {
+			err = errors.New("missing required `changed_at` field")
+		}
+		if err != nil {
+			msg := fmt.Sprintf("changes[%d]: %v", i, err)
+			r := NewErrorResponse(ErrBadJson.Error(), msg)
+			writeError(ctx, http.StatusBadRequest, r)
+			return
+		}
+	}
+
+	c := ctx.Request.Context()
+	results, err := h.svc.Push(c, req.Changes)
+	if err != nil {
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	resp := pushResponse{Results: make([]bulkItem, len(results)), Conflicts: []syncConflict{}}
+	for i, res := range results {
+		change := req.Changes[i]
+		var ce *ConflictError
+		switch {
+		case errors.As(res.Err, &ce):
+			msg := fmt.Sprintf("The todo with ID = %d changed since %s", change.ID, change.ChangedAt.UTC().Format(time.RFC3339))
+			if ce.Todo == nil {
+				msg = fmt.Sprintf("The todo with ID = %d was deleted", change.ID)
+			}
+			r := NewErrorResponse(ErrSyncConflict.Error(), msg)
+			resp.Results[i] = bulkItem{Status: http.StatusConflict, Todo: ce.Todo, Error: newProblem(http.StatusConflict, r, ctx.Request.URL.Path)}
+			resp.Conflicts = append(resp.Conflicts, syncConflict{Index: i, ID: change.ID, Todo: ce.Todo})
+		case res.Err != nil:
+			status, r := todoError(res.Err, change.ID, change.Todo)
+			resp.Results[i] = bulkItem{Status: status, Error: newProblem(status, r, ctx.Request.URL.Path)}
+		case change.Op == BulkCreate:
+			resp.Results[i] = bulkItem{Status: http.StatusCreated, Todo: res.Todo}
+		case change.Op == BulkUpdate:
+			resp.Results[i] = bulkItem{Status: http.StatusOK, Todo: res.Todo}
+		default:
+			resp.Results[i] = bulkItem{Status: http.StatusNoContent}
+		}
+	}
+
+	ctx.JSON(http.StatusOK, resp)
+}
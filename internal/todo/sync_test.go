@@ -0,0 +1,247 @@
+package todo_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+var _ = Describe("sync", Label("sync"), func() {
+	var (
+		ctx  context.Context
+		repo *mockRepo
+		svc  Service
+		now  time.Time
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		repo = &mockRepo{}
+		svc = NewService(repo)
+		now = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	})
+
+	Describe("Pull", func() {
+		It("starts with every live todo and resumes from the token", func() {
+			repo.changesFn = func(ctx context.Context, after ChangeToken, limit int, tombstones bool) ([]Change, error) {
+				Expect(after).To(Equal(ChangeToken{}))
+				Expect(limit).To(Equal(3))
+				Expect(tombstones).To(BeFalse())
+				return []Change{
+					{Seq: 4, ID: 1, Todo: &Todo{ID: 1}},
+					{Seq: 6, ID: 2, Todo: &Todo{ID: 2}},
+				}, nil
+			}
+
+			set, err := svc.Pull(ctx, "", 2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(set.Todos).To(HaveLen(2))
+			Expect(set.Tombstones).To(BeEmpty())
+			Expect(set.More).To(BeFalse())
+
+			repo.changesFn = func(ctx context.Context, after ChangeToken, limit int, tombstones bool) ([]Change, error) {
+				Expect(after).To(Equal(ChangeToken{Seq: 6, ID: 2}))
+				Expect(tombstones).To(BeTrue())
+				return []Change{}, nil
+			}
+
+			next, err := svc.Pull(ctx, set.Token, 2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(next.Todos).To(BeEmpty())
+			// Nothing changed, so the token stays put.
+			Expect(next.Token).To(Equal(set.Token))
+		})
+
+		It("pages through the changes", func() {
+			repo.changesFn = func(ctx context.Context, after ChangeToken, limit int, tombstones bool) ([]Change, error) {
+				return []Change{
+					{Seq: 7, ID: 3, Tombstone: &Tombstone{ID: 3, ListID: 1, DeletedAt: now}},
+					{Seq: 8, ID: 4, Todo: &Todo{ID: 4}},
+					{Seq: 8, ID: 5, Todo: &Todo{ID: 5}},
+				}, nil
+			}
+
+			set, err := svc.Pull(ctx, "", 2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(set.More).To(BeTrue())
+			Expect(set.Tombstones).To(ConsistOf(MatchFields(IgnoreExtras, Fields{"ID": BeEquivalentTo(3)})))
+			Expect(set.Todos).To(ConsistOf(MatchFields(IgnoreExtras, Fields{"ID": BeEquivalentTo(4)})))
+
+			repo.changesFn = func(ctx context.Context, after ChangeToken, limit int, tombstones bool) ([]Change, error) {
+				Expect(after).To(Equal(ChangeToken{Seq: 8, ID: 4}))
+				return []Change{}, nil
+			}
+			_, err = svc.Pull(ctx, set.Token, 2)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("rejects tokens it didn't issue", func() {
+			_, err := svc.Pull(ctx, "not a token", 0)
+			Expect(err).To(MatchError(ErrBadSyncToken))
+		})
+	})
+
+	Describe("Push", func() {
+		var text string
+
+		BeforeEach(func() {
+			text = "buy milk"
+			repo.getFn = func(ctx context.Context, id uint32) (*Todo, error) {
+				return &Todo{ID: id, Text: "buy eggs", UpdatedAt: now, Version: 3}, nil
+			}
+		})
+
+		It("applies changes made after the todo last changed on the server", func() {
+			repo.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+				Expect(in.Version).To(PointTo(BeEquivalentTo(3)))
+				return &Todo{ID: id, Text: *in.Text}, nil
+			}
+
+			results, err := svc.Push(ctx, []SyncChange{{
+				BulkOp:    BulkOp{Op: BulkUpdate, ID: 1, Todo: TodoInput{Text: &text}},
+				ChangedAt: now.Add(time.Minute),
+			}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results[0].Err).NotTo(HaveOccurred())
+			Expect(results[0].Todo.Text).To(Equal(text))
+			Expect(repo.transacted).To(Equal(1))
+		})
+
+		It("reports a conflict if the todo changed on the server since", func() {
+			repo.updateFn = func(ctx context.Context, id uint32, in TodoInput) (*Todo, error) {
+				Fail("the todo should not be updated")
+				return nil, nil
+			}
+
+			results, err := svc.Push(ctx, []SyncChange{{
+				BulkOp:    BulkOp{Op: BulkUpdate, ID: 1, Todo: TodoInput{Text: &text}},
+				ChangedAt: now.Add(-time.Minute),
+			}})
+			Expect(err).NotTo(HaveOccurred())
+			var conflict *ConflictError
+			Expect(results[0].Err).To(BeAssignableToTypeOf(conflict))
+			Expect(results[0].Err).To(MatchError(ErrSyncConflict))
+			Expect(results[0].Err.(*ConflictError).Todo.Text).To(Equal("buy eggs"))
+		})
+
+		It("reports updates of deleted todos as conflicts, but not deletions", func() {
+			repo.getFn = nil
+
+			results, err := svc.Push(ctx, []SyncChange{
+				{BulkOp: BulkOp{Op: BulkUpdate, ID: 1, Todo: TodoInput{Text: &text}}, ChangedAt: now},
+				{BulkOp: BulkOp{Op: BulkDelete, ID: 2}, ChangedAt: now},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results[0].Err).To(MatchError(ErrSyncConflict))
+			Expect(results[0].Err.(*ConflictError).Todo).To(BeNil())
+			Expect(results[1].Err).NotTo(HaveOccurred())
+		})
+	})
+
+	Describe("handler", func() {
+		var (
+			svc    *mockService
+			router *gin.Engine
+			rr     *httptest.ResponseRecorder
+		)
+
+		BeforeEach(func() {
+			gin.SetMode(gin.TestMode)
+			svc = &mockService{}
+			router = gin.New()
+			NewHandler(svc).Register(router)
+			rr = httptest.NewRecorder()
+		})
+
+		It("returns the changes since the token", func() {
+			svc.pullFn = func(ctx context.Context, token string, limit int) (*ChangeSet, error) {
+				Expect(token).To(Equal("abc"))
+				Expect(limit).To(Equal(50))
+				return &ChangeSet{
+					Todos:      []Todo{},
+					Tombstones: []Tombstone{{ID: 3, ListID: 1, DeletedAt: now}},
+					Token:      "def",
+				}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/sync?since=abc&limit=50", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Body.String()).To(MatchJSON(`{"todos":[],"tombstones":[{"id":3,"list_id":1,"deleted_at":"2024-05-01T12:00:00Z"}],"token":"def","more":false}`))
+		})
+
+		It("reports bad sync tokens", func() {
+			svc.pullFn = func(ctx context.Context, token string, limit int) (*ChangeSet, error) {
+				return nil, ErrBadSyncToken
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/sync?since=abc", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Code).To(Equal(ErrBadSyncToken.Error()))
+		})
+
+		It("reports the outcome of every change and lists the conflicts", func() {
+			current := &Todo{ID: 4, Text: "buy eggs", UpdatedAt: now}
+			svc.pushFn = func(ctx context.Context, changes []SyncChange) ([]BulkResult, error) {
+				Expect(changes).To(HaveLen(2))
+				Expect(changes[0].ChangedAt).To(BeTemporally("==", now))
+				return []BulkResult{
+					{Todo: &Todo{ID: 9, Text: "buy milk"}},
+					{Err: &ConflictError{Todo: current}},
+				}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/sync", strings.NewReader(`{"changes":[{"op":"create","todo":{"text":"buy milk"},"changed_at":"2024-05-01T12:00:00Z"},{"op":"update","id":4,"todo":{"completed":true},"changed_at":"2024-05-01T11:00:00Z"}]}`))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			var out struct {
+				Results []struct {
+					Status int `json:"status"`
+					Error  *struct {
+						Code string `json:"code"`
+					} `json:"error"`
+				} `json:"results"`
+				Conflicts []struct {
+					Index int   `json:"index"`
+					ID    int   `json:"id"`
+					Todo  *Todo `json:"todo"`
+				} `json:"conflicts"`
+			}
+			Expect(json.Unmarshal(rr.Body.Bytes(), &out)).To(Succeed())
+			Expect(out.Results[0].Status).To(Equal(http.StatusCreated))
+			Expect(out.Results[1].Status).To(Equal(http.StatusConflict))
+			Expect(out.Results[1].Error.Code).To(Equal(ErrSyncConflict.Error()))
+			Expect(out.Conflicts).To(HaveLen(1))
+			Expect(out.Conflicts[0].Index).To(Equal(1))
+			Expect(out.Conflicts[0].Todo.Text).To(Equal("buy eggs"))
+		})
+
+		It("requires the time of every change", func() {
+			req := httptest.NewRequest(http.MethodPost, "/sync", strings.NewReader(`{"changes":[{"op":"delete","id":4}]}`))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Detail).To(Equal("changes[0]: missing required `changed_at` field"))
+		})
+	})
+})
@@ -0,0 +1,197 @@
+package todo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TagHandler struct{ svc TagService }
+
+func NewTagHandler(s TagService) *TagHandler {
+	return &TagHandler{svc: s}
+}
+
+func (h *TagHandler) Register(r gin.IRoutes) {
+	r.GET("/tags", h.getAll)
+	r.GET("/tags/:id", h.getById)
+	r.POST("/tags", h.post)
+	r.PATCH("/tags/:id", h.patch)
+	r.DELETE("/tags/:id", h.delete)
+}
+
+// Scopes returns the scope API tokens need for each route, keyed by the
+// method followed by the path.
+func (h *TagHandler) Scopes() map[string]string {
+	return scopesByMethod("GET /tags", "GET /tags/:id", "POST /tags", "PATCH /tags/:id", "DELETE /tags/:id")
+}
+
+func (h *TagHandler) getAll(ctx *gin.Context) {
+	c := ctx.Request.Context()
+
+	tags, err := h.svc.GetAll(c)
+	if err != nil {
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tags)
+}
+
+func (h *TagHandler) getById(ctx *gin.Context) {
+	i := ctx.Param("id")
+	id, err := strconv.ParseUint(i, 10, 32)
+	if err != nil {
+		r := NewErrorResponse(ErrBadId.Error(), "ID must be an integer")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	t, err := h.svc.GetById(c, uint32(id))
+	if err != nil {
+		if errors.Is(err, ErrTagNotFound) {
+			msg := fmt.Sprintf("No resource found with ID = %d", id)
+			r := NewErrorResponse(ErrTagNotFound.Error(), msg)
+			writeError(ctx, http.StatusNotFound, r)
+			return
+		}
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, t)
+}
+
+func (h *TagHandler) post(ctx *gin.Context) {
+	if ctx.ContentType() != "application/json" {
+		r := NewErrorResponse(ErrUnsupportedMediaType.Error(), "Content-Type must be application/json")
+		writeError(ctx, http.StatusUnsupportedMediaType, r)
+		return
+	}
+
+	var newTag TagInput
+	err := decodeIntoInput(ctx, &newTag)
+	if err != nil {
+		r := NewErrorResponse(ErrBadJson.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	if newTag.Name == nil {
+		r := NewErrorResponse(ErrBadJson.Error(), "missing required `name` field")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	t, err := h.svc.Create(c, newTag)
+	if err != nil {
+		if e := inputError(err); e != nil {
+			r := newInputErrorResponse(e, err)
+			writeError(ctx, http.StatusUnprocessableEntity, r)
+			return
+		}
+		if errors.Is(err, ErrTagExists) {
+			msg := fmt.Sprintf("A tag named %q already exists", *newTag.Name)
+			r := NewErrorResponse(ErrTagExists.Error(), msg)
+			writeError(ctx, http.StatusConflict, r)
+			return
+		}
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.Header("Location", fmt.Sprintf("/tags/%d", t.ID))
+	ctx.JSON(http.StatusCreated, t)
+}
+
+func (h *TagHandler) patch(ctx *gin.Context) {
+	if ctx.ContentType() != "application/json" {
+		r := NewErrorResponse(ErrUnsupportedMediaType.Error(), "Content-Type must be application/json")
+		writeError(ctx, http.StatusUnsupportedMediaType, r)
+		return
+	}
+
+	i := ctx.Param("id")
+	id, err := strconv.ParseUint(i, 10, 32)
+	if err != nil {
+		r := NewErrorResponse(ErrBadId.Error(), "ID must be an integer")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	var updatedTag TagInput
+	err = decodeIntoInput(ctx, &updatedTag)
+	if err != nil {
+		r := NewErrorResponse(ErrBadJson.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	if updatedTag == (TagInput{}) {
+		msg := "missing required `name` or `color` field"
+		r := NewErrorResponse(ErrBadJson.Error(), msg)
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	t, err := h.svc.Update(c, uint32(id), updatedTag)
+	if err != nil {
+		if e := inputError(err); e != nil {
+			r := newInputErrorResponse(e, err)
+			writeError(ctx, http.StatusUnprocessableEntity, r)
+			return
+		}
+		if errors.Is(err, ErrTagExists) {
+			msg := fmt.Sprintf("A tag named %q already exists", *updatedTag.Name)
+			r := NewErrorResponse(ErrTagExists.Error(), msg)
+			writeError(ctx, http.StatusConflict, r)
+			return
+		}
+		if errors.Is(err, ErrTagNotFound) {
+			msg := fmt.Sprintf("No resource found with ID = %d", id)
+			r := NewErrorResponse(ErrTagNotFound.Error(), msg)
+			writeError(ctx, http.StatusNotFound, r)
+			return
+		}
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, t)
+}
+
+func (h *TagHandler) delete(ctx *gin.Context) {
+	i := ctx.Param("id")
+	id, err := strconv.ParseUint(i, 10, 32)
+	if err != nil {
+		r := NewErrorResponse(ErrBadId.Error(), "ID must be an integer")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	err = h.svc.Delete(c, uint32(id))
+	if err != nil {
+		if errors.Is(err, ErrTagNotFound) {
+			msg := fmt.Sprintf("No resource found with ID = %d", id)
+			r := NewErrorResponse(ErrTagNotFound.Error(), msg)
+			writeError(ctx, http.StatusNotFound, r)
+			return
+		}
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.JSON(http.StatusNoContent, nil)
+}
@@ -0,0 +1,202 @@
+package todo_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+type mockTagService struct {
+	getAllFn  func(context.Context) ([]Tag, error)
+	getByIDFn func(context.Context, uint32) (*Tag, error)
+	createFn  func(context.Context, TagInput) (*Tag, error)
+	updateFn  func(context.Context, uint32, TagInput) (*Tag, error)
+	deleteFn  func(context.Context, uint32) error
+}
+
+var _ TagService = (*mockTagService)(nil)
+
+func (m *mockTagService) GetAll(ctx context.Context) ([]Tag, error) {
+	if m.getAllFn != nil {
+		return m.getAllFn(ctx)
+	}
+	return []Tag{}, nil
+}
+
+func (m *mockTagService) GetById(ctx context.Context, id uint32) (*Tag, error) {
+	if m.getByIDFn != nil {
+		return m.getByIDFn(ctx, id)
+	}
+	return nil, nil
+}
+
+func (m *mockTagService) Create(ctx context.Context, in TagInput) (*Tag, error) {
+	if m.createFn != nil {
+		return m.createFn(ctx, in)
+	}
+	return nil, nil
+}
+
+func (m *mockTagService) Update(ctx context.Context, id uint32, in TagInput) (*Tag, error) {
+	if m.updateFn != nil {
+		return m.updateFn(ctx, id, in)
+	}
+	return nil, nil
+}
+
+func (m *mockTagService) Delete(ctx context.Context, id uint32) error {
+	if m.deleteFn != nil {
+		return m.deleteFn(ctx, id)
+	}
+	return nil
+}
+
+var _ = Describe("tag handler", Label("tag-handler"), func() {
+	var (
+		svc    *mockTagService
+		router *gin.Engine
+		rr     *httptest.ResponseRecorder
+	)
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		svc = &mockTagService{}
+		router = gin.New()
+		NewTagHandler(svc).Register(router)
+		rr = httptest.NewRecorder()
+	})
+
+	Describe("GET /tags", func() {
+		It("Verifies happy path", func() {
+			expected := []Tag{{ID: 1, Name: "home"}, {ID: 2, Name: "work"}}
+			svc.getAllFn = func(ctx context.Context) ([]Tag, error) { return expected, nil }
+
+			req := httptest.NewRequest(http.MethodGet, "/tags", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			var out []Tag
+			Expect(json.Unmarshal(rr.Body.Bytes(), &out)).To(Succeed())
+			Expect(out).To(Equal(expected))
+		})
+	})
+
+	Describe("GET /tags/:id", func() {
+		It("Reports not found", func() {
+			svc.getByIDFn = func(ctx context.Context, id uint32) (*Tag, error) { return nil, ErrTagNotFound }
+
+			req := httptest.NewRequest(http.MethodGet, "/tags/7", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusNotFound))
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Code).To(Equal(ErrTagNotFound.Error()))
+		})
+	})
+
+	Describe("POST /tags", func() {
+		It("Creates a tag", func() {
+			svc.createFn = func(ctx context.Context, in TagInput) (*Tag, error) {
+				Expect(in.Name).To(PointTo(Equal("home")))
+				Expect(in.Color).To(PointTo(Equal("#ff8800")))
+				return &Tag{ID: 3, Name: *in.Name, Color: in.Color}, nil
+			}
+
+			body := strings.NewReader(`{"name":"home","color":"#ff8800"}`)
+			req := httptest.NewRequest(http.MethodPost, "/tags", body)
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusCreated))
+			Expect(rr.Header().Get("Location")).To(Equal("/tags/3"))
+		})
+
+		It("Requires a name", func() {
+			req := httptest.NewRequest(http.MethodPost, "/tags", strings.NewReader(`{"color":"#ff8800"}`))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("Reports unprocessable entity for an invalid color", func() {
+			svc.createFn = func(ctx context.Context, in TagInput) (*Tag, error) { return nil, ErrTagColorInvalid }
+
+			req := httptest.NewRequest(http.MethodPost, "/tags", strings.NewReader(`{"name":"home","color":"orange"}`))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Code).To(Equal(ErrTagColorInvalid.Error()))
+		})
+
+		It("Reports conflict for a duplicate name", func() {
+			svc.createFn = func(ctx context.Context, in TagInput) (*Tag, error) { return nil, ErrTagExists }
+
+			req := httptest.NewRequest(http.MethodPost, "/tags", strings.NewReader(`{"name":"home"}`))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusConflict))
+			var resp Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Code).To(Equal(ErrTagExists.Error()))
+		})
+	})
+
+	Describe("PATCH /tags/:id", func() {
+		It("Renames a tag", func() {
+			svc.updateFn = func(ctx context.Context, id uint32, in TagInput) (*Tag, error) {
+				Expect(id).To(BeEquivalentTo(2))
+				Expect(in.Name).To(PointTo(Equal("chores")))
+				Expect(in.Color).To(BeNil())
+				return &Tag{ID: id, Name: *in.Name}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodPatch, "/tags/2", strings.NewReader(`{"name":"chores"}`))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+		})
+
+		It("Requires at least one field", func() {
+			req := httptest.NewRequest(http.MethodPatch, "/tags/2", strings.NewReader(`{}`))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Describe("DELETE /tags/:id", func() {
+		It("Deletes a tag", func() {
+			req := httptest.NewRequest(http.MethodDelete, "/tags/2", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusNoContent))
+		})
+
+		It("Reports internal server error", func() {
+			svc.deleteFn = func(ctx context.Context, id uint32) error { return errors.New("database is down") }
+
+			req := httptest.NewRequest(http.MethodDelete, "/tags/2", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusInternalServerError))
+		})
+	})
+})
@@ -0,0 +1,259 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	tagsTable     = "tags"
+	todoTagsTable = "todo_tags"
+)
+
+// tagColumns lists the columns selected for a Tag, in the order tagFields
+// expects.
+const tagColumns = "id, name, color, created_at, updated_at"
+
+// TagRepository reads and writes the tags of the user of the context within
+// its workspace.
+type TagRepository interface {
+	List(ctx context.Context) ([]Tag, error)
+	Get(ctx context.Context, id uint32) (*Tag, error)
+	Create(ctx context.Context, in TagInput) (*Tag, error)
+	Update(ctx context.Context, id uint32, in TagInput) (*Tag, error)
+	Delete(ctx context.Context, id uint32) error
+}
+
+type sqltagrepo struct {
+	db *sql.DB
+}
+
+func NewTagRepo(db *sql.DB) TagRepository {
+	return &sqltagrepo{db: db}
+}
+
+func (r *sqltagrepo) List(ctx context.Context) ([]Tag, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM `%s` WHERE workspace_id = ? AND owner_id = ? ORDER BY name", tagColumns, tagsTable)
+
+	rows, err := r.db.QueryContext(ctx, query, workspace, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []Tag{}
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(tagFields(&t)...); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+func (r *sqltagrepo) Get(ctx context.Context, id uint32) (*Tag, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM `%s` WHERE id=? AND workspace_id=? AND owner_id=?", tagColumns, tagsTable)
+
+	var t Tag
+	err = r.db.QueryRowContext(ctx, query, id, workspace, owner).Scan(tagFields(&t)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTagNotFound
+		}
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func (r *sqltagrepo) Create(ctx context.Context, in TagInput) (*Tag, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("INSERT INTO `%s` (workspace_id, owner_id, name, color) VALUES (?, ?, ?, ?)", tagsTable)
+
+	result, err := r.db.ExecContext(ctx, query, workspace, owner, in.Name, in.Color)
+	if err != nil {
+		if isDuplicateKey(err) {
+			return nil, ErrTagExists
+		}
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Get(ctx, uint32(id))
+}
+
+func (r *sqltagrepo) Update(ctx context.Context, id uint32, in TagInput) (*Tag, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("UPDATE `%s` SET name = IFNULL(?, name), color = IFNULL(?, color) WHERE id=? AND workspace_id=? AND owner_id=?", tagsTable)
+
+	result, err := r.db.ExecContext(ctx, query, in.Name, in.Color, id, workspace, owner)
+	if err != nil {
+		if isDuplicateKey(err) {
+			return nil, ErrTagExists
+		}
+		return nil, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rows > 1 {
+		log.Print("unexpected: multiple rows affected")
+		return nil, ErrUnexpected
+	}
+
+	return r.Get(ctx, id)
+}
+
+// Delete removes a tag. The foreign key on todo_tags cascades, detaching the
+// tag from every todo carrying it.
+func (r *sqltagrepo) Delete(ctx context.Context, id uint32) error {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("DELETE FROM `%s` WHERE id=? AND workspace_id=? AND owner_id=?", tagsTable)
+	result, err := r.db.ExecContext(ctx, query, id, workspace, owner)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrTagNotFound
+	} else if rows != 1 {
+		log.Print("unexpected: multiple rows affected")
+		return ErrUnexpected
+	}
+
+	return nil
+}
+
+func tagFields(t *Tag) []any {
+	return []any{&t.ID, &t.Name, &t.Color, &t.CreatedAt, &t.UpdatedAt}
+}
+
+// loadTags fills in the tag names of todos with a single query, regardless of
+// the number of todos.
+func loadTags(ctx context.Context, q querier, todos []Todo) error {
+	if len(todos) == 0 {
+		return nil
+	}
+
+	ids := make([]any, len(todos))
+	index := make(map[uint32]int, len(todos))
+	for i := range todos {
+		todos[i].Tags = []string{}
+		ids[i] = todos[i].ID
+		index[todos[i].ID] = i
+	}
+
+	query := fmt.Sprintf("SELECT tt.todo_id, g.name FROM `%s` tt JOIN `%s` g ON g.id = tt.tag_id WHERE tt.todo_id IN (%s) ORDER BY g.name", todoTagsTable, tagsTable, placeholders(len(ids)))
+	rows, err := q.QueryContext(ctx, query, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id   uint32
+			name string
+		)
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		if i, ok := index[id]; ok {
+			todos[i].Tags = append(todos[i].Tags, name)
+		}
+	}
+
+	return rows.Err()
+}
+
+// setTags replaces the tags of a todo with names, creating the tags of the
+// user of the context that don't exist yet in its workspace.
+func setTags(ctx context.Context, q querier, todoID uint32, names []string) error {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("DELETE FROM `%s` WHERE todo_id=?", todoTagsTable)
+	if _, err := q.ExecContext(ctx, query, todoID); err != nil {
+		return err
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	args := make([]any, len(names))
+	values := make([]any, 0, 3*len(names))
+	for i, n := range names {
+		args[i] = n
+		values = append(values, workspace, owner, n)
+	}
+
+	rows := strings.TrimSuffix(strings.Repeat("(?, ?, ?), ", len(names)), ", ")
+	query = fmt.Sprintf("INSERT INTO `%s` (workspace_id, owner_id, name) VALUES %s ON DUPLICATE KEY UPDATE name = name", tagsTable, rows)
+	if _, err := q.ExecContext(ctx, query, values...); err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf("INSERT INTO `%s` (todo_id, tag_id) SELECT ?, id FROM `%s` WHERE workspace_id = ? AND owner_id = ? AND name IN (%s)", todoTagsTable, tagsTable, placeholders(len(names)))
+	if _, err := q.ExecContext(ctx, query, append([]any{todoID, workspace, owner}, args...)...); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// placeholders returns n comma-separated placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// isDuplicateKey reports whether err is a MySQL unique constraint violation.
+func isDuplicateKey(err error) bool {
+	var me *mysql.MySQLError
+	return errors.As(err, &me) && me.Number == 1062
+}
@@ -0,0 +1,131 @@
+package todo_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+var _ = Describe("tag repo", Label("tag-repo"), func() {
+	var (
+		ctx  context.Context
+		db   *sql.DB
+		mock sqlmock.Sqlmock
+		repo TagRepository
+		now  time.Time
+		rows *sqlmock.Rows
+	)
+
+	const getQuery = "SELECT id, name, color, created_at, updated_at FROM `tags` WHERE id=? AND workspace_id=? AND owner_id=?"
+
+	BeforeEach(func() {
+		var err error
+		ctx = WithWorkspace(WithUser(context.Background(), testOwner), testWorkspace)
+		db, mock, err = sqlmock.New()
+		Expect(err).NotTo(HaveOccurred())
+		repo = NewTagRepo(db)
+		now = time.Now().UTC().Truncate(time.Second)
+		rows = sqlmock.NewRows([]string{"id", "name", "color", "created_at", "updated_at"})
+	})
+
+	AfterEach(func() {
+		mock.ExpectClose()
+		Expect(db.Close()).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("lists tags by name", func() {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, name, color, created_at, updated_at FROM `tags` WHERE workspace_id = ? AND owner_id = ? ORDER BY name")).
+			WithArgs(testWorkspace, testOwner).
+			WillReturnRows(rows.AddRow(1, "home", nil, now, now).AddRow(2, "work", "#ff8800", now, now))
+
+		tags, err := repo.List(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tags).To(HaveLen(2))
+		Expect(tags[0].Color).To(BeNil())
+		Expect(*tags[1].Color).To(Equal("#ff8800"))
+	})
+
+	It("returns tag not found errors", func() {
+		mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WithArgs(9, testWorkspace, testOwner).WillReturnError(sql.ErrNoRows)
+
+		tag, err := repo.Get(ctx, 9)
+		Expect(err).To(MatchError(ErrTagNotFound))
+		Expect(tag).To(BeNil())
+	})
+
+	It("creates and returns a tag", func() {
+		name := "home"
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `tags` (workspace_id, owner_id, name, color) VALUES (?, ?, ?, ?)")).
+			WithArgs(testWorkspace, testOwner, &name, nil).
+			WillReturnResult(sqlmock.NewResult(3, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WithArgs(3, testWorkspace, testOwner).WillReturnRows(rows.AddRow(3, name, nil, now, now))
+
+		tag, err := repo.Create(ctx, TagInput{Name: &name})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tag.ID).To(BeEquivalentTo(3))
+	})
+
+	It("maps duplicate names to tag exists errors", func() {
+		name := "home"
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `tags` SET name = IFNULL(?, name), color = IFNULL(?, color) WHERE id=? AND workspace_id=? AND owner_id=?")).
+			WithArgs(&name, nil, 2, testWorkspace, testOwner).
+			WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+
+		tag, err := repo.Update(ctx, 2, TagInput{Name: &name})
+		Expect(err).To(MatchError(ErrTagExists))
+		Expect(tag).To(BeNil())
+	})
+
+	It("propagates other insert errors", func() {
+		name := "home"
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `tags` (workspace_id, owner_id, name, color) VALUES (?, ?, ?, ?)")).
+			WillReturnError(errors.New("insert failed"))
+
+		_, err := repo.Create(ctx, TagInput{Name: &name})
+		Expect(err).To(MatchError("insert failed"))
+	})
+
+	It("returns tag not found errors when deleting a missing tag", func() {
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `tags` WHERE id=? AND workspace_id=? AND owner_id=?")).
+			WithArgs(4, testWorkspace, testOwner).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		Expect(repo.Delete(ctx, 4)).To(MatchError(ErrTagNotFound))
+	})
+
+	It("doesn't reach the tags of other users or workspaces", func() {
+		other := WithWorkspace(WithUser(context.Background(), testOwner+1), testWorkspace)
+		mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WithArgs(2, testWorkspace, testOwner+1).WillReturnError(sql.ErrNoRows)
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `tags` WHERE id=? AND workspace_id=? AND owner_id=?")).
+			WithArgs(2, testWorkspace+1, testOwner).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		_, err := repo.Get(other, 2)
+		Expect(err).To(MatchError(ErrTagNotFound))
+		Expect(repo.Delete(WithWorkspace(ctx, testWorkspace+1), 2)).To(MatchError(ErrTagNotFound))
+	})
+
+	It("refuses to reach tags without a workspace", func() {
+		ctx := WithUser(context.Background(), testOwner)
+
+		_, err := repo.List(ctx)
+		Expect(err).To(MatchError(ErrWorkspaceRequired))
+		_, err = repo.Get(ctx, 1)
+		Expect(err).To(MatchError(ErrWorkspaceRequired))
+		_, err = repo.Create(ctx, TagInput{})
+		Expect(err).To(MatchError(ErrWorkspaceRequired))
+		_, err = repo.Update(ctx, 1, TagInput{})
+		Expect(err).To(MatchError(ErrWorkspaceRequired))
+		Expect(repo.Delete(ctx, 1)).To(MatchError(ErrWorkspaceRequired))
+	})
+})
@@ -0,0 +1,133 @@
+package todo
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const MaxTagNameLength = 64
+
+// colorPattern matches the #rrggbb colors accepted for tags and lists.
+var colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+type TagService interface {
+	GetAll(ctx context.Context) ([]Tag, error)
+	GetById(ctx context.Context, id uint32) (*Tag, error)
+	Create(ctx context.Context, in TagInput) (*Tag, error)
+	Update(ctx context.Context, id uint32, in TagInput) (*Tag, error)
+	Delete(ctx context.Context, id uint32) error
+}
+
+type tagService struct {
+	repo TagRepository
+}
+
+func NewTagService(r TagRepository) TagService {
+	return &tagService{repo: r}
+}
+
+func (s *tagService) GetAll(ctx context.Context) ([]Tag, error) {
+	tags, err := s.repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+func (s *tagService) GetById(ctx context.Context, id uint32) (*Tag, error) {
+	t, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (s *tagService) Create(ctx context.Context, in TagInput) (*Tag, error) {
+	if err := normalizeTagInput(&in); err != nil {
+		return nil, err
+	}
+
+	t, err := s.repo.Create(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (s *tagService) Update(ctx context.Context, id uint32, in TagInput) (*Tag, error) {
+	if err := normalizeTagInput(&in); err != nil {
+		return nil, err
+	}
+
+	t, err := s.repo.Update(ctx, id, in)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (s *tagService) Delete(ctx context.Context, id uint32) error {
+	err := s.repo.Delete(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func normalizeTagInput(in *TagInput) error {
+	if in.Name != nil {
+		name, err := normalizeTagName(*in.Name)
+		if err != nil {
+			return err
+		}
+		in.Name = &name
+	}
+
+	if in.Color != nil {
+		if !colorPattern.MatchString(*in.Color) {
+			return ErrTagColorInvalid
+		}
+		color := strings.ToLower(*in.Color)
+		in.Color = &color
+	}
+
+	return nil
+}
+
+// normalizeTagName trims surrounding whitespace from a tag name and checks
+// that what remains fits the tags table.
+func normalizeTagName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > MaxTagNameLength {
+		return "", ErrTagNameInvalid
+	}
+	return name, nil
+}
+
+// normalizeTagNames normalizes each name and drops duplicates. Tag names are
+// compared case-insensitively, like the database does; the first spelling
+// wins.
+func normalizeTagNames(names []string) ([]string, error) {
+	out := make([]string, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, n := range names {
+		n, err := normalizeTagName(n)
+		if err != nil {
+			return nil, err
+		}
+		key := strings.ToLower(n)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, n)
+	}
+	return out, nil
+}
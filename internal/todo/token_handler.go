@@ -0,0 +1,107 @@
+package todo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TokenHandler serves the API tokens of the user. Its routes declare no
+// scope, so API tokens can't be used to issue more of them.
+type TokenHandler struct{ svc TokenService }
+
+func NewTokenHandler(s TokenService) *TokenHandler {
+	return &TokenHandler{svc: s}
+}
+
+func (h *TokenHandler) Register(r gin.IRoutes) {
+	r.GET("/tokens", h.getAll)
+	r.POST("/tokens", h.post)
+	r.DELETE("/tokens/:id", h.delete)
+}
+
+func (h *TokenHandler) getAll(ctx *gin.Context) {
+	c := ctx.Request.Context()
+
+	tokens, err := h.svc.GetAll(c)
+	if err != nil {
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+func (h *TokenHandler) post(ctx *gin.Context) {
+	if ctx.ContentType() != "application/json" {
+		r := NewErrorResponse(ErrUnsupportedMediaType.Error(), "Content-Type must be application/json")
+		writeError(ctx, http.StatusUnsupportedMediaType, r)
+		return
+	}
+
+	var newToken APITokenInput
+	err := decodeIntoInput(ctx, &newToken)
+	if err != nil {
+		r := NewErrorResponse(ErrBadJson.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	if newToken.Name == nil || newToken.Scopes == nil {
+		r := NewErrorResponse(ErrBadJson.Error(), "missing required `name` or `scopes` field")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	t, err := h.svc.Create(c, newToken)
+	if err != nil {
+		writeTokenError(ctx, err, 0)
+		return
+	}
+
+	ctx.Header("Location", fmt.Sprintf("/tokens/%d", t.ID))
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(http.StatusCreated, t)
+}
+
+func (h *TokenHandler) delete(ctx *gin.Context) {
+	i := ctx.Param("id")
+	id, err := strconv.ParseUint(i, 10, 32)
+	if err != nil {
+		r := NewErrorResponse(ErrBadId.Error(), "ID must be an integer")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	err = h.svc.Delete(c, uint32(id))
+	if err != nil {
+		writeTokenError(ctx, err, uint32(id))
+		return
+	}
+
+	ctx.JSON(http.StatusNoContent, nil)
+}
+
+// writeTokenError writes the response reporting err, the error of an
+// operation on API token id.
+func writeTokenError(ctx *gin.Context, err error, id uint32) {
+	if e := inputError(err); e != nil {
+		r := newInputErrorResponse(e, err)
+		writeError(ctx, http.StatusUnprocessableEntity, r)
+		return
+	}
+	if errors.Is(err, ErrTokenNotFound) {
+		msg := fmt.Sprintf("No resource found with ID = %d", id)
+		r := NewErrorResponse(ErrTokenNotFound.Error(), msg)
+		writeError(ctx, http.StatusNotFound, r)
+		return
+	}
+	r := NewErrorResponse(ErrUnexpected.Error(), "")
+	writeError(ctx, http.StatusInternalServerError, r)
+}
@@ -0,0 +1,185 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+const apiTokensTable = "api_tokens"
+
+// apiTokenColumns lists the columns selected for an APIToken, in the order
+// scanAPIToken expects.
+const apiTokenColumns = "id, name, prefix, scopes, expires_at, last_used_at, created_at"
+
+type TokenRepository interface {
+	List(ctx context.Context) ([]APIToken, error)
+	Get(ctx context.Context, id uint32) (*APIToken, error)
+	// Create stores a token of the user of ctx. Only the hash of the token
+	// is stored, along with its prefix.
+	Create(ctx context.Context, in APITokenInput, prefix string, tokenHash []byte) (*APIToken, error)
+	Delete(ctx context.Context, id uint32) error
+	// Use returns the principal of the unexpired token with the given hash,
+	// or ErrUnauthenticated if there is none, and records it as used.
+	Use(ctx context.Context, tokenHash []byte) (*Principal, error)
+}
+
+type sqltokenrepo struct {
+	db *sql.DB
+}
+
+func NewTokenRepo(db *sql.DB) TokenRepository {
+	return &sqltokenrepo{db: db}
+}
+
+func (r *sqltokenrepo) List(ctx context.Context) ([]APIToken, error) {
+	owner, err := ownerOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM `%s` WHERE user_id=? ORDER BY id", apiTokenColumns, apiTokensTable)
+
+	rows, err := r.db.QueryContext(ctx, query, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (r *sqltokenrepo) Get(ctx context.Context, id uint32) (*APIToken, error) {
+	owner, err := ownerOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM `%s` WHERE id=? AND user_id=?", apiTokenColumns, apiTokensTable)
+
+	t, err := scanAPIToken(r.db.QueryRowContext(ctx, query, id, owner))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTokenNotFound
+		}
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (r *sqltokenrepo) Create(ctx context.Context, in APITokenInput, prefix string, tokenHash []byte) (*APIToken, error) {
+	owner, err := ownerOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes, err := json.Marshal(in.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("INSERT INTO `%s` (user_id, name, prefix, token_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?, ?)", apiTokensTable)
+	result, err := r.db.ExecContext(ctx, query, owner, in.Name, prefix, tokenHash, scopes, in.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Get(ctx, uint32(id))
+}
+
+func (r *sqltokenrepo) Delete(ctx context.Context, id uint32) error {
+	owner, err := ownerOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("DELETE FROM `%s` WHERE id=? AND user_id=?", apiTokensTable)
+	result, err := r.db.ExecContext(ctx, query, id, owner)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrTokenNotFound
+	} else if rows != 1 {
+		log.Print("unexpected: multiple rows affected")
+		return ErrUnexpected
+	}
+
+	return nil
+}
+
+func (r *sqltokenrepo) Use(ctx context.Context, tokenHash []byte) (*Principal, error) {
+	query := fmt.Sprintf("SELECT id, user_id, scopes FROM `%s` WHERE token_hash=? AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)", apiTokensTable)
+
+	var (
+		id     uint32
+		p      Principal
+		scopes []byte
+	)
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(&id, &p.UserID, &scopes)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUnauthenticated
+		}
+		return nil, err
+	}
+
+	p.Scopes = []string{}
+	if err := json.Unmarshal(scopes, &p.Scopes); err != nil {
+		return nil, err
+	}
+
+	// The last use is only recorded to the minute, sparing a write on every
+	// request of a busy script.
+	query = fmt.Sprintf("UPDATE `%s` SET last_used_at = CURRENT_TIMESTAMP WHERE id=? AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL 1 MINUTE)", apiTokensTable)
+	if _, err := r.db.ExecContext(ctx, query, id); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func scanAPIToken(s scanner) (*APIToken, error) {
+	var (
+		t      APIToken
+		scopes []byte
+	)
+	err := s.Scan(&t.ID, &t.Name, &t.Prefix, &scopes, &t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Scopes = []string{}
+	if len(scopes) > 0 {
+		if err := json.Unmarshal(scopes, &t.Scopes); err != nil {
+			return nil, err
+		}
+	}
+	return &t, nil
+}
@@ -0,0 +1,114 @@
+package todo_test
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+var _ = Describe("token repo", Label("token-repo"), func() {
+	var (
+		ctx  context.Context
+		db   *sql.DB
+		mock sqlmock.Sqlmock
+		repo TokenRepository
+		now  time.Time
+	)
+
+	const columns = "id, name, prefix, scopes, expires_at, last_used_at, created_at"
+
+	BeforeEach(func() {
+		var err error
+		ctx = WithUser(context.Background(), testOwner)
+		db, mock, err = sqlmock.New()
+		Expect(err).NotTo(HaveOccurred())
+		repo = NewTokenRepo(db)
+		now = time.Now().UTC().Truncate(time.Second)
+	})
+
+	AfterEach(func() {
+		mock.ExpectClose()
+		Expect(db.Close()).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	tokenRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "name", "prefix", "scopes", "expires_at", "last_used_at", "created_at"})
+	}
+
+	It("creates tokens of the user and returns them", func() {
+		name, scopes := "backup script", []string{ScopeTodosRead}
+		hash := make([]byte, 32)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `api_tokens` (user_id, name, prefix, token_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?, ?)")).
+			WithArgs(testOwner, name, "2do_pat_abcdef", hash, []byte(`["todos:read"]`), nil).
+			WillReturnResult(sqlmock.NewResult(4, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT "+columns+" FROM `api_tokens` WHERE id=? AND user_id=?")).
+			WithArgs(4, testOwner).
+			WillReturnRows(tokenRows().AddRow(4, name, "2do_pat_abcdef", `["todos:read"]`, nil, nil, now))
+
+		t, err := repo.Create(ctx, APITokenInput{Name: &name, Scopes: &scopes}, "2do_pat_abcdef", hash)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t.ID).To(BeEquivalentTo(4))
+		Expect(t.Scopes).To(Equal(scopes))
+		Expect(t.ExpiresAt).To(BeNil())
+	})
+
+	It("lists the tokens of the user", func() {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT " + columns + " FROM `api_tokens` WHERE user_id=? ORDER BY id")).
+			WithArgs(testOwner).
+			WillReturnRows(tokenRows().
+				AddRow(1, "backup script", "2do_pat_abcdef", `["todos:read"]`, nil, now, now).
+				AddRow(2, "importer", "2do_pat_ghijkl", `["todos:read","todos:write"]`, now, nil, now))
+
+		tokens, err := repo.List(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tokens).To(HaveLen(2))
+		Expect(tokens[0].LastUsedAt).NotTo(BeNil())
+		Expect(tokens[1].Scopes).To(ConsistOf(ScopeTodosRead, ScopeTodosWrite))
+	})
+
+	It("refuses to list tokens without a user", func() {
+		_, err := repo.List(context.Background())
+		Expect(err).To(MatchError(ErrUnauthenticated))
+	})
+
+	It("returns token not found errors when revoking unknown tokens", func() {
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `api_tokens` WHERE id=? AND user_id=?")).
+			WithArgs(9, testOwner).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		Expect(repo.Delete(ctx, 9)).To(MatchError(ErrTokenNotFound))
+	})
+
+	It("records the use of unexpired tokens", func() {
+		hash := make([]byte, 32)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id, scopes FROM `api_tokens` WHERE token_hash=? AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)")).
+			WithArgs(hash).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "scopes"}).AddRow(4, testOwner, `["todos:write"]`))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `api_tokens` SET last_used_at = CURRENT_TIMESTAMP WHERE id=? AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL 1 MINUTE)")).
+			WithArgs(4).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		p, err := repo.Use(ctx, hash)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p.UserID).To(BeEquivalentTo(testOwner))
+		Expect(p.Scopes).To(Equal([]string{ScopeTodosWrite}))
+	})
+
+	It("doesn't authenticate expired or revoked tokens", func() {
+		hash := make([]byte, 32)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id, scopes FROM `api_tokens` WHERE token_hash=?")).
+			WithArgs(hash).
+			WillReturnError(sql.ErrNoRows)
+
+		_, err := repo.Use(ctx, hash)
+		Expect(err).To(MatchError(ErrUnauthenticated))
+	})
+})
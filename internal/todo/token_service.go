@@ -0,0 +1,138 @@
+package todo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxTokenNameLength bounds the names of API tokens, which have to fit the
+// api_tokens table.
+const MaxTokenNameLength = 100
+
+// APITokenPrefix starts every API token, telling them apart from session
+// tokens, and from other secrets when they leak.
+const APITokenPrefix = "2do_pat_"
+
+// tokenPrefixLength is the number of characters of an API token kept to
+// identify it, counting APITokenPrefix.
+const tokenPrefixLength = len(APITokenPrefix) + 6
+
+type TokenService interface {
+	GetAll(ctx context.Context) ([]APIToken, error)
+	// Create issues a token to the user of the context. The token itself is
+	// only ever returned here.
+	Create(ctx context.Context, in APITokenInput) (*NewAPIToken, error)
+	// Delete revokes a token.
+	Delete(ctx context.Context, id uint32) error
+	// Authenticate returns the principal of the API token, or
+	// ErrUnauthenticated if it is unknown, expired or revoked.
+	Authenticate(ctx context.Context, token string) (*Principal, error)
+}
+
+type tokenService struct {
+	repo TokenRepository
+	now  func() time.Time
+}
+
+func NewTokenService(r TokenRepository) TokenService {
+	return &tokenService{repo: r, now: time.Now}
+}
+
+func (s *tokenService) GetAll(ctx context.Context) ([]APIToken, error) {
+	tokens, err := s.repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (s *tokenService) Create(ctx context.Context, in APITokenInput) (*NewAPIToken, error) {
+	if err := validateAPIToken(&in, s.now()); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	token := APITokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	t, err := s.repo.Create(ctx, in, token[:tokenPrefixLength], hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+
+	return &NewAPIToken{APIToken: *t, Token: token}, nil
+}
+
+func (s *tokenService) Delete(ctx context.Context, id uint32) error {
+	err := s.repo.Delete(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *tokenService) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	if !isAPIToken(token) {
+		return nil, ErrUnauthenticated
+	}
+	return s.repo.Use(ctx, hashToken(token))
+}
+
+// isAPIToken reports whether token is an API token rather than a session
+// token.
+func isAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+// validateAPIToken checks the fields of in, which needs a name and at least
+// one scope, and drops duplicate scopes. An expiry has to be after now. All
+// violations are reported at once, as a *ValidationError.
+func validateAPIToken(in *APITokenInput, now time.Time) error {
+	var errs []FieldError
+	if in.Name != nil {
+		name := strings.TrimSpace(*in.Name)
+		in.Name = &name
+	}
+	if in.Name == nil || *in.Name == "" || utf8.RuneCountInString(*in.Name) > MaxTokenNameLength {
+		msg := fmt.Sprintf("name must be between 1 and %d characters long", MaxTokenNameLength)
+		errs = append(errs, newFieldError("name", ErrTokenNameInvalid, msg))
+	}
+	if in.Scopes != nil {
+		granted := []string{}
+		for _, scope := range *in.Scopes {
+			if !slices.Contains(scopes, scope) {
+				granted = nil
+				break
+			}
+			if !slices.Contains(granted, scope) {
+				granted = append(granted, scope)
+			}
+		}
+		in.Scopes = &granted
+	}
+	if in.Scopes == nil || len(*in.Scopes) == 0 {
+		msg := fmt.Sprintf("scopes must hold at least one of %s", strings.Join(scopes, ", "))
+		errs = append(errs, newFieldError("scopes", ErrTokenScopesInvalid, msg))
+	}
+	if in.ExpiresAt != nil && in.ExpiresAt.IsZero() {
+		in.ExpiresAt = nil
+	}
+	if in.ExpiresAt != nil && !in.ExpiresAt.After(now) {
+		errs = append(errs, newFieldError("expires_at", ErrTokenExpiryInvalid, "expires_at must be in the future"))
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package todo_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+// mockTokenRepo keeps API tokens, and the principals of their hashes, in
+// memory.
+type mockTokenRepo struct {
+	tokens map[uint32]APIToken
+	hashes map[string]uint32
+	owners map[uint32]uint32
+	nextID uint32
+}
+
+var _ TokenRepository = (*mockTokenRepo)(nil)
+
+func newMockTokenRepo() *mockTokenRepo {
+	return &mockTokenRepo{
+		tokens: map[uint32]APIToken{},
+		hashes: map[string]uint32{},
+		owners: map[uint32]uint32{},
+	}
+}
+
+func (m *mockTokenRepo) List(ctx context.Context) ([]APIToken, error) {
+	owner, _ := UserID(ctx)
+	tokens := []APIToken{}
+	for id, t := range m.tokens {
+		if m.owners[id] == owner {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens, nil
+}
+
+func (m *mockTokenRepo) Get(ctx context.Context, id uint32) (*APIToken, error) {
+	owner, _ := UserID(ctx)
+	t, ok := m.tokens[id]
+	if !ok || m.owners[id] != owner {
+		return nil, ErrTokenNotFound
+	}
+	return &t, nil
+}
+
+func (m *mockTokenRepo) Create(ctx context.Context, in APITokenInput, prefix string, tokenHash []byte) (*APIToken, error) {
+	owner, ok := UserID(ctx)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	m.nextID++
+	t := APIToken{ID: m.nextID, Name: *in.Name, Prefix: prefix, Scopes: *in.Scopes, CreatedAt: time.Now()}
+	if in.ExpiresAt != nil {
+		t.ExpiresAt = &in.ExpiresAt.Time
+	}
+	m.tokens[t.ID] = t
+	m.hashes[string(tokenHash)] = t.ID
+	m.owners[t.ID] = owner
+	return &t, nil
+}
+
+func (m *mockTokenRepo) Delete(ctx context.Context, id uint32) error {
+	if _, err := m.Get(ctx, id); err != nil {
+		return err
+	}
+	delete(m.tokens, id)
+	return nil
+}
+
+func (m *mockTokenRepo) Use(ctx context.Context, tokenHash []byte) (*Principal, error) {
+	id, ok := m.hashes[string(tokenHash)]
+	t, found := m.tokens[id]
+	if !ok || !found || (t.ExpiresAt != nil && !t.ExpiresAt.After(time.Now())) {
+		return nil, ErrUnauthenticated
+	}
+	now := time.Now()
+	t.LastUsedAt = &now
+	m.tokens[id] = t
+	return &Principal{UserID: m.owners[id], Scopes: t.Scopes}, nil
+}
+
+var _ = Describe("tokens", Label("tokens"), func() {
+	var (
+		ctx  context.Context
+		repo *mockTokenRepo
+		svc  TokenService
+	)
+
+	BeforeEach(func() {
+		ctx = WithUser(context.Background(), testOwner)
+		repo = newMockTokenRepo()
+		svc = NewTokenService(repo)
+	})
+
+	tokenInput := func(name string, scopes ...string) APITokenInput {
+		return APITokenInput{Name: &name, Scopes: &scopes}
+	}
+
+	Describe("service", func() {
+		It("issues prefixed tokens which are only stored as hashes", func() {
+			t, err := svc.Create(ctx, tokenInput("backup script", ScopeTodosRead))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t.Token).To(HavePrefix(APITokenPrefix))
+			Expect(t.Prefix).To(Equal(t.Token[:len(t.Prefix)]))
+			Expect(len(t.Prefix)).To(BeNumerically(">", len(APITokenPrefix)))
+			Expect(repo.hashes).NotTo(HaveKey(t.Token))
+		})
+
+		It("authenticates tokens as their user, within their scopes", func() {
+			t, err := svc.Create(ctx, tokenInput("backup script", ScopeTodosRead, ScopeTodosRead))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t.Scopes).To(Equal([]string{ScopeTodosRead}))
+
+			p, err := svc.Authenticate(context.Background(), t.Token)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p.UserID).To(BeEquivalentTo(testOwner))
+			Expect(p.Allows(ScopeTodosRead)).To(BeTrue())
+			Expect(p.Allows(ScopeTodosWrite)).To(BeFalse())
+			Expect(repo.tokens[t.ID].LastUsedAt).NotTo(BeNil())
+		})
+
+		It("stops authenticating revoked tokens", func() {
+			t, err := svc.Create(ctx, tokenInput("backup script", ScopeTodosRead))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(svc.Delete(ctx, t.ID)).To(Succeed())
+			_, err = svc.Authenticate(context.Background(), t.Token)
+			Expect(err).To(MatchError(ErrUnauthenticated))
+			Expect(svc.Delete(ctx, t.ID)).To(MatchError(ErrTokenNotFound))
+		})
+
+		It("doesn't take session tokens for API tokens", func() {
+			_, err := svc.Authenticate(ctx, "not-an-api-token")
+			Expect(err).To(MatchError(ErrUnauthenticated))
+		})
+
+		It("reports every invalid field at once", func() {
+			in := tokenInput("  ", "todos:admin")
+			in.ExpiresAt = &DateTime{Time: time.Now().Add(-time.Hour)}
+
+			_, err := svc.Create(ctx, in)
+			var verr *ValidationError
+			Expect(err).To(BeAssignableToTypeOf(verr))
+			Expect(err.(*ValidationError).Errors).To(ConsistOf(
+				MatchFields(IgnoreExtras, Fields{"Field": Equal("name"), "Rule": Equal(ErrTokenNameInvalid.Error())}),
+				MatchFields(IgnoreExtras, Fields{"Field": Equal("scopes"), "Rule": Equal(ErrTokenScopesInvalid.Error())}),
+				MatchFields(IgnoreExtras, Fields{"Field": Equal("expires_at"), "Rule": Equal(ErrTokenExpiryInvalid.Error())}),
+			))
+		})
+
+		It("requires at least one scope", func() {
+			_, err := svc.Create(ctx, tokenInput("backup script"))
+			Expect(err).To(MatchError(ErrTokenScopesInvalid))
+		})
+	})
+
+	Describe("bearer auth", func() {
+		var (
+			users UserService
+			auth  *BearerAuth
+		)
+
+		BeforeEach(func() {
+			users = NewUserService(newMockUserRepo(), time.Hour)
+			auth = NewBearerAuth(users, svc)
+		})
+
+		It("grants sessions every scope", func() {
+			email, password := "ada@example.com", "correct horse"
+			_, err := users.Register(ctx, Credentials{Email: &email, Password: &password})
+			Expect(err).NotTo(HaveOccurred())
+			s, err := users.Login(ctx, Credentials{Email: &email, Password: &password})
+			Expect(err).NotTo(HaveOccurred())
+
+			p, err := auth.Authenticate(ctx, s.Token)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p.Scopes).To(BeNil())
+			Expect(p.Allows(ScopeTodosWrite)).To(BeTrue())
+		})
+
+		It("limits API tokens to their scopes", func() {
+			t, err := svc.Create(ctx, tokenInput("backup script", ScopeTodosRead))
+			Expect(err).NotTo(HaveOccurred())
+
+			p, err := auth.Authenticate(ctx, t.Token)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p.Scopes).To(Equal([]string{ScopeTodosRead}))
+		})
+
+		It("rejects unknown tokens", func() {
+			_, err := auth.Authenticate(ctx, APITokenPrefix+"nope")
+			Expect(err).To(MatchError(ErrUnauthenticated))
+			_, err = auth.Authenticate(ctx, "nope")
+			Expect(err).To(MatchError(ErrUnauthenticated))
+		})
+	})
+
+	Describe("handler", func() {
+		var (
+			router *gin.Engine
+			rr     *httptest.ResponseRecorder
+		)
+
+		BeforeEach(func() {
+			gin.SetMode(gin.TestMode)
+			router = gin.New()
+			router.Use(asUser(testOwner))
+			NewTokenHandler(svc).Register(router)
+			rr = httptest.NewRecorder()
+		})
+
+		post := func(body string) {
+			req := httptest.NewRequest(http.MethodPost, "/tokens", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+		}
+
+		It("creates tokens without letting them be cached", func() {
+			post(`{"name": "backup script", "scopes": ["todos:read"], "expires_at": "2999-01-01"}`)
+
+			Expect(rr.Code).To(Equal(http.StatusCreated))
+			Expect(rr.Header().Get("Location")).To(Equal("/tokens/1"))
+			Expect(rr.Header().Get("Cache-Control")).To(Equal("no-store"))
+			var t NewAPIToken
+			Expect(json.Unmarshal(rr.Body.Bytes(), &t)).To(Succeed())
+			Expect(t.Token).To(HavePrefix(APITokenPrefix))
+			Expect(t.ExpiresAt).NotTo(BeNil())
+		})
+
+		It("lists tokens without the tokens themselves", func() {
+			post(`{"name": "backup script", "scopes": ["todos:read"]}`)
+			Expect(rr.Code).To(Equal(http.StatusCreated))
+
+			rr = httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/tokens", nil))
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Body.String()).To(ContainSubstring(`"prefix":"` + APITokenPrefix))
+			Expect(rr.Body.String()).NotTo(ContainSubstring(`"token"`))
+		})
+
+		It("requires a name and scopes", func() {
+			post(`{"name": "backup script"}`)
+
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("rejects unknown scopes as unprocessable", func() {
+			post(`{"name": "backup script", "scopes": ["todos:admin"]}`)
+
+			Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+			Expect(rr.Body.String()).To(ContainSubstring(ErrTokenScopesInvalid.Error()))
+		})
+
+		It("revokes tokens", func() {
+			post(`{"name": "backup script", "scopes": ["todos:read"]}`)
+			Expect(rr.Code).To(Equal(http.StatusCreated))
+
+			rr = httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/tokens/1", nil))
+			Expect(rr.Code).To(Equal(http.StatusNoContent))
+
+			rr = httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/tokens/1", nil))
+			Expect(rr.Code).To(Equal(http.StatusNotFound))
+			Expect(rr.Body.String()).To(ContainSubstring(ErrTokenNotFound.Error()))
+		})
+	})
+
+	Describe("auth errors", func() {
+		It("names the missing scope", func() {
+			gin.SetMode(gin.TestMode)
+			rr := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(rr)
+			ctx.Request = httptest.NewRequest(http.MethodPost, "/todos", nil)
+
+			WriteAuthError(ctx, &ScopeError{Scope: ScopeTodosWrite})
+
+			Expect(rr.Code).To(Equal(http.StatusForbidden))
+			Expect(rr.Header().Get("WWW-Authenticate")).To(Equal(`Bearer error="insufficient_scope", scope="todos:write"`))
+			var p Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &p)).To(Succeed())
+			Expect(p.Code).To(Equal(ErrInsufficientScope.Error()))
+		})
+	})
+})
@@ -1,16 +1,533 @@
 package todo
 
-import "time"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+)
 
 type Todo struct {
+	ID         uint32     `json:"id"`
+	Text       string     `json:"text"`
+	Completed  bool       `json:"completed"`
+	Priority   Priority   `json:"priority"`
+	StartAt    *time.Time `json:"start_at"`
+	DueAt      *time.Time `json:"due_at"`
+	ListID     uint32     `json:"list_id"`
+	OwnerID    uint32     `json:"owner_id"`
+	ParentID   *uint32    `json:"parent_id"`
+	Recurrence *string    `json:"recurrence"`
+	SeriesID   *uint32    `json:"series_id"`
+	Occurrence int        `json:"occurrence"`
+	Progress   Progress   `json:"progress"`
+	Tags       []string   `json:"tags"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	DeletedAt  *time.Time `json:"deleted_at"`
+	Version    uint32     `json:"version"`
+
+	// DueDateOnly is set when the due date is a calendar date, stored as
+	// midnight UTC, rather than a point in time.
+	DueDateOnly bool `json:"due_date_only"`
+
+	// Children is only filled in when expanding the subtree of a todo.
+	Children []Todo `json:"children,omitempty"`
+}
+
+// Progress counts the direct children of a todo and how many of them are
+// completed.
+type Progress struct {
+	Completed int `json:"completed"`
+	Total     int `json:"total"`
+}
+
+type TodoInput struct {
+	Text       *string   `json:"text"`
+	Completed  *bool     `json:"completed"`
+	Priority   *Priority `json:"priority"`
+	StartAt    *DateTime `json:"start_at"`
+	DueAt      *DateTime `json:"due_at"`
+	ListID     *uint32   `json:"list_id"`
+	ParentID   *uint32   `json:"parent_id"`  // 0 detaches the todo from its parent
+	Recurrence *string   `json:"recurrence"` // "" stops the todo from recurring
+	Tags       *[]string `json:"tags"`
+
+	// Cascade applies a change of Completed to all descendants of the todo.
+	Cascade bool `json:"-"`
+
+	// Series applies the series-wide fields of an update (Text, Priority,
+	// ListID, Recurrence and Tags) to every other occurrence of the todo's
+	// series as well.
+	Series bool `json:"-"`
+
+	// Next is the following occurrence of a recurring todo, created along
+	// with an update that completes it.
+	Next *TodoInput `json:"-"`
+
+	// SeriesID and Occurrence place a new todo in an existing series.
+	SeriesID   *uint32 `json:"-"`
+	Occurrence int     `json:"-"`
+
+	// Version, if set, makes an update conditional on the todo still being
+	// at that version.
+	Version *uint32 `json:"-"`
+}
+
+// Operations of a bulk request.
+const (
+	BulkCreate = "create"
+	BulkUpdate = "update"
+	BulkDelete = "delete"
+)
+
+// MaxBulkOps is the number of operations a bulk request may hold.
+const MaxBulkOps = 100
+
+// BulkOp is one operation of a bulk request. ID names the todo to update or
+// delete, and Version, if set, makes the operation conditional on the todo
+// being at that version. Todo holds the todo to create, or the fields to
+// update.
+type BulkOp struct {
+	Op      string    `json:"op"`
+	ID      uint32    `json:"id"`
+	Version *uint32   `json:"version"`
+	Todo    TodoInput `json:"todo"`
+}
+
+// BulkResult is the outcome of one operation of a bulk request: the todo it
+// created or updated, or the error it failed with.
+type BulkResult struct {
+	Todo *Todo
+	Err  error
+}
+
+// Affected lists the todos a write changed besides the todo it was made to,
+// as they are after the write, so that each of them is announced as well.
+type Affected struct {
+	Created []Todo
+	Updated []Todo
+	Deleted []Todo
+}
+
+// ChangeToken is a position in the change sequence of a workspace, which
+// numbers the changes to its todos in the order they were committed. ID tells apart the todos written
+// by a single change.
+type ChangeToken struct {
+	Seq uint64 `json:"s"`
+	ID  uint32 `json:"i"`
+}
+
+// Change is a todo at its latest position in the change sequence: either the
+// todo as it is now, or the tombstone of a deleted todo. Neither is set for a
+// todo purged in the meantime, whose tombstone comes later in the sequence.
+type Change struct {
+	Seq       uint64
+	ID        uint32
+	Todo      *Todo
+	Tombstone *Tombstone
+}
+
+// Tombstone stands for a todo which was trashed or deleted for good.
+type Tombstone struct {
 	ID        uint32    `json:"id"`
-	Text      string    `json:"text"`
-	Completed bool      `json:"completed"`
+	ListID    uint32    `json:"list_id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// ChangeSet is a page of the changes since a sync token: the todos created or
+// updated and the tombstones of those deleted. Token resumes the sync after
+// them, and More tells whether changes remain past it.
+type ChangeSet struct {
+	Todos      []Todo      `json:"todos"`
+	Tombstones []Tombstone `json:"tombstones"`
+	Token      string      `json:"token"`
+	More       bool        `json:"more"`
+}
+
+// SyncChange is a change made by a client while offline, at ChangedAt by the
+// clock of the client.
+type SyncChange struct {
+	BulkOp
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+type Tag struct {
+	ID        uint32    `json:"id"`
+	Name      string    `json:"name"`
+	Color     *string   `json:"color"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
-type TodoInput struct {
-	Text      *string `json:"text"`
-	Completed *bool   `json:"completed"`
+type TagInput struct {
+	Name  *string `json:"name"`
+	Color *string `json:"color"`
+}
+
+// List groups todos. Every todo belongs to exactly one list; todos created
+// without one land in the default list (the Inbox unless configured otherwise).
+// A list created by a user is owned by them and can be shared with others,
+// and Role is the role of the user reading it. Lists without an owner are
+// common to every user, who each have their own todos in them.
+type List struct {
+	ID          uint32    `json:"id"`
+	Name        string    `json:"name"`
+	Description *string   `json:"description"`
+	Color       *string   `json:"color"`
+	Archived    bool      `json:"archived"`
+	Position    int       `json:"position"`
+	OwnerID     *uint32   `json:"owner_id"`
+	Role        Role      `json:"role,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type ListInput struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Color       *string `json:"color"`
+	Archived    *bool   `json:"archived"`
+	Position    *int    `json:"position"`
+}
+
+// Role is what a user may do with a shared list and its todos: viewers read
+// them, editors also write the todos, and owners also change the list itself
+// and who it is shared with.
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleOwner  Role = "owner"
+)
+
+func (r Role) Valid() bool {
+	switch r {
+	case RoleViewer, RoleEditor, RoleOwner:
+		return true
+	}
+	return false
+}
+
+// Includes reports whether r allows everything other allows.
+func (r Role) Includes(other Role) bool {
+	return r.rank() >= other.rank()
+}
+
+func (r Role) rank() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleEditor:
+		return 2
+	case RoleOwner:
+		return 3
+	}
+	return 0
+}
+
+// Member is a user a list is shared with. The owner of the list is listed
+// among its members, as an owner.
+type Member struct {
+	UserID    uint32    `json:"user_id"`
+	Email     string    `json:"email"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Invitation is a pending invitation of a user to a list, which makes them a
+// member with Role once accepted.
+type Invitation struct {
+	ID        uint32    `json:"id"`
+	ListID    uint32    `json:"list_id"`
+	ListName  string    `json:"list_name"`
+	Inviter   string    `json:"inviter"`
+	Invitee   string    `json:"invitee"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// InvitationInput holds the email of the user to invite to a list, and the
+// role to give them.
+type InvitationInput struct {
+	Email *string `json:"email"`
+	Role  *Role   `json:"role"`
+}
+
+// Webhook is an endpoint notified of changes to todos. Events lists the types
+// of the events it receives, all of them if empty. A webhook is disabled once
+// its deliveries have failed too many times in a row; DisabledAt tells when.
+type Webhook struct {
+	ID         uint32     `json:"id"`
+	URL        string     `json:"url"`
+	Events     []string   `json:"events"`
+	Active     bool       `json:"active"`
+	Failures   int        `json:"failures"`
+	DisabledAt *time.Time `json:"disabled_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// WebhookInput holds the fields of a webhook to create or update. The secret
+// signing the deliveries can be set but is never read back.
+type WebhookInput struct {
+	URL    *string   `json:"url"`
+	Events *[]string `json:"events"`
+	Secret *string   `json:"secret"`
+	Active *bool     `json:"active"`
+}
+
+// Statuses of webhook deliveries.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Delivery is an event queued for delivery to a webhook, along with the
+// outcome of the latest attempt. A failed delivery is no longer retried.
+type Delivery struct {
+	ID             uint64          `json:"id"`
+	WebhookID      uint32          `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus *int            `json:"response_status"`
+	Error          *string         `json:"error"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+}
+
+type User struct {
+	ID        uint32    `json:"id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Credentials are the email and password a user registers and logs in with.
+type Credentials struct {
+	Email    *string `json:"email"`
+	Password *string `json:"password"`
+}
+
+// IdentityLink carries a token of an identity provider, whose identity the
+// user links to their account.
+type IdentityLink struct {
+	Token *string `json:"token"`
+}
+
+// Session is the bearer token of a logged in user, which is valid until
+// ExpiresAt or until the user logs out.
+type Session struct {
+	Token     string    `json:"token"`
+	TokenType string    `json:"token_type"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Identity is a user as known to an external identity provider: the subject
+// of the tokens of Issuer, along with the email the provider has for them.
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// APIToken is a personal access token, which lets scripts act on behalf of
+// its user within its scopes. The token itself is never read back, only the
+// prefix identifying it.
+type APIToken struct {
+	ID         uint32     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// APITokenInput holds the fields of an API token to create. A token without
+// an expiry is valid until revoked.
+type APITokenInput struct {
+	Name      *string   `json:"name"`
+	Scopes    *[]string `json:"scopes"`
+	ExpiresAt *DateTime `json:"expires_at"`
+}
+
+// NewAPIToken is an API token just created, along with the token itself,
+// which is only ever returned then.
+type NewAPIToken struct {
+	APIToken
+	Token string `json:"token"`
+}
+
+type Priority string
+
+const (
+	PriorityNone   Priority = "none"
+	PriorityLow    Priority = "low"
+	PriorityMedium Priority = "medium"
+	PriorityHigh   Priority = "high"
+	PriorityUrgent Priority = "urgent"
+)
+
+func (p Priority) Valid() bool {
+	switch p {
+	case PriorityNone, PriorityLow, PriorityMedium, PriorityHigh, PriorityUrgent:
+		return true
+	}
+	return false
+}
+
+// DateTime is a point in time accepted either as an RFC3339 timestamp or as a
+// date-only (YYYY-MM-DD) value, which is taken as midnight UTC and marked
+// DateOnly. In an update, the zero DateTime clears the date.
+type DateTime struct {
+	time.Time
+	DateOnly bool
+}
+
+func (d *DateTime) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if t, err := time.Parse(layout, s); err == nil {
+			d.Time = t.UTC()
+			d.DateOnly = layout == time.DateOnly
+			return nil
+		}
+	}
+	return errors.New("expected an RFC3339 timestamp or a YYYY-MM-DD date")
+}
+
+func (d DateTime) Value() (driver.Value, error) {
+	if d.IsZero() {
+		return nil, nil
+	}
+	return d.Time, nil
+}
+
+// dateOnly reports whether d sets a date to a calendar date.
+func (d *DateTime) dateOnly() bool {
+	return d != nil && d.DateOnly
+}
+
+// or returns the time d sets a date to, which is cur if d is nil, or nil if d
+// clears the date.
+func (d *DateTime) or(cur *time.Time) *time.Time {
+	switch {
+	case d == nil:
+		return cur
+	case d.IsZero():
+		return nil
+	}
+	return &d.Time
+}
+
+// DefaultMaxDepth is the default number of levels a todo tree may span,
+// counting the root.
+const DefaultMaxDepth = 5
+
+// DefaultTrashRetention is how long todos stay in the trash before they are
+// purged, unless configured otherwise.
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// DefaultListID is the ID of the Inbox list created by the migrations.
+const DefaultListID uint32 = 1
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 500
+)
+
+// Due date windows, relative to the current time in the client's time zone.
+const (
+	DueOverdue  = "overdue"
+	DueToday    = "today"
+	DueThisWeek = "this_week"
+)
+
+// Update scopes of a recurring todo, changing either the given occurrence only
+// or every occurrence of its series.
+const (
+	ScopeThis   = "this"
+	ScopeSeries = "series"
+)
+
+// Tag filter modes, matching todos carrying any or all of the given tags.
+const (
+	TagModeAny = "any"
+	TagModeAll = "all"
+)
+
+const (
+	SortByID        = "id"
+	SortByCreatedAt = "created_at"
+	SortByUpdatedAt = "updated_at"
+	SortByPriority  = "priority"
+)
+
+// Sort describes the ordering of a todo listing. Ties are always broken by id
+// in the same direction so that pages are stable. Sorting by priority puts the
+// most urgent todos first, then orders them by due date with undated todos
+// last; Desc reverses all of it.
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+// ListParams narrows down and pages through the todos returned by a listing.
+// Nil filters are not applied. All time bounds are exclusive except DueFrom. A
+// listing is paged either by Offset or by Cursor, an opaque token taken from a
+// previous Page.
+//
+// Due names a due date window (see DueOverdue et al.) computed in Location,
+// which defaults to UTC. The service resolves it into DueFrom and DueBefore,
+// and into DueDateFrom and DueDateBefore, which bound date-only due dates by
+// the calendar dates of the window in Location, taken as midnight UTC like
+// the dates themselves. They default to DueFrom and DueBefore.
+// Tags are matched by name according to TagMode, which defaults to any.
+// Expand fills in the Children subtree of every todo on the page. Trashed
+// todos are left out, unless Trashed is set, in which case only they are
+// listed.
+type ListParams struct {
+	Trashed       bool
+	ListID        *uint32
+	ParentID      *uint32
+	SeriesID      *uint32
+	Completed     *bool
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	UpdatedAfter  *time.Time
+	UpdatedBefore *time.Time
+	Due           string
+	Location      *time.Location
+	DueFrom       *time.Time
+	DueBefore     *time.Time
+	DueDateFrom   *time.Time
+	DueDateBefore *time.Time
+	Tags          []string
+	TagMode       string
+	Sort          Sort
+	Limit         int
+	Offset        int
+	Cursor        string
+	Expand        bool
+}
+
+// Page is a single page of a todo listing along with the number of todos
+// matching the filters across all pages. NextCursor and PrevCursor are empty
+// when there is no page in that direction.
+type Page struct {
+	Todos      []Todo `json:"todos"`
+	Total      int    `json:"-"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
 }
@@ -0,0 +1,260 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IdentityVerifier returns the identity a token of an identity provider was
+// issued to, failing with ErrIdentityInvalid for tokens it doesn't accept.
+type IdentityVerifier interface {
+	Identify(ctx context.Context, token string) (*Identity, error)
+}
+
+type UserHandler struct {
+	svc        UserService
+	identities IdentityVerifier
+}
+
+type UserHandlerOption func(*UserHandler)
+
+// WithIdentities lets users link the identities whose tokens v verifies to
+// their account.
+func WithIdentities(v IdentityVerifier) UserHandlerOption {
+	return func(h *UserHandler) {
+		h.identities = v
+	}
+}
+
+func NewUserHandler(s UserService, opts ...UserHandlerOption) *UserHandler {
+	h := &UserHandler{svc: s}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *UserHandler) Register(r gin.IRoutes) {
+	r.POST("/users", h.register)
+	r.GET("/users/me", h.me)
+	if h.identities != nil {
+		r.POST("/users/me/identities", h.linkIdentity)
+	}
+	r.POST("/sessions", h.login)
+	r.DELETE("/sessions/current", h.logout)
+}
+
+// Public returns the routes served without authentication, as the method
+// followed by the path.
+func (h *UserHandler) Public() []string {
+	return []string{"POST /users", "POST /sessions"}
+}
+
+func (h *UserHandler) register(ctx *gin.Context) {
+	if ctx.ContentType() != "application/json" {
+		r := NewErrorResponse(ErrUnsupportedMediaType.Error(), "Content-Type must be application/json")
+		writeError(ctx, http.StatusUnsupportedMediaType, r)
+		return
+	}
+
+	var in Credentials
+	err := decodeIntoInput(ctx, &in)
+	if err != nil {
+		r := NewErrorResponse(ErrBadJson.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	if in.Email == nil || in.Password == nil {
+		r := NewErrorResponse(ErrBadJson.Error(), "missing required `email` or `password` field")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	u, err := h.svc.Register(c, in)
+	if err != nil {
+		if e := inputError(err); e != nil {
+			r := newInputErrorResponse(e, err)
+			writeError(ctx, http.StatusUnprocessableEntity, r)
+			return
+		}
+		if errors.Is(err, ErrUserExists) {
+			r := NewErrorResponse(ErrUserExists.Error(), "A user with this email already exists")
+			writeError(ctx, http.StatusConflict, r)
+			return
+		}
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.Header("Location", "/users/me")
+	ctx.JSON(http.StatusCreated, u)
+}
+
+func (h *UserHandler) me(ctx *gin.Context) {
+	c := ctx.Request.Context()
+	u, err := h.svc.Me(c)
+	if err != nil {
+		if errors.Is(err, ErrUnauthenticated) || errors.Is(err, ErrUserNotFound) {
+			writeUnauthenticated(ctx)
+			return
+		}
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, u)
+}
+
+// linkIdentity links the identity of a token of the identity provider to the
+// user, who may then authenticate with the tokens of either.
+func (h *UserHandler) linkIdentity(ctx *gin.Context) {
+	if ctx.ContentType() != "application/json" {
+		r := NewErrorResponse(ErrUnsupportedMediaType.Error(), "Content-Type must be application/json")
+		writeError(ctx, http.StatusUnsupportedMediaType, r)
+		return
+	}
+
+	var in IdentityLink
+	err := decodeIntoInput(ctx, &in)
+	if err != nil {
+		r := NewErrorResponse(ErrBadJson.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	if in.Token == nil {
+		r := NewErrorResponse(ErrBadJson.Error(), "missing required `token` field")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	id, err := h.identities.Identify(c, *in.Token)
+	if err == nil {
+		err = h.svc.LinkIdentity(c, *id)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrIdentityInvalid):
+			r := NewErrorResponse(ErrIdentityInvalid.Error(), "The token isn't a valid token of the identity provider")
+			writeError(ctx, http.StatusUnprocessableEntity, r)
+		case errors.Is(err, ErrIdentityExists):
+			r := NewErrorResponse(ErrIdentityExists.Error(), "The identity is already linked to a user")
+			writeError(ctx, http.StatusConflict, r)
+		default:
+			r := NewErrorResponse(ErrUnexpected.Error(), "")
+			writeError(ctx, http.StatusInternalServerError, r)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusNoContent, nil)
+}
+
+func (h *UserHandler) login(ctx *gin.Context) {
+	if ctx.ContentType() != "application/json" {
+		r := NewErrorResponse(ErrUnsupportedMediaType.Error(), "Content-Type must be application/json")
+		writeError(ctx, http.StatusUnsupportedMediaType, r)
+		return
+	}
+
+	var in Credentials
+	err := decodeIntoInput(ctx, &in)
+	if err != nil {
+		r := NewErrorResponse(ErrBadJson.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	if in.Email == nil || in.Password == nil {
+		r := NewErrorResponse(ErrBadJson.Error(), "missing required `email` or `password` field")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	s, err := h.svc.Login(c, in)
+	if err != nil {
+		if errors.Is(err, ErrBadCredentials) {
+			r := NewErrorResponse(ErrBadCredentials.Error(), "Invalid email or password")
+			writeError(ctx, http.StatusUnauthorized, r)
+			return
+		}
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(http.StatusCreated, s)
+}
+
+func (h *UserHandler) logout(ctx *gin.Context) {
+	c := ctx.Request.Context()
+	err := h.svc.Logout(c, BearerToken(ctx))
+	if err != nil {
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.JSON(http.StatusNoContent, nil)
+}
+
+// BearerToken returns the token of the Authorization header, or "" if the
+// request has no bearer token.
+func BearerToken(ctx *gin.Context) string {
+	scheme, token, ok := strings.Cut(ctx.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, TokenTypeBearer) {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// WriteAuthError writes the response rejecting a request which failed
+// authentication with err: a challenge for ErrUnauthenticated, or a
+// forbidden response naming the missing scope for a *ScopeError. A
+// *ScopeError without a scope rejects an API token from a route only
+// sessions reach. An identity whose email is taken by a registered user
+// gets a conflict, until the user links it.
+func WriteAuthError(ctx *gin.Context, err error) {
+	if errors.Is(err, ErrUnauthenticated) {
+		writeUnauthenticated(ctx)
+		return
+	}
+	if errors.Is(err, ErrUserExists) {
+		r := NewErrorResponse(ErrUserExists.Error(), "A user with this email already exists, who must link the identity to their account first")
+		writeError(ctx, http.StatusConflict, r)
+		return
+	}
+	var se *ScopeError
+	if errors.As(err, &se) {
+		challenge := fmt.Sprintf(`%s error="%v"`, TokenTypeBearer, ErrInsufficientScope)
+		msg := "API tokens can't be used on this route"
+		if se.Scope != "" {
+			challenge += fmt.Sprintf(`, scope="%s"`, se.Scope)
+			msg = fmt.Sprintf("The %s scope is required", se.Scope)
+		}
+		ctx.Header("WWW-Authenticate", challenge)
+		r := NewErrorResponse(ErrInsufficientScope.Error(), msg)
+		writeError(ctx, http.StatusForbidden, r)
+		return
+	}
+	r := NewErrorResponse(ErrUnexpected.Error(), "")
+	writeError(ctx, http.StatusInternalServerError, r)
+}
+
+func writeUnauthenticated(ctx *gin.Context) {
+	ctx.Header("WWW-Authenticate", TokenTypeBearer)
+	r := NewErrorResponse(ErrUnauthenticated.Error(), "A valid bearer token is required")
+	writeError(ctx, http.StatusUnauthorized, r)
+}
@@ -0,0 +1,203 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	usersTable      = "users"
+	sessionsTable   = "sessions"
+	identitiesTable = "user_identities"
+)
+
+// userColumns lists the columns selected for a User, in the order userFields
+// expects.
+const userColumns = "id, email, created_at, updated_at"
+
+type UserRepository interface {
+	Get(ctx context.Context, id uint32) (*User, error)
+	// GetByEmail returns the user registered with email, along with the
+	// hash of their password, which is empty for users without one.
+	GetByEmail(ctx context.Context, email string) (*User, string, error)
+	Create(ctx context.Context, email, passwordHash string) (*User, error)
+
+	// UserByIdentity returns the ID of the user the subject of issuer is
+	// linked to, or ErrUserNotFound if there is none.
+	UserByIdentity(ctx context.Context, issuer, subject string) (uint32, error)
+	// CreateWithIdentity registers a user without a password, linked to the
+	// subject of issuer. It fails with ErrUserExists if the email is taken.
+	CreateWithIdentity(ctx context.Context, email, issuer, subject string) (uint32, error)
+	// LinkIdentity links the subject of issuer to user id.
+	LinkIdentity(ctx context.Context, id uint32, issuer, subject string) error
+
+	// CreateSession starts a session of user id, which lasts until
+	// expiresAt. Only the hash of its token is stored.
+	CreateSession(ctx context.Context, id uint32, tokenHash []byte, expiresAt time.Time) error
+	// SessionUser returns the ID of the user of the unexpired session with
+	// the given token hash, or ErrUnauthenticated if there is none.
+	SessionUser(ctx context.Context, tokenHash []byte) (uint32, error)
+	DeleteSession(ctx context.Context, tokenHash []byte) error
+}
+
+type sqluserrepo struct {
+	db *sql.DB
+}
+
+func NewUserRepo(db *sql.DB) UserRepository {
+	return &sqluserrepo{db: db}
+}
+
+func (r *sqluserrepo) Get(ctx context.Context, id uint32) (*User, error) {
+	query := fmt.Sprintf("SELECT %s FROM `%s` WHERE id=?", userColumns, usersTable)
+
+	var u User
+	err := r.db.QueryRowContext(ctx, query, id).Scan(userFields(&u)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+func (r *sqluserrepo) GetByEmail(ctx context.Context, email string) (*User, string, error) {
+	query := fmt.Sprintf("SELECT %s, COALESCE(password_hash, '') FROM `%s` WHERE email=?", userColumns, usersTable)
+
+	var (
+		u    User
+		hash string
+	)
+	err := r.db.QueryRowContext(ctx, query, email).Scan(append(userFields(&u), &hash)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", ErrUserNotFound
+		}
+		return nil, "", err
+	}
+
+	return &u, hash, nil
+}
+
+func (r *sqluserrepo) Create(ctx context.Context, email, passwordHash string) (*User, error) {
+	query := fmt.Sprintf("INSERT INTO `%s` (email, password_hash) VALUES (?, ?)", usersTable)
+
+	result, err := r.db.ExecContext(ctx, query, email, passwordHash)
+	if err != nil {
+		if isDuplicateKey(err) {
+			return nil, ErrUserExists
+		}
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Get(ctx, uint32(id))
+}
+
+func (r *sqluserrepo) UserByIdentity(ctx context.Context, issuer, subject string) (uint32, error) {
+	query := fmt.Sprintf("SELECT user_id FROM `%s` WHERE issuer=? AND subject=?", identitiesTable)
+
+	var id uint32
+	err := r.db.QueryRowContext(ctx, query, issuer, subject).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrUserNotFound
+		}
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (r *sqluserrepo) CreateWithIdentity(ctx context.Context, email, issuer, subject string) (uint32, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("INSERT INTO `%s` (email) VALUES (?)", usersTable)
+	result, err := tx.ExecContext(ctx, query, email)
+	if err != nil {
+		if isDuplicateKey(err) {
+			return 0, ErrUserExists
+		}
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := linkIdentity(ctx, tx, uint32(id), issuer, subject); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return uint32(id), nil
+}
+
+func (r *sqluserrepo) LinkIdentity(ctx context.Context, id uint32, issuer, subject string) error {
+	return linkIdentity(ctx, r.db, id, issuer, subject)
+}
+
+// linkIdentity links the subject of issuer to user id, failing with
+// ErrIdentityExists if it is already linked.
+func linkIdentity(ctx context.Context, db querier, id uint32, issuer, subject string) error {
+	query := fmt.Sprintf("INSERT INTO `%s` (issuer, subject, user_id) VALUES (?, ?, ?)", identitiesTable)
+	_, err := db.ExecContext(ctx, query, issuer, subject, id)
+	if isDuplicateKey(err) {
+		return ErrIdentityExists
+	}
+	return err
+}
+
+func (r *sqluserrepo) CreateSession(ctx context.Context, id uint32, tokenHash []byte, expiresAt time.Time) error {
+	// Expired sessions are cleaned up lazily, whenever a user logs in.
+	query := fmt.Sprintf("DELETE FROM `%s` WHERE expires_at <= CURRENT_TIMESTAMP", sessionsTable)
+	if _, err := r.db.ExecContext(ctx, query); err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf("INSERT INTO `%s` (user_id, token_hash, expires_at) VALUES (?, ?, ?)", sessionsTable)
+	_, err := r.db.ExecContext(ctx, query, id, tokenHash, expiresAt)
+	return err
+}
+
+func (r *sqluserrepo) SessionUser(ctx context.Context, tokenHash []byte) (uint32, error) {
+	query := fmt.Sprintf("SELECT user_id FROM `%s` WHERE token_hash=? AND expires_at > CURRENT_TIMESTAMP", sessionsTable)
+
+	var id uint32
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrUnauthenticated
+		}
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (r *sqluserrepo) DeleteSession(ctx context.Context, tokenHash []byte) error {
+	query := fmt.Sprintf("DELETE FROM `%s` WHERE token_hash=?", sessionsTable)
+	_, err := r.db.ExecContext(ctx, query, tokenHash)
+	return err
+}
+
+func userFields(u *User) []any {
+	return []any{&u.ID, &u.Email, &u.CreatedAt, &u.UpdatedAt}
+}
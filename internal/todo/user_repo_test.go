@@ -0,0 +1,128 @@
+package todo_test
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+var _ = Describe("user repo", Label("user-repo"), func() {
+	var (
+		ctx  context.Context
+		db   *sql.DB
+		mock sqlmock.Sqlmock
+		repo UserRepository
+		now  time.Time
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		db, mock, err = sqlmock.New()
+		Expect(err).NotTo(HaveOccurred())
+		repo = NewUserRepo(db)
+		now = time.Now().UTC().Truncate(time.Second)
+	})
+
+	AfterEach(func() {
+		mock.ExpectClose()
+		Expect(db.Close()).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("creates and returns a user", func() {
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `users` (email, password_hash) VALUES (?, ?)")).
+			WithArgs("ada@example.com", "hash").
+			WillReturnResult(sqlmock.NewResult(3, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, email, created_at, updated_at FROM `users` WHERE id=?")).
+			WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "email", "created_at", "updated_at"}).AddRow(3, "ada@example.com", now, now))
+
+		u, err := repo.Create(ctx, "ada@example.com", "hash")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(u.ID).To(BeEquivalentTo(3))
+	})
+
+	It("returns user exists errors for taken emails", func() {
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `users`")).
+			WillReturnError(&mysql.MySQLError{Number: 1062})
+
+		u, err := repo.Create(ctx, "ada@example.com", "hash")
+		Expect(err).To(MatchError(ErrUserExists))
+		Expect(u).To(BeNil())
+	})
+
+	It("returns user not found errors for unknown emails", func() {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, email, created_at, updated_at, COALESCE(password_hash, '') FROM `users` WHERE email=?")).
+			WithArgs("ada@example.com").
+			WillReturnError(sql.ErrNoRows)
+
+		_, _, err := repo.GetByEmail(ctx, "ada@example.com")
+		Expect(err).To(MatchError(ErrUserNotFound))
+	})
+
+	It("creates users of identities along with the link to them", func() {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `users` (email) VALUES (?)")).
+			WithArgs("ada@example.com").
+			WillReturnResult(sqlmock.NewResult(3, 1))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `user_identities` (issuer, subject, user_id) VALUES (?, ?, ?)")).
+			WithArgs("https://id.example.com", "abc", 3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		id, err := repo.CreateWithIdentity(ctx, "ada@example.com", "https://id.example.com", "abc")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(id).To(BeEquivalentTo(3))
+	})
+
+	It("rolls back users whose identity is already linked", func() {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `users` (email) VALUES (?)")).
+			WillReturnResult(sqlmock.NewResult(3, 1))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `user_identities`")).
+			WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectRollback()
+
+		_, err := repo.CreateWithIdentity(ctx, "ada@example.com", "https://id.example.com", "abc")
+		Expect(err).To(MatchError(ErrIdentityExists))
+	})
+
+	It("returns user not found errors for unknown identities", func() {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM `user_identities` WHERE issuer=? AND subject=?")).
+			WithArgs("https://id.example.com", "abc").
+			WillReturnError(sql.ErrNoRows)
+
+		_, err := repo.UserByIdentity(ctx, "https://id.example.com", "abc")
+		Expect(err).To(MatchError(ErrUserNotFound))
+	})
+
+	It("cleans up expired sessions when starting one", func() {
+		hash := make([]byte, 32)
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `sessions` WHERE expires_at <= CURRENT_TIMESTAMP")).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `sessions` (user_id, token_hash, expires_at) VALUES (?, ?, ?)")).
+			WithArgs(3, hash, now).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		Expect(repo.CreateSession(ctx, 3, hash, now)).To(Succeed())
+	})
+
+	It("only authenticates unexpired sessions", func() {
+		hash := make([]byte, 32)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM `sessions` WHERE token_hash=? AND expires_at > CURRENT_TIMESTAMP")).
+			WithArgs(hash).
+			WillReturnError(sql.ErrNoRows)
+
+		_, err := repo.SessionUser(ctx, hash)
+		Expect(err).To(MatchError(ErrUnauthenticated))
+	})
+})
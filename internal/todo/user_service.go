@@ -0,0 +1,228 @@
+package todo
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Bounds of emails and passwords. Passwords are counted in bytes, since
+// bcrypt ignores anything past the 72nd.
+const (
+	MaxEmailLength    = 254
+	MinPasswordLength = 8
+	MaxPasswordLength = 72
+)
+
+// DefaultSessionTTL is how long a session lasts after logging in, unless
+// configured otherwise.
+const DefaultSessionTTL = 30 * 24 * time.Hour
+
+// TokenTypeBearer is the type of session tokens, which are sent in the
+// Authorization header.
+const TokenTypeBearer = "Bearer"
+
+type UserService interface {
+	Register(ctx context.Context, in Credentials) (*User, error)
+	Login(ctx context.Context, in Credentials) (*Session, error)
+	Logout(ctx context.Context, token string) error
+	// Authenticate returns the ID of the user logged in with the session
+	// token, or ErrUnauthenticated if the session is unknown or expired.
+	Authenticate(ctx context.Context, token string) (uint32, error)
+	// Me returns the user the context acts on behalf of.
+	Me(ctx context.Context) (*User, error)
+	// Provision returns the ID of the user of an identity, registering one
+	// on first sight. It fails with ErrUserExists if a user registered with
+	// the same email, who never proved owning it, so that whoever registers
+	// an email first can't take over the account of its owner.
+	Provision(ctx context.Context, id Identity) (uint32, error)
+	// LinkIdentity links an identity to the user of the context, who holds
+	// a token of it, so that they can then authenticate with either.
+	LinkIdentity(ctx context.Context, id Identity) error
+}
+
+type userService struct {
+	repo       UserRepository
+	sessionTTL time.Duration
+	now        func() time.Time
+}
+
+// NewUserService returns a UserService whose sessions last for sessionTTL. A
+// non-positive sessionTTL means DefaultSessionTTL.
+func NewUserService(r UserRepository, sessionTTL time.Duration) UserService {
+	if sessionTTL <= 0 {
+		sessionTTL = DefaultSessionTTL
+	}
+	return &userService{repo: r, sessionTTL: sessionTTL, now: time.Now}
+}
+
+func (s *userService) Register(ctx context.Context, in Credentials) (*User, error) {
+	if err := validateCredentials(&in); err != nil {
+		return nil, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(*in.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := s.repo.Create(ctx, *in.Email, string(hash))
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// Login starts a session of the user with the given credentials. Unknown
+// emails and wrong passwords both fail with ErrBadCredentials, and take as
+// long to check, so that logging in doesn't tell which emails are registered.
+func (s *userService) Login(ctx context.Context, in Credentials) (*Session, error) {
+	if in.Email == nil || in.Password == nil {
+		return nil, ErrBadCredentials
+	}
+	email := normalizeEmail(*in.Email)
+
+	u, hash, err := s.repo.GetByEmail(ctx, email)
+	if errors.Is(err, ErrUserNotFound) {
+		bcrypt.CompareHashAndPassword(decoyHash(), []byte(*in.Password))
+		return nil, ErrBadCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(*in.Password)) != nil {
+		return nil, ErrBadCredentials
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	expiresAt := s.now().Add(s.sessionTTL).UTC().Truncate(time.Second)
+
+	if err := s.repo.CreateSession(ctx, u.ID, hashToken(token), expiresAt); err != nil {
+		return nil, err
+	}
+
+	return &Session{Token: token, TokenType: TokenTypeBearer, ExpiresAt: expiresAt}, nil
+}
+
+func (s *userService) Logout(ctx context.Context, token string) error {
+	return s.repo.DeleteSession(ctx, hashToken(token))
+}
+
+func (s *userService) Authenticate(ctx context.Context, token string) (uint32, error) {
+	if token == "" {
+		return 0, ErrUnauthenticated
+	}
+	return s.repo.SessionUser(ctx, hashToken(token))
+}
+
+func (s *userService) Me(ctx context.Context) (*User, error) {
+	id, err := ownerOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func (s *userService) Provision(ctx context.Context, id Identity) (uint32, error) {
+	user, err := s.repo.UserByIdentity(ctx, id.Issuer, id.Subject)
+	if !errors.Is(err, ErrUserNotFound) {
+		return user, err
+	}
+
+	email := normalizeEmail(id.Email)
+	if !validEmail(email) {
+		return 0, fmt.Errorf("%w: identity without a valid email", ErrUnauthenticated)
+	}
+
+	user, err = s.repo.CreateWithIdentity(ctx, email, id.Issuer, id.Subject)
+	if errors.Is(err, ErrIdentityExists) {
+		// Provisioned by a concurrent request.
+		return s.repo.UserByIdentity(ctx, id.Issuer, id.Subject)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return user, nil
+}
+
+func (s *userService) LinkIdentity(ctx context.Context, id Identity) error {
+	user, err := ownerOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.LinkIdentity(ctx, user, id.Issuer, id.Subject)
+}
+
+// hashToken returns the hash a session token is stored as. Tokens are random,
+// so a fast hash is as good as a password hash.
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// decoyHash is checked against the password of a login with an unknown email,
+// to spend as long on it as on a wrong password.
+var decoyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("decoy password"), bcrypt.DefaultCost)
+	return hash
+})
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// validateCredentials normalizes the email of in and checks both fields,
+// which are required. All violations are reported at once, as a
+// *ValidationError.
+func validateCredentials(in *Credentials) error {
+	var errs []FieldError
+	if in.Email != nil {
+		email := normalizeEmail(*in.Email)
+		in.Email = &email
+	}
+	if in.Email == nil || !validEmail(*in.Email) {
+		msg := fmt.Sprintf("email must be a valid email address of at most %d characters", MaxEmailLength)
+		errs = append(errs, newFieldError("email", ErrEmailInvalid, msg))
+	}
+	if in.Password == nil || len(*in.Password) < MinPasswordLength || len(*in.Password) > MaxPasswordLength {
+		msg := fmt.Sprintf("password must be between %d and %d bytes long", MinPasswordLength, MaxPasswordLength)
+		errs = append(errs, newFieldError("password", ErrPasswordInvalid, msg))
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// validEmail reports whether s is a bare email address, without a display
+// name or angle brackets.
+func validEmail(s string) bool {
+	if len(s) > MaxEmailLength {
+		return false
+	}
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s
+}
@@ -0,0 +1,427 @@
+package todo_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+// mockUserRepo keeps users, the sessions of their token hashes and their
+// identities in memory.
+type mockUserRepo struct {
+	users      map[uint32]User
+	hashes     map[uint32]string
+	sessions   map[string]uint32
+	expiry     map[string]time.Time
+	identities map[string]uint32
+}
+
+var _ UserRepository = (*mockUserRepo)(nil)
+
+func newMockUserRepo() *mockUserRepo {
+	return &mockUserRepo{
+		users:      map[uint32]User{},
+		hashes:     map[uint32]string{},
+		sessions:   map[string]uint32{},
+		expiry:     map[string]time.Time{},
+		identities: map[string]uint32{},
+	}
+}
+
+func (m *mockUserRepo) Get(ctx context.Context, id uint32) (*User, error) {
+	u, ok := m.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return &u, nil
+}
+
+func (m *mockUserRepo) GetByEmail(ctx context.Context, email string) (*User, string, error) {
+	for id, u := range m.users {
+		if u.Email == email {
+			return &u, m.hashes[id], nil
+		}
+	}
+	return nil, "", ErrUserNotFound
+}
+
+func (m *mockUserRepo) Create(ctx context.Context, email, passwordHash string) (*User, error) {
+	if _, _, err := m.GetByEmail(ctx, email); err == nil {
+		return nil, ErrUserExists
+	}
+	u := User{ID: uint32(len(m.users) + 1), Email: email}
+	m.users[u.ID] = u
+	m.hashes[u.ID] = passwordHash
+	return &u, nil
+}
+
+func (m *mockUserRepo) UserByIdentity(ctx context.Context, issuer, subject string) (uint32, error) {
+	id, ok := m.identities[issuer+" "+subject]
+	if !ok {
+		return 0, ErrUserNotFound
+	}
+	return id, nil
+}
+
+func (m *mockUserRepo) CreateWithIdentity(ctx context.Context, email, issuer, subject string) (uint32, error) {
+	u, err := m.Create(ctx, email, "")
+	if err != nil {
+		return 0, err
+	}
+	return u.ID, m.LinkIdentity(ctx, u.ID, issuer, subject)
+}
+
+func (m *mockUserRepo) LinkIdentity(ctx context.Context, id uint32, issuer, subject string) error {
+	if _, ok := m.identities[issuer+" "+subject]; ok {
+		return ErrIdentityExists
+	}
+	m.identities[issuer+" "+subject] = id
+	return nil
+}
+
+func (m *mockUserRepo) CreateSession(ctx context.Context, id uint32, tokenHash []byte, expiresAt time.Time) error {
+	m.sessions[string(tokenHash)] = id
+	m.expiry[string(tokenHash)] = expiresAt
+	return nil
+}
+
+func (m *mockUserRepo) SessionUser(ctx context.Context, tokenHash []byte) (uint32, error) {
+	id, ok := m.sessions[string(tokenHash)]
+	if !ok {
+		return 0, ErrUnauthenticated
+	}
+	return id, nil
+}
+
+func (m *mockUserRepo) DeleteSession(ctx context.Context, tokenHash []byte) error {
+	delete(m.sessions, string(tokenHash))
+	return nil
+}
+
+// fakeVerifier identifies the tokens it maps to an identity.
+type fakeVerifier map[string]Identity
+
+func (f fakeVerifier) Identify(ctx context.Context, token string) (*Identity, error) {
+	id, ok := f[token]
+	if !ok {
+		return nil, ErrIdentityInvalid
+	}
+	return &id, nil
+}
+
+var _ = Describe("users", Label("users"), func() {
+	var (
+		ctx  context.Context
+		repo *mockUserRepo
+		svc  UserService
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		repo = newMockUserRepo()
+		svc = NewUserService(repo, time.Hour)
+	})
+
+	credentials := func(email, password string) Credentials {
+		return Credentials{Email: &email, Password: &password}
+	}
+
+	Describe("service", func() {
+		It("registers users with a normalized email and a hashed password", func() {
+			u, err := svc.Register(ctx, credentials("  Ada@Example.com ", "correct horse"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(u.Email).To(Equal("ada@example.com"))
+			Expect(repo.hashes[u.ID]).NotTo(BeEmpty())
+			Expect(repo.hashes[u.ID]).NotTo(ContainSubstring("correct horse"))
+		})
+
+		It("reports every invalid field at once", func() {
+			_, err := svc.Register(ctx, credentials("Ada <ada@example.com>", "short"))
+			var verr *ValidationError
+			Expect(err).To(BeAssignableToTypeOf(verr))
+			Expect(err.(*ValidationError).Errors).To(ConsistOf(
+				MatchFields(IgnoreExtras, Fields{"Field": Equal("email"), "Rule": Equal(ErrEmailInvalid.Error())}),
+				MatchFields(IgnoreExtras, Fields{"Field": Equal("password"), "Rule": Equal(ErrPasswordInvalid.Error())}),
+			))
+		})
+
+		It("rejects passwords longer than bcrypt reads", func() {
+			_, err := svc.Register(ctx, credentials("ada@example.com", strings.Repeat("a", MaxPasswordLength+1)))
+			Expect(err).To(MatchError(ErrPasswordInvalid))
+		})
+
+		It("logs users in with a bearer token which authenticates them", func() {
+			u, err := svc.Register(ctx, credentials("ada@example.com", "correct horse"))
+			Expect(err).NotTo(HaveOccurred())
+
+			s, err := svc.Login(ctx, credentials("ADA@example.com", "correct horse"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s.TokenType).To(Equal(TokenTypeBearer))
+			Expect(s.ExpiresAt).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+			Expect(repo.sessions).NotTo(HaveKey(s.Token))
+
+			id, err := svc.Authenticate(ctx, s.Token)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(id).To(Equal(u.ID))
+		})
+
+		It("tells wrong passwords and unknown emails apart from no one", func() {
+			_, err := svc.Register(ctx, credentials("ada@example.com", "correct horse"))
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = svc.Login(ctx, credentials("ada@example.com", "battery staple"))
+			Expect(err).To(MatchError(ErrBadCredentials))
+			_, err = svc.Login(ctx, credentials("bob@example.com", "correct horse"))
+			Expect(err).To(MatchError(ErrBadCredentials))
+		})
+
+		It("ends sessions on logout", func() {
+			_, err := svc.Register(ctx, credentials("ada@example.com", "correct horse"))
+			Expect(err).NotTo(HaveOccurred())
+			s, err := svc.Login(ctx, credentials("ada@example.com", "correct horse"))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(svc.Logout(ctx, s.Token)).To(Succeed())
+			_, err = svc.Authenticate(ctx, s.Token)
+			Expect(err).To(MatchError(ErrUnauthenticated))
+		})
+
+		It("provisions users of identities on first sight", func() {
+			id := Identity{Issuer: "https://id.example.com", Subject: "abc", Email: "Ada@Example.com"}
+
+			first, err := svc.Provision(ctx, id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(repo.users[first].Email).To(Equal("ada@example.com"))
+
+			again, err := svc.Provision(ctx, id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(again).To(Equal(first))
+			Expect(repo.users).To(HaveLen(1))
+		})
+
+		It("doesn't let provisioned users log in with a password", func() {
+			_, err := svc.Provision(ctx, Identity{Issuer: "https://id.example.com", Subject: "abc", Email: "ada@example.com"})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = svc.Login(ctx, credentials("ada@example.com", ""))
+			Expect(err).To(MatchError(ErrBadCredentials))
+		})
+
+		It("doesn't link identities to registered users whose email is unverified", func() {
+			// Whoever registered the email may not own it, so even an
+			// identity provider vouching for the email can't claim it.
+			_, err := svc.Register(ctx, credentials("ada@example.com", "correct horse"))
+			Expect(err).NotTo(HaveOccurred())
+			id := Identity{Issuer: "https://id.example.com", Subject: "abc", Email: "ada@example.com", EmailVerified: true}
+
+			_, err = svc.Provision(ctx, id)
+			Expect(err).To(MatchError(ErrUserExists))
+			Expect(repo.identities).To(BeEmpty())
+		})
+
+		It("links identities to the user of the context on request", func() {
+			u, err := svc.Register(ctx, credentials("ada@example.com", "correct horse"))
+			Expect(err).NotTo(HaveOccurred())
+			id := Identity{Issuer: "https://id.example.com", Subject: "abc", Email: "ada@example.com"}
+
+			Expect(svc.LinkIdentity(WithUser(ctx, u.ID), id)).To(Succeed())
+			linked, err := svc.Provision(ctx, id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(linked).To(Equal(u.ID))
+
+			Expect(svc.LinkIdentity(WithUser(ctx, u.ID), id)).To(MatchError(ErrIdentityExists))
+			Expect(svc.LinkIdentity(ctx, id)).To(MatchError(ErrUnauthenticated))
+		})
+
+		It("refuses identities without an email", func() {
+			_, err := svc.Provision(ctx, Identity{Issuer: "https://id.example.com", Subject: "abc"})
+			Expect(err).To(MatchError(ErrUnauthenticated))
+		})
+
+		It("returns the user of the context", func() {
+			u, err := svc.Register(ctx, credentials("ada@example.com", "correct horse"))
+			Expect(err).NotTo(HaveOccurred())
+
+			me, err := svc.Me(WithUser(ctx, u.ID))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(me.Email).To(Equal("ada@example.com"))
+
+			_, err = svc.Me(ctx)
+			Expect(err).To(MatchError(ErrUnauthenticated))
+		})
+	})
+
+	Describe("handler", func() {
+		var (
+			h      *UserHandler
+			router *gin.Engine
+			rr     *httptest.ResponseRecorder
+		)
+
+		BeforeEach(func() {
+			gin.SetMode(gin.TestMode)
+			h = NewUserHandler(svc)
+			router = gin.New()
+			h.Register(router)
+			rr = httptest.NewRecorder()
+		})
+
+		post := func(path, body string) {
+			req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+		}
+
+		It("registers users", func() {
+			post("/users", `{"email": "ada@example.com", "password": "correct horse"}`)
+
+			Expect(rr.Code).To(Equal(http.StatusCreated))
+			Expect(rr.Header().Get("Location")).To(Equal("/users/me"))
+			Expect(rr.Body.String()).NotTo(ContainSubstring("password"))
+		})
+
+		It("returns conflict for registered emails", func() {
+			_, err := svc.Register(ctx, credentials("ada@example.com", "correct horse"))
+			Expect(err).NotTo(HaveOccurred())
+
+			post("/users", `{"email": "ada@example.com", "password": "battery staple"}`)
+
+			Expect(rr.Code).To(Equal(http.StatusConflict))
+			Expect(rr.Body.String()).To(ContainSubstring(ErrUserExists.Error()))
+		})
+
+		It("rejects invalid credentials as unprocessable", func() {
+			post("/users", `{"email": "ada", "password": "correct horse"}`)
+
+			Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+			Expect(rr.Body.String()).To(ContainSubstring(ErrEmailInvalid.Error()))
+		})
+
+		It("requires an email and a password", func() {
+			post("/sessions", `{"email": "ada@example.com"}`)
+
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("logs users in without letting the token be cached", func() {
+			_, err := svc.Register(ctx, credentials("ada@example.com", "correct horse"))
+			Expect(err).NotTo(HaveOccurred())
+
+			post("/sessions", `{"email": "ada@example.com", "password": "correct horse"}`)
+
+			Expect(rr.Code).To(Equal(http.StatusCreated))
+			Expect(rr.Header().Get("Cache-Control")).To(Equal("no-store"))
+			var s Session
+			Expect(json.Unmarshal(rr.Body.Bytes(), &s)).To(Succeed())
+			Expect(s.Token).NotTo(BeEmpty())
+			Expect(s.TokenType).To(Equal(TokenTypeBearer))
+		})
+
+		It("returns unauthorized for bad credentials", func() {
+			post("/sessions", `{"email": "ada@example.com", "password": "correct horse"}`)
+
+			Expect(rr.Code).To(Equal(http.StatusUnauthorized))
+			Expect(rr.Body.String()).To(ContainSubstring(ErrBadCredentials.Error()))
+		})
+
+		Describe("authentication", func() {
+			var token string
+
+			BeforeEach(func() {
+				auth := NewBearerAuth(svc, NewTokenService(newMockTokenRepo()))
+				router = gin.New()
+				router.Use(func(ctx *gin.Context) {
+					p, err := auth.Authenticate(ctx.Request.Context(), BearerToken(ctx))
+					if err != nil {
+						WriteAuthError(ctx, err)
+						ctx.Abort()
+						return
+					}
+					ctx.Request = ctx.Request.WithContext(WithPrincipal(ctx.Request.Context(), *p))
+					ctx.Next()
+				})
+				h.Register(router)
+
+				_, err := svc.Register(ctx, credentials("ada@example.com", "correct horse"))
+				Expect(err).NotTo(HaveOccurred())
+				s, err := svc.Login(ctx, credentials("ada@example.com", "correct horse"))
+				Expect(err).NotTo(HaveOccurred())
+				token = s.Token
+			})
+
+			It("acts on behalf of the user of the bearer token", func() {
+				req := httptest.NewRequest(http.MethodGet, "/users/me", nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				router.ServeHTTP(rr, req)
+
+				Expect(rr.Code).To(Equal(http.StatusOK))
+				var u User
+				Expect(json.Unmarshal(rr.Body.Bytes(), &u)).To(Succeed())
+				Expect(u.Email).To(Equal("ada@example.com"))
+			})
+
+			It("challenges requests without a valid token", func() {
+				for _, header := range []string{"", "Bearer", "Bearer nope", "Basic " + token} {
+					rr = httptest.NewRecorder()
+					req := httptest.NewRequest(http.MethodGet, "/users/me", nil)
+					req.Header.Set("Authorization", header)
+					router.ServeHTTP(rr, req)
+
+					Expect(rr.Code).To(Equal(http.StatusUnauthorized), header)
+					Expect(rr.Header().Get("WWW-Authenticate")).To(Equal(TokenTypeBearer))
+					var p Problem
+					Expect(json.Unmarshal(rr.Body.Bytes(), &p)).To(Succeed())
+					Expect(p.Code).To(Equal(ErrUnauthenticated.Error()))
+				}
+			})
+
+			It("links the identities of valid tokens of the identity provider", func() {
+				verifier := fakeVerifier{"good": {Issuer: "https://id.example.com", Subject: "abc"}}
+				router = gin.New()
+				router.Use(func(ctx *gin.Context) {
+					p, _ := NewBearerAuth(svc, nil).Authenticate(ctx.Request.Context(), BearerToken(ctx))
+					ctx.Request = ctx.Request.WithContext(WithPrincipal(ctx.Request.Context(), *p))
+				})
+				NewUserHandler(svc, WithIdentities(verifier)).Register(router)
+
+				link := func(body string) *httptest.ResponseRecorder {
+					rr := httptest.NewRecorder()
+					req := httptest.NewRequest(http.MethodPost, "/users/me/identities", strings.NewReader(body))
+					req.Header.Set("Content-Type", "application/json")
+					req.Header.Set("Authorization", "Bearer "+token)
+					router.ServeHTTP(rr, req)
+					return rr
+				}
+
+				Expect(link(`{"token": "bad"}`).Code).To(Equal(http.StatusUnprocessableEntity))
+				Expect(link(`{}`).Code).To(Equal(http.StatusBadRequest))
+				Expect(link(`{"token": "good"}`).Code).To(Equal(http.StatusNoContent))
+				Expect(repo.identities).To(HaveKey("https://id.example.com abc"))
+				Expect(link(`{"token": "good"}`).Code).To(Equal(http.StatusConflict))
+			})
+
+			It("revokes the token on logout", func() {
+				req := httptest.NewRequest(http.MethodDelete, "/sessions/current", nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				router.ServeHTTP(rr, req)
+				Expect(rr.Code).To(Equal(http.StatusNoContent))
+
+				rr = httptest.NewRecorder()
+				req = httptest.NewRequest(http.MethodGet, "/users/me", nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				router.ServeHTTP(rr, req)
+				Expect(rr.Code).To(Equal(http.StatusUnauthorized))
+			})
+		})
+	})
+})
@@ -0,0 +1,83 @@
+package todo
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/unicode/norm"
+)
+
+// TextRules bound the length of the text of a todo, counted in grapheme
+// clusters (user-perceived characters) after normalization.
+type TextRules struct {
+	MinLength int
+	MaxLength int
+}
+
+// DefaultTextRules are the rules of todo text unless configured otherwise.
+var DefaultTextRules = TextRules{MinLength: 1, MaxLength: 1000}
+
+func newFieldError(field string, rule error, msg string) FieldError {
+	return FieldError{Field: field, Rule: rule.Error(), Message: msg, err: rule}
+}
+
+// validateTodo normalizes the fields set by in and checks them against the
+// validation rules of todos. All violations are reported at once, as a
+// *ValidationError.
+func (s *service) validateTodo(in *TodoInput) error {
+	var errs []FieldError
+	if in.Text != nil {
+		text := normalizeText(*in.Text)
+		in.Text = &text
+		errs = append(errs, s.textRules.check("text", text)...)
+	}
+	if in.Priority != nil && !in.Priority.Valid() {
+		msg := fmt.Sprintf("priority must be one of %s, %s, %s, %s or %s", PriorityNone, PriorityLow, PriorityMedium, PriorityHigh, PriorityUrgent)
+		errs = append(errs, newFieldError("priority", ErrPriorityInvalid, msg))
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// normalizeText puts text in Unicode normalization form C, so that equal texts
+// are stored alike, and trims surrounding whitespace.
+func normalizeText(text string) string {
+	return strings.TrimSpace(norm.NFC.String(text))
+}
+
+// check returns the violations of the rules by the normalized text of field.
+func (r TextRules) check(field, text string) []FieldError {
+	var errs []FieldError
+	if strings.IndexFunc(text, isForbiddenControl) >= 0 {
+		msg := fmt.Sprintf("%s must not contain control characters", field)
+		errs = append(errs, newFieldError(field, ErrTextControlChars, msg))
+	}
+
+	switch n := uniseg.GraphemeClusterCount(text); {
+	case n == 0:
+		msg := fmt.Sprintf("%s must not be empty", field)
+		errs = append(errs, newFieldError(field, ErrTextEmpty, msg))
+	case n < r.MinLength:
+		msg := fmt.Sprintf("%s must be at least %d characters long", field, r.MinLength)
+		errs = append(errs, newFieldError(field, ErrTodoTooShort, msg))
+	case r.MaxLength > 0 && n > r.MaxLength:
+		msg := fmt.Sprintf("%s must be at most %d characters long", field, r.MaxLength)
+		errs = append(errs, newFieldError(field, ErrTodoTooLong, msg))
+	}
+	return errs
+}
+
+// isForbiddenControl reports whether r is a control character other than the
+// tabs and line breaks of multi-line text.
+func isForbiddenControl(r rune) bool {
+	switch r {
+	case '\t', '\n', '\r':
+		return false
+	}
+	return unicode.IsControl(r)
+}
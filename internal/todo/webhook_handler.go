@@ -0,0 +1,196 @@
+package todo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookHandler struct{ svc WebhookService }
+
+func NewWebhookHandler(s WebhookService) *WebhookHandler {
+	return &WebhookHandler{svc: s}
+}
+
+func (h *WebhookHandler) Register(r gin.IRoutes) {
+	r.GET("/webhooks", h.getAll)
+	r.GET("/webhooks/:id", h.getById)
+	r.POST("/webhooks", h.post)
+	r.PATCH("/webhooks/:id", h.patch)
+	r.DELETE("/webhooks/:id", h.delete)
+	r.GET("/webhooks/:id/deliveries", h.getDeliveries)
+}
+
+func (h *WebhookHandler) getAll(ctx *gin.Context) {
+	c := ctx.Request.Context()
+
+	webhooks, err := h.svc.GetAll(c)
+	if err != nil {
+		r := NewErrorResponse(ErrUnexpected.Error(), "")
+		writeError(ctx, http.StatusInternalServerError, r)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, webhooks)
+}
+
+func (h *WebhookHandler) getById(ctx *gin.Context) {
+	i := ctx.Param("id")
+	id, err := strconv.ParseUint(i, 10, 32)
+	if err != nil {
+		r := NewErrorResponse(ErrBadId.Error(), "ID must be an integer")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	w, err := h.svc.GetById(c, uint32(id))
+	if err != nil {
+		writeWebhookError(ctx, err, uint32(id))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, w)
+}
+
+func (h *WebhookHandler) post(ctx *gin.Context) {
+	if ctx.ContentType() != "application/json" {
+		r := NewErrorResponse(ErrUnsupportedMediaType.Error(), "Content-Type must be application/json")
+		writeError(ctx, http.StatusUnsupportedMediaType, r)
+		return
+	}
+
+	var newWebhook WebhookInput
+	err := decodeIntoInput(ctx, &newWebhook)
+	if err != nil {
+		r := NewErrorResponse(ErrBadJson.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	if newWebhook.URL == nil || newWebhook.Secret == nil {
+		r := NewErrorResponse(ErrBadJson.Error(), "missing required `url` or `secret` field")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	w, err := h.svc.Create(c, newWebhook)
+	if err != nil {
+		writeWebhookError(ctx, err, 0)
+		return
+	}
+
+	ctx.Header("Location", fmt.Sprintf("/webhooks/%d", w.ID))
+	ctx.JSON(http.StatusCreated, w)
+}
+
+func (h *WebhookHandler) patch(ctx *gin.Context) {
+	if ctx.ContentType() != "application/json" {
+		r := NewErrorResponse(ErrUnsupportedMediaType.Error(), "Content-Type must be application/json")
+		writeError(ctx, http.StatusUnsupportedMediaType, r)
+		return
+	}
+
+	i := ctx.Param("id")
+	id, err := strconv.ParseUint(i, 10, 32)
+	if err != nil {
+		r := NewErrorResponse(ErrBadId.Error(), "ID must be an integer")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	var updatedWebhook WebhookInput
+	err = decodeIntoInput(ctx, &updatedWebhook)
+	if err != nil {
+		r := NewErrorResponse(ErrBadJson.Error(), err.Error())
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	if updatedWebhook == (WebhookInput{}) {
+		msg := "missing at least one field to update"
+		r := NewErrorResponse(ErrBadJson.Error(), msg)
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	w, err := h.svc.Update(c, uint32(id), updatedWebhook)
+	if err != nil {
+		writeWebhookError(ctx, err, uint32(id))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, w)
+}
+
+func (h *WebhookHandler) delete(ctx *gin.Context) {
+	i := ctx.Param("id")
+	id, err := strconv.ParseUint(i, 10, 32)
+	if err != nil {
+		r := NewErrorResponse(ErrBadId.Error(), "ID must be an integer")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	c := ctx.Request.Context()
+	err = h.svc.Delete(c, uint32(id))
+	if err != nil {
+		writeWebhookError(ctx, err, uint32(id))
+		return
+	}
+
+	ctx.JSON(http.StatusNoContent, nil)
+}
+
+func (h *WebhookHandler) getDeliveries(ctx *gin.Context) {
+	i := ctx.Param("id")
+	id, err := strconv.ParseUint(i, 10, 32)
+	if err != nil {
+		r := NewErrorResponse(ErrBadId.Error(), "ID must be an integer")
+		writeError(ctx, http.StatusBadRequest, r)
+		return
+	}
+
+	var limit int
+	if v, ok := ctx.GetQuery("limit"); ok {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			msg := fmt.Sprintf("`limit` must be an integer between 1 and %d", MaxLimit)
+			r := NewErrorResponse(ErrBadQuery.Error(), msg)
+			writeError(ctx, http.StatusBadRequest, r)
+			return
+		}
+	}
+
+	c := ctx.Request.Context()
+	deliveries, err := h.svc.Deliveries(c, uint32(id), limit)
+	if err != nil {
+		writeWebhookError(ctx, err, uint32(id))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, deliveries)
+}
+
+// writeWebhookError writes the response reporting err, the error of an
+// operation on webhook id.
+func writeWebhookError(ctx *gin.Context, err error, id uint32) {
+	if e := inputError(err); e != nil {
+		r := newInputErrorResponse(e, err)
+		writeError(ctx, http.StatusUnprocessableEntity, r)
+		return
+	}
+	if errors.Is(err, ErrWebhookNotFound) {
+		msg := fmt.Sprintf("No resource found with ID = %d", id)
+		r := NewErrorResponse(ErrWebhookNotFound.Error(), msg)
+		writeError(ctx, http.StatusNotFound, r)
+		return
+	}
+	r := NewErrorResponse(ErrUnexpected.Error(), "")
+	writeError(ctx, http.StatusInternalServerError, r)
+}
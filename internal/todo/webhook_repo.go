@@ -0,0 +1,360 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	webhooksTable   = "webhooks"
+	deliveriesTable = "webhook_deliveries"
+)
+
+// webhookColumns lists the columns selected for a Webhook, in the order
+// scanWebhook expects.
+const webhookColumns = "id, url, events, active, failures, disabled_at, created_at, updated_at"
+
+// deliveryColumns lists the columns selected for a Delivery, in the order
+// deliveryFields expects.
+const deliveryColumns = "id, webhook_id, event, payload, status, attempts, response_status, error, next_attempt_at, created_at, delivered_at"
+
+// WebhookRepository stores webhooks. Like a Repository, it only reaches the
+// webhooks of the user of the context within its workspace, except for the claims and attempts of
+// the dispatcher, which delivers to the webhooks of every user.
+type WebhookRepository interface {
+	List(ctx context.Context) ([]Webhook, error)
+	Get(ctx context.Context, id uint32) (*Webhook, error)
+	Create(ctx context.Context, in WebhookInput) (*Webhook, error)
+	Update(ctx context.Context, id uint32, in WebhookInput) (*Webhook, error)
+	Delete(ctx context.Context, id uint32) error
+	// Deliveries returns the latest deliveries to webhook id, newest first.
+	Deliveries(ctx context.Context, id uint32, limit int) ([]Delivery, error)
+
+	// Claim returns up to limit pending deliveries which are due, to
+	// active webhooks, and holds them back from other claims for the
+	// duration of the lease.
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]PendingDelivery, error)
+	// RecordAttempt stores the outcome of an attempt to deliver d. Failed
+	// attempts count towards the consecutive failures of the webhook,
+	// which is disabled once they reach failureLimit.
+	RecordAttempt(ctx context.Context, d PendingDelivery, a DeliveryAttempt, failureLimit int) error
+}
+
+// PendingDelivery is a delivery claimed for an attempt, along with the
+// endpoint it goes to. Attempts counts the earlier attempts.
+type PendingDelivery struct {
+	ID        uint64
+	WebhookID uint32
+	Event     string
+	Payload   []byte
+	Attempts  int
+	URL       string
+	Secret    string
+}
+
+// DeliveryAttempt is the outcome of an attempt to deliver a webhook. Status is
+// the status code of the response, or zero if none came. A failed attempt is
+// retried at RetryAt, or never if it is nil.
+type DeliveryAttempt struct {
+	Succeeded bool
+	Status    int
+	Error     string
+	RetryAt   *time.Time
+}
+
+type sqlwebhookrepo struct {
+	db *sql.DB
+}
+
+func NewWebhookRepo(db *sql.DB) WebhookRepository {
+	return &sqlwebhookrepo{db: db}
+}
+
+func (r *sqlwebhookrepo) List(ctx context.Context) ([]Webhook, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM `%s` WHERE workspace_id=? AND owner_id=? ORDER BY id", webhookColumns, webhooksTable)
+
+	rows, err := r.db.QueryContext(ctx, query, workspace, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, *w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (r *sqlwebhookrepo) Get(ctx context.Context, id uint32) (*Webhook, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM `%s` WHERE id=? AND workspace_id=? AND owner_id=?", webhookColumns, webhooksTable)
+
+	w, err := scanWebhook(r.db.QueryRowContext(ctx, query, id, workspace, owner))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (r *sqlwebhookrepo) Create(ctx context.Context, in WebhookInput) (*Webhook, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := json.Marshal(in.Events)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("INSERT INTO `%s` (workspace_id, owner_id, url, events, secret, active) VALUES (?, ?, ?, ?, ?, IFNULL(?, TRUE))", webhooksTable)
+	result, err := r.db.ExecContext(ctx, query, workspace, owner, in.URL, events, in.Secret, in.Active)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Get(ctx, uint32(id))
+}
+
+// Update changes the fields set by in. Setting whether the webhook is active
+// starts the count of its failures over.
+func (r *sqlwebhookrepo) Update(ctx context.Context, id uint32, in WebhookInput) (*Webhook, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []byte
+	if in.Events != nil {
+		var err error
+		if events, err = json.Marshal(*in.Events); err != nil {
+			return nil, err
+		}
+	}
+
+	query := fmt.Sprintf("UPDATE `%s` SET url = IFNULL(?, url), events = IFNULL(?, events), secret = IFNULL(?, secret), failures = IF(? IS NULL, failures, 0), disabled_at = IF(? IS NULL, disabled_at, NULL), active = IFNULL(?, active) WHERE id=? AND workspace_id=? AND owner_id=?", webhooksTable)
+	result, err := r.db.ExecContext(ctx, query, in.URL, events, in.Secret, in.Active, in.Active, in.Active, id, workspace, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rows > 1 {
+		log.Print("unexpected: multiple rows affected")
+		return nil, ErrUnexpected
+	}
+
+	return r.Get(ctx, id)
+}
+
+// Delete removes a webhook. The foreign key on webhook_deliveries cascades,
+// dropping its deliveries.
+func (r *sqlwebhookrepo) Delete(ctx context.Context, id uint32) error {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("DELETE FROM `%s` WHERE id=? AND workspace_id=? AND owner_id=?", webhooksTable)
+	result, err := r.db.ExecContext(ctx, query, id, workspace, owner)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrWebhookNotFound
+	} else if rows != 1 {
+		log.Print("unexpected: multiple rows affected")
+		return ErrUnexpected
+	}
+
+	return nil
+}
+
+func (r *sqlwebhookrepo) Deliveries(ctx context.Context, id uint32, limit int) ([]Delivery, error) {
+	workspace, owner, err := scopeOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM `%s` WHERE webhook_id = (SELECT id FROM `%s` WHERE id=? AND workspace_id=? AND owner_id=?) ORDER BY id DESC LIMIT ?", deliveryColumns, deliveriesTable, webhooksTable)
+
+	rows, err := r.db.QueryContext(ctx, query, id, workspace, owner, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		if err := rows.Scan(deliveryFields(&d)...); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (r *sqlwebhookrepo) Claim(ctx context.Context, limit int, lease time.Duration) ([]PendingDelivery, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Skipping locked rows lets several dispatchers claim deliveries side
+	// by side.
+	query := fmt.Sprintf("SELECT d.id, d.webhook_id, d.event, d.payload, d.attempts, w.url, w.secret FROM `%s` d JOIN `%s` w ON w.id = d.webhook_id WHERE d.status = ? AND d.next_attempt_at <= CURRENT_TIMESTAMP AND w.active ORDER BY d.id LIMIT ? FOR UPDATE OF d SKIP LOCKED", deliveriesTable, webhooksTable)
+	rows, err := tx.QueryContext(ctx, query, DeliveryPending, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		claimed []PendingDelivery
+		ids     []any
+	)
+	for rows.Next() {
+		var d PendingDelivery
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Attempts, &d.URL, &d.Secret); err != nil {
+			return nil, err
+		}
+		claimed = append(claimed, d)
+		ids = append(ids, d.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if len(claimed) == 0 {
+		return nil, nil
+	}
+
+	query = fmt.Sprintf("UPDATE `%s` SET next_attempt_at = CURRENT_TIMESTAMP + INTERVAL ? SECOND WHERE id IN (%s)", deliveriesTable, placeholders(len(ids)))
+	args := append([]any{int64(lease / time.Second)}, ids...)
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return claimed, nil
+}
+
+func (r *sqlwebhookrepo) RecordAttempt(ctx context.Context, d PendingDelivery, a DeliveryAttempt, failureLimit int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status any
+	if a.Status != 0 {
+		status = a.Status
+	}
+
+	if a.Succeeded {
+		query := fmt.Sprintf("UPDATE `%s` SET status = ?, attempts = attempts + 1, response_status = ?, error = NULL, next_attempt_at = NULL, delivered_at = CURRENT_TIMESTAMP WHERE id=?", deliveriesTable)
+		if _, err := tx.ExecContext(ctx, query, DeliverySucceeded, status, d.ID); err != nil {
+			return err
+		}
+		query = fmt.Sprintf("UPDATE `%s` SET failures = 0 WHERE id=?", webhooksTable)
+		if _, err := tx.ExecContext(ctx, query, d.WebhookID); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	next := DeliveryPending
+	if a.RetryAt == nil {
+		next = DeliveryFailed
+	}
+	query := fmt.Sprintf("UPDATE `%s` SET status = ?, attempts = attempts + 1, response_status = ?, error = LEFT(?, 1024), next_attempt_at = ? WHERE id=?", deliveriesTable)
+	if _, err := tx.ExecContext(ctx, query, next, status, a.Error, a.RetryAt, d.ID); err != nil {
+		return err
+	}
+
+	// MySQL assigns from left to right, so failures is bumped last for the
+	// other assignments to see the count before this failure.
+	query = fmt.Sprintf("UPDATE `%s` SET disabled_at = IF(active AND failures + 1 >= ?, CURRENT_TIMESTAMP, disabled_at), active = active AND failures + 1 < ?, failures = failures + 1 WHERE id=?", webhooksTable)
+	if _, err := tx.ExecContext(ctx, query, failureLimit, failureLimit, d.WebhookID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanWebhook(s scanner) (*Webhook, error) {
+	var (
+		w      Webhook
+		events []byte
+	)
+	err := s.Scan(&w.ID, &w.URL, &events, &w.Active, &w.Failures, &w.DisabledAt, &w.CreatedAt, &w.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Events = []string{}
+	if len(events) > 0 {
+		if err := json.Unmarshal(events, &w.Events); err != nil {
+			return nil, err
+		}
+	}
+	return &w, nil
+}
+
+func deliveryFields(d *Delivery) []any {
+	return []any{&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.ResponseStatus, &d.Error, &d.NextAttemptAt, &d.CreatedAt, &d.DeliveredAt}
+}
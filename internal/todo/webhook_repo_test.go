@@ -0,0 +1,138 @@
+package todo_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+var _ = Describe("webhook repo", Label("webhook-repo"), func() {
+	var (
+		ctx  context.Context
+		db   *sql.DB
+		mock sqlmock.Sqlmock
+		repo WebhookRepository
+		now  time.Time
+		rows *sqlmock.Rows
+	)
+
+	const getQuery = "SELECT id, url, events, active, failures, disabled_at, created_at, updated_at FROM `webhooks` WHERE id=? AND workspace_id=? AND owner_id=?"
+
+	BeforeEach(func() {
+		var err error
+		ctx = WithWorkspace(WithUser(context.Background(), testOwner), testWorkspace)
+		db, mock, err = sqlmock.New()
+		Expect(err).NotTo(HaveOccurred())
+		repo = NewWebhookRepo(db)
+		now = time.Now().UTC().Truncate(time.Second)
+		rows = sqlmock.NewRows([]string{"id", "url", "events", "active", "failures", "disabled_at", "created_at", "updated_at"})
+	})
+
+	AfterEach(func() {
+		mock.ExpectClose()
+		Expect(db.Close()).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("creates and returns a webhook", func() {
+		url, secret := "https://example.com/hooks", "0123456789abcdef"
+		events := []string{EventTodoCreated}
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `webhooks` (workspace_id, owner_id, url, events, secret, active) VALUES (?, ?, ?, ?, ?, IFNULL(?, TRUE))")).
+			WithArgs(testWorkspace, testOwner, &url, []byte(`["todo.created"]`), &secret, nil).
+			WillReturnResult(sqlmock.NewResult(3, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WithArgs(3, testWorkspace, testOwner).
+			WillReturnRows(rows.AddRow(3, url, `["todo.created"]`, true, 0, nil, now, now))
+
+		w, err := repo.Create(ctx, WebhookInput{URL: &url, Events: &events, Secret: &secret})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.ID).To(BeEquivalentTo(3))
+		Expect(w.Events).To(Equal(events))
+		Expect(w.DisabledAt).To(BeNil())
+	})
+
+	It("returns webhook not found errors", func() {
+		mock.ExpectQuery(regexp.QuoteMeta(getQuery)).WithArgs(9, testWorkspace, testOwner).WillReturnError(sql.ErrNoRows)
+
+		w, err := repo.Get(ctx, 9)
+		Expect(err).To(MatchError(ErrWebhookNotFound))
+		Expect(w).To(BeNil())
+	})
+
+	It("refuses to list webhooks without a user", func() {
+		ws, err := repo.List(context.Background())
+		Expect(err).To(MatchError(ErrUnauthenticated))
+		Expect(ws).To(BeNil())
+	})
+
+	It("claims due deliveries and leases them", func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT d.id, d.webhook_id, d.event, d.payload, d.attempts, w.url, w.secret FROM `webhook_deliveries` d JOIN `webhooks` w ON w.id = d.webhook_id WHERE d.status = ? AND d.next_attempt_at <= CURRENT_TIMESTAMP AND w.active ORDER BY d.id LIMIT ? FOR UPDATE OF d SKIP LOCKED")).
+			WithArgs(DeliveryPending, 20).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "webhook_id", "event", "payload", "attempts", "url", "secret"}).
+				AddRow(4, 1, EventTodoCreated, `{"id":1}`, 0, "https://example.com/hooks", "0123456789abcdef").
+				AddRow(5, 2, EventTodoDeleted, `{"id":2}`, 3, "https://example.org/hooks", "fedcba9876543210"))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `webhook_deliveries` SET next_attempt_at = CURRENT_TIMESTAMP + INTERVAL ? SECOND WHERE id IN (?, ?)")).
+			WithArgs(60, 4, 5).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		claimed, err := repo.Claim(ctx, 20, time.Minute)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(claimed).To(HaveLen(2))
+		Expect(claimed[1]).To(Equal(PendingDelivery{ID: 5, WebhookID: 2, Event: EventTodoDeleted, Payload: []byte(`{"id":2}`), Attempts: 3, URL: "https://example.org/hooks", Secret: "fedcba9876543210"}))
+	})
+
+	It("records successful attempts and resets the failures of the webhook", func() {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `webhook_deliveries` SET status = ?, attempts = attempts + 1, response_status = ?, error = NULL, next_attempt_at = NULL, delivered_at = CURRENT_TIMESTAMP WHERE id=?")).
+			WithArgs(DeliverySucceeded, 200, 4).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `webhooks` SET failures = 0 WHERE id=?")).
+			WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := repo.RecordAttempt(ctx, PendingDelivery{ID: 4, WebhookID: 1}, DeliveryAttempt{Succeeded: true, Status: 200}, 50)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("records failed attempts and counts them towards disabling the webhook", func() {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `webhook_deliveries` SET status = ?, attempts = attempts + 1, response_status = ?, error = LEFT(?, 1024), next_attempt_at = ? WHERE id=?")).
+			WithArgs(DeliveryFailed, nil, "connection refused", nil, 4).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `webhooks` SET disabled_at = IF(active AND failures + 1 >= ?, CURRENT_TIMESTAMP, disabled_at), active = active AND failures + 1 < ?, failures = failures + 1 WHERE id=?")).
+			WithArgs(50, 50, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := repo.RecordAttempt(ctx, PendingDelivery{ID: 4, WebhookID: 1}, DeliveryAttempt{Error: "connection refused"}, 50)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("rolls back attempts it fails to record", func() {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `webhook_deliveries`")).WillReturnError(errors.New("update failed"))
+		mock.ExpectRollback()
+
+		err := repo.RecordAttempt(ctx, PendingDelivery{ID: 4, WebhookID: 1}, DeliveryAttempt{Succeeded: true, Status: 200}, 50)
+		Expect(err).To(MatchError("update failed"))
+	})
+
+	It("enqueues events for the active webhooks receiving them", func() {
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `webhook_deliveries` (webhook_id, event, payload) SELECT id, ?, ? FROM `webhooks` WHERE workspace_id = ? AND owner_id = ? AND active AND (JSON_LENGTH(events) = 0 OR JSON_CONTAINS(events, JSON_QUOTE(?)))")).
+			WithArgs(EventTodoDeleted, sqlmock.AnyArg(), testWorkspace, testOwner, EventTodoDeleted).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+
+		err := NewRepo(db).Enqueue(ctx, Event{Type: EventTodoDeleted, TodoID: 1, Time: now})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
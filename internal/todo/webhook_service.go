@@ -0,0 +1,166 @@
+package todo
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Bounds of webhook URLs and secrets, which have to fit the webhooks table.
+const (
+	MaxWebhookURLLength    = 2048
+	MinWebhookSecretLength = 16
+	MaxWebhookSecretLength = 255
+)
+
+// Number of deliveries returned by the deliveries log unless the client asks
+// for a different number.
+const DefaultDeliveriesLimit = 50
+
+// webhookEvents are the event types a webhook can receive.
+var webhookEvents = []string{EventTodoCreated, EventTodoUpdated, EventTodoDeleted}
+
+type WebhookService interface {
+	GetAll(ctx context.Context) ([]Webhook, error)
+	GetById(ctx context.Context, id uint32) (*Webhook, error)
+	Create(ctx context.Context, in WebhookInput) (*Webhook, error)
+	Update(ctx context.Context, id uint32, in WebhookInput) (*Webhook, error)
+	Delete(ctx context.Context, id uint32) error
+	Deliveries(ctx context.Context, id uint32, limit int) ([]Delivery, error)
+}
+
+type webhookService struct {
+	repo WebhookRepository
+}
+
+func NewWebhookService(r WebhookRepository) WebhookService {
+	return &webhookService{repo: r}
+}
+
+func (s *webhookService) GetAll(ctx context.Context) ([]Webhook, error) {
+	webhooks, err := s.repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (s *webhookService) GetById(ctx context.Context, id uint32) (*Webhook, error) {
+	w, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (s *webhookService) Create(ctx context.Context, in WebhookInput) (*Webhook, error) {
+	if in.Events == nil {
+		in.Events = &[]string{}
+	}
+	if err := validateWebhook(&in); err != nil {
+		return nil, err
+	}
+
+	w, err := s.repo.Create(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (s *webhookService) Update(ctx context.Context, id uint32, in WebhookInput) (*Webhook, error) {
+	if err := validateWebhook(&in); err != nil {
+		return nil, err
+	}
+
+	w, err := s.repo.Update(ctx, id, in)
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (s *webhookService) Delete(ctx context.Context, id uint32) error {
+	err := s.repo.Delete(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Deliveries returns the latest deliveries to webhook id, newest first.
+func (s *webhookService) Deliveries(ctx context.Context, id uint32, limit int) ([]Delivery, error) {
+	if limit <= 0 {
+		limit = DefaultDeliveriesLimit
+	} else if limit > MaxLimit {
+		limit = MaxLimit
+	}
+
+	if _, err := s.repo.Get(ctx, id); err != nil {
+		return nil, err
+	}
+
+	deliveries, err := s.repo.Deliveries(ctx, id, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// validateWebhook checks the fields set by in, and drops duplicate event
+// types. All violations are reported at once, as a *ValidationError.
+func validateWebhook(in *WebhookInput) error {
+	var errs []FieldError
+	if in.URL != nil {
+		u, err := url.Parse(*in.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(*in.URL) > MaxWebhookURLLength {
+			msg := fmt.Sprintf("url must be an absolute http or https URL of at most %d characters", MaxWebhookURLLength)
+			errs = append(errs, newFieldError("url", ErrWebhookURLInvalid, msg))
+		} else if !publicHost(u.Hostname()) {
+			errs = append(errs, newFieldError("url", ErrWebhookURLInvalid, "url must point to a public host"))
+		}
+	}
+	if in.Events != nil {
+		events := []string{}
+		for _, e := range *in.Events {
+			if !slices.Contains(webhookEvents, e) {
+				msg := fmt.Sprintf("events must only hold %s, %s or %s", EventTodoCreated, EventTodoUpdated, EventTodoDeleted)
+				errs = append(errs, newFieldError("events", ErrWebhookEventsInvalid, msg))
+				break
+			}
+			if !slices.Contains(events, e) {
+				events = append(events, e)
+			}
+		}
+		in.Events = &events
+	}
+	if in.Secret != nil && (len(*in.Secret) < MinWebhookSecretLength || len(*in.Secret) > MaxWebhookSecretLength) {
+		msg := fmt.Sprintf("secret must be between %d and %d bytes long", MinWebhookSecretLength, MaxWebhookSecretLength)
+		errs = append(errs, newFieldError("secret", ErrWebhookSecretInvalid, msg))
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// publicHost reports whether host may be public. Host names are only checked
+// against the names of the local host, since the addresses they resolve to
+// are checked when deliveries connect to them.
+func publicHost(host string) bool {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return isPublic(ip)
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	return host != "localhost" && !strings.HasSuffix(host, ".localhost")
+}
@@ -0,0 +1,518 @@
+package todo_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+// mockWebhookRepo keeps webhooks in memory, and hands out the pending
+// deliveries once, recording the outcome of their attempts.
+type mockWebhookRepo struct {
+	webhooks   map[uint32]Webhook
+	deliveries []Delivery
+	pending    []PendingDelivery
+	claimErr   error
+
+	mu       sync.Mutex
+	attempts map[uint64]DeliveryAttempt
+}
+
+var _ WebhookRepository = (*mockWebhookRepo)(nil)
+
+func (m *mockWebhookRepo) List(ctx context.Context) ([]Webhook, error) {
+	webhooks := []Webhook{}
+	for _, w := range m.webhooks {
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, nil
+}
+
+func (m *mockWebhookRepo) Get(ctx context.Context, id uint32) (*Webhook, error) {
+	w, ok := m.webhooks[id]
+	if !ok {
+		return nil, ErrWebhookNotFound
+	}
+	return &w, nil
+}
+
+func (m *mockWebhookRepo) Create(ctx context.Context, in WebhookInput) (*Webhook, error) {
+	w := Webhook{ID: uint32(len(m.webhooks) + 1), URL: *in.URL, Events: *in.Events, Active: in.Active == nil || *in.Active}
+	m.webhooks[w.ID] = w
+	return &w, nil
+}
+
+func (m *mockWebhookRepo) Update(ctx context.Context, id uint32, in WebhookInput) (*Webhook, error) {
+	w, ok := m.webhooks[id]
+	if !ok {
+		return nil, ErrWebhookNotFound
+	}
+	if in.URL != nil {
+		w.URL = *in.URL
+	}
+	if in.Events != nil {
+		w.Events = *in.Events
+	}
+	if in.Active != nil {
+		w.Active, w.Failures, w.DisabledAt = *in.Active, 0, nil
+	}
+	m.webhooks[id] = w
+	return &w, nil
+}
+
+func (m *mockWebhookRepo) Delete(ctx context.Context, id uint32) error {
+	if _, ok := m.webhooks[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(m.webhooks, id)
+	return nil
+}
+
+func (m *mockWebhookRepo) Deliveries(ctx context.Context, id uint32, limit int) ([]Delivery, error) {
+	return m.deliveries[:min(limit, len(m.deliveries))], nil
+}
+
+func (m *mockWebhookRepo) Claim(ctx context.Context, limit int, lease time.Duration) ([]PendingDelivery, error) {
+	if m.claimErr != nil {
+		return nil, m.claimErr
+	}
+	n := min(limit, len(m.pending))
+	claimed := m.pending[:n]
+	m.pending = m.pending[n:]
+	return claimed, nil
+}
+
+func (m *mockWebhookRepo) RecordAttempt(ctx context.Context, d PendingDelivery, a DeliveryAttempt, failureLimit int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.attempts[d.ID] = a
+	return nil
+}
+
+// receiver is a local endpoint for webhooks, which records the deliveries
+// whose signature is valid and answers with the given status.
+type receiver struct {
+	*httptest.Server
+	secret string
+	status int
+
+	mu       sync.Mutex
+	received []*http.Request
+	bodies   [][]byte
+	rejected int
+}
+
+func newReceiver(secret string, status int) *receiver {
+	rc := &receiver{secret: secret, status: status}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		if !VerifyWebhook(rc.secret, r.Header.Get(HeaderWebhookTimestamp), r.Header.Get(HeaderWebhookSignature), body) {
+			rc.rejected++
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rc.received = append(rc.received, r)
+		rc.bodies = append(rc.bodies, body)
+		w.WriteHeader(rc.status)
+	}))
+	return rc
+}
+
+var _ = Describe("webhooks", Label("webhooks"), func() {
+	const secret = "0123456789abcdef"
+
+	var (
+		ctx  context.Context
+		repo *mockWebhookRepo
+		now  time.Time
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		repo = &mockWebhookRepo{webhooks: map[uint32]Webhook{}, attempts: map[uint64]DeliveryAttempt{}}
+		now = time.Date(2025, 9, 17, 12, 0, 0, 0, time.UTC)
+	})
+
+	Describe("signatures", func() {
+		It("verifies signatures of the timestamp and body", func() {
+			body := []byte(`{"type":"todo.created"}`)
+			sig := "sha256=" + SignWebhook(secret, "1758110400", body)
+
+			Expect(VerifyWebhook(secret, "1758110400", sig, body)).To(BeTrue())
+			Expect(VerifyWebhook(secret, "1758110401", sig, body)).To(BeFalse())
+			Expect(VerifyWebhook(secret, "1758110400", sig, []byte(`{}`))).To(BeFalse())
+			Expect(VerifyWebhook("another secret!!", "1758110400", sig, body)).To(BeFalse())
+			Expect(VerifyWebhook(secret, "1758110400", strings.TrimPrefix(sig, "sha256="), body)).To(BeFalse())
+		})
+	})
+
+	Describe("dispatcher", func() {
+		var dispatcher *Dispatcher
+
+		BeforeEach(func() {
+			// The receivers listen on the loopback interface, which the
+			// default client refuses to connect to.
+			dispatcher = NewDispatcher(repo, WithHTTPClient(http.DefaultClient), WithMaxAttempts(3), WithDispatcherClock(func() time.Time { return now }))
+		})
+
+		It("posts signed payloads and records the successes", func() {
+			rc := newReceiver(secret, http.StatusNoContent)
+			defer rc.Close()
+			payload := []byte(`{"type":"todo.created","id":7}`)
+			repo.pending = []PendingDelivery{
+				{ID: 1, WebhookID: 1, Event: EventTodoCreated, Payload: payload, URL: rc.URL, Secret: secret},
+				{ID: 2, WebhookID: 1, Event: EventTodoCreated, Payload: payload, URL: rc.URL, Secret: secret},
+			}
+
+			n, err := dispatcher.Dispatch(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n).To(Equal(2))
+
+			Expect(rc.rejected).To(BeZero())
+			Expect(rc.received).To(HaveLen(2))
+			r := rc.received[0]
+			Expect(r.Method).To(Equal(http.MethodPost))
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(r.Header.Get(HeaderWebhookEvent)).To(Equal(EventTodoCreated))
+			Expect(r.Header.Get(HeaderWebhookTimestamp)).To(Equal("1758110400"))
+			Expect([]string{rc.received[0].Header.Get(HeaderWebhookDelivery), rc.received[1].Header.Get(HeaderWebhookDelivery)}).To(ConsistOf("1", "2"))
+			Expect(rc.bodies[0]).To(MatchJSON(payload))
+
+			Expect(repo.attempts).To(HaveLen(2))
+			Expect(repo.attempts[1]).To(Equal(DeliveryAttempt{Succeeded: true, Status: http.StatusNoContent}))
+		})
+
+		It("retries failed deliveries with exponential backoff", func() {
+			rc := newReceiver(secret, http.StatusServiceUnavailable)
+			defer rc.Close()
+			repo.pending = []PendingDelivery{
+				{ID: 1, WebhookID: 1, Event: EventTodoCreated, Payload: []byte(`{}`), URL: rc.URL, Secret: secret},
+				{ID: 2, WebhookID: 1, Event: EventTodoCreated, Payload: []byte(`{}`), Attempts: 1, URL: rc.URL, Secret: secret},
+			}
+
+			_, err := dispatcher.Dispatch(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(repo.attempts[1]).To(MatchAllFields(Fields{
+				"Succeeded": BeFalse(),
+				"Status":    Equal(http.StatusServiceUnavailable),
+				"Error":     ContainSubstring("503"),
+				"RetryAt":   PointTo(Equal(now.Add(30 * time.Second))),
+			}))
+			Expect(repo.attempts[2].RetryAt).To(PointTo(Equal(now.Add(time.Minute))))
+		})
+
+		It("gives up on deliveries after the last attempt", func() {
+			rc := newReceiver(secret, http.StatusInternalServerError)
+			defer rc.Close()
+			repo.pending = []PendingDelivery{
+				{ID: 1, WebhookID: 1, Event: EventTodoDeleted, Payload: []byte(`{}`), Attempts: 2, URL: rc.URL, Secret: secret},
+			}
+
+			_, err := dispatcher.Dispatch(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(repo.attempts[1].Succeeded).To(BeFalse())
+			Expect(repo.attempts[1].RetryAt).To(BeNil())
+		})
+
+		It("records unreachable endpoints as failures without a status", func() {
+			rc := newReceiver(secret, http.StatusOK)
+			url := rc.URL
+			rc.Close()
+			repo.pending = []PendingDelivery{
+				{ID: 1, WebhookID: 1, Event: EventTodoCreated, Payload: []byte(`{}`), URL: url, Secret: secret},
+			}
+
+			_, err := dispatcher.Dispatch(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(repo.attempts[1]).To(MatchFields(IgnoreExtras, Fields{
+				"Succeeded": BeFalse(),
+				"Status":    BeZero(),
+				"Error":     Not(BeEmpty()),
+				"RetryAt":   Not(BeNil()),
+			}))
+		})
+
+		It("refuses to deliver to addresses outside of the public internet by default", func() {
+			rc := newReceiver(secret, http.StatusOK)
+			defer rc.Close()
+			repo.pending = []PendingDelivery{
+				{ID: 1, WebhookID: 1, Event: EventTodoCreated, Payload: []byte(`{}`), URL: rc.URL, Secret: secret},
+			}
+
+			_, err := NewDispatcher(repo).Dispatch(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rc.received).To(BeEmpty())
+			Expect(repo.attempts[1]).To(MatchFields(IgnoreExtras, Fields{
+				"Succeeded": BeFalse(),
+				"Error":     ContainSubstring("address is not public"),
+			}))
+		})
+
+		It("returns errors claiming deliveries", func() {
+			repo.claimErr = errors.New("claim failed")
+
+			n, err := dispatcher.Dispatch(ctx)
+			Expect(err).To(MatchError("claim failed"))
+			Expect(n).To(BeZero())
+		})
+
+		It("runs until the context is done", func() {
+			rc := newReceiver(secret, http.StatusOK)
+			defer rc.Close()
+			repo.pending = []PendingDelivery{
+				{ID: 1, WebhookID: 1, Event: EventTodoCreated, Payload: []byte(`{}`), URL: rc.URL, Secret: secret},
+			}
+
+			c, cancel := context.WithCancel(ctx)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				dispatcher.Run(c, time.Hour)
+			}()
+
+			Eventually(func() int {
+				rc.mu.Lock()
+				defer rc.mu.Unlock()
+				return len(rc.received)
+			}).Should(Equal(1))
+			cancel()
+			Eventually(done).Should(BeClosed())
+		})
+	})
+
+	Describe("outbox", func() {
+		var (
+			todos    *mockRepo
+			enqueued []Event
+		)
+
+		BeforeEach(func() {
+			enqueued = nil
+			todos = &mockRepo{
+				getFn: func(ctx context.Context, id uint32) (*Todo, error) {
+					return &Todo{ID: id, ListID: 2}, nil
+				},
+				enqueueFn: func(ctx context.Context, e Event) error {
+					enqueued = append(enqueued, e)
+					return nil
+				},
+			}
+		})
+
+		It("enqueues the events of writes in their transaction", func() {
+			svc := NewService(todos, WithOutbox(), WithClock(func() time.Time { return now }))
+			text := "Buy milk"
+
+			_, err := svc.Create(ctx, TodoInput{Text: &text})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(svc.Delete(ctx, 5, nil)).To(Succeed())
+
+			Expect(todos.transacted).To(Equal(2))
+			Expect(enqueued).To(HaveLen(2))
+			Expect(enqueued[0]).To(MatchFields(IgnoreExtras, Fields{"Type": Equal(EventTodoCreated), "Todo": Not(BeNil()), "Time": Equal(now)}))
+			Expect(enqueued[1]).To(MatchFields(IgnoreExtras, Fields{"Type": Equal(EventTodoDeleted), "TodoID": BeEquivalentTo(5), "ListID": BeEquivalentTo(2), "Todo": BeNil()}))
+		})
+
+		It("enqueues nothing for failed writes", func() {
+			todos.deleteFn = func(ctx context.Context, id uint32, version *uint32) error {
+				return ErrTodoNotFound
+			}
+			svc := NewService(todos, WithOutbox())
+
+			Expect(svc.Delete(ctx, 5, nil)).To(MatchError(ErrTodoNotFound))
+			Expect(enqueued).To(BeEmpty())
+		})
+
+		It("fails writes whose events cannot be enqueued", func() {
+			todos.enqueueFn = func(ctx context.Context, e Event) error {
+				return errors.New("enqueue failed")
+			}
+			svc := NewService(todos, WithOutbox())
+			text := "Buy milk"
+
+			_, err := svc.Create(ctx, TodoInput{Text: &text})
+			Expect(err).To(MatchError("enqueue failed"))
+		})
+
+		It("enqueues nothing without the outbox", func() {
+			svc := NewService(todos)
+			text := "Buy milk"
+
+			_, err := svc.Create(ctx, TodoInput{Text: &text})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(todos.transacted).To(BeZero())
+			Expect(enqueued).To(BeEmpty())
+		})
+	})
+
+	Describe("handler", func() {
+		var (
+			router *gin.Engine
+			rr     *httptest.ResponseRecorder
+		)
+
+		BeforeEach(func() {
+			gin.SetMode(gin.TestMode)
+			router = gin.New()
+			NewWebhookHandler(NewWebhookService(repo)).Register(router)
+			rr = httptest.NewRecorder()
+		})
+
+		It("registers webhooks receiving every event by default", func() {
+			body := `{"url": "https://example.com/hooks", "secret": "` + secret + `"}`
+			req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusCreated))
+			Expect(rr.Header().Get("Location")).To(Equal("/webhooks/1"))
+			Expect(rr.Body.String()).NotTo(ContainSubstring(secret))
+			var w Webhook
+			Expect(json.Unmarshal(rr.Body.Bytes(), &w)).To(Succeed())
+			Expect(w).To(MatchFields(IgnoreExtras, Fields{"URL": Equal("https://example.com/hooks"), "Events": BeEmpty(), "Active": BeTrue()}))
+		})
+
+		It("drops duplicate events", func() {
+			body := `{"url": "https://example.com/hooks", "secret": "` + secret + `", "events": ["todo.created", "todo.created"]}`
+			req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusCreated))
+			Expect(repo.webhooks[1].Events).To(Equal([]string{EventTodoCreated}))
+		})
+
+		It("requires a url and a secret", func() {
+			req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(`{"url": "https://example.com/hooks"}`))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("reports every invalid field at once", func() {
+			body := `{"url": "ftp://example.com", "secret": "short", "events": ["todo.archived"]}`
+			req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+			var p Problem
+			Expect(json.Unmarshal(rr.Body.Bytes(), &p)).To(Succeed())
+			Expect(p.Errors).To(ConsistOf(
+				MatchFields(IgnoreExtras, Fields{"Field": Equal("url"), "Rule": Equal(ErrWebhookURLInvalid.Error())}),
+				MatchFields(IgnoreExtras, Fields{"Field": Equal("events"), "Rule": Equal(ErrWebhookEventsInvalid.Error())}),
+				MatchFields(IgnoreExtras, Fields{"Field": Equal("secret"), "Rule": Equal(ErrWebhookSecretInvalid.Error())}),
+			))
+		})
+
+		It("rejects URLs of hosts which aren't public", func() {
+			for _, url := range []string{"http://127.0.0.1:8080/hooks", "http://169.254.169.254/latest", "https://10.0.0.7/hooks", "http://[::1]/hooks", "http://localhost/hooks", "http://api.localhost/hooks"} {
+				rr = httptest.NewRecorder()
+				body := `{"url": "` + url + `", "secret": "` + secret + `"}`
+				req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				router.ServeHTTP(rr, req)
+
+				Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity), url)
+				Expect(rr.Body.String()).To(ContainSubstring(ErrWebhookURLInvalid.Error()), url)
+			}
+		})
+
+		DescribeTable("checks whether the addresses of hosts are public",
+			func(host string, public bool) {
+				body := `{"url": "https://` + host + `/hooks", "secret": "` + secret + `"}`
+				req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				router.ServeHTTP(rr, req)
+
+				if public {
+					Expect(rr.Code).To(Equal(http.StatusCreated))
+				} else {
+					Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+					Expect(rr.Body.String()).To(ContainSubstring(ErrWebhookURLInvalid.Error()))
+				}
+			},
+			Entry("public IPv4", "93.184.216.34", true),
+			Entry("public IPv6", "[2606:2800:220:1:248:1893:25c8:1946]", true),
+			Entry("this network", "0.0.0.1", false),
+			Entry("shared address space", "100.64.0.1", false),
+			Entry("IETF protocol assignments", "192.0.0.8", false),
+			Entry("documentation", "192.0.2.1", false),
+			Entry("benchmarking", "198.18.0.1", false),
+			Entry("multicast", "224.0.0.251", false),
+			Entry("broadcast", "255.255.255.255", false),
+			Entry("IPv4-mapped private", "[::ffff:10.0.0.1]", false),
+			Entry("IPv4-mapped loopback", "[::ffff:127.0.0.1]", false),
+			Entry("IPv4-mapped public", "[::ffff:93.184.216.34]", true),
+			Entry("6to4 of private", "[2002:c0a8:0101::1]", false),
+			Entry("6to4 of loopback", "[2002:7f00:0001::1]", false),
+			Entry("6to4 of public", "[2002:5db8:d822::1]", true),
+			Entry("NAT64", "[64:ff9b::a00:1]", false),
+			Entry("unique local", "[fd00::1]", false),
+			Entry("link-local", "[fe80::1]", false),
+			Entry("IPv6 documentation", "[2001:db8::1]", false),
+			Entry("IPv6 unspecified", "[::]", false),
+		)
+
+		It("reactivates disabled webhooks", func() {
+			disabled := now
+			repo.webhooks[1] = Webhook{ID: 1, Events: []string{}, Failures: 50, DisabledAt: &disabled}
+			req := httptest.NewRequest(http.MethodPatch, "/webhooks/1", strings.NewReader(`{"active": true}`))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(repo.webhooks[1]).To(MatchFields(IgnoreExtras, Fields{"Active": BeTrue(), "Failures": BeZero(), "DisabledAt": BeNil()}))
+		})
+
+		It("lists the deliveries of a webhook", func() {
+			repo.webhooks[1] = Webhook{ID: 1}
+			repo.deliveries = []Delivery{
+				{ID: 2, WebhookID: 1, Event: EventTodoUpdated, Payload: json.RawMessage(`{"id":1}`), Status: DeliveryPending, Attempts: 1},
+				{ID: 1, WebhookID: 1, Event: EventTodoCreated, Payload: json.RawMessage(`{"id":1}`), Status: DeliverySucceeded, Attempts: 1},
+			}
+			req := httptest.NewRequest(http.MethodGet, "/webhooks/1/deliveries?limit=1", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			var deliveries []Delivery
+			Expect(json.Unmarshal(rr.Body.Bytes(), &deliveries)).To(Succeed())
+			Expect(deliveries).To(HaveLen(1))
+			Expect(deliveries[0].Status).To(Equal(DeliveryPending))
+			Expect(deliveries[0].Payload).To(MatchJSON(`{"id":1}`))
+		})
+
+		It("returns not found for the deliveries of a missing webhook", func() {
+			req := httptest.NewRequest(http.MethodGet, "/webhooks/9/deliveries", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusNotFound))
+			Expect(rr.Body.String()).To(ContainSubstring(ErrWebhookNotFound.Error()))
+		})
+
+		It("rejects out of range limits", func() {
+			req := httptest.NewRequest(http.MethodGet, "/webhooks/1/deliveries?limit=0", nil)
+			router.ServeHTTP(rr, req)
+
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
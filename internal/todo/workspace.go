@@ -0,0 +1,128 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultWorkspace is the slug of the workspace requests naming none act
+// within, unless configured otherwise.
+const DefaultWorkspace = "default"
+
+// WorkspaceHeader names the workspace a request acts within.
+const WorkspaceHeader = "X-Workspace"
+
+// Workspace is a tenant of the deployment. Its todos are out of reach from
+// every other workspace, and there are at most MaxTodos of them, trashed ones
+// included, unless MaxTodos is nil.
+type Workspace struct {
+	ID       uint32
+	Slug     string
+	Name     string
+	MaxTodos *uint32
+}
+
+// WithWorkspace returns a copy of ctx acting within workspace id. The todo
+// repositories only reach the todos of its workspace, and fail with
+// ErrWorkspaceRequired when ctx carries none.
+func WithWorkspace(ctx context.Context, id uint32) context.Context {
+	return context.WithValue(ctx, workspaceKey, id)
+}
+
+// WorkspaceID returns the ID of the workspace ctx acts within, if any.
+func WorkspaceID(ctx context.Context) (uint32, bool) {
+	id, ok := ctx.Value(workspaceKey).(uint32)
+	return id, ok && id != 0
+}
+
+// workspaceOf returns the ID of the workspace whose todos ctx reaches. Like
+// ownerOf, it fails rather than reaching the todos of every workspace when
+// ctx carries none.
+func workspaceOf(ctx context.Context) (uint32, error) {
+	id, ok := WorkspaceID(ctx)
+	if !ok {
+		return 0, ErrWorkspaceRequired
+	}
+	return id, nil
+}
+
+// Tenancy resolves the workspace of requests, which is named by the claim of
+// their token, or else by their WorkspaceHeader, or else by the subdomain of
+// their host. Requests naming none act within the default workspace, which is
+// open to every user, while the others are only open to their members.
+type Tenancy struct {
+	repo        WorkspaceRepository
+	defaultSlug string
+	domain      string
+}
+
+// NewTenancy returns a Tenancy falling back to the workspace with slug
+// defaultSlug. Workspaces are named by the subdomains of domain, unless domain
+// is empty.
+func NewTenancy(r WorkspaceRepository, defaultSlug, domain string) *Tenancy {
+	return &Tenancy{repo: r, defaultSlug: defaultSlug, domain: strings.ToLower(domain)}
+}
+
+// Resolve returns the ID of the workspace of the request, made on behalf of
+// the principal of its context. It fails with ErrWorkspaceNotFound if the
+// workspace doesn't exist, isn't open to the user, or isn't the one the token
+// is bound to.
+func (t *Tenancy) Resolve(c *gin.Context) (uint32, error) {
+	ctx := c.Request.Context()
+
+	slug := c.GetHeader(WorkspaceHeader)
+	if slug == "" {
+		slug = t.subdomain(c.Request.Host)
+	}
+	if p, _ := PrincipalOf(ctx); p.Workspace != "" {
+		if slug != "" && slug != p.Workspace {
+			return 0, ErrWorkspaceNotFound
+		}
+		slug = p.Workspace
+	}
+	if slug == "" {
+		slug = t.defaultSlug
+	}
+
+	w, member, err := t.repo.Get(ctx, slug)
+	if err != nil {
+		return 0, err
+	}
+	if !member && w.Slug != t.defaultSlug {
+		return 0, ErrWorkspaceNotFound
+	}
+
+	return w.ID, nil
+}
+
+// subdomain returns the label host has in front of the domain of t, if any.
+func (t *Tenancy) subdomain(host string) string {
+	if t.domain == "" {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	label, ok := strings.CutSuffix(strings.ToLower(host), "."+t.domain)
+	if !ok || strings.Contains(label, ".") {
+		return ""
+	}
+	return label
+}
+
+// WriteWorkspaceError writes the response rejecting a request whose workspace
+// couldn't be resolved with err.
+func WriteWorkspaceError(ctx *gin.Context, err error) {
+	if errors.Is(err, ErrWorkspaceNotFound) {
+		r := NewErrorResponse(ErrWorkspaceNotFound.Error(), "No workspace open to the user matches the request")
+		writeError(ctx, http.StatusNotFound, r)
+		return
+	}
+	WriteAuthError(ctx, err)
+}
@@ -0,0 +1,51 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const (
+	workspacesTable       = "workspaces"
+	workspaceMembersTable = "workspace_members"
+)
+
+// WorkspaceRepository stores the workspaces and who they are open to.
+type WorkspaceRepository interface {
+	// Get returns the workspace with slug, or ErrWorkspaceNotFound if there
+	// is none, along with whether the user of the context is a member.
+	Get(ctx context.Context, slug string) (*Workspace, bool, error)
+}
+
+type sqlworkspacerepo struct {
+	db *sql.DB
+}
+
+func NewWorkspaceRepo(db *sql.DB) WorkspaceRepository {
+	return &sqlworkspacerepo{db: db}
+}
+
+func (r *sqlworkspacerepo) Get(ctx context.Context, slug string) (*Workspace, bool, error) {
+	user, err := ownerOf(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	query := fmt.Sprintf("SELECT w.id, w.slug, w.name, w.max_todos, m.user_id IS NOT NULL FROM `%s` w LEFT JOIN `%s` m ON m.workspace_id = w.id AND m.user_id = ? WHERE w.slug=?", workspacesTable, workspaceMembersTable)
+
+	var (
+		w      Workspace
+		member bool
+	)
+	err = r.db.QueryRowContext(ctx, query, user, slug).Scan(&w.ID, &w.Slug, &w.Name, &w.MaxTodos, &member)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false, ErrWorkspaceNotFound
+		}
+		return nil, false, err
+	}
+
+	return &w, member, nil
+}
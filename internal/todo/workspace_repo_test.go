@@ -0,0 +1,66 @@
+package todo_test
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+var _ = Describe("workspace repo", Label("workspace-repo"), func() {
+	var (
+		ctx  context.Context
+		db   *sql.DB
+		mock sqlmock.Sqlmock
+		repo WorkspaceRepository
+	)
+
+	const query = "SELECT w.id, w.slug, w.name, w.max_todos, m.user_id IS NOT NULL FROM `workspaces` w LEFT JOIN `workspace_members` m ON m.workspace_id = w.id AND m.user_id = ? WHERE w.slug=?"
+
+	BeforeEach(func() {
+		var err error
+		ctx = WithUser(context.Background(), testOwner)
+		db, mock, err = sqlmock.New()
+		Expect(err).NotTo(HaveOccurred())
+		repo = NewWorkspaceRepo(db)
+	})
+
+	AfterEach(func() {
+		mock.ExpectClose()
+		Expect(db.Close()).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("reads workspaces along with the membership of the user", func() {
+		mock.ExpectQuery(regexp.QuoteMeta(query)).
+			WithArgs(testOwner, "acme").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "slug", "name", "max_todos", "member"}).AddRow(3, "acme", "Acme", 100, true))
+
+		w, member, err := repo.Get(ctx, "acme")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(member).To(BeTrue())
+		Expect(w.ID).To(BeEquivalentTo(3))
+		Expect(w.Name).To(Equal("Acme"))
+		Expect(w.MaxTodos).To(PointTo(BeEquivalentTo(100)))
+	})
+
+	It("returns workspace not found errors for unknown slugs", func() {
+		mock.ExpectQuery(regexp.QuoteMeta(query)).
+			WithArgs(testOwner, "nowhere").
+			WillReturnError(sql.ErrNoRows)
+
+		_, _, err := repo.Get(ctx, "nowhere")
+		Expect(err).To(MatchError(ErrWorkspaceNotFound))
+	})
+
+	It("refuses to read workspaces without a user", func() {
+		_, _, err := repo.Get(context.Background(), "acme")
+		Expect(err).To(MatchError(ErrUnauthenticated))
+	})
+})
@@ -0,0 +1,132 @@
+package todo_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/anas-salha/2do/internal/todo"
+)
+
+// mockWorkspaceRepo keeps workspaces, and the users they are open to, in
+// memory.
+type mockWorkspaceRepo struct {
+	workspaces map[string]Workspace
+	members    map[string][]uint32
+}
+
+var _ WorkspaceRepository = (*mockWorkspaceRepo)(nil)
+
+func (m *mockWorkspaceRepo) Get(ctx context.Context, slug string) (*Workspace, bool, error) {
+	w, ok := m.workspaces[slug]
+	if !ok {
+		return nil, false, ErrWorkspaceNotFound
+	}
+	user, _ := UserID(ctx)
+	for _, id := range m.members[slug] {
+		if id == user {
+			return &w, true, nil
+		}
+	}
+	return &w, false, nil
+}
+
+var _ = Describe("workspaces", Label("workspaces"), func() {
+	var tenancy *Tenancy
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		repo := &mockWorkspaceRepo{
+			workspaces: map[string]Workspace{
+				DefaultWorkspace: {ID: 1, Slug: DefaultWorkspace},
+				"acme":           {ID: 2, Slug: "acme"},
+				"globex":         {ID: 3, Slug: "globex"},
+			},
+			members: map[string][]uint32{
+				"acme":   {testOwner},
+				"globex": {testEditor},
+			},
+		}
+		tenancy = NewTenancy(repo, DefaultWorkspace, "2do.example.com")
+	})
+
+	// resolve resolves the workspace of a request to host made by p with the
+	// given WorkspaceHeader.
+	resolve := func(p Principal, host, header string) (uint32, error) {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest(http.MethodGet, "/todos", nil)
+		ctx.Request.Host = host
+		if header != "" {
+			ctx.Request.Header.Set(WorkspaceHeader, header)
+		}
+		ctx.Request = ctx.Request.WithContext(WithPrincipal(ctx.Request.Context(), p))
+		return tenancy.Resolve(ctx)
+	}
+
+	owner := Principal{UserID: testOwner}
+
+	It("resolves the workspace named by the header", func() {
+		Expect(resolve(owner, "localhost:8080", "acme")).To(BeEquivalentTo(2))
+	})
+
+	It("resolves the workspace named by the subdomain", func() {
+		Expect(resolve(owner, "acme.2do.example.com", "")).To(BeEquivalentTo(2))
+		Expect(resolve(owner, "ACME.2do.example.com:443", "")).To(BeEquivalentTo(2))
+	})
+
+	It("prefers the header to the subdomain", func() {
+		_, err := resolve(owner, "acme.2do.example.com", "globex")
+		Expect(err).To(MatchError(ErrWorkspaceNotFound))
+	})
+
+	It("falls back to the default workspace, open to every user", func() {
+		Expect(resolve(owner, "2do.example.com", "")).To(BeEquivalentTo(1))
+		Expect(resolve(owner, "a.b.2do.example.com", "")).To(BeEquivalentTo(1))
+		Expect(resolve(Principal{UserID: testViewer}, "localhost", "")).To(BeEquivalentTo(1))
+	})
+
+	It("only opens the other workspaces to their members", func() {
+		_, err := resolve(owner, "globex.2do.example.com", "")
+		Expect(err).To(MatchError(ErrWorkspaceNotFound))
+		Expect(resolve(Principal{UserID: testEditor}, "globex.2do.example.com", "")).To(BeEquivalentTo(3))
+	})
+
+	It("reports unknown workspaces as not found", func() {
+		_, err := resolve(owner, "localhost", "initech")
+		Expect(err).To(MatchError(ErrWorkspaceNotFound))
+	})
+
+	It("binds the requests of tokens claiming a workspace to it", func() {
+		bound := Principal{UserID: testOwner, Workspace: "acme"}
+		Expect(resolve(bound, "localhost", "")).To(BeEquivalentTo(2))
+		Expect(resolve(bound, "acme.2do.example.com", "acme")).To(BeEquivalentTo(2))
+
+		_, err := resolve(bound, "localhost", DefaultWorkspace)
+		Expect(err).To(MatchError(ErrWorkspaceNotFound))
+		_, err = resolve(bound, "globex.2do.example.com", "")
+		Expect(err).To(MatchError(ErrWorkspaceNotFound))
+	})
+
+	It("ignores subdomains when no domain is configured", func() {
+		tenancy = NewTenancy(&mockWorkspaceRepo{workspaces: map[string]Workspace{"main": {ID: 4, Slug: "main"}}}, "main", "")
+		Expect(resolve(owner, "acme.2do.example.com", "")).To(BeEquivalentTo(4))
+	})
+
+	It("reports unresolved workspaces as not found", func() {
+		rr := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(rr)
+		ctx.Request = httptest.NewRequest(http.MethodGet, "/todos", nil)
+
+		WriteWorkspaceError(ctx, ErrWorkspaceNotFound)
+
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+		var p Problem
+		Expect(json.Unmarshal(rr.Body.Bytes(), &p)).To(Succeed())
+		Expect(p.Code).To(Equal(ErrWorkspaceNotFound.Error()))
+	})
+})
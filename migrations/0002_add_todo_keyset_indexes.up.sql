@@ -0,0 +1,3 @@
+ALTER TABLE todos
+    ADD INDEX idx_todos_created_at_id (created_at, id),
+    ADD INDEX idx_todos_updated_at_id (updated_at, id);
@@ -0,0 +1,4 @@
+ALTER TABLE todos
+    ADD COLUMN due_at DATETIME NULL,
+    ADD COLUMN start_at DATETIME NULL,
+    ADD INDEX idx_todos_due_at (due_at);
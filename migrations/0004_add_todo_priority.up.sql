@@ -0,0 +1,3 @@
+ALTER TABLE todos
+    ADD COLUMN priority ENUM('none', 'low', 'medium', 'high', 'urgent') DEFAULT 'none' NOT NULL,
+    ADD INDEX idx_todos_priority_due_at (priority, due_at);
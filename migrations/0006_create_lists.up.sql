@@ -0,0 +1,17 @@
+CREATE TABLE lists (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NULL,
+    color CHAR(7) NULL,
+    archived BOOLEAN DEFAULT FALSE NOT NULL,
+    position INT DEFAULT 0 NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP NOT NULL,
+    KEY idx_lists_position (position, id)
+);
+
+INSERT INTO lists (id, name) VALUES (1, 'Inbox');
+
+ALTER TABLE todos
+    ADD COLUMN list_id INT UNSIGNED DEFAULT 1 NOT NULL,
+    ADD CONSTRAINT fk_todos_list FOREIGN KEY (list_id) REFERENCES lists (id) ON DELETE CASCADE;
@@ -0,0 +1,5 @@
+ALTER TABLE todos
+    ADD COLUMN recurrence VARCHAR(255) NULL,
+    ADD COLUMN series_id INT UNSIGNED NULL,
+    ADD COLUMN occurrence INT UNSIGNED DEFAULT 1 NOT NULL,
+    ADD UNIQUE KEY uniq_todos_series_occurrence (series_id, occurrence);
@@ -0,0 +1,3 @@
+ALTER TABLE todos
+    ADD COLUMN deleted_at TIMESTAMP NULL,
+    ADD KEY idx_todos_deleted_at (deleted_at);
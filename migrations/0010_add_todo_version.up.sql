@@ -0,0 +1,2 @@
+ALTER TABLE todos
+    ADD COLUMN version INT UNSIGNED DEFAULT 1 NOT NULL;
@@ -0,0 +1,10 @@
+CREATE TABLE idempotency_keys (
+    idempotency_key VARCHAR(255) NOT NULL PRIMARY KEY,
+    fingerprint CHAR(64) NOT NULL,
+    status SMALLINT UNSIGNED NULL,
+    headers JSON NULL,
+    body MEDIUMBLOB NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    KEY idx_idempotency_keys_expires_at (expires_at)
+);
@@ -0,0 +1,28 @@
+CREATE TABLE webhooks (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    url VARCHAR(2048) NOT NULL,
+    events JSON NOT NULL,
+    secret VARCHAR(255) NOT NULL,
+    active BOOLEAN DEFAULT TRUE NOT NULL,
+    failures INT UNSIGNED DEFAULT 0 NOT NULL,
+    disabled_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE TABLE webhook_deliveries (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    webhook_id INT UNSIGNED NOT NULL,
+    event VARCHAR(32) NOT NULL,
+    payload JSON NOT NULL,
+    status ENUM('pending', 'succeeded', 'failed') DEFAULT 'pending' NOT NULL,
+    attempts INT UNSIGNED DEFAULT 0 NOT NULL,
+    response_status SMALLINT UNSIGNED NULL,
+    error VARCHAR(1024) NULL,
+    next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    delivered_at TIMESTAMP NULL,
+    KEY idx_webhook_deliveries_due (status, next_attempt_at),
+    KEY idx_webhook_deliveries_webhook (webhook_id, id),
+    CONSTRAINT fk_webhook_deliveries_webhook FOREIGN KEY (webhook_id) REFERENCES webhooks (id) ON DELETE CASCADE
+);
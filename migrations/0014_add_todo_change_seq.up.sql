@@ -0,0 +1,18 @@
+CREATE TABLE change_sequence (
+    id TINYINT UNSIGNED PRIMARY KEY,
+    seq BIGINT UNSIGNED NOT NULL
+);
+
+INSERT INTO change_sequence (id, seq) VALUES (1, 0);
+
+ALTER TABLE todos
+    ADD COLUMN change_seq BIGINT UNSIGNED DEFAULT 0 NOT NULL,
+    ADD KEY idx_todos_change_seq (change_seq, id);
+
+CREATE TABLE todo_tombstones (
+    todo_id INT UNSIGNED PRIMARY KEY,
+    list_id INT UNSIGNED NOT NULL,
+    change_seq BIGINT UNSIGNED NOT NULL,
+    deleted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    KEY idx_todo_tombstones_change_seq (change_seq, todo_id)
+);
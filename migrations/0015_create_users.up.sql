@@ -0,0 +1,42 @@
+CREATE TABLE users (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    email VARCHAR(254) NOT NULL,
+    password_hash VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP NOT NULL,
+    UNIQUE KEY uq_users_email (email)
+);
+
+CREATE TABLE sessions (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id INT UNSIGNED NOT NULL,
+    token_hash BINARY(32) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    UNIQUE KEY uq_sessions_token_hash (token_hash),
+    KEY idx_sessions_expires_at (expires_at),
+    CONSTRAINT fk_sessions_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- Todos created before there were users have no owner, so no one sees them.
+ALTER TABLE todos
+    ADD COLUMN owner_id INT UNSIGNED NULL,
+    ADD KEY idx_todos_owner_change_seq (owner_id, change_seq, id),
+    ADD CONSTRAINT fk_todos_owner FOREIGN KEY (owner_id) REFERENCES users (id) ON DELETE CASCADE;
+
+ALTER TABLE todo_tombstones
+    ADD COLUMN owner_id INT UNSIGNED NULL,
+    ADD KEY idx_todo_tombstones_owner_change_seq (owner_id, change_seq, todo_id);
+
+ALTER TABLE webhooks
+    ADD COLUMN owner_id INT UNSIGNED NULL,
+    ADD CONSTRAINT fk_webhooks_owner FOREIGN KEY (owner_id) REFERENCES users (id) ON DELETE CASCADE;
+
+-- Idempotency keys are chosen by clients, so they only need to be unique
+-- per user.
+DELETE FROM idempotency_keys;
+
+ALTER TABLE idempotency_keys
+    ADD COLUMN owner_id INT UNSIGNED NOT NULL FIRST,
+    DROP PRIMARY KEY,
+    ADD PRIMARY KEY (owner_id, idempotency_key);
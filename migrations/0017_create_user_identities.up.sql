@@ -0,0 +1,14 @@
+-- Users provisioned from an identity provider have no password, so they can
+-- only authenticate with its tokens.
+ALTER TABLE users
+    MODIFY password_hash VARCHAR(255) NULL;
+
+CREATE TABLE user_identities (
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id INT UNSIGNED NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    PRIMARY KEY (issuer, subject),
+    KEY idx_user_identities_user (user_id),
+    CONSTRAINT fk_user_identities_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
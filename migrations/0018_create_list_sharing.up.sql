@@ -0,0 +1,32 @@
+-- Lists created before there were owners, such as the Inbox, have none and
+-- stay common to every user. They can't be shared.
+ALTER TABLE lists
+    ADD COLUMN owner_id INT UNSIGNED NULL,
+    ADD CONSTRAINT fk_lists_owner FOREIGN KEY (owner_id) REFERENCES users (id) ON DELETE CASCADE;
+
+CREATE TABLE list_members (
+    list_id INT UNSIGNED NOT NULL,
+    user_id INT UNSIGNED NOT NULL,
+    role ENUM('viewer', 'editor', 'owner') NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    PRIMARY KEY (list_id, user_id),
+    KEY idx_list_members_user (user_id),
+    CONSTRAINT fk_list_members_list FOREIGN KEY (list_id) REFERENCES lists (id) ON DELETE CASCADE,
+    CONSTRAINT fk_list_members_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- A user has at most one pending invitation to a list, which inviting them
+-- again replaces.
+CREATE TABLE list_invitations (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    list_id INT UNSIGNED NOT NULL,
+    inviter_id INT UNSIGNED NOT NULL,
+    invitee_id INT UNSIGNED NOT NULL,
+    role ENUM('viewer', 'editor', 'owner') NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    UNIQUE KEY uq_list_invitations_list_invitee (list_id, invitee_id),
+    KEY idx_list_invitations_invitee (invitee_id),
+    CONSTRAINT fk_list_invitations_list FOREIGN KEY (list_id) REFERENCES lists (id) ON DELETE CASCADE,
+    CONSTRAINT fk_list_invitations_inviter FOREIGN KEY (inviter_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT fk_list_invitations_invitee FOREIGN KEY (invitee_id) REFERENCES users (id) ON DELETE CASCADE
+);
@@ -0,0 +1,41 @@
+-- Workspaces keep the todos of the teams sharing a deployment apart. The
+-- default workspace is open to every user, and receives the existing todos
+-- and webhooks.
+CREATE TABLE workspaces (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    slug VARCHAR(63) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    max_todos INT UNSIGNED NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    UNIQUE KEY uq_workspaces_slug (slug)
+);
+
+INSERT INTO workspaces (id, slug, name) VALUES (1, 'default', 'Default');
+
+CREATE TABLE workspace_members (
+    workspace_id INT UNSIGNED NOT NULL,
+    user_id INT UNSIGNED NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    PRIMARY KEY (workspace_id, user_id),
+    KEY idx_workspace_members_user (user_id),
+    CONSTRAINT fk_workspace_members_workspace FOREIGN KEY (workspace_id) REFERENCES workspaces (id) ON DELETE CASCADE,
+    CONSTRAINT fk_workspace_members_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+ALTER TABLE todos
+    ADD COLUMN workspace_id INT UNSIGNED NOT NULL DEFAULT 1,
+    ADD KEY idx_todos_workspace_owner_change_seq (workspace_id, owner_id, change_seq, id),
+    ADD CONSTRAINT fk_todos_workspace FOREIGN KEY (workspace_id) REFERENCES workspaces (id) ON DELETE CASCADE;
+
+ALTER TABLE todo_tombstones
+    ADD COLUMN workspace_id INT UNSIGNED NOT NULL DEFAULT 1,
+    ADD KEY idx_todo_tombstones_workspace_owner_change_seq (workspace_id, owner_id, change_seq, todo_id);
+
+ALTER TABLE webhooks
+    ADD COLUMN workspace_id INT UNSIGNED NOT NULL DEFAULT 1,
+    ADD CONSTRAINT fk_webhooks_workspace FOREIGN KEY (workspace_id) REFERENCES workspaces (id) ON DELETE CASCADE;
+
+-- Todos and webhooks are only written within a workspace from now on.
+ALTER TABLE todos ALTER COLUMN workspace_id DROP DEFAULT;
+ALTER TABLE todo_tombstones ALTER COLUMN workspace_id DROP DEFAULT;
+ALTER TABLE webhooks ALTER COLUMN workspace_id DROP DEFAULT;
@@ -0,0 +1,4 @@
+-- Date-only due dates are calendar dates, which due date windows compare by
+-- date in the time zone of the client. Existing due dates keep their time,
+-- since a due date at midnight may well have been meant as one.
+ALTER TABLE todos ADD COLUMN due_date_only BOOLEAN DEFAULT FALSE NOT NULL;
@@ -0,0 +1,4 @@
+-- Todos are trashed before their list is deleted, never deleted along with it.
+ALTER TABLE todos
+    DROP FOREIGN KEY fk_todos_list,
+    ADD CONSTRAINT fk_todos_list FOREIGN KEY (list_id) REFERENCES lists (id) ON DELETE RESTRICT;
@@ -0,0 +1,30 @@
+-- Tags belong to a user within a workspace, like their todos. Each user gets
+-- a copy of the tags on their todos, and the tags of todos without an owner,
+-- which no one sees, are dropped.
+ALTER TABLE tags
+    ADD COLUMN workspace_id INT UNSIGNED NULL FIRST,
+    ADD COLUMN owner_id INT UNSIGNED NULL AFTER workspace_id,
+    DROP KEY uq_tags_name,
+    ADD UNIQUE KEY uq_tags_workspace_owner_name (workspace_id, owner_id, name);
+
+INSERT INTO tags (workspace_id, owner_id, name, color, created_at)
+SELECT DISTINCT t.workspace_id, t.owner_id, g.name, g.color, g.created_at
+FROM todo_tags tt
+JOIN todos t ON t.id = tt.todo_id
+JOIN tags g ON g.id = tt.tag_id
+WHERE t.owner_id IS NOT NULL AND g.owner_id IS NULL;
+
+UPDATE todo_tags tt
+JOIN todos t ON t.id = tt.todo_id
+JOIN tags g ON g.id = tt.tag_id
+JOIN tags n ON n.workspace_id = t.workspace_id AND n.owner_id = t.owner_id AND n.name = g.name
+SET tt.tag_id = n.id
+WHERE g.owner_id IS NULL;
+
+DELETE FROM tags WHERE owner_id IS NULL;
+
+ALTER TABLE tags
+    MODIFY workspace_id INT UNSIGNED NOT NULL,
+    MODIFY owner_id INT UNSIGNED NOT NULL,
+    ADD CONSTRAINT fk_tags_workspace FOREIGN KEY (workspace_id) REFERENCES workspaces (id) ON DELETE CASCADE,
+    ADD CONSTRAINT fk_tags_owner FOREIGN KEY (owner_id) REFERENCES users (id) ON DELETE CASCADE;
@@ -0,0 +1,8 @@
+-- Lists belong to the workspace of their owner, and existing ones move to the
+-- default workspace. Lists without an owner, such as the Inbox, stay common to
+-- every workspace and belong to none.
+ALTER TABLE lists
+    ADD COLUMN workspace_id INT UNSIGNED NULL,
+    ADD CONSTRAINT fk_lists_workspace FOREIGN KEY (workspace_id) REFERENCES workspaces (id) ON DELETE CASCADE;
+
+UPDATE lists SET workspace_id = 1 WHERE owner_id IS NOT NULL;
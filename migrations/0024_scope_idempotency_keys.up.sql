@@ -0,0 +1,9 @@
+-- Idempotency keys only need to be unique per user within a workspace, so
+-- that a key reused in another workspace doesn't replay the response of a
+-- request made in this one.
+DELETE FROM idempotency_keys;
+
+ALTER TABLE idempotency_keys
+    ADD COLUMN workspace_id INT UNSIGNED NOT NULL FIRST,
+    DROP PRIMARY KEY,
+    ADD PRIMARY KEY (workspace_id, owner_id, idempotency_key);
@@ -0,0 +1,10 @@
+-- Each workspace numbers its changes with a sequence of its own, so that
+-- writes to different workspaces don't wait on one another. The sequences
+-- carry on from the shared one, so that no sync token goes back.
+ALTER TABLE change_sequence CHANGE id workspace_id INT UNSIGNED NOT NULL;
+
+INSERT INTO change_sequence (workspace_id, seq)
+SELECT w.id, s.seq FROM workspaces w JOIN change_sequence s ON s.workspace_id = 1 WHERE w.id <> 1;
+
+ALTER TABLE change_sequence
+    ADD CONSTRAINT fk_change_sequence_workspace FOREIGN KEY (workspace_id) REFERENCES workspaces (id) ON DELETE CASCADE;